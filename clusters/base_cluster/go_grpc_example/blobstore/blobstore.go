@@ -0,0 +1,61 @@
+// Package blobstore keeps binary objects, such as post attachments, outside of the database.
+// Blobs are written whole under a key chosen by the caller and read back as a stream; the
+// store knows nothing about what the blobs mean, which is recorded by the caller in its db.
+package blobstore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+const (
+	STORE_FS = "fs"
+	STORE_S3 = "s3"
+)
+
+var (
+	// ErrNotFound is returned by Get for keys without a blob.
+	ErrNotFound = errors.New("blobstore: blob not found")
+	// ErrInvalidKey is returned for keys that are empty, absolute, or contain '..' segments.
+	ErrInvalidKey = errors.New("blobstore: invalid key")
+)
+
+// BlobStore stores blobs by key. Keys are '/'-separated paths, such as 'ab/abcdef'.
+type BlobStore interface {
+	// Put stores the size bytes read from r under key, replacing any blob stored there.
+	// A failed Put leaves no partial blob behind.
+	Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) error
+	// Get opens the blob stored under key, or returns ErrNotFound.
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+	// Delete removes the blob stored under key; deleting a missing blob is not an error.
+	Delete(ctx context.Context, key string) error
+}
+
+// New returns a blob store of the passed kind: fs (target is the directory), or s3
+// (target is the bucket url, such as https://minio:9000/attachments).
+func New(kind, target string, creds S3Creds) (BlobStore, error) {
+	switch kind {
+	case STORE_FS:
+		return NewFSStore(target)
+	case STORE_S3:
+		return NewS3Store(target, creds)
+	default:
+		return nil, fmt.Errorf("unknown blob store %q, must be one of: %s, %s", kind, STORE_FS, STORE_S3)
+	}
+}
+
+// checkKey rejects keys that could escape the store's directory or bucket prefix.
+func checkKey(key string) error {
+	if key == "" || strings.HasPrefix(key, "/") || strings.Contains(key, "\\") {
+		return ErrInvalidKey
+	}
+	for _, seg := range strings.Split(key, "/") {
+		if seg == "" || seg == "." || seg == ".." {
+			return ErrInvalidKey
+		}
+	}
+	return nil
+}
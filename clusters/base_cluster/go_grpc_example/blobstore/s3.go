@@ -0,0 +1,243 @@
+package blobstore
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	S3_REGION_DEFAULT = "us-east-1"
+
+	// UNSIGNED_PAYLOAD skips hashing request bodies, which would mean reading uploads twice;
+	// the attachment checksum is verified before the upload, and TLS protects it in flight.
+	UNSIGNED_PAYLOAD = "UNSIGNED-PAYLOAD"
+	// emptyPayloadHash is the sha256 of an empty body, signed for requests without one.
+	emptyPayloadHash = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+	amzDateFormat    = "20060102T150405Z"
+)
+
+// S3Creds are the access key and region used to sign S3 requests.
+type S3Creds struct {
+	AccessKey string
+	SecretKey string
+	// Region defaults to S3_REGION_DEFAULT, which MinIO accepts unless configured otherwise.
+	Region string
+}
+
+// S3Store keeps blobs in a bucket of an S3-compatible service, such as AWS S3 or MinIO.
+// Requests are signed with AWS signature v4 and address the bucket path-style, i.e.
+// https://host/bucket/key, which MinIO requires and S3 still supports.
+//
+// FUTURE: multipart uploads, should attachments ever outgrow a single PUT (5GiB).
+type S3Store struct {
+	endpoint *url.URL
+	bucket   string
+	creds    S3Creds
+	client   *http.Client
+	// now is replaced by tests.
+	now func() time.Time
+}
+
+// NewS3Store returns a store for the bucket at bucketURL, e.g. https://minio:9000/attachments.
+func NewS3Store(bucketURL string, creds S3Creds) (*S3Store, error) {
+	u, err := url.Parse(bucketURL)
+	if err != nil || u.Host == "" || (u.Scheme != "http" && u.Scheme != "https") {
+		return nil, fmt.Errorf("s3 blob store requires a bucket url such as https://host/bucket, got %q", bucketURL)
+	}
+	bucket := strings.Trim(u.Path, "/")
+	if bucket == "" || strings.Contains(bucket, "/") {
+		return nil, fmt.Errorf("s3 blob store url %q must name a single bucket", bucketURL)
+	}
+	if creds.AccessKey == "" || creds.SecretKey == "" {
+		return nil, errors.New("s3 blob store requires an access key and secret key")
+	}
+	if creds.Region == "" {
+		creds.Region = S3_REGION_DEFAULT
+	}
+
+	return &S3Store{
+		endpoint: &url.URL{Scheme: u.Scheme, Host: u.Host},
+		bucket:   bucket,
+		creds:    creds,
+		// No client timeout, since blobs stream for as long as they take; requests are bound by their ctx.
+		client: &http.Client{},
+		now:    time.Now,
+	}, nil
+}
+
+func (s *S3Store) newRequest(ctx context.Context, method, key string, body io.Reader) (*http.Request, error) {
+	if err := checkKey(key); err != nil {
+		return nil, err
+	}
+
+	u := *s.endpoint
+	u.Path = "/" + s.bucket + "/" + key
+	u.RawPath = "/" + s3Escape(s.bucket) + "/" + s3Escape(key)
+	return http.NewRequestWithContext(ctx, method, u.String(), body)
+}
+
+func (s *S3Store) do(req *http.Request, payloadHash string) (*http.Response, error) {
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	req.Header.Set("X-Amz-Date", s.now().UTC().Format(amzDateFormat))
+	signV4(req, s.creds)
+	return s.client.Do(req)
+}
+
+func (s *S3Store) Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) error {
+	req, err := s.newRequest(ctx, http.MethodPut, key, io.LimitReader(r, size))
+	if err != nil {
+		return err
+	}
+	req.ContentLength = size
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+
+	res, err := s.do(req, UNSIGNED_PAYLOAD)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return s3Error(req, res)
+	}
+	return nil
+}
+
+func (s *S3Store) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	req, err := s.newRequest(ctx, http.MethodGet, key, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := s.do(req, emptyPayloadHash)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode == http.StatusNotFound {
+		res.Body.Close()
+		return nil, ErrNotFound
+	}
+	if res.StatusCode != http.StatusOK {
+		defer res.Body.Close()
+		return nil, s3Error(req, res)
+	}
+	return res.Body, nil
+}
+
+func (s *S3Store) Delete(ctx context.Context, key string) error {
+	req, err := s.newRequest(ctx, http.MethodDelete, key, nil)
+	if err != nil {
+		return err
+	}
+
+	res, err := s.do(req, emptyPayloadHash)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	switch res.StatusCode {
+	case http.StatusOK, http.StatusNoContent, http.StatusNotFound:
+		return nil
+	}
+	return s3Error(req, res)
+}
+
+// s3Error describes a failed request by its status and the start of the xml error body.
+func s3Error(req *http.Request, res *http.Response) error {
+	body, _ := io.ReadAll(io.LimitReader(res.Body, 512))
+	return fmt.Errorf("s3 %s %s: %s: %s", req.Method, req.URL.Path, res.Status, strings.TrimSpace(string(body)))
+}
+
+// signV4 adds an AWS signature v4 authorization header to req, signing its host and every
+// header already set, which must include X-Amz-Date and X-Amz-Content-Sha256.
+// See https://docs.aws.amazon.com/AmazonS3/latest/API/sig-v4-header-based-auth.html
+func signV4(req *http.Request, creds S3Creds) {
+	amzDate := req.Header.Get("X-Amz-Date")
+	scope := amzDate[:8] + "/" + creds.Region + "/s3/aws4_request"
+
+	headers := map[string]string{"host": req.URL.Host}
+	for k, vals := range req.Header {
+		headers[strings.ToLower(k)] = strings.TrimSpace(strings.Join(vals, ","))
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	canonicalHeaders := &strings.Builder{}
+	for _, name := range names {
+		fmt.Fprintf(canonicalHeaders, "%s:%s\n", name, headers[name])
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		canonicalQuery(req.URL.Query()),
+		canonicalHeaders.String(),
+		signedHeaders,
+		req.Header.Get("X-Amz-Content-Sha256"),
+	}, "\n")
+
+	hashed := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(hashed[:])
+
+	key := hmacSHA256([]byte("AWS4"+creds.SecretKey), amzDate[:8])
+	key = hmacSHA256(key, creds.Region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		creds.AccessKey, scope, signedHeaders, signature))
+}
+
+func canonicalQuery(q url.Values) string {
+	params := []string{}
+	for k, vals := range q {
+		for _, v := range vals {
+			params = append(params, queryEscape(k)+"="+queryEscape(v))
+		}
+	}
+	sort.Strings(params)
+	return strings.Join(params, "&")
+}
+
+// queryEscape is s3Escape for query parameters, in which '/' is encoded too.
+func queryEscape(s string) string {
+	return strings.ReplaceAll(s3Escape(s), "/", "%2F")
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// s3Escape percent-encodes all but the unreserved characters of s, keeping '/' as is,
+// since sigv4 signs paths encoded that way rather than as url.PathEscape encodes them.
+func s3Escape(s string) string {
+	b := &strings.Builder{}
+	for _, c := range []byte(s) {
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9',
+			c == '-', c == '_', c == '.', c == '~', c == '/':
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,581 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	crud "go_grpc_example/crud_client"
+	pb "go_grpc_example/proto"
+)
+
+const (
+	EVENT_CREATED = "created"
+	EVENT_UPDATED = "updated"
+	EVENT_DELETED = "deleted"
+	EVENT_ADDED   = "added"
+	EVENT_CHANGED = "changed"
+	EVENT_REMOVED = "removed"
+	// The events printed by publish, unpublish, and archive.
+	EVENT_PUBLISHED   = "published"
+	EVENT_SCHEDULED   = "scheduled"
+	EVENT_UNPUBLISHED = "unpublished"
+	EVENT_ARCHIVED    = "archived"
+	// The events printed by attach and download.
+	EVENT_ATTACHED   = "attached"
+	EVENT_DOWNLOADED = "downloaded"
+
+	WATCH_INTERVAL_DEFAULT = 2 * time.Second
+	// GET_BATCH_SIZE is how many ids get fetches per BatchGetPosts rpc, the service's default maximum.
+	GET_BATCH_SIZE = 100
+)
+
+// postFlags are the flags shared by create and update to describe a post inline.
+type postFlags struct {
+	file string
+	post pb.Post
+}
+
+func newPostFlagSet(name string, pf *postFlags) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.StringVar(&pf.file, "f", "", "read posts from a json, ndjson, or yaml `file`; use '-' for stdin")
+	fs.StringVar(&pf.post.Id, "id", "", "post id")
+	fs.StringVar(&pf.post.AuthorId, "author", "", "author id")
+	fs.StringVar(&pf.post.Title, "title", "", "title")
+	fs.StringVar(&pf.post.Description, "desc", "", "description")
+	fs.StringVar(&pf.post.FullText, "text", "", "full text")
+	return fs
+}
+
+// posts returns the posts described by the file flag and the inline flags.
+func (pf *postFlags) posts() ([]*pb.Post, error) {
+	posts := []*pb.Post{}
+	if pf.file != "" {
+		fromFile, err := readPostsFile(pf.file)
+		if err != nil {
+			return nil, err
+		}
+		posts = append(posts, fromFile...)
+	}
+
+	if !proto.Equal(&pf.post, &pb.Post{}) {
+		posts = append(posts, proto.Clone(&pf.post).(*pb.Post))
+	}
+
+	if len(posts) == 0 {
+		return nil, errors.New("no posts given, pass post flags or -f")
+	}
+	return posts, nil
+}
+
+// parseArgs parses command flags. Parse errors and -h have already printed usage.
+func parseArgs(fs *flag.FlagSet, args []string) error {
+	if err := fs.Parse(args); err != nil {
+		return errUsage
+	}
+	return nil
+}
+
+func runCreate(ctx context.Context, a *app, args []string) error {
+	pf := &postFlags{}
+	fs := newPostFlagSet("create", pf)
+	key := fs.String("key", "", "idempotency `key`, so that rerunning the same create does not duplicate posts; "+
+		"the n-th of several posts uses key-n. By default each create gets a random key.")
+	statusFlag := fs.String("status", "", "create posts as draft, scheduled, or published (the default)")
+	publishAt := fs.String("publish-at", "", "RFC3339 `time` at which scheduled posts are published")
+	if err := parseArgs(fs, args); err != nil {
+		return err
+	}
+
+	posts, err := pf.posts()
+	if err != nil {
+		return err
+	}
+
+	if err := setStatus(posts, *statusFlag, *publishAt); err != nil {
+		return err
+	}
+
+	for i, post := range posts {
+		postCtx := ctx
+		if *key != "" {
+			postKey := *key
+			if len(posts) > 1 {
+				postKey = fmt.Sprintf("%s-%d", *key, i)
+			}
+			postCtx = crud.WithIdempotencyKey(ctx, postKey)
+		}
+
+		id, err := a.cli.CreatePost(postCtx, post)
+		if err != nil {
+			return fmt.Errorf("create %q: %w", post.Id, err)
+		}
+
+		if err := a.printer.PrintID(id, EVENT_CREATED); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// setStatus sets the status and publish time given by the create flags on the posts that do not set their own.
+func setStatus(posts []*pb.Post, name, publishAt string) error {
+	status := pb.PostStatus_POST_STATUS_UNSPECIFIED
+	if name != "" {
+		value, ok := pb.PostStatus_value["POST_STATUS_"+strings.ToUpper(name)]
+		if !ok {
+			return fmt.Errorf("unknown status %q, use draft, scheduled, or published", name)
+		}
+		status = pb.PostStatus(value)
+	}
+
+	var at *timestamppb.Timestamp
+	if publishAt != "" {
+		t, err := time.Parse(time.RFC3339, publishAt)
+		if err != nil {
+			return fmt.Errorf("publish-at: %w", err)
+		}
+		at = timestamppb.New(t)
+		if status == pb.PostStatus_POST_STATUS_UNSPECIFIED {
+			status = pb.PostStatus_POST_STATUS_SCHEDULED
+		}
+	}
+
+	for _, post := range posts {
+		if post.Status == pb.PostStatus_POST_STATUS_UNSPECIFIED {
+			post.Status = status
+		}
+		if post.PublishAt == nil {
+			post.PublishAt = at
+		}
+	}
+	return nil
+}
+
+func runGet(ctx context.Context, a *app, args []string) error {
+	fs := flag.NewFlagSet("get", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: client get <post-id>... (use '-' to read ids from stdin)")
+	}
+	if err := parseArgs(fs, args); err != nil {
+		return err
+	}
+
+	ids, err := idArgs(fs.Args())
+	if err != nil {
+		return err
+	}
+
+	if len(ids) == 1 {
+		post, err := a.cli.ReadPost(ctx, ids[0])
+		if err != nil {
+			return fmt.Errorf("get %q: %w", ids[0], err)
+		}
+		return a.printer.PrintPost(post, "")
+	}
+
+	for len(ids) > 0 {
+		n := len(ids)
+		if n > GET_BATCH_SIZE {
+			n = GET_BATCH_SIZE
+		}
+
+		results, err := a.cli.BatchGetPosts(ctx, ids[:n]...)
+		if err != nil {
+			return fmt.Errorf("get: %w", err)
+		}
+		for _, res := range results {
+			if !res.Found {
+				return fmt.Errorf("get %q: %w", res.Id, crud.ErrNotFound)
+			}
+			if err := a.printer.PrintPost(res.Post, ""); err != nil {
+				return err
+			}
+		}
+		ids = ids[n:]
+	}
+	return nil
+}
+
+func runUpdate(ctx context.Context, a *app, args []string) error {
+	pf := &postFlags{}
+	fs := newPostFlagSet("update", pf)
+	if err := parseArgs(fs, args); err != nil {
+		return err
+	}
+
+	posts, err := pf.posts()
+	if err != nil {
+		return err
+	}
+
+	for _, post := range posts {
+		if post.Id == "" {
+			return errors.New("update requires a post id")
+		}
+
+		if err := a.cli.UpdatePost(ctx, post); err != nil {
+			return fmt.Errorf("update %q: %w", post.Id, err)
+		}
+
+		if err := a.printer.PrintID(post.Id, EVENT_UPDATED); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func runDelete(ctx context.Context, a *app, args []string) error {
+	fs := flag.NewFlagSet("delete", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: client delete <post-id>... (use '-' to read ids from stdin)")
+	}
+	if err := parseArgs(fs, args); err != nil {
+		return err
+	}
+
+	ids, err := idArgs(fs.Args())
+	if err != nil {
+		return err
+	}
+
+	for _, id := range ids {
+		if err := a.cli.DeletePost(ctx, id); err != nil {
+			return fmt.Errorf("delete %q: %w", id, err)
+		}
+
+		if err := a.printer.PrintID(id, EVENT_DELETED); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func runList(ctx context.Context, a *app, args []string) error {
+	fs := flag.NewFlagSet("list", flag.ContinueOnError)
+	drafts := fs.Bool("drafts", false, "also list your own draft and scheduled posts; needs a token")
+	if err := parseArgs(fs, args); err != nil {
+		return err
+	}
+
+	posts, err := listPosts(ctx, a, &pb.ListPostsRequest{IncludeOwnDrafts: *drafts})
+	if err != nil {
+		return err
+	}
+
+	for _, post := range posts {
+		if err := a.printer.PrintPost(post, ""); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// runWatch polls ListPosts and prints the differences between successive snapshots.
+// The service has no change feed, so polling is the best that can be done for now.
+func runWatch(ctx context.Context, a *app, args []string) error {
+	fs := flag.NewFlagSet("watch", flag.ContinueOnError)
+	interval := fs.Duration("interval", WATCH_INTERVAL_DEFAULT, "polling interval")
+	skipExisting := fs.Bool("skip-existing", false, "do not print the posts that exist when the watch starts")
+	drafts := fs.Bool("drafts", false, "also watch your own draft and scheduled posts; needs a token")
+	if err := parseArgs(fs, args); err != nil {
+		return err
+	}
+	if *interval <= 0 {
+		return errors.New("interval must be positive")
+	}
+
+	var prev map[string]*pb.Post
+	ticker := time.NewTicker(*interval)
+	defer ticker.Stop()
+
+	for {
+		posts, err := listPosts(ctx, a, &pb.ListPostsRequest{IncludeOwnDrafts: *drafts})
+		if ctx.Err() != nil {
+			// Interrupted by the user
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		cur := make(map[string]*pb.Post, len(posts))
+		for _, post := range posts {
+			cur[post.Id] = post
+		}
+
+		if prev != nil || !*skipExisting {
+			if err := printChanges(a.printer, prev, cur, posts); err != nil {
+				return err
+			}
+		}
+		prev = cur
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// printChanges prints the posts that were added, changed, or removed between snapshots.
+// The ordered posts of the current snapshot are passed to keep output in server order.
+func printChanges(p printer, prev, cur map[string]*pb.Post, ordered []*pb.Post) error {
+	for _, post := range ordered {
+		old, existed := prev[post.Id]
+		switch {
+		case !existed:
+			if err := p.PrintPost(post, EVENT_ADDED); err != nil {
+				return err
+			}
+		case !proto.Equal(old, post):
+			if err := p.PrintPost(post, EVENT_CHANGED); err != nil {
+				return err
+			}
+		}
+	}
+
+	for id, old := range prev {
+		if _, ok := cur[id]; !ok {
+			if err := p.PrintPost(old, EVENT_REMOVED); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func listPosts(ctx context.Context, a *app, req *pb.ListPostsRequest) ([]*pb.Post, error) {
+	posts, err := a.cli.ListAllPosts(ctx, req)
+	if err != nil {
+		return nil, fmt.Errorf("list: %w", err)
+	}
+	return posts, nil
+}
+
+func runPublish(ctx context.Context, a *app, args []string) error {
+	fs := flag.NewFlagSet("publish", flag.ContinueOnError)
+	at := fs.String("at", "", "schedule the posts for publishing at an RFC3339 `time` instead of now")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: client publish [-at time] <post-id>... (use '-' to read ids from stdin)")
+		fs.PrintDefaults()
+	}
+	if err := parseArgs(fs, args); err != nil {
+		return err
+	}
+
+	publishAt := time.Time{}
+	if *at != "" {
+		t, err := time.Parse(time.RFC3339, *at)
+		if err != nil {
+			return fmt.Errorf("at: %w", err)
+		}
+		publishAt = t
+	}
+
+	return transitionPosts(a, "publish", fs.Args(), func(id string) (*pb.Post, string, error) {
+		post, err := a.cli.PublishPost(ctx, id, publishAt)
+		if post.GetStatus() == pb.PostStatus_POST_STATUS_SCHEDULED {
+			return post, EVENT_SCHEDULED, err
+		}
+		return post, EVENT_PUBLISHED, err
+	})
+}
+
+func runUnpublish(ctx context.Context, a *app, args []string) error {
+	fs := flag.NewFlagSet("unpublish", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: client unpublish <post-id>... (use '-' to read ids from stdin)")
+	}
+	if err := parseArgs(fs, args); err != nil {
+		return err
+	}
+
+	return transitionPosts(a, "unpublish", fs.Args(), func(id string) (*pb.Post, string, error) {
+		post, err := a.cli.UnpublishPost(ctx, id)
+		return post, EVENT_UNPUBLISHED, err
+	})
+}
+
+func runArchive(ctx context.Context, a *app, args []string) error {
+	fs := flag.NewFlagSet("archive", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: client archive <post-id>... (use '-' to read ids from stdin)")
+	}
+	if err := parseArgs(fs, args); err != nil {
+		return err
+	}
+
+	return transitionPosts(a, "archive", fs.Args(), func(id string) (*pb.Post, string, error) {
+		post, err := a.cli.ArchivePost(ctx, id)
+		return post, EVENT_ARCHIVED, err
+	})
+}
+
+// transitionPosts applies a status change to each post-id argument and prints the changed posts.
+func transitionPosts(a *app, name string, args []string, change func(id string) (*pb.Post, string, error)) error {
+	ids, err := idArgs(args)
+	if err != nil {
+		return err
+	}
+
+	for _, id := range ids {
+		post, event, err := change(id)
+		if err != nil {
+			return fmt.Errorf("%s %q: %w", name, id, err)
+		}
+
+		if err := a.printer.PrintPost(post, event); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func runAttach(ctx context.Context, a *app, args []string) error {
+	fs := flag.NewFlagSet("attach", flag.ContinueOnError)
+	contentType := fs.String("type", "", "content `type` of the file; detected from its content by default")
+	name := fs.String("name", "", "file `name` of the attachment; the base name of the file by default")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: client attach [-type content-type] [-name filename] <post-id> <file>")
+		fs.PrintDefaults()
+	}
+	if err := parseArgs(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() != 2 {
+		fs.Usage()
+		return errUsage
+	}
+	postID, path := fs.Arg(0), fs.Arg(1)
+
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if *name == "" {
+		*name = filepath.Base(path)
+	}
+	attachment, err := a.cli.UploadAttachment(ctx, postID, *name, *contentType, f)
+	if err != nil {
+		return fmt.Errorf("attach %q: %w", path, err)
+	}
+	return a.printer.PrintAttachment(attachment, EVENT_ATTACHED)
+}
+
+func runAttachments(ctx context.Context, a *app, args []string) error {
+	fs := flag.NewFlagSet("attachments", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: client attachments <post-id>")
+	}
+	if err := parseArgs(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return errUsage
+	}
+
+	attachments, err := a.cli.ListAttachments(ctx, fs.Arg(0))
+	if err != nil {
+		return fmt.Errorf("attachments %q: %w", fs.Arg(0), err)
+	}
+	for _, attachment := range attachments {
+		if err := a.printer.PrintAttachment(attachment, ""); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// runDownload writes an attachment to a file, or to stdout with '-f -'. The file is only
+// created once the download was checked against the attachment's sha256.
+func runDownload(ctx context.Context, a *app, args []string) error {
+	fs := flag.NewFlagSet("download", flag.ContinueOnError)
+	out := fs.String("f", "", "write the attachment to `file`, or '-' for stdout; its file name in the current directory by default")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: client download [-f file] <attachment-id>")
+		fs.PrintDefaults()
+	}
+	if err := parseArgs(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return errUsage
+	}
+	id := fs.Arg(0)
+
+	if *out == "-" {
+		_, err := a.cli.DownloadAttachment(ctx, id, os.Stdout)
+		if err != nil {
+			return fmt.Errorf("download %q: %w", id, err)
+		}
+		return nil
+	}
+
+	dir := "."
+	if *out != "" {
+		dir = filepath.Dir(*out)
+	}
+	tmp, err := os.CreateTemp(dir, ".download-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	attachment, err := a.cli.DownloadAttachment(ctx, id, tmp)
+	if err != nil {
+		return fmt.Errorf("download %q: %w", id, err)
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	path := *out
+	if path == "" {
+		// The service rejects file names with directories, but do not trust it with the path.
+		path = filepath.Base(attachment.Filename)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return err
+	}
+	return a.printer.PrintAttachment(attachment, EVENT_DOWNLOADED)
+}
+
+// idArgs returns the passed post ids. A single '-' reads whitespace-separated ids from stdin.
+func idArgs(args []string) ([]string, error) {
+	if len(args) == 1 && args[0] == "-" {
+		return readIDs(os.Stdin)
+	}
+	if len(args) == 0 {
+		return nil, errors.New("no post ids given")
+	}
+	return args, nil
+}
+
+func readIDs(r io.Reader) ([]string, error) {
+	ids := []string{}
+	scanner := bufio.NewScanner(r)
+	scanner.Split(bufio.ScanWords)
+	for scanner.Scan() {
+		if id := strings.TrimSpace(scanner.Text()); id != "" {
+			ids = append(ids, id)
+		}
+	}
+	return ids, scanner.Err()
+}
@@ -0,0 +1,268 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	pb "go_grpc_example/proto"
+
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	// FORMAT_NDJSON is one protojson PostRecord per line.
+	FORMAT_NDJSON = "ndjson"
+	// FORMAT_PB is a stream of PostRecords, each prefixed with its varint length, like
+	// java's writeDelimitedTo.
+	FORMAT_PB = "pb"
+
+	MODE_SKIP   = "skip"
+	MODE_UPSERT = "upsert"
+
+	// maxRecordLen bounds a single record when reading, to fail fast on corrupt input.
+	maxRecordLen = 64 << 20
+)
+
+// recordWriter writes exported records in some format.
+type recordWriter interface {
+	Write(rec *pb.PostRecord) error
+	Flush() error
+}
+
+// recordReader reads records to import; Read returns io.EOF after the last record.
+type recordReader interface {
+	Read() (*pb.PostRecord, error)
+}
+
+// datasetFormat returns the format flag, or else the format implied by the file extension.
+func datasetFormat(format, path string) (string, error) {
+	switch format {
+	case FORMAT_NDJSON, FORMAT_PB:
+		return format, nil
+	case "":
+		switch filepath.Ext(path) {
+		case ".pb", ".bin", ".binpb":
+			return FORMAT_PB, nil
+		}
+		return FORMAT_NDJSON, nil
+	}
+	return "", fmt.Errorf("unknown format %q, must be %s or %s", format, FORMAT_NDJSON, FORMAT_PB)
+}
+
+func newRecordWriter(format string, w io.Writer) recordWriter {
+	bw := bufio.NewWriter(w)
+	if format == FORMAT_PB {
+		return &pbWriter{w: bw}
+	}
+	return &ndjsonWriter{w: bw}
+}
+
+func newRecordReader(format string, r io.Reader) recordReader {
+	br := bufio.NewReader(r)
+	if format == FORMAT_PB {
+		return &pbReader{r: br}
+	}
+	sc := bufio.NewScanner(br)
+	sc.Buffer(make([]byte, 0, 64*1024), maxRecordLen)
+	return &ndjsonReader{sc: sc}
+}
+
+type ndjsonWriter struct {
+	w *bufio.Writer
+}
+
+func (w *ndjsonWriter) Write(rec *pb.PostRecord) error {
+	b, err := protojson.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	w.w.Write(b)
+	return w.w.WriteByte('\n')
+}
+
+func (w *ndjsonWriter) Flush() error {
+	return w.w.Flush()
+}
+
+type ndjsonReader struct {
+	sc   *bufio.Scanner
+	line int
+}
+
+func (r *ndjsonReader) Read() (*pb.PostRecord, error) {
+	for r.sc.Scan() {
+		r.line++
+		if len(r.sc.Bytes()) == 0 {
+			continue
+		}
+
+		rec := &pb.PostRecord{}
+		if err := (protojson.UnmarshalOptions{DiscardUnknown: true}).Unmarshal(r.sc.Bytes(), rec); err != nil {
+			return nil, fmt.Errorf("line %d: %w", r.line, err)
+		}
+		return rec, nil
+	}
+	if err := r.sc.Err(); err != nil {
+		return nil, err
+	}
+	return nil, io.EOF
+}
+
+type pbWriter struct {
+	w   *bufio.Writer
+	buf [binary.MaxVarintLen64]byte
+}
+
+func (w *pbWriter) Write(rec *pb.PostRecord) error {
+	b, err := proto.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	n := binary.PutUvarint(w.buf[:], uint64(len(b)))
+	w.w.Write(w.buf[:n])
+	_, err = w.w.Write(b)
+	return err
+}
+
+func (w *pbWriter) Flush() error {
+	return w.w.Flush()
+}
+
+type pbReader struct {
+	r *bufio.Reader
+	n int
+}
+
+func (r *pbReader) Read() (*pb.PostRecord, error) {
+	size, err := binary.ReadUvarint(r.r)
+	if errors.Is(err, io.EOF) {
+		return nil, io.EOF
+	}
+	if err != nil {
+		return nil, fmt.Errorf("record %d: %w", r.n, err)
+	}
+	if size > maxRecordLen {
+		return nil, fmt.Errorf("record %d: length %d exceeds %d bytes", r.n, size, maxRecordLen)
+	}
+
+	b := make([]byte, size)
+	if _, err := io.ReadFull(r.r, b); err != nil {
+		return nil, fmt.Errorf("record %d: %w", r.n, err)
+	}
+
+	rec := &pb.PostRecord{}
+	if err := proto.Unmarshal(b, rec); err != nil {
+		return nil, fmt.Errorf("record %d: %w", r.n, err)
+	}
+	r.n++
+	return rec, nil
+}
+
+func runExport(ctx context.Context, a *app, args []string) error {
+	fs := flag.NewFlagSet("export", flag.ContinueOnError)
+	path := fs.String("f", "-", "write to `file`; '-' is stdout")
+	format := fs.String("format", "", "ndjson or pb (length-delimited protobuf); by default pb for .pb files, else ndjson")
+	deleted := fs.Bool("deleted", false, "include soft-deleted posts")
+	timestamps := fs.Bool("timestamps", false, "include created and updated timestamps")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: client export [flags]\n\nExport all posts. Large exports may need a longer global -timeout.")
+		fs.PrintDefaults()
+	}
+	if err := parseArgs(fs, args); err != nil {
+		return err
+	}
+
+	f, err := datasetFormat(*format, *path)
+	if err != nil {
+		return err
+	}
+
+	out := os.Stdout
+	if *path != "-" {
+		if out, err = os.Create(*path); err != nil {
+			return err
+		}
+		defer out.Close()
+	}
+
+	w := newRecordWriter(f, out)
+	n := 0
+	err = a.cli.ExportPosts(ctx, &pb.ExportPostsRequest{
+		IncludeDeleted:    *deleted,
+		IncludeTimestamps: *timestamps,
+	}, func(rec *pb.PostRecord) error {
+		n++
+		return w.Write(rec)
+	})
+	if err != nil {
+		return fmt.Errorf("export: %w", err)
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	if out != os.Stdout {
+		if err := out.Close(); err != nil {
+			return err
+		}
+	}
+
+	fmt.Fprintf(os.Stderr, "exported %d posts\n", n)
+	return nil
+}
+
+func runImport(ctx context.Context, a *app, args []string) error {
+	fs := flag.NewFlagSet("import", flag.ContinueOnError)
+	path := fs.String("f", "-", "read from `file`; '-' is stdin")
+	format := fs.String("format", "", "ndjson or pb (length-delimited protobuf); by default pb for .pb files, else ndjson")
+	mode := fs.String("mode", MODE_SKIP, "how to treat existing post-ids: skip keeps the existing post, upsert overwrites it")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: client import [flags]\n\nImport posts, such as those written by export. Imports are safe to rerun.")
+		fs.PrintDefaults()
+	}
+	if err := parseArgs(fs, args); err != nil {
+		return err
+	}
+
+	f, err := datasetFormat(*format, *path)
+	if err != nil {
+		return err
+	}
+
+	var conflictMode pb.ConflictMode
+	switch *mode {
+	case MODE_SKIP:
+		conflictMode = pb.ConflictMode_CONFLICT_MODE_SKIP
+	case MODE_UPSERT:
+		conflictMode = pb.ConflictMode_CONFLICT_MODE_UPSERT
+	default:
+		return fmt.Errorf("unknown mode %q, must be %s or %s", *mode, MODE_SKIP, MODE_UPSERT)
+	}
+
+	in := os.Stdin
+	if *path != "-" {
+		if in, err = os.Open(*path); err != nil {
+			return err
+		}
+		defer in.Close()
+	}
+
+	res, err := a.cli.ImportPosts(ctx, conflictMode, newRecordReader(f, in).Read)
+	if err != nil {
+		if res != nil {
+			fmt.Fprintf(os.Stderr, "committed before failing: %d created, %d updated, %d skipped; rerun with -mode skip to resume\n",
+				res.Created, res.Updated, res.Skipped)
+		}
+		return fmt.Errorf("import: %w", err)
+	}
+
+	fmt.Fprintf(os.Stderr, "imported posts: %d created, %d updated, %d skipped\n", res.Created, res.Updated, res.Skipped)
+	return nil
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+
+	pb "go_grpc_example/proto"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+func TestDatasetFormats(t *testing.T) {
+	Convey("Dataset format tests", t, func() {
+		recs := []*pb.PostRecord{
+			{Post: &pb.Post{Id: "1", Title: "one"}, CreatedAt: timestamppb.Now()},
+			{Post: &pb.Post{Id: "2", FullText: "line one\nline two"}, DeletedAt: timestamppb.Now()},
+		}
+
+		for _, format := range []string{FORMAT_NDJSON, FORMAT_PB} {
+			format := format
+			Convey("Records round-trip through "+format, func() {
+				buf := &bytes.Buffer{}
+				w := newRecordWriter(format, buf)
+				for _, rec := range recs {
+					So(w.Write(rec), ShouldBeNil)
+				}
+				So(w.Flush(), ShouldBeNil)
+
+				r := newRecordReader(format, buf)
+				for _, want := range recs {
+					got, err := r.Read()
+					So(err, ShouldBeNil)
+					So(proto.Equal(got, want), ShouldBeTrue)
+				}
+				_, err := r.Read()
+				So(errors.Is(err, io.EOF), ShouldBeTrue)
+			})
+		}
+
+		Convey("Truncated pb input is an error rather than EOF", func() {
+			buf := &bytes.Buffer{}
+			w := newRecordWriter(FORMAT_PB, buf)
+			So(w.Write(recs[0]), ShouldBeNil)
+			So(w.Flush(), ShouldBeNil)
+
+			r := newRecordReader(FORMAT_PB, bytes.NewReader(buf.Bytes()[:buf.Len()-1]))
+			_, err := r.Read()
+			So(err, ShouldNotBeNil)
+			So(errors.Is(err, io.EOF), ShouldBeFalse)
+		})
+
+		Convey("The format defaults by file extension", func() {
+			f, err := datasetFormat("", "posts.pb")
+			So(err, ShouldBeNil)
+			So(f, ShouldEqual, FORMAT_PB)
+			f, err = datasetFormat("", "-")
+			So(err, ShouldBeNil)
+			So(f, ShouldEqual, FORMAT_NDJSON)
+			_, err = datasetFormat("csv", "-")
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
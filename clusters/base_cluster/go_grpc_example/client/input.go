@@ -0,0 +1,173 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"gopkg.in/yaml.v3"
+
+	pb "go_grpc_example/proto"
+)
+
+// readPostsFile reads posts from the passed file path, or from stdin if path is "-".
+func readPostsFile(path string) ([]*pb.Post, error) {
+	if path == "-" {
+		return readPosts(os.Stdin)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	posts, err := readPosts(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return posts, nil
+}
+
+// readPosts decodes posts from r. Accepted formats are a single json object,
+// a json array of objects, newline-delimited json, or one or more yaml documents.
+// Field names may be given in either proto (author_id) or json (authorId) form.
+func readPosts(r io.Reader) ([]*pb.Post, error) {
+	br := bufio.NewReader(r)
+	first, err := peekNonSpace(br)
+	if errors.Is(err, io.EOF) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if first == '{' || first == '[' {
+		return readJSONPosts(br)
+	}
+	return readYAMLPosts(br)
+}
+
+func peekNonSpace(br *bufio.Reader) (byte, error) {
+	for {
+		b, err := br.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+		switch b {
+		case ' ', '\t', '\r', '\n':
+			continue
+		}
+		return b, br.UnreadByte()
+	}
+}
+
+func readJSONPosts(r io.Reader) ([]*pb.Post, error) {
+	posts := []*pb.Post{}
+	dec := json.NewDecoder(r)
+	for {
+		var raw json.RawMessage
+		err := dec.Decode(&raw)
+		if errors.Is(err, io.EOF) {
+			return posts, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid json: %w", err)
+		}
+
+		raw = bytes.TrimSpace(raw)
+		if len(raw) > 0 && raw[0] == '[' {
+			var items []json.RawMessage
+			if err := json.Unmarshal(raw, &items); err != nil {
+				return nil, fmt.Errorf("invalid json array: %w", err)
+			}
+			for _, item := range items {
+				post, err := decodePost(item)
+				if err != nil {
+					return nil, err
+				}
+				posts = append(posts, post)
+			}
+			continue
+		}
+
+		post, err := decodePost(raw)
+		if err != nil {
+			return nil, err
+		}
+		posts = append(posts, post)
+	}
+}
+
+func readYAMLPosts(r io.Reader) ([]*pb.Post, error) {
+	posts := []*pb.Post{}
+	dec := yaml.NewDecoder(r)
+	for {
+		var doc interface{}
+		err := dec.Decode(&doc)
+		if errors.Is(err, io.EOF) {
+			return posts, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid yaml: %w", err)
+		}
+
+		// A yaml document may hold a single post or a list of them.
+		items, ok := doc.([]interface{})
+		if !ok {
+			items = []interface{}{doc}
+		}
+		for _, item := range items {
+			// Round-trip through json so that protojson handles the field naming.
+			raw, err := json.Marshal(stringifyScalars(item))
+			if err != nil {
+				return nil, fmt.Errorf("invalid yaml post: %w", err)
+			}
+			post, err := decodePost(raw)
+			if err != nil {
+				return nil, err
+			}
+			posts = append(posts, post)
+		}
+	}
+}
+
+// stringifyScalars converts unquoted yaml scalars like 'id: 123' to strings for the string
+// fields of Post, since protojson will not coerce them. Other fields are left as parsed: a
+// status may be given by its number, and yaml parses a publish_at like 2024-01-02T15:04:05Z
+// as a time, which json marshals back to the same RFC 3339 string protojson expects.
+func stringifyScalars(item interface{}) interface{} {
+	fields, ok := item.(map[string]interface{})
+	if !ok {
+		return item
+	}
+	descs := (&pb.Post{}).ProtoReflect().Descriptor().Fields()
+	for k, v := range fields {
+		fd := descs.ByName(protoreflect.Name(k))
+		if fd == nil {
+			fd = descs.ByJSONName(k)
+		}
+		if fd == nil || fd.Kind() != protoreflect.StringKind {
+			continue
+		}
+		switch v.(type) {
+		case int, float64, bool:
+			fields[k] = fmt.Sprint(v)
+		}
+	}
+	return fields
+}
+
+func decodePost(raw []byte) (*pb.Post, error) {
+	post := &pb.Post{}
+	if err := protojson.Unmarshal(raw, post); err != nil {
+		return nil, fmt.Errorf("invalid post %s: %w", raw, err)
+	}
+	return post, nil
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+
+	pb "go_grpc_example/proto"
+)
+
+func TestReadPosts(t *testing.T) {
+	Convey("readPosts tests", t, func() {
+		Convey("When given a single json object", func() {
+			posts, err := readPosts(strings.NewReader(`{"id": "1", "author_id": "jose", "title": "Gone With the Wind"}`))
+			So(err, ShouldBeNil)
+			So(posts, ShouldHaveLength, 1)
+			So(posts[0].Id, ShouldEqual, "1")
+			So(posts[0].AuthorId, ShouldEqual, "jose")
+		})
+
+		Convey("When given a json array using json field names", func() {
+			posts, err := readPosts(strings.NewReader(`[{"id": "1", "authorId": "jose"}, {"id": "2", "fullText": "In the beginning..."}]`))
+			So(err, ShouldBeNil)
+			So(posts, ShouldHaveLength, 2)
+			So(posts[0].AuthorId, ShouldEqual, "jose")
+			So(posts[1].FullText, ShouldEqual, "In the beginning...")
+		})
+
+		Convey("When given newline-delimited json", func() {
+			posts, err := readPosts(strings.NewReader("{\"id\": \"1\"}\n{\"id\": \"2\"}\n\n{\"id\": \"3\"}\n"))
+			So(err, ShouldBeNil)
+			So(posts, ShouldHaveLength, 3)
+			So(posts[2].Id, ShouldEqual, "3")
+		})
+
+		Convey("When given multiple yaml documents", func() {
+			in := "id: 1\ntitle: first\n---\n- id: \"2\"\n- id: \"3\"\n  description: humpty dumpty\n"
+			posts, err := readPosts(strings.NewReader(in))
+			So(err, ShouldBeNil)
+			So(posts, ShouldHaveLength, 3)
+			So(posts[0].Id, ShouldEqual, "1")
+			So(posts[0].Title, ShouldEqual, "first")
+			So(posts[2].Description, ShouldEqual, "humpty dumpty")
+		})
+
+		Convey("When given yaml statuses and timestamps", func() {
+			in := "id: 1\nstatus: 2\npublish_at: 2030-01-02T15:04:05Z\n---\nid: 2\nstatus: POST_STATUS_DRAFT\n"
+			posts, err := readPosts(strings.NewReader(in))
+			So(err, ShouldBeNil)
+			So(posts, ShouldHaveLength, 2)
+			So(posts[0].Status, ShouldEqual, pb.PostStatus_POST_STATUS_SCHEDULED)
+			So(posts[0].PublishAt.AsTime().Year(), ShouldEqual, 2030)
+			So(posts[1].Status, ShouldEqual, pb.PostStatus_POST_STATUS_DRAFT)
+		})
+
+		Convey("When given an unknown field, an error is returned", func() {
+			_, err := readPosts(strings.NewReader(`{"id": "1", "bogus": true}`))
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("When given empty input, no posts are returned", func() {
+			posts, err := readPosts(strings.NewReader("  \n"))
+			So(err, ShouldBeNil)
+			So(posts, ShouldBeEmpty)
+		})
+	})
+}
+
+func TestOutputRoundTrip(t *testing.T) {
+	Convey("Posts printed as json or yaml can be read back in", t, func() {
+		posts := []*pb.Post{
+			{Id: "1", AuthorId: "jose", Title: "Gone With the Wind"},
+			{Id: "2", FullText: "In the beginning..."},
+		}
+
+		for _, format := range []string{OUTPUT_JSON, OUTPUT_YAML} {
+			buf := &bytes.Buffer{}
+			p, err := newPrinter(format, buf)
+			So(err, ShouldBeNil)
+			for _, post := range posts {
+				So(p.PrintPost(post, ""), ShouldBeNil)
+			}
+			So(p.Flush(), ShouldBeNil)
+
+			read, err := readPosts(buf)
+			So(err, ShouldBeNil)
+			So(read, ShouldHaveLength, len(posts))
+			So(read[0].Title, ShouldEqual, posts[0].Title)
+			So(read[1].FullText, ShouldEqual, posts[1].FullText)
+		}
+	})
+
+	Convey("Unknown output formats are rejected", t, func() {
+		_, err := newPrinter("xml", &bytes.Buffer{})
+		So(err, ShouldNotBeNil)
+	})
+}
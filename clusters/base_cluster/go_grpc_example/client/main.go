@@ -1,108 +1,268 @@
-// The Client implementation here is purely for manual testing and development.
-// It merely exercises the CRUD api of the gRPC Post service.
+// The client is a small command-line tool for poking at the gRPC Post service,
+// so that nobody has to edit and rebuild Go code just to create or read a post.
+//
+// Usage:
+//
+//	client      [global flags] <command> [command flags] [args]
+//
+// Commands:
+//
+//	create      create posts from flags, files, or stdin
+//	get         read one or more posts by post-id
+//	update      update posts from flags, files, or stdin
+//	delete      delete one or more posts by post-id
+//	list        list all published posts, and with -drafts your own drafts
+//	publish     publish posts now, or schedule them with -at
+//	unpublish   return posts to draft
+//	archive     archive posts
+//	watch       poll the post list and print posts as they are added, changed, or removed
+//	attach      attach a file to a post
+//	attachments list the attachments of a post
+//	download    download an attachment
+//	export      export all posts as ndjson or length-delimited protobuf
+//	import      import posts written by export
+//
+// Examples:
+//
+//	client -addr 127.0.0.1:8080 create -id 123 -author jose -title "Gone With the Wind"
+//	client -addr dns:///crud-headless.default.svc.cluster.local:80 list
+//	client -o yaml get 123
+//	cat posts.ndjson | client create -f -
+//	client -o table list
+//	client create -id 124 -author jose -title "Draft" -status draft
+//	client publish -at 2030-01-02T15:04:05Z 124
+//	client -tls -ca ./ssl/ca.crt -token $TOKEN delete 123
+//	client attach 123 ./cover.png
+//	client -timeout 10m export -deleted -timestamps -f posts.pb
 
 package main
 
 import (
 	"context"
-	"log"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
 	"time"
 
-	"google.golang.org/grpc"
-	"google.golang.org/grpc/codes"
-	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/credentials"
 	"google.golang.org/grpc/status"
 
-	pb "go_grpc_example/proto"
+	crud "go_grpc_example/crud_client"
 )
 
-var addr string = "127.0.0.1:80"
+const (
+	ENV_CLIENT_ADDR   = "CRUD_ADDR"
+	ENV_CLIENT_TOKEN  = "CRUD_TOKEN"
+	ENV_CLIENT_TENANT = "CRUD_TENANT"
+	ADDR_DEFAULT      = "127.0.0.1:80"
+	TIMEOUT_DEFAULT   = 10 * time.Second
+)
+
+// errUsage is returned when a command is invoked with bad arguments; the usage has already been printed.
+var errUsage = errors.New("usage error")
 
-func readPost(c pb.CrudServiceClient, postId *pb.PostID) *pb.Post {
-	log.Println("readPost was invoked")
+// options are the global flags shared by every command.
+type options struct {
+	addr       string
+	useTLS     bool
+	caFile     string
+	serverName string
+	token      string
+	tenant     string
+	timeout    time.Duration
+	output     string
+}
 
-	res, err := c.ReadPost(context.Background(), postId)
-	logErr(err)
+// command is a single cli verb such as 'create' or 'list'.
+type command struct {
+	name  string
+	usage string
+	run   func(ctx context.Context, app *app, args []string) error
+}
 
-	log.Printf("ReadPost response: %v\n", res)
-	return res
+// app binds together the connected client, the global options, and the output printer.
+type app struct {
+	opts    options
+	cli     *crud.Client
+	printer printer
 }
 
-func deletePost(c pb.CrudServiceClient, postId *pb.PostID) bool {
-	_, err := c.DeletePost(context.Background(), postId)
-	logErr(err)
-	return err == nil
+var commands = []command{
+	{name: "create", usage: "create posts from flags, files, or stdin", run: runCreate},
+	{name: "get", usage: "read one or more posts by post-id", run: runGet},
+	{name: "update", usage: "update posts from flags, files, or stdin", run: runUpdate},
+	{name: "delete", usage: "delete one or more posts by post-id", run: runDelete},
+	{name: "list", usage: "list all published posts, and with -drafts your own drafts", run: runList},
+	{name: "publish", usage: "publish posts now, or schedule them with -at", run: runPublish},
+	{name: "unpublish", usage: "return posts to draft", run: runUnpublish},
+	{name: "archive", usage: "archive posts", run: runArchive},
+	{name: "watch", usage: "poll the post list and print added, changed, and removed posts", run: runWatch},
+	{name: "attach", usage: "attach a file to a post", run: runAttach},
+	{name: "attachments", usage: "list the attachments of a post", run: runAttachments},
+	{name: "download", usage: "download an attachment", run: runDownload},
+	{name: "export", usage: "export all posts as ndjson or length-delimited protobuf", run: runExport},
+	{name: "import", usage: "import posts written by export", run: runImport},
 }
 
-func updatePost(c pb.CrudServiceClient, post *pb.Post) bool {
-	_, err := c.UpdatePost(context.Background(), post)
-	logErr(err)
-	return err == nil
+func main() {
+	if err := run(os.Args[1:]); err != nil {
+		if !errors.Is(err, errUsage) {
+			printErr(err)
+		}
+		os.Exit(1)
+	}
 }
 
-func createPost(c pb.CrudServiceClient) *pb.PostID {
-	log.Println("createPost was invoked")
+func run(args []string) error {
+	opts := options{}
+	fs := flag.NewFlagSet("client", flag.ContinueOnError)
+	fs.StringVar(&opts.addr, "addr", envOrDefault(ENV_CLIENT_ADDR, ADDR_DEFAULT), "service `address` host:port, a comma-separated list of them, or a dns:/// target, balanced round robin (env "+ENV_CLIENT_ADDR+")")
+	fs.BoolVar(&opts.useTLS, "tls", false, "connect using TLS")
+	fs.StringVar(&opts.caFile, "ca", "", "CA certificate `file` used to verify the server; the system pool is used if empty")
+	fs.StringVar(&opts.serverName, "server-name", "", "override the TLS server name, e.g. 'localhost' for the certs generated by ssl.sh")
+	fs.StringVar(&opts.token, "token", os.Getenv(ENV_CLIENT_TOKEN), "bearer token sent in the authorization header (env "+ENV_CLIENT_TOKEN+")")
+	fs.StringVar(&opts.tenant, "tenant", os.Getenv(ENV_CLIENT_TENANT), "tenant sent in the x-tenant-id header; ignored by services taking it from the token (env "+ENV_CLIENT_TENANT+")")
+	fs.DurationVar(&opts.timeout, "timeout", TIMEOUT_DEFAULT, "deadline for each rpc; 0 disables the deadline")
+	fs.StringVar(&opts.output, "o", "json", "output format: json, yaml, or table")
+	fs.Usage = func() { usage(fs) }
 
-	res, err := c.CreatePost(context.Background(), &pb.Post{
-		Id:          "321123",
-		AuthorId:    "Jose",
-		Title:       "Gone With the Wind",
-		Description: "Humpty dumpy",
-		FullText:    "In the beginning...",
-	})
-	logErr(err)
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return nil
+		}
+		return errUsage
+	}
 
-	log.Printf("CreatePost response: %v\n", res)
-	return res
-}
+	if fs.NArg() == 0 {
+		usage(fs)
+		return errUsage
+	}
 
-func logErr(err error) {
-	if err == nil {
-		return
+	cmd, ok := findCommand(fs.Arg(0))
+	if !ok {
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n", fs.Arg(0))
+		usage(fs)
+		return errUsage
 	}
 
-	e, ok := status.FromError(err)
-	if ok {
-		log.Printf("Error message from server: %v\n", e.Message())
-		log.Println("Code: ", e.Code())
-		log.Println("Error: ", e.String())
-		if e.Code() == codes.InvalidArgument {
-			log.Println("We probably sent a negative number!")
+	p, err := newPrinter(opts.output, os.Stdout)
+	if err != nil {
+		return err
+	}
+
+	cli, err := dial(&opts)
+	if err != nil {
+		return fmt.Errorf("did not connect: %w", err)
+	}
+	defer cli.Close()
+
+	a := &app{
+		opts:    opts,
+		cli:     cli,
+		printer: p,
+	}
+
+	ctx, stop := signalContext()
+	defer stop()
+
+	if err := cmd.run(ctx, a, fs.Args()[1:]); err != nil {
+		return err
+	}
+	return p.Flush()
+}
+
+func findCommand(name string) (command, bool) {
+	for _, cmd := range commands {
+		if cmd.name == name {
+			return cmd, true
 		}
-	} else {
-		log.Printf("A non gRPC error: %v\n", err)
 	}
-	log.Fatalf("client dying a cowardly end :(")
+	return command{}, false
 }
 
-func main() {
-	conn, err := grpc.Dial(addr, grpc.WithTransportCredentials(insecure.NewCredentials()))
-	if err != nil {
-		log.Fatalf("Did not connect: %v\n", err)
+func usage(fs *flag.FlagSet) {
+	out := fs.Output()
+	fmt.Fprintf(out, "Usage: client [global flags] <command> [command flags] [args]\n\nCommands:\n")
+	for _, cmd := range commands {
+		fmt.Fprintf(out, "  %-11s %s\n", cmd.name, cmd.usage)
+	}
+	fmt.Fprintf(out, "\nGlobal flags:\n")
+	fs.PrintDefaults()
+	fmt.Fprintf(out, "\nRun 'client <command> -h' for command flags.\n")
+}
+
+// dial connects to the service per the passed options. The connection is not
+// blocking, so connection errors surface on the first rpc.
+func dial(opts *options) (*crud.Client, error) {
+	clientOpts := []crud.Option{
+		crud.WithTimeout(opts.timeout),
+		crud.WithListTimeout(opts.timeout),
+		crud.WithIdempotencyKeys(),
+		crud.WithRoundRobin(),
+		crud.WithHealthChecks(),
+	}
+
+	if opts.useTLS {
+		creds, err := transportCreds(opts.caFile, opts.serverName)
+		if err != nil {
+			return nil, err
+		}
+		clientOpts = append(clientOpts, crud.WithTransportCredentials(creds))
+	}
+
+	if opts.token != "" {
+		if !opts.useTLS {
+			fmt.Fprintln(os.Stderr, "Warning: sending token without TLS. This is only acceptable inside a mesh that encrypts traffic.")
+		}
+		clientOpts = append(clientOpts, crud.WithToken(opts.token))
 	}
 
-	defer conn.Close()
-	cli := pb.NewCrudServiceClient(conn)
+	if opts.tenant != "" {
+		clientOpts = append(clientOpts, crud.WithTenant(opts.tenant))
+	}
 
-	postId := createPost(cli)
-	post := readPost(cli, postId)
+	target := opts.addr
+	if strings.Contains(target, ",") {
+		target = crud.StaticTarget(strings.Split(target, ",")...)
+	}
+	return crud.Dial(target, clientOpts...)
+}
 
-	post.Description = post.Description + " " + time.Now().Format(time.RFC3339)
-	if updatePost(cli, post) {
-		log.Println("post updated successfully")
-	} else {
-		log.Println("post update FAILED")
+func transportCreds(caFile, serverName string) (credentials.TransportCredentials, error) {
+	if caFile == "" {
+		return credentials.NewClientTLSFromCert(nil, serverName), nil
 	}
 
-	_ = readPost(cli, postId)
+	creds, err := credentials.NewClientTLSFromFile(caFile, serverName)
+	if err != nil {
+		return nil, fmt.Errorf("unable to load ca cert %s: %w", caFile, err)
+	}
+	return creds, nil
+}
 
-	if deletePost(cli, postId) {
-		log.Printf("deletion of %s succeeded\n", postId)
-	} else {
-		log.Printf("deletion of %s FAILED\n", postId)
-		return
+// printErr prints the error and, for errors returned by the server, its status code.
+func printErr(err error) {
+	fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+
+	var se interface{ GRPCStatus() *status.Status }
+	if errors.As(err, &se) {
+		fmt.Fprintf(os.Stderr, "Code: %s\n", se.GRPCStatus().Code())
 	}
+}
 
-	// This is expected to fail, if the post was successfully deleted
-	_ = readPost(cli, postId)
+// signalContext returns a context that is cancelled on interrupt, so that
+// streaming commands like 'watch' exit cleanly.
+func signalContext() (context.Context, context.CancelFunc) {
+	return signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+}
+
+func envOrDefault(envVar, defaultVal string) string {
+	if val := os.Getenv(envVar); val != "" {
+		return val
+	}
+	return defaultVal
 }
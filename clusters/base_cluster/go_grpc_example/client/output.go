@@ -0,0 +1,247 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"gopkg.in/yaml.v3"
+
+	pb "go_grpc_example/proto"
+)
+
+const (
+	OUTPUT_JSON  = "json"
+	OUTPUT_YAML  = "yaml"
+	OUTPUT_TABLE = "table"
+
+	// maxCellLen truncates long fields like full_text in table output.
+	maxCellLen = 40
+)
+
+// printer writes command results in one of the supported output formats.
+type printer interface {
+	// PrintPost writes a single post. The event is optional and is used by 'watch'
+	// to mark posts as added, changed, or removed.
+	PrintPost(post *pb.Post, event string) error
+	// PrintID writes the id of a post that was created, updated, or deleted.
+	PrintID(id, event string) error
+	// PrintAttachment writes the metadata of an attachment; the event is optional.
+	PrintAttachment(a *pb.Attachment, event string) error
+	// Flush writes any buffered output.
+	Flush() error
+}
+
+func newPrinter(format string, w io.Writer) (printer, error) {
+	switch format {
+	case OUTPUT_JSON:
+		return &jsonPrinter{w: w}, nil
+	case OUTPUT_YAML:
+		return &yamlPrinter{w: w}, nil
+	case OUTPUT_TABLE:
+		return newTablePrinter(w), nil
+	}
+	return nil, fmt.Errorf("unknown output format %q, expected one of: %s, %s, %s",
+		format, OUTPUT_JSON, OUTPUT_YAML, OUTPUT_TABLE)
+}
+
+// messageFields returns a post or attachment as a generic map using proto field names,
+// the same names accepted as input.
+func messageFields(m proto.Message, event string) (map[string]interface{}, error) {
+	b, err := protojson.MarshalOptions{UseProtoNames: true, EmitUnpopulated: true}.Marshal(m)
+	if err != nil {
+		return nil, err
+	}
+
+	fields := map[string]interface{}{}
+	if err := json.Unmarshal(b, &fields); err != nil {
+		return nil, err
+	}
+	if event != "" {
+		fields["event"] = event
+	}
+	return fields, nil
+}
+
+// jsonPrinter writes newline-delimited json, which can be piped straight back into 'create -f -'.
+type jsonPrinter struct {
+	w io.Writer
+}
+
+func (p *jsonPrinter) PrintPost(post *pb.Post, event string) error {
+	if event == "" {
+		b, err := protojson.MarshalOptions{UseProtoNames: true}.Marshal(post)
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintln(p.w, string(b))
+		return err
+	}
+
+	fields, err := messageFields(post, event)
+	if err != nil {
+		return err
+	}
+	return json.NewEncoder(p.w).Encode(fields)
+}
+
+func (p *jsonPrinter) PrintID(id, event string) error {
+	return json.NewEncoder(p.w).Encode(map[string]string{"id": id, "event": event})
+}
+
+func (p *jsonPrinter) PrintAttachment(a *pb.Attachment, event string) error {
+	fields, err := messageFields(a, event)
+	if err != nil {
+		return err
+	}
+	return json.NewEncoder(p.w).Encode(fields)
+}
+
+func (p *jsonPrinter) Flush() error { return nil }
+
+// yamlPrinter writes one yaml document per result.
+type yamlPrinter struct {
+	w     io.Writer
+	count int
+}
+
+func (p *yamlPrinter) write(v interface{}) error {
+	if p.count > 0 {
+		if _, err := fmt.Fprintln(p.w, "---"); err != nil {
+			return err
+		}
+	}
+	p.count++
+
+	b, err := yaml.Marshal(v)
+	if err != nil {
+		return err
+	}
+	_, err = p.w.Write(b)
+	return err
+}
+
+func (p *yamlPrinter) PrintPost(post *pb.Post, event string) error {
+	fields, err := messageFields(post, event)
+	if err != nil {
+		return err
+	}
+	return p.write(fields)
+}
+
+func (p *yamlPrinter) PrintID(id, event string) error {
+	return p.write(map[string]string{"id": id, "event": event})
+}
+
+func (p *yamlPrinter) PrintAttachment(a *pb.Attachment, event string) error {
+	fields, err := messageFields(a, event)
+	if err != nil {
+		return err
+	}
+	return p.write(fields)
+}
+
+func (p *yamlPrinter) Flush() error { return nil }
+
+// tablePrinter writes aligned columns. Output is buffered until Flush so that
+// the columns can be aligned, except for 'watch' where rows are flushed as they arrive.
+type tablePrinter struct {
+	tw         *tabwriter.Writer
+	wroteHdr   bool
+	withEvent  bool
+	wroteIDHdr bool
+	// wroteAttachmentHdr is set once the attachment columns were written.
+	wroteAttachmentHdr bool
+}
+
+func newTablePrinter(w io.Writer) *tablePrinter {
+	return &tablePrinter{
+		tw: tabwriter.NewWriter(w, 0, 4, 2, ' ', 0),
+	}
+}
+
+func (p *tablePrinter) PrintPost(post *pb.Post, event string) error {
+	// The event column is only shown by 'watch', whose every row has an event.
+	if !p.wroteHdr {
+		p.wroteHdr = true
+		p.withEvent = event != ""
+		hdr := "ID\tAUTHOR\tTITLE\tSTATUS\tDESCRIPTION\tFULL TEXT"
+		if p.withEvent {
+			hdr = "EVENT\t" + hdr
+		}
+		if _, err := fmt.Fprintln(p.tw, hdr); err != nil {
+			return err
+		}
+	}
+
+	row := fmt.Sprintf("%s\t%s\t%s\t%s\t%s\t%s",
+		cell(post.Id),
+		cell(post.AuthorId),
+		cell(post.Title),
+		cell(statusText(post.Status)),
+		cell(post.Description),
+		cell(post.FullText))
+	if p.withEvent {
+		row = cell(event) + "\t" + row
+	}
+	if _, err := fmt.Fprintln(p.tw, row); err != nil {
+		return err
+	}
+
+	if p.withEvent {
+		return p.tw.Flush()
+	}
+	return nil
+}
+
+func (p *tablePrinter) PrintID(id, event string) error {
+	if !p.wroteIDHdr {
+		p.wroteIDHdr = true
+		if _, err := fmt.Fprintln(p.tw, "EVENT\tID"); err != nil {
+			return err
+		}
+	}
+	_, err := fmt.Fprintf(p.tw, "%s\t%s\n", cell(event), cell(id))
+	return err
+}
+
+func (p *tablePrinter) PrintAttachment(a *pb.Attachment, event string) error {
+	if !p.wroteAttachmentHdr {
+		p.wroteAttachmentHdr = true
+		if _, err := fmt.Fprintln(p.tw, "EVENT\tID\tPOST\tFILENAME\tTYPE\tSIZE"); err != nil {
+			return err
+		}
+	}
+	_, err := fmt.Fprintf(p.tw, "%s\t%s\t%s\t%s\t%s\t%d\n",
+		cell(event), cell(a.Id), cell(a.PostId), cell(a.Filename), cell(a.ContentType), a.Size)
+	return err
+}
+
+func (p *tablePrinter) Flush() error {
+	return p.tw.Flush()
+}
+
+// statusText returns the lower-case name of a post status, such as 'draft', or "" if unspecified.
+func statusText(s pb.PostStatus) string {
+	if s == pb.PostStatus_POST_STATUS_UNSPECIFIED {
+		return ""
+	}
+	return strings.ToLower(strings.TrimPrefix(s.String(), "POST_STATUS_"))
+}
+
+// cell sanitizes a field for a single table cell.
+func cell(s string) string {
+	if s == "" {
+		return "-"
+	}
+
+	s = strings.Join(strings.Fields(s), " ")
+	if r := []rune(s); len(r) > maxCellLen {
+		s = string(r[:maxCellLen-3]) + "..."
+	}
+	return s
+}
@@ -0,0 +1,85 @@
+package crud_client
+
+import (
+	"strings"
+	"time"
+
+	// Registers the client side of grpc health checking, used by WithHealthChecks.
+	_ "google.golang.org/grpc/health"
+	"google.golang.org/grpc/keepalive"
+	"google.golang.org/grpc/resolver"
+)
+
+const (
+	// StaticScheme is the scheme of static target lists, e.g. "static:///10.0.0.1:80,10.0.0.2:80".
+	StaticScheme = "static"
+	// DefaultKeepaliveTime is how long a connection may be idle before the client pings the
+	// service. It must not be below the service's KEEPALIVE_MIN_TIME, or the service closes
+	// the connection for pinging too often.
+	DefaultKeepaliveTime = 30 * time.Second
+	// DefaultKeepaliveTimeout is how long the client waits for a ping's ack before closing the
+	// connection, so that rpcs move to the other replicas.
+	DefaultKeepaliveTimeout = 10 * time.Second
+)
+
+func init() {
+	resolver.Register(staticBuilder{})
+}
+
+// StaticTarget returns the target dialing every one of addrs, each a host:port. Combine it with
+// WithRoundRobin to spread rpcs across them. Targets naming a dns name that resolves to each
+// replica, such as "dns:///crud-headless.default.svc.cluster.local:80" for a headless
+// kubernetes service, are dialed as is and pick up replicas as they are resolved.
+func StaticTarget(addrs ...string) string {
+	return StaticScheme + ":///" + strings.Join(addrs, ",")
+}
+
+// WithRoundRobin spreads rpcs across every address the target resolves to, rather than
+// sending them all to the first that connects.
+func WithRoundRobin() Option {
+	return func(c *config) { c.roundRobin = true }
+}
+
+// WithHealthChecks only sends rpcs to addresses whose grpc.health.v1.Health service reports
+// crud.CrudService as serving, watching each for changes, e.g. when a replica loses its db.
+// Services that do not serve health checks are treated as healthy.
+func WithHealthChecks() Option {
+	return func(c *config) { c.healthChecks = true }
+}
+
+// WithKeepalive pings the service after a connection has been idle for time, and closes the
+// connection unless the ping is acked within timeout, so that connections silently dropped,
+// e.g. by a load balancer or a dead replica, are noticed before the next rpc. Idle connections
+// without rpcs are pinged too.
+func WithKeepalive(time, timeout time.Duration) Option {
+	return func(c *config) {
+		c.keepalive = &keepalive.ClientParameters{Time: time, Timeout: timeout, PermitWithoutStream: true}
+	}
+}
+
+// staticBuilder resolves static target lists to their addresses, once.
+type staticBuilder struct{}
+
+func (staticBuilder) Build(target resolver.Target, cc resolver.ClientConn, _ resolver.BuildOptions) (resolver.Resolver, error) {
+	state := resolver.State{}
+	for _, addr := range strings.Split(target.Endpoint, ",") {
+		if addr = strings.TrimSpace(addr); addr != "" {
+			state.Addresses = append(state.Addresses, resolver.Address{Addr: addr})
+		}
+	}
+	if err := cc.UpdateState(state); err != nil {
+		return nil, err
+	}
+	return staticResolver{}, nil
+}
+
+func (staticBuilder) Scheme() string {
+	return StaticScheme
+}
+
+// staticResolver has nothing to re-resolve.
+type staticResolver struct{}
+
+func (staticResolver) ResolveNow(resolver.ResolveNowOptions) {}
+
+func (staticResolver) Close() {}
@@ -0,0 +1,97 @@
+package crud_client
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"testing"
+	"time"
+
+	pb "go_grpc_example/proto"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+// replica is a service listening on localhost, with its health server.
+type replica struct {
+	srv  *flakyServer
+	hs   *health.Server
+	addr string
+}
+
+func newReplica() (*replica, func()) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	So(err, ShouldBeNil)
+	r := &replica{srv: &flakyServer{calls: map[string]int{}}, hs: health.NewServer(), addr: lis.Addr().String()}
+	gs := grpc.NewServer()
+	pb.RegisterCrudServiceServer(gs, r.srv)
+	healthpb.RegisterHealthServer(gs, r.hs)
+	r.hs.SetServingStatus(serviceName, healthpb.HealthCheckResponse_SERVING)
+	go gs.Serve(lis)
+	return r, gs.Stop
+}
+
+func TestBalancing(t *testing.T) {
+	Convey("Load balancing tests", t, func() {
+		a, stopA := newReplica()
+		defer stopA()
+		b, stopB := newReplica()
+		defer stopB()
+		ctx := context.Background()
+
+		c, err := Dial(StaticTarget(a.addr, b.addr), WithRoundRobin(), WithHealthChecks(),
+			WithKeepalive(DefaultKeepaliveTime, DefaultKeepaliveTimeout))
+		So(err, ShouldBeNil)
+		defer c.Close()
+
+		// reads makes n reads, returning how many each replica served.
+		reads := func(n int) (int, int) {
+			beforeA, beforeB := a.srv.count("ReadPost"), b.srv.count("ReadPost")
+			for i := 0; i < n; i++ {
+				_, err := c.ReadPost(ctx, "1")
+				So(err, ShouldBeNil)
+			}
+			return a.srv.count("ReadPost") - beforeA, b.srv.count("ReadPost") - beforeB
+		}
+
+		Convey("Rpcs are spread across the static targets", func() {
+			// Until both connect, the first may take every rpc.
+			deadline := time.Now().Add(5 * time.Second)
+			for fromA, fromB := reads(10); fromA == 0 || fromB == 0; fromA, fromB = reads(10) {
+				So(time.Now(), ShouldHappenBefore, deadline)
+			}
+			fromA, fromB := reads(10)
+			So(fromA, ShouldEqual, 5)
+			So(fromB, ShouldEqual, 5)
+		})
+
+		Convey("Replicas reported as not serving are skipped", func() {
+			b.hs.SetServingStatus(serviceName, healthpb.HealthCheckResponse_NOT_SERVING)
+			deadline := time.Now().Add(5 * time.Second)
+			for _, fromB := reads(10); fromB > 0; _, fromB = reads(10) {
+				So(time.Now(), ShouldHappenBefore, deadline)
+			}
+			fromA, fromB := reads(10)
+			So(fromA, ShouldEqual, 10)
+			So(fromB, ShouldEqual, 0)
+
+			b.hs.SetServingStatus(serviceName, healthpb.HealthCheckResponse_SERVING)
+			for _, fromB := reads(10); fromB == 0; _, fromB = reads(10) {
+				So(time.Now(), ShouldHappenBefore, deadline)
+			}
+		})
+
+		Convey("The service config names the balancing policy and health checked service", func() {
+			cfg := serviceConfigJSON{}
+			So(json.Unmarshal([]byte(config{roundRobin: true, healthChecks: true}.serviceConfig()), &cfg), ShouldBeNil)
+			So(cfg.LoadBalancingConfig, ShouldHaveLength, 1)
+			So(cfg.LoadBalancingConfig[0], ShouldContainKey, "round_robin")
+			So(cfg.HealthCheckConfig.ServiceName, ShouldEqual, "crud.CrudService")
+
+			So(ServiceConfig(DefaultRetryPolicy), ShouldNotContainSubstring, "round_robin")
+		})
+	})
+}
@@ -0,0 +1,658 @@
+// Package crud_client is the supported Go client for the CrudService. It wraps the generated
+// pb.CrudServiceClient so that callers need not each build their own wrappers:
+//   - every rpc gets a default deadline, unless the caller's context already has one
+//   - idempotent rpcs are retried on Unavailable with exponential backoff and jitter,
+//     configured through the grpc service config
+//   - CreatePost is retried too when idempotency keys are enabled via WithIdempotencyKeys
+//   - interrupted ListPosts streams are reopened, without repeating posts already delivered
+//   - reads shortly after a write may be pinned to the primary db via WithReadYourWrites
+//   - rpcs may be balanced across the healthy replicas of a dns or static target list
+//   - errors are returned as *Error, which matches sentinels like ErrNotFound via errors.Is
+package crud_client
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	pb "go_grpc_example/proto"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/keepalive"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+const (
+	// TenantHeader is the metadata key the service reads the tenant from.
+	TenantHeader = "x-tenant-id"
+	// DefaultTimeout is the deadline applied to unary rpcs whose context has none.
+	DefaultTimeout = 10 * time.Second
+	// DefaultListTimeout is the deadline applied to a ListPosts call, including reconnects,
+	// and to ExportPosts and ImportPosts.
+	DefaultListTimeout = 5 * time.Minute
+	// UploadChunkSize is the size of the chunks UploadAttachment sends.
+	UploadChunkSize = 256 << 10
+)
+
+// Client is a CrudService client. It is safe for concurrent use.
+type Client struct {
+	conn  *grpc.ClientConn
+	owned bool
+	cli   pb.CrudServiceClient
+	cfg   config
+}
+
+type config struct {
+	timeout     time.Duration
+	listTimeout time.Duration
+	retry       RetryPolicy
+	creds       credentials.TransportCredentials
+	token       string
+	tenant      string
+	autoKeys    bool
+	// readYourWrites echoes primary pins back to the service; see WithReadYourWrites.
+	readYourWrites bool
+	// roundRobin, healthChecks, and keepalive configure load balancing; see balancing.go.
+	roundRobin   bool
+	healthChecks bool
+	keepalive    *keepalive.ClientParameters
+	dialOpts     []grpc.DialOption
+}
+
+// Option configures a Client.
+type Option func(*config)
+
+// WithTimeout sets the default deadline of unary rpcs; zero disables it.
+func WithTimeout(d time.Duration) Option {
+	return func(c *config) { c.timeout = d }
+}
+
+// WithListTimeout sets the default deadline of ListPosts; zero disables it.
+func WithListTimeout(d time.Duration) Option {
+	return func(c *config) { c.listTimeout = d }
+}
+
+// WithRetryPolicy replaces the DefaultRetryPolicy. A policy with MaxAttempts < 2 disables retries.
+func WithRetryPolicy(p RetryPolicy) Option {
+	return func(c *config) { c.retry = p }
+}
+
+// WithTransportCredentials sets the transport credentials, e.g. for TLS. The default is insecure,
+// which is the norm inside the mesh.
+func WithTransportCredentials(creds credentials.TransportCredentials) Option {
+	return func(c *config) { c.creds = creds }
+}
+
+// WithToken sends the token as a bearer token in the authorization header of every rpc.
+func WithToken(token string) Option {
+	return func(c *config) { c.token = token }
+}
+
+// WithTenant sends the tenant in the x-tenant-id header of every rpc. Services that take the
+// tenant from a bearer token ignore it, or reject it if it names a different tenant.
+func WithTenant(tenant string) Option {
+	return func(c *config) { c.tenant = tenant }
+}
+
+// WithIdempotencyKeys sends a random idempotency key with every CreatePost whose context has
+// none, which lets the service recognize retries, so CreatePost is retried like the other rpcs.
+func WithIdempotencyKeys() Option {
+	return func(c *config) { c.autoKeys = true }
+}
+
+// WithDialOptions appends raw grpc dial options, applied after the client's own.
+func WithDialOptions(opts ...grpc.DialOption) Option {
+	return func(c *config) { c.dialOpts = append(c.dialOpts, opts...) }
+}
+
+func newConfig(opts []Option) config {
+	cfg := config{
+		timeout:     DefaultTimeout,
+		listTimeout: DefaultListTimeout,
+		retry:       DefaultRetryPolicy,
+		creds:       insecure.NewCredentials(),
+	}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	return cfg
+}
+
+// Dial returns a Client connected to target, e.g. "host:port", a "dns:///" target, or a
+// StaticTarget. Like grpc.Dial the connection is made in the background, so an unreachable
+// target surfaces as ErrUnavailable on the first rpc.
+func Dial(target string, opts ...Option) (*Client, error) {
+	cfg := newConfig(opts)
+
+	dialOpts := []grpc.DialOption{
+		grpc.WithTransportCredentials(cfg.creds),
+		grpc.WithDefaultServiceConfig(cfg.serviceConfig()),
+	}
+	if cfg.token != "" {
+		dialOpts = append(dialOpts, grpc.WithPerRPCCredentials(&tokenAuth{
+			token:      cfg.token,
+			requireTLS: cfg.creds.Info().SecurityProtocol != "insecure",
+		}))
+	}
+	if cfg.tenant != "" {
+		dialOpts = append(dialOpts, grpc.WithPerRPCCredentials(tenantHeader(cfg.tenant)))
+	}
+	if cfg.keepalive != nil {
+		dialOpts = append(dialOpts, grpc.WithKeepaliveParams(*cfg.keepalive))
+	}
+	if cfg.readYourWrites {
+		pin := &primaryPin{}
+		dialOpts = append(dialOpts,
+			grpc.WithChainUnaryInterceptor(pin.unaryInterceptor()),
+			grpc.WithChainStreamInterceptor(pin.streamInterceptor()))
+	}
+	dialOpts = append(dialOpts, cfg.dialOpts...)
+
+	conn, err := grpc.Dial(target, dialOpts...)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Client{
+		conn:  conn,
+		owned: true,
+		cli:   pb.NewCrudServiceClient(conn),
+		cfg:   cfg,
+	}, nil
+}
+
+// New returns a Client using an existing connection, which Close will not close.
+// Retries are configured when dialing, so conn must have been dialed with
+// grpc.WithDefaultServiceConfig(ServiceConfig(policy)) for unary rpcs to be retried;
+// CreatePost is never retried on such a connection.
+func New(conn *grpc.ClientConn, opts ...Option) *Client {
+	return &Client{
+		conn: conn,
+		cli:  pb.NewCrudServiceClient(conn),
+		cfg:  newConfig(opts),
+	}
+}
+
+// Close closes the connection if it was opened by Dial.
+func (c *Client) Close() error {
+	if !c.owned {
+		return nil
+	}
+	return c.conn.Close()
+}
+
+// Raw returns the underlying generated client, for rpcs not wrapped by Client.
+func (c *Client) Raw() pb.CrudServiceClient {
+	return c.cli
+}
+
+// withTimeout applies d to ctx unless ctx already has a deadline or d is zero.
+func withTimeout(ctx context.Context, d time.Duration) (context.Context, context.CancelFunc) {
+	if _, ok := ctx.Deadline(); ok || d <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, d)
+}
+
+// CreatePost creates the post and returns its post-id. Without an idempotency key CreatePost is
+// not retried, since retrying a create that reached the service could create the post twice.
+// See WithIdempotencyKey and WithIdempotencyKeys.
+func (c *Client) CreatePost(ctx context.Context, post *pb.Post) (string, error) {
+	ctx, cancel := withTimeout(ctx, c.cfg.timeout)
+	defer cancel()
+
+	if c.cfg.autoKeys && !hasIdempotencyKey(ctx) {
+		key, err := NewIdempotencyKey()
+		if err != nil {
+			return "", err
+		}
+		ctx = WithIdempotencyKey(ctx, key)
+	}
+
+	res, err := c.cli.CreatePost(ctx, post)
+	if err != nil {
+		return "", wrapErr(err)
+	}
+	return res.Id, nil
+}
+
+// ReadPost returns the post with the passed post-id, or ErrNotFound.
+func (c *Client) ReadPost(ctx context.Context, id string) (*pb.Post, error) {
+	return c.ReadPostAs(ctx, id, pb.RenderFormat_RENDER_FORMAT_UNSPECIFIED)
+}
+
+// ReadPostAs is ReadPost in the render format; with RENDER_FORMAT_HTML, the post's Html and
+// Excerpt are set too.
+func (c *Client) ReadPostAs(ctx context.Context, id string, format pb.RenderFormat) (*pb.Post, error) {
+	ctx, cancel := withTimeout(ctx, c.cfg.timeout)
+	defer cancel()
+
+	post, err := c.cli.ReadPost(ctx, &pb.PostID{Id: id, Render: format})
+	if err != nil {
+		return nil, wrapErr(err)
+	}
+	return post, nil
+}
+
+// BatchGetPosts returns a result per id in the order passed, reporting whether each was found.
+// The service limits how many ids may be passed at once (100 by default), failing with
+// ErrInvalidArgument beyond it.
+func (c *Client) BatchGetPosts(ctx context.Context, ids ...string) ([]*pb.BatchGetPostsResult, error) {
+	ctx, cancel := withTimeout(ctx, c.cfg.timeout)
+	defer cancel()
+
+	res, err := c.cli.BatchGetPosts(ctx, &pb.BatchGetPostsRequest{Ids: ids})
+	if err != nil {
+		return nil, wrapErr(err)
+	}
+	return res.Results, nil
+}
+
+// PublishPost publishes the post now, or schedules it if at is in the future, and returns it.
+// A zero at publishes it now.
+func (c *Client) PublishPost(ctx context.Context, id string, at time.Time) (*pb.Post, error) {
+	ctx, cancel := withTimeout(ctx, c.cfg.timeout)
+	defer cancel()
+
+	req := &pb.PublishPostRequest{Id: id}
+	if !at.IsZero() {
+		req.PublishAt = timestamppb.New(at)
+	}
+	post, err := c.cli.PublishPost(ctx, req)
+	if err != nil {
+		return nil, wrapErr(err)
+	}
+	return post, nil
+}
+
+// UnpublishPost returns the post to draft and returns it.
+func (c *Client) UnpublishPost(ctx context.Context, id string) (*pb.Post, error) {
+	ctx, cancel := withTimeout(ctx, c.cfg.timeout)
+	defer cancel()
+
+	post, err := c.cli.UnpublishPost(ctx, &pb.PostID{Id: id})
+	if err != nil {
+		return nil, wrapErr(err)
+	}
+	return post, nil
+}
+
+// ArchivePost archives the post and returns it.
+func (c *Client) ArchivePost(ctx context.Context, id string) (*pb.Post, error) {
+	ctx, cancel := withTimeout(ctx, c.cfg.timeout)
+	defer cancel()
+
+	post, err := c.cli.ArchivePost(ctx, &pb.PostID{Id: id})
+	if err != nil {
+		return nil, wrapErr(err)
+	}
+	return post, nil
+}
+
+// UpdatePost updates the non-empty fields of the post with the same post-id, or returns ErrNotFound.
+func (c *Client) UpdatePost(ctx context.Context, post *pb.Post) error {
+	ctx, cancel := withTimeout(ctx, c.cfg.timeout)
+	defer cancel()
+
+	_, err := c.cli.UpdatePost(ctx, post)
+	return wrapErr(err)
+}
+
+// DeletePost deletes the post with the passed post-id.
+func (c *Client) DeletePost(ctx context.Context, id string) error {
+	ctx, cancel := withTimeout(ctx, c.cfg.timeout)
+	defer cancel()
+
+	_, err := c.cli.DeletePost(ctx, &pb.PostID{Id: id})
+	return wrapErr(err)
+}
+
+// callbackErr wraps errors returned by the ListPosts callback, to tell them apart from stream errors.
+type callbackErr struct {
+	err error
+}
+
+func (e *callbackErr) Error() string { return e.err.Error() }
+
+// ListPosts calls fn for every published post, and the caller's own drafts if req asks for them;
+// req may be nil. If the stream is interrupted with Unavailable, it is
+// reopened per the retry policy and posts already passed to fn are skipped, so fn sees each
+// post-id at most once. Posts created or deleted while reconnecting may or may not be seen.
+// If fn returns an error, listing stops and that error is returned as-is.
+func (c *Client) ListPosts(ctx context.Context, req *pb.ListPostsRequest, fn func(*pb.Post) error) error {
+	ctx, cancel := withTimeout(ctx, c.cfg.listTimeout)
+	defer cancel()
+
+	if req == nil {
+		req = &pb.ListPostsRequest{}
+	}
+
+	seen := map[string]struct{}{}
+	failures := 0
+	for {
+		delivered, err := c.listOnce(ctx, req, seen, fn)
+		if err == nil {
+			return nil
+		}
+
+		var cbErr *callbackErr
+		if errors.As(err, &cbErr) {
+			return cbErr.err
+		}
+
+		// Only consecutive failures without progress count against the policy.
+		if delivered > 0 {
+			failures = 0
+		}
+		failures++
+		if status.Code(err) != codes.Unavailable || failures >= c.cfg.retry.MaxAttempts {
+			return wrapErr(err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return wrapErr(status.FromContextError(ctx.Err()).Err())
+		case <-time.After(c.cfg.retry.backoff(failures)):
+		}
+	}
+}
+
+// listOnce opens a single ListPosts stream and drains it, returning the number of new posts delivered.
+func (c *Client) listOnce(ctx context.Context, req *pb.ListPostsRequest, seen map[string]struct{}, fn func(*pb.Post) error) (int, error) {
+	stream, err := c.cli.ListPosts(ctx, req)
+	if err != nil {
+		return 0, err
+	}
+
+	delivered := 0
+	for {
+		post, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			return delivered, nil
+		}
+		if err != nil {
+			return delivered, err
+		}
+
+		if _, ok := seen[post.Id]; ok {
+			continue
+		}
+		seen[post.Id] = struct{}{}
+		delivered++
+
+		if err := fn(post); err != nil {
+			return delivered, &callbackErr{err: err}
+		}
+	}
+}
+
+// ListAllPosts returns every listed post; see ListPosts.
+func (c *Client) ListAllPosts(ctx context.Context, req *pb.ListPostsRequest) ([]*pb.Post, error) {
+	posts := []*pb.Post{}
+	err := c.ListPosts(ctx, req, func(post *pb.Post) error {
+		posts = append(posts, post)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return posts, nil
+}
+
+// ExportPosts calls fn for every exported record. Unlike ListPosts, an interrupted export is not
+// resumed, since it is usually written to a file that would then be incomplete anyway.
+// If fn returns an error, exporting stops and that error is returned as-is.
+func (c *Client) ExportPosts(ctx context.Context, req *pb.ExportPostsRequest, fn func(*pb.PostRecord) error) error {
+	ctx, cancel := withTimeout(ctx, c.cfg.listTimeout)
+	defer cancel()
+
+	stream, err := c.cli.ExportPosts(ctx, req)
+	if err != nil {
+		return wrapErr(err)
+	}
+
+	for {
+		rec, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return wrapErr(err)
+		}
+
+		if err := fn(rec); err != nil {
+			return err
+		}
+	}
+}
+
+// ImportPosts streams the records returned by next until it returns io.EOF, and returns the
+// service's counts. Any other error from next aborts the import and is returned as-is, though
+// batches the service already committed remain. If the service fails the import after committing
+// batches, their counts are returned along with the error. Imports are not retried, but are safe
+// to rerun, e.g. with CONFLICT_MODE_SKIP to resume one.
+func (c *Client) ImportPosts(ctx context.Context, mode pb.ConflictMode, next func() (*pb.PostRecord, error)) (*pb.ImportPostsResponse, error) {
+	ctx, cancel := withTimeout(ctx, c.cfg.listTimeout)
+	defer cancel()
+
+	stream, err := c.cli.ImportPosts(ctx)
+	if err != nil {
+		return nil, wrapErr(err)
+	}
+
+	for first := true; ; first = false {
+		rec, err := next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			// Cancel so the service aborts, rather than committing a partial final batch.
+			cancel()
+			return nil, err
+		}
+
+		req := &pb.ImportPostsRequest{Record: rec}
+		if first {
+			req.ConflictMode = mode
+		}
+		if err := stream.Send(req); err != nil {
+			// The service ended the stream; its status is returned by CloseAndRecv.
+			break
+		}
+	}
+
+	res, err := stream.CloseAndRecv()
+	if err != nil {
+		return committedCounts(err), wrapErr(err)
+	}
+	return res, nil
+}
+
+// committedCounts returns the counts of the batches a failed import committed, or nil.
+func committedCounts(err error) *pb.ImportPostsResponse {
+	for _, detail := range status.Convert(err).Details() {
+		if res, ok := detail.(*pb.ImportPostsResponse); ok {
+			return res
+		}
+	}
+	return nil
+}
+
+// UploadAttachment attaches the contents of r to the post. The content is read twice: once to
+// compute the size and sha256 the service checks the upload against, and once to send it.
+// An empty contentType is detected from the content. Uploads are not retried.
+func (c *Client) UploadAttachment(ctx context.Context, postID, filename, contentType string, r io.ReadSeeker) (*pb.Attachment, error) {
+	hash := sha256.New()
+	head := make([]byte, 512)
+	n, err := io.ReadFull(r, head)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+	hash.Write(head[:n])
+	rest, err := io.Copy(hash, r)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	if contentType == "" {
+		contentType = http.DetectContentType(head[:n])
+	}
+
+	ctx, cancel := withTimeout(ctx, c.cfg.listTimeout)
+	defer cancel()
+
+	stream, err := c.cli.UploadAttachment(ctx)
+	if err != nil {
+		return nil, wrapErr(err)
+	}
+
+	meta := &pb.AttachmentMetadata{
+		PostId:      postID,
+		Filename:    filename,
+		ContentType: contentType,
+		Size:        int64(n) + rest,
+		Sha256:      hex.EncodeToString(hash.Sum(nil)),
+	}
+	req := &pb.UploadAttachmentRequest{Data: &pb.UploadAttachmentRequest_Metadata{Metadata: meta}}
+	buf := make([]byte, UploadChunkSize)
+	for {
+		if err := stream.Send(req); err != nil {
+			// The service ended the stream; its status is returned by CloseAndRecv.
+			break
+		}
+
+		n, err := io.ReadFull(r, buf)
+		if n == 0 && errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) {
+			// Cancel so the service aborts, rather than storing a truncated attachment.
+			cancel()
+			return nil, err
+		}
+		req = &pb.UploadAttachmentRequest{Data: &pb.UploadAttachmentRequest_Chunk{Chunk: buf[:n]}}
+	}
+
+	attachment, err := stream.CloseAndRecv()
+	if err != nil {
+		return nil, wrapErr(err)
+	}
+	return attachment, nil
+}
+
+// DownloadAttachment writes the content of the attachment to w and returns the attachment,
+// after checking the content against the attachment's size and sha256.
+func (c *Client) DownloadAttachment(ctx context.Context, id string, w io.Writer) (*pb.Attachment, error) {
+	ctx, cancel := withTimeout(ctx, c.cfg.listTimeout)
+	defer cancel()
+
+	stream, err := c.cli.DownloadAttachment(ctx, &pb.AttachmentID{Id: id})
+	if err != nil {
+		return nil, wrapErr(err)
+	}
+
+	res, err := stream.Recv()
+	if err != nil {
+		return nil, wrapErr(err)
+	}
+	attachment := res.GetAttachment()
+	if attachment == nil {
+		return nil, errors.New("download did not start with the attachment")
+	}
+
+	hash := sha256.New()
+	out := io.MultiWriter(w, hash)
+	var size int64
+	for {
+		res, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, wrapErr(err)
+		}
+		n, err := out.Write(res.GetChunk())
+		size += int64(n)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if size != attachment.Size || hex.EncodeToString(hash.Sum(nil)) != attachment.Sha256 {
+		return nil, fmt.Errorf("attachment %s: downloaded content does not match its size and sha256", id)
+	}
+	return attachment, nil
+}
+
+// ListAttachments returns the attachments of the post, oldest first, or ErrNotFound.
+func (c *Client) ListAttachments(ctx context.Context, postID string) ([]*pb.Attachment, error) {
+	ctx, cancel := withTimeout(ctx, c.cfg.timeout)
+	defer cancel()
+
+	res, err := c.cli.ListAttachments(ctx, &pb.PostID{Id: postID})
+	if err != nil {
+		return nil, wrapErr(err)
+	}
+	return res.Attachments, nil
+}
+
+// GetPostStats returns the counts of the posts created in [start, end) by author and by day,
+// or by week with pb.StatsInterval_STATS_INTERVAL_WEEK. A zero start counts every post before
+// end, and a zero end every post until now.
+func (c *Client) GetPostStats(ctx context.Context, start, end time.Time, interval pb.StatsInterval) (*pb.GetPostStatsResponse, error) {
+	ctx, cancel := withTimeout(ctx, c.cfg.timeout)
+	defer cancel()
+
+	req := &pb.GetPostStatsRequest{Interval: interval}
+	if !start.IsZero() {
+		req.Start = timestamppb.New(start)
+	}
+	if !end.IsZero() {
+		req.End = timestamppb.New(end)
+	}
+	res, err := c.cli.GetPostStats(ctx, req)
+	if err != nil {
+		return nil, wrapErr(err)
+	}
+	return res, nil
+}
+
+// tokenAuth attaches a bearer token to every rpc.
+type tokenAuth struct {
+	token      string
+	requireTLS bool
+}
+
+func (t *tokenAuth) GetRequestMetadata(_ context.Context, _ ...string) (map[string]string, error) {
+	return map[string]string{
+		"authorization": "Bearer " + t.token,
+	}, nil
+}
+
+func (t *tokenAuth) RequireTransportSecurity() bool {
+	return t.requireTLS
+}
+
+// tenantHeader attaches the tenant to every rpc.
+type tenantHeader string
+
+func (t tenantHeader) GetRequestMetadata(_ context.Context, _ ...string) (map[string]string, error) {
+	return map[string]string{
+		TenantHeader: string(t),
+	}, nil
+}
+
+func (t tenantHeader) RequireTransportSecurity() bool {
+	return false
+}
@@ -0,0 +1,349 @@
+package crud_client
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	pb "go_grpc_example/proto"
+
+	"github.com/golang/protobuf/ptypes/empty"
+	. "github.com/smartystreets/goconvey/convey"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// flakyServer fails the first n calls of each rpc with Unavailable.
+type flakyServer struct {
+	pb.UnimplementedCrudServiceServer
+
+	mu       sync.Mutex
+	failures int
+	calls    map[string]int
+	posts    []*pb.Post
+	// keys are the idempotency keys received by CreatePost.
+	keys []string
+	// pin is returned by CreatePost as the primary pin, and pins are those received by ReadPost.
+	pin  string
+	pins []string
+	// listFailAfter interrupts the first ListPosts stream after sending this many posts.
+	listFailAfter int
+	// uploaded is the metadata and content of the last UploadAttachment; corrupt flips a byte on download.
+	uploaded *pb.AttachmentMetadata
+	content  []byte
+	corrupt  bool
+}
+
+func (s *flakyServer) call(method string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.calls[method]++
+	if s.calls[method] <= s.failures {
+		return status.Error(codes.Unavailable, "try again")
+	}
+	return nil
+}
+
+func (s *flakyServer) count(method string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.calls[method]
+}
+
+func (s *flakyServer) CreatePost(ctx context.Context, post *pb.Post) (*pb.PostID, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	s.mu.Lock()
+	s.keys = append(s.keys, md.Get(IdempotencyKeyHeader)...)
+	s.mu.Unlock()
+
+	if err := s.call("CreatePost"); err != nil {
+		return nil, err
+	}
+	if s.pin != "" {
+		grpc.SetHeader(ctx, metadata.Pairs(PrimaryUntilHeader, s.pin))
+	}
+	return &pb.PostID{Id: post.Id}, nil
+}
+
+func (s *flakyServer) ReadPost(ctx context.Context, id *pb.PostID) (*pb.Post, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	s.mu.Lock()
+	s.pins = append(s.pins, md.Get(PrimaryUntilHeader)...)
+	s.mu.Unlock()
+
+	if err := s.call("ReadPost"); err != nil {
+		return nil, err
+	}
+	if id.Id == "missing" {
+		return nil, status.Error(codes.NotFound, "record not found")
+	}
+	if id.Id == "invalid" {
+		st, _ := status.New(codes.InvalidArgument, "invalid post").WithDetails(&errdetails.BadRequest{
+			FieldViolations: []*errdetails.BadRequest_FieldViolation{{Field: "id", Description: "is invalid"}},
+		})
+		return nil, st.Err()
+	}
+	return &pb.Post{Id: id.Id}, nil
+}
+
+func (s *flakyServer) UpdatePost(ctx context.Context, _ *pb.Post) (*empty.Empty, error) {
+	// Block until the client's deadline passes.
+	<-ctx.Done()
+	return nil, status.FromContextError(ctx.Err()).Err()
+}
+
+func (s *flakyServer) ListPosts(_ *pb.ListPostsRequest, stream pb.CrudService_ListPostsServer) error {
+	s.mu.Lock()
+	s.calls["ListPosts"]++
+	first := s.calls["ListPosts"] == 1
+	s.mu.Unlock()
+
+	for i, post := range s.posts {
+		if first && i == s.listFailAfter {
+			return status.Error(codes.Unavailable, "connection reset")
+		}
+		if err := stream.Send(post); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *flakyServer) UploadAttachment(stream pb.CrudService_UploadAttachmentServer) error {
+	content := []byte{}
+	var meta *pb.AttachmentMetadata
+	for {
+		req, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if m := req.GetMetadata(); m != nil {
+			meta = m
+		}
+		content = append(content, req.GetChunk()...)
+	}
+
+	s.mu.Lock()
+	s.uploaded, s.content = meta, content
+	s.mu.Unlock()
+	return stream.SendAndClose(&pb.Attachment{Id: "a1", PostId: meta.PostId, Size: meta.Size, Sha256: meta.Sha256})
+}
+
+func (s *flakyServer) DownloadAttachment(req *pb.AttachmentID, stream pb.CrudService_DownloadAttachmentServer) error {
+	s.mu.Lock()
+	content := append([]byte{}, s.content...)
+	if s.corrupt {
+		content[0] ^= 0xff
+	}
+	attachment := &pb.Attachment{Id: req.Id, Size: s.uploaded.Size, Sha256: s.uploaded.Sha256}
+	s.mu.Unlock()
+
+	if err := stream.Send(&pb.DownloadAttachmentResponse{Data: &pb.DownloadAttachmentResponse_Attachment{Attachment: attachment}}); err != nil {
+		return err
+	}
+	return stream.Send(&pb.DownloadAttachmentResponse{Data: &pb.DownloadAttachmentResponse_Chunk{Chunk: content}})
+}
+
+func newTestClient(srv *flakyServer, opts ...Option) (*Client, func()) {
+	lis := bufconn.Listen(1024 * 1024)
+	gs := grpc.NewServer()
+	pb.RegisterCrudServiceServer(gs, srv)
+	go gs.Serve(lis)
+
+	opts = append([]Option{
+		WithRetryPolicy(RetryPolicy{
+			MaxAttempts:    4,
+			InitialBackoff: time.Millisecond,
+			MaxBackoff:     5 * time.Millisecond,
+			Multiplier:     2,
+		}),
+		WithDialOptions(grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) {
+			return lis.Dial()
+		})),
+	}, opts...)
+
+	c, err := Dial("bufnet", opts...)
+	if err != nil {
+		panic(err)
+	}
+	return c, func() {
+		c.Close()
+		gs.Stop()
+	}
+}
+
+func TestClient(t *testing.T) {
+	Convey("Client tests", t, func() {
+		srv := &flakyServer{calls: map[string]int{}}
+		ctx := context.Background()
+
+		Convey("Idempotent rpcs are retried on Unavailable", func() {
+			srv.failures = 2
+			c, cleanup := newTestClient(srv)
+			defer cleanup()
+
+			post, err := c.ReadPost(ctx, "123")
+			So(err, ShouldBeNil)
+			So(post.Id, ShouldEqual, "123")
+			So(srv.count("ReadPost"), ShouldEqual, 3)
+		})
+
+		Convey("CreatePost is not retried", func() {
+			srv.failures = 1
+			c, cleanup := newTestClient(srv)
+			defer cleanup()
+
+			_, err := c.CreatePost(ctx, &pb.Post{Id: "123"})
+			So(errors.Is(err, ErrUnavailable), ShouldBeTrue)
+			So(srv.count("CreatePost"), ShouldEqual, 1)
+		})
+
+		Convey("CreatePost is retried with idempotency keys enabled", func() {
+			srv.failures = 2
+			c, cleanup := newTestClient(srv, WithIdempotencyKeys())
+			defer cleanup()
+
+			id, err := c.CreatePost(ctx, &pb.Post{Id: "123"})
+			So(err, ShouldBeNil)
+			So(id, ShouldEqual, "123")
+			So(srv.count("CreatePost"), ShouldEqual, 3)
+			So(srv.keys, ShouldHaveLength, 3)
+			So(srv.keys[1], ShouldEqual, srv.keys[0])
+			So(srv.keys[2], ShouldEqual, srv.keys[0])
+		})
+
+		Convey("An explicit idempotency key is sent as-is", func() {
+			c, cleanup := newTestClient(srv, WithIdempotencyKeys())
+			defer cleanup()
+
+			_, err := c.CreatePost(WithIdempotencyKey(ctx, "abc"), &pb.Post{Id: "123"})
+			So(err, ShouldBeNil)
+			So(srv.keys, ShouldResemble, []string{"abc"})
+		})
+
+		Convey("Retries give up after MaxAttempts", func() {
+			srv.failures = 10
+			c, cleanup := newTestClient(srv)
+			defer cleanup()
+
+			_, err := c.ReadPost(ctx, "123")
+			So(errors.Is(err, ErrUnavailable), ShouldBeTrue)
+			So(srv.count("ReadPost"), ShouldEqual, 4)
+		})
+
+		Convey("Reads after a write carry its primary pin until it expires", func() {
+			srv.pin = time.Now().Add(time.Minute).UTC().Format(time.RFC3339Nano)
+			c, cleanup := newTestClient(srv, WithReadYourWrites())
+			defer cleanup()
+
+			_, err := c.ReadPost(ctx, "1")
+			So(err, ShouldBeNil)
+			So(srv.pins, ShouldBeEmpty)
+
+			_, err = c.CreatePost(ctx, &pb.Post{Id: "1"})
+			So(err, ShouldBeNil)
+			_, err = c.ReadPost(ctx, "1")
+			So(err, ShouldBeNil)
+			So(srv.pins, ShouldResemble, []string{srv.pin})
+
+			srv.pin = time.Now().Add(-time.Second).UTC().Format(time.RFC3339Nano)
+			other, cleanupOther := newTestClient(srv, WithReadYourWrites())
+			defer cleanupOther()
+			_, err = other.CreatePost(ctx, &pb.Post{Id: "2"})
+			So(err, ShouldBeNil)
+			_, err = other.ReadPost(ctx, "2")
+			So(err, ShouldBeNil)
+			So(srv.pins, ShouldHaveLength, 1)
+		})
+
+		Convey("Errors match the typed errors and keep their status", func() {
+			c, cleanup := newTestClient(srv)
+			defer cleanup()
+
+			_, err := c.ReadPost(ctx, "missing")
+			So(errors.Is(err, ErrNotFound), ShouldBeTrue)
+
+			var e *Error
+			So(errors.As(err, &e), ShouldBeTrue)
+			So(e.Code(), ShouldEqual, codes.NotFound)
+			So(status.Convert(e).Code(), ShouldEqual, codes.NotFound)
+			So(e.FieldViolations(), ShouldBeNil)
+
+			_, err = c.ReadPost(ctx, "invalid")
+			So(errors.Is(err, ErrInvalidArgument), ShouldBeTrue)
+			So(errors.As(err, &e), ShouldBeTrue)
+			So(e.FieldViolations(), ShouldHaveLength, 1)
+			So(e.FieldViolations()[0].Field, ShouldEqual, "id")
+		})
+
+		Convey("A default deadline is applied when the context has none", func() {
+			c, cleanup := newTestClient(srv, WithTimeout(20*time.Millisecond))
+			defer cleanup()
+
+			err := c.UpdatePost(ctx, &pb.Post{Id: "123"})
+			So(errors.Is(err, context.DeadlineExceeded), ShouldBeTrue)
+		})
+
+		Convey("Interrupted ListPosts streams are reopened without repeating posts", func() {
+			for i := 0; i < 5; i++ {
+				srv.posts = append(srv.posts, &pb.Post{Id: fmt.Sprint(i)})
+			}
+			srv.listFailAfter = 3
+			c, cleanup := newTestClient(srv)
+			defer cleanup()
+
+			posts, err := c.ListAllPosts(ctx, nil)
+			So(err, ShouldBeNil)
+			So(posts, ShouldHaveLength, 5)
+			for i, post := range posts {
+				So(post.Id, ShouldEqual, fmt.Sprint(i))
+			}
+			So(srv.count("ListPosts"), ShouldEqual, 2)
+		})
+
+		Convey("ListPosts returns callback errors as-is", func() {
+			srv.posts = []*pb.Post{{Id: "1"}, {Id: "2"}}
+			c, cleanup := newTestClient(srv)
+			defer cleanup()
+
+			stop := errors.New("stop")
+			err := c.ListPosts(ctx, nil, func(*pb.Post) error { return stop })
+			So(err, ShouldEqual, stop)
+		})
+
+		Convey("Attachments are uploaded in chunks and checked on download", func() {
+			c, cleanup := newTestClient(srv)
+			defer cleanup()
+
+			content := bytes.Repeat([]byte("0123456789"), UploadChunkSize/5)
+			attachment, err := c.UploadAttachment(ctx, "1", "digits.txt", "", bytes.NewReader(content))
+			So(err, ShouldBeNil)
+			So(srv.uploaded.ContentType, ShouldEqual, "text/plain; charset=utf-8")
+			So(srv.uploaded.Size, ShouldEqual, len(content))
+			So(bytes.Equal(srv.content, content), ShouldBeTrue)
+
+			buf := &bytes.Buffer{}
+			_, err = c.DownloadAttachment(ctx, attachment.Id, buf)
+			So(err, ShouldBeNil)
+			So(bytes.Equal(buf.Bytes(), content), ShouldBeTrue)
+
+			srv.corrupt = true
+			_, err = c.DownloadAttachment(ctx, attachment.Id, &bytes.Buffer{})
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
@@ -0,0 +1,96 @@
+package crud_client
+
+import (
+	"context"
+	"errors"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+var (
+	// ErrNotFound is returned when the requested post does not exist.
+	ErrNotFound error = errors.New("post not found")
+	// ErrConflict is returned when a write conflicts with existing state, e.g. a duplicate post.
+	ErrConflict error = errors.New("post conflict")
+	// ErrInvalidArgument is returned when the service rejects the request.
+	ErrInvalidArgument error = errors.New("invalid argument")
+	// ErrUnauthenticated is returned when the request carries no valid credentials.
+	ErrUnauthenticated error = errors.New("unauthenticated")
+	// ErrPermissionDenied is returned when the caller may not perform the request.
+	ErrPermissionDenied error = errors.New("permission denied")
+	// ErrUnavailable is returned when the service could not be reached, after any retries.
+	ErrUnavailable error = errors.New("service unavailable")
+)
+
+// Error is returned by every Client rpc method that fails. It matches one of the package's
+// sentinel errors, or context.DeadlineExceeded and context.Canceled, via errors.Is:
+//
+//	if errors.Is(err, crud_client.ErrNotFound) { ... }
+//
+// The underlying grpc status remains available via status.FromError or GRPCStatus.
+type Error struct {
+	kind error
+	st   *status.Status
+}
+
+func (e *Error) Error() string {
+	if e.kind == nil {
+		return e.st.Message()
+	}
+	return e.kind.Error() + ": " + e.st.Message()
+}
+
+// Unwrap returns the sentinel error for the status code, or nil if the code has none.
+func (e *Error) Unwrap() error {
+	return e.kind
+}
+
+// GRPCStatus returns the status returned by the service.
+func (e *Error) GRPCStatus() *status.Status {
+	return e.st
+}
+
+// Code returns the grpc status code of the error.
+func (e *Error) Code() codes.Code {
+	return e.st.Code()
+}
+
+// FieldViolations returns the fields the service rejected, e.g. a missing title, as listed
+// by the BadRequest detail of an ErrInvalidArgument; nil if there is none.
+func (e *Error) FieldViolations() []*errdetails.BadRequest_FieldViolation {
+	for _, d := range e.st.Details() {
+		if br, ok := d.(*errdetails.BadRequest); ok {
+			return br.FieldViolations
+		}
+	}
+	return nil
+}
+
+// kinds maps status codes to the sentinel errors they unwrap to.
+var kinds = map[codes.Code]error{
+	codes.NotFound:           ErrNotFound,
+	codes.AlreadyExists:      ErrConflict,
+	codes.Aborted:            ErrConflict,
+	codes.InvalidArgument:    ErrInvalidArgument,
+	codes.Unauthenticated:    ErrUnauthenticated,
+	codes.PermissionDenied:   ErrPermissionDenied,
+	codes.Unavailable:        ErrUnavailable,
+	codes.DeadlineExceeded:   context.DeadlineExceeded,
+	codes.Canceled:           context.Canceled,
+	codes.FailedPrecondition: ErrConflict,
+}
+
+// wrapErr converts an rpc error into an *Error. Nil is returned for nil.
+func wrapErr(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	st := status.Convert(err)
+	return &Error{
+		kind: kinds[st.Code()],
+		st:   st,
+	}
+}
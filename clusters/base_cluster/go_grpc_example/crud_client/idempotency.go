@@ -0,0 +1,34 @@
+package crud_client
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+
+	"google.golang.org/grpc/metadata"
+)
+
+// IdempotencyKeyHeader is the metadata key the service reads idempotency keys from.
+const IdempotencyKeyHeader = "idempotency-key"
+
+// WithIdempotencyKey returns a context that sends key as the idempotency key of the rpc.
+// The service answers a repeated CreatePost with the same key and post with the post-id of the
+// first, and rejects one with the same key but a different post with ErrInvalidArgument.
+// Keys are remembered for the service's idempotency window, 24h by default.
+func WithIdempotencyKey(ctx context.Context, key string) context.Context {
+	return metadata.AppendToOutgoingContext(ctx, IdempotencyKeyHeader, key)
+}
+
+// NewIdempotencyKey returns a random key suitable for WithIdempotencyKey.
+func NewIdempotencyKey() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+func hasIdempotencyKey(ctx context.Context) bool {
+	md, _ := metadata.FromOutgoingContext(ctx)
+	return len(md.Get(IdempotencyKeyHeader)) > 0
+}
@@ -0,0 +1,88 @@
+package crud_client
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+// PrimaryUntilHeader is the metadata key by which the service, when reading from replicas,
+// pins clients that wrote to the primary for a short window.
+const PrimaryUntilHeader = "x-primary-until"
+
+// WithReadYourWrites echoes back the primary pin the service returns from rpcs that write, so
+// that reads made by this Client shortly after its writes see them despite replication lag.
+// Pins are per Client, not per caller; clients serving many users may want one Client each.
+// Like retries, this is configured when dialing, so it has no effect on clients made by New.
+func WithReadYourWrites() Option {
+	return func(c *config) { c.readYourWrites = true }
+}
+
+// primaryPin holds the latest pin returned by the service.
+type primaryPin struct {
+	mu    sync.Mutex
+	until time.Time
+	raw   string
+}
+
+func (p *primaryPin) record(md metadata.MD) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for _, v := range md.Get(PrimaryUntilHeader) {
+		if until, err := time.Parse(time.RFC3339Nano, v); err == nil && until.After(p.until) {
+			p.until, p.raw = until, v
+		}
+	}
+}
+
+// attach sends the pin with the rpc, until it expires.
+func (p *primaryPin) attach(ctx context.Context) context.Context {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.raw == "" || !time.Now().Before(p.until) {
+		return ctx
+	}
+	return metadata.AppendToOutgoingContext(ctx, PrimaryUntilHeader, p.raw)
+}
+
+func (p *primaryPin) unaryInterceptor() grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		header := metadata.MD{}
+		err := invoker(p.attach(ctx), method, req, reply, cc, append(opts, grpc.Header(&header))...)
+		p.record(header)
+		return err
+	}
+}
+
+func (p *primaryPin) streamInterceptor() grpc.StreamClientInterceptor {
+	return func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		cs, err := streamer(p.attach(ctx), desc, cc, method, opts...)
+		if err != nil {
+			return nil, err
+		}
+		return &pinnedStream{ClientStream: cs, pin: p}, nil
+	}
+}
+
+// pinnedStream records the pin from the stream's header once the first response, or the end
+// of the stream, has been received, by which time the header has been too.
+type pinnedStream struct {
+	grpc.ClientStream
+	pin  *primaryPin
+	once sync.Once
+}
+
+func (s *pinnedStream) RecvMsg(m interface{}) error {
+	err := s.ClientStream.RecvMsg(m)
+	s.once.Do(func() {
+		if md, err := s.Header(); err == nil {
+			s.pin.record(md)
+		}
+	})
+	return err
+}
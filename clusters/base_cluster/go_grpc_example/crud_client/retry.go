@@ -0,0 +1,134 @@
+package crud_client
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"math/rand"
+	"time"
+)
+
+const serviceName = "crud.CrudService"
+
+// idempotentMethods are retried on Unavailable. CreatePost is excluded since a retried
+// create could duplicate a post that the service received before the connection failed,
+// unless every create carries an idempotency key.
+var idempotentMethods = []string{
+	"ReadPost", "BatchGetPosts", "UpdatePost", "DeletePost", "ListPosts",
+	"PublishPost", "UnpublishPost", "ArchivePost", "DownloadAttachment", "ListAttachments",
+	"GetPostStats",
+}
+
+// RetryPolicy describes the exponential backoff used to retry idempotent rpcs on Unavailable.
+// The n-th retry waits a random duration in [0, min(InitialBackoff*Multiplier^(n-1), MaxBackoff)),
+// which is the 'full jitter' grpc implements for service config retries.
+type RetryPolicy struct {
+	// MaxAttempts is the total number of attempts, including the first. grpc caps this at 5.
+	MaxAttempts    int
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+	Multiplier     float64
+}
+
+// DefaultRetryPolicy makes up to four attempts, waiting up to 100ms, 200ms, then 400ms.
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts:    4,
+	InitialBackoff: 100 * time.Millisecond,
+	MaxBackoff:     2 * time.Second,
+	Multiplier:     2,
+}
+
+// ServiceConfig returns the grpc service config json implementing the policy, for callers
+// that dial their own connections and pass them to New.
+func ServiceConfig(p RetryPolicy) string {
+	return config{retry: p}.serviceConfig()
+}
+
+type methodName struct {
+	Service string `json:"service"`
+	Method  string `json:"method"`
+}
+
+type retryPolicyJSON struct {
+	MaxAttempts          int      `json:"maxAttempts"`
+	InitialBackoff       string   `json:"initialBackoff"`
+	MaxBackoff           string   `json:"maxBackoff"`
+	BackoffMultiplier    float64  `json:"backoffMultiplier"`
+	RetryableStatusCodes []string `json:"retryableStatusCodes"`
+}
+
+type methodConfig struct {
+	Name        []methodName     `json:"name"`
+	RetryPolicy *retryPolicyJSON `json:"retryPolicy,omitempty"`
+}
+
+type healthCheckConfig struct {
+	ServiceName string `json:"serviceName"`
+}
+
+type serviceConfigJSON struct {
+	LoadBalancingConfig []map[string]struct{} `json:"loadBalancingConfig,omitempty"`
+	HealthCheckConfig   *healthCheckConfig    `json:"healthCheckConfig,omitempty"`
+	MethodConfig        []methodConfig        `json:"methodConfig"`
+}
+
+// serviceConfig returns the service config json of the client's retry policy and load balancing.
+// With idempotency keys enabled, CreatePost is retried too.
+func (c config) serviceConfig() string {
+	cfg := serviceConfigJSON{MethodConfig: []methodConfig{}}
+	if c.roundRobin {
+		cfg.LoadBalancingConfig = []map[string]struct{}{{"round_robin": {}}}
+	}
+	if c.healthChecks {
+		cfg.HealthCheckConfig = &healthCheckConfig{ServiceName: serviceName}
+	}
+
+	p := c.retry
+	if p.MaxAttempts >= 2 {
+		methods := idempotentMethods
+		if c.autoKeys {
+			methods = append([]string{"CreatePost"}, methods...)
+		}
+
+		names := []methodName{}
+		for _, method := range methods {
+			names = append(names, methodName{Service: serviceName, Method: method})
+		}
+
+		cfg.MethodConfig = append(cfg.MethodConfig, methodConfig{
+			Name: names,
+			RetryPolicy: &retryPolicyJSON{
+				MaxAttempts:          p.MaxAttempts,
+				InitialBackoff:       durationJSON(p.InitialBackoff),
+				MaxBackoff:           durationJSON(p.MaxBackoff),
+				BackoffMultiplier:    p.Multiplier,
+				RetryableStatusCodes: []string{"UNAVAILABLE"},
+			},
+		})
+	}
+
+	b, err := json.Marshal(cfg)
+	if err != nil {
+		// The config is built from plain values, so this cannot happen.
+		panic(err)
+	}
+	return string(b)
+}
+
+// durationJSON formats d as a service config duration, e.g. "0.1s".
+func durationJSON(d time.Duration) string {
+	return fmt.Sprintf("%gs", d.Seconds())
+}
+
+// backoff returns the jittered wait before the nth retry, mirroring grpc's retry backoff.
+// It is used for reopening ListPosts streams, which grpc does not retry once a post was received.
+func (p RetryPolicy) backoff(n int) time.Duration {
+	ceiling := float64(p.InitialBackoff) * math.Pow(p.Multiplier, float64(n-1))
+	if ceiling > float64(p.MaxBackoff) {
+		ceiling = float64(p.MaxBackoff)
+	}
+	if ceiling <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(ceiling)))
+}
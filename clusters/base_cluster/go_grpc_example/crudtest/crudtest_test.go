@@ -0,0 +1,139 @@
+package crudtest_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"path/filepath"
+	"testing"
+	"time"
+
+	crud "go_grpc_example/crud_client"
+	"go_grpc_example/crudtest"
+	ep "go_grpc_example/endpoints"
+	pb "go_grpc_example/proto"
+	crudv2 "go_grpc_example/proto/v2"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"google.golang.org/grpc/codes"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+)
+
+// list returns the ids of the posts listed by ListPosts.
+func list(ctx context.Context, cli pb.CrudServiceClient) []string {
+	stream, err := cli.ListPosts(ctx, &pb.ListPostsRequest{})
+	So(err, ShouldBeNil)
+	ids := []string{}
+	for {
+		post, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			return ids
+		}
+		So(err, ShouldBeNil)
+		ids = append(ids, post.Id)
+	}
+}
+
+func TestHarness(t *testing.T) {
+	Convey("Harness tests", t, func() {
+		h := crudtest.New(t)
+		ctx := context.Background()
+
+		Convey("Seeded posts are served by both api versions", func() {
+			h.Seed(t, crudtest.Posts(3)...)
+			So(list(ctx, h.Client), ShouldResemble, []string{"1", "2", "3"})
+
+			post, err := h.Client.ReadPost(ctx, &pb.PostID{Id: "2"})
+			So(err, ShouldBeNil)
+			So(post.Title, ShouldEqual, "Post 2")
+			So(post.FullText, ShouldEqual, crudtest.Post("2").FullText)
+
+			got, err := h.V2.GetPost(ctx, &crudv2.GetPostRequest{Id: "3"})
+			So(err, ShouldBeNil)
+			So(got.Post.Version, ShouldEqual, 1)
+		})
+
+		Convey("The crud lifecycle runs without external services", func() {
+			_, err := h.Client.ReadPost(ctx, &pb.PostID{Id: "1"})
+			So(status.Code(err), ShouldEqual, codes.NotFound)
+
+			h.Seed(t, crudtest.Post("1"))
+			_, err = h.Client.CreatePost(ctx, crudtest.Post("1"))
+			So(status.Code(err), ShouldEqual, codes.AlreadyExists)
+
+			_, err = h.Client.UpdatePost(ctx, &pb.Post{Id: "1", Description: "new"})
+			So(err, ShouldBeNil)
+			post, err := h.Client.ReadPost(ctx, &pb.PostID{Id: "1"})
+			So(err, ShouldBeNil)
+			So(post.Description, ShouldEqual, "new")
+
+			_, err = h.Client.DeletePost(ctx, &pb.PostID{Id: "1"})
+			So(err, ShouldBeNil)
+			_, err = h.Client.ReadPost(ctx, &pb.PostID{Id: "1"})
+			So(status.Code(err), ShouldEqual, codes.NotFound)
+		})
+
+		Convey("Tenants are resolved by the interceptors", func() {
+			h.Seed(t, crudtest.Post("1"))
+			h.SeedContext(t, crudtest.Tenant(ctx, "other"), crudtest.Posts(2)...)
+			So(list(ctx, h.Client), ShouldResemble, []string{"1"})
+			So(list(crudtest.Tenant(ctx, "other"), h.Client), ShouldResemble, []string{"1", "2"})
+
+			strict := crudtest.New(t, crudtest.WithTenants(ep.TenantConfig{TrustHeader: true}))
+			_, err := strict.Client.ReadPost(ctx, &pb.PostID{Id: "1"})
+			So(status.Code(err), ShouldEqual, codes.Unauthenticated)
+			res, err := strict.Health.Check(ctx, &healthpb.HealthCheckRequest{})
+			So(err, ShouldBeNil)
+			So(res.Status, ShouldEqual, healthpb.HealthCheckResponse_SERVING)
+		})
+
+		Convey("Rpcs are capped by the deadlines", func() {
+			capped := crudtest.New(t, crudtest.WithDeadlines(ep.Deadlines{Unary: time.Nanosecond}))
+			_, err := capped.Client.CreatePost(ctx, crudtest.Post("1"))
+			So(status.Code(err), ShouldEqual, codes.DeadlineExceeded)
+		})
+
+		Convey("Server options and storage are configurable", func() {
+			path := filepath.Join(t.TempDir(), "shared.db")
+			quota := crudtest.New(t, crudtest.WithSQLite(path), crudtest.WithServerOptions(ep.WithQuota(1, 0)))
+			quota.Seed(t, crudtest.Post("1"))
+			_, err := quota.Client.CreatePost(ctx, crudtest.Post("2"))
+			So(status.Code(err), ShouldEqual, codes.ResourceExhausted)
+
+			// Another harness over the same db sees the posts, without the quota.
+			reopened := crudtest.New(t, crudtest.WithDB(quota.DB))
+			reopened.Seed(t, crudtest.Post("2"))
+			So(list(ctx, reopened.Client), ShouldResemble, []string{"1", "2"})
+		})
+
+		Convey("Mutating rpcs are audited with an audit log", func() {
+			sink, err := ep.NewFileAuditSink(filepath.Join(t.TempDir(), "audit.log"))
+			So(err, ShouldBeNil)
+			defer sink.Close()
+			audited := crudtest.New(t, crudtest.WithAuditLog(sink))
+
+			_, err = audited.Client.CreatePost(ctx, crudtest.Post("1"))
+			So(err, ShouldBeNil)
+			records, err := sink.Query(ctx, ep.AuditQuery{PostId: "1", Limit: 10})
+			So(err, ShouldBeNil)
+			So(records, ShouldHaveLength, 1)
+			So(records[0].Tenant, ShouldEqual, ep.DEFAULT_TENANT)
+			So(records[0].Method, ShouldEndWith, "CreatePost")
+		})
+
+		Convey("The crud_client can be connected", func() {
+			conn, err := h.Dial()
+			So(err, ShouldBeNil)
+			defer conn.Close()
+			cli := crud.New(conn)
+
+			h.Seed(t, crudtest.Post("1"))
+			post, err := cli.ReadPost(ctx, "1")
+			So(err, ShouldBeNil)
+			So(post.AuthorId, ShouldEqual, "author-1")
+			_, err = cli.ReadPost(ctx, "2")
+			So(errors.Is(err, crud.ErrNotFound), ShouldBeTrue)
+		})
+	})
+}
@@ -0,0 +1,270 @@
+package endpoints
+
+import (
+	"context"
+	"runtime"
+	"runtime/debug"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	pb "go_grpc_example/proto"
+
+	empty "github.com/golang/protobuf/ptypes/empty"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// ADMIN_ROLE is the jwt role required by every AdminService rpc.
+const ADMIN_ROLE = "admin"
+
+// Version and Commit identify the build, e.g.
+// go build -ldflags "-X go_grpc_example/endpoints.Version=v1.2.0 -X go_grpc_example/endpoints.Commit=$(git rev-parse HEAD)"
+// Commit defaults to the vcs revision go embeds when building within the repo.
+var (
+	Version = ""
+	Commit  = ""
+)
+
+// RPCTracker counts the rpcs in flight per method, via its interceptors.
+type RPCTracker struct {
+	mu       sync.Mutex
+	inFlight map[string]int64
+}
+
+func NewRPCTracker() *RPCTracker {
+	return &RPCTracker{inFlight: map[string]int64{}}
+}
+
+// track counts an rpc of the method as in flight until the returned func is called.
+func (t *RPCTracker) track(method string) func() {
+	t.mu.Lock()
+	t.inFlight[method]++
+	t.mu.Unlock()
+
+	return func() {
+		t.mu.Lock()
+		defer t.mu.Unlock()
+		if t.inFlight[method]--; t.inFlight[method] == 0 {
+			delete(t.inFlight, method)
+		}
+	}
+}
+
+// InFlight returns the number of rpcs in flight by method, omitting methods with none.
+func (t *RPCTracker) InFlight() map[string]int64 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	counts := make(map[string]int64, len(t.inFlight))
+	for method, n := range t.inFlight {
+		counts[method] = n
+	}
+	return counts
+}
+
+// UnaryInterceptor counts unary rpcs in flight.
+func (t *RPCTracker) UnaryInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		defer t.track(info.FullMethod)()
+		return handler(ctx, req)
+	}
+}
+
+// StreamInterceptor counts streaming rpcs in flight.
+func (t *RPCTracker) StreamInterceptor() grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		defer t.track(info.FullMethod)()
+		return handler(srv, ss)
+	}
+}
+
+// ServerOptions returns the grpc server options installing the interceptors. Install them
+// first, so that rpcs rejected by later interceptors are counted too.
+func (t *RPCTracker) ServerOptions() []grpc.ServerOption {
+	return []grpc.ServerOption{
+		grpc.ChainUnaryInterceptor(t.UnaryInterceptor()),
+		grpc.ChainStreamInterceptor(t.StreamInterceptor()),
+	}
+}
+
+// AdminServer implements the AdminService over a Server, for operators. Every rpc requires
+// a caller with the admin role, i.e. a jwt listing it, so the service is unusable without a
+// JWTKey configured.
+type AdminServer struct {
+	srv       *Server
+	tracker   *RPCTracker
+	toolchain []string
+	startedAt time.Time
+	pb.UnimplementedAdminServiceServer
+}
+
+// NewAdminServer returns an admin server for srv. The tracker may be nil, in which case no
+// rpcs are reported in flight. The toolchain lists the versions of the tools the service was
+// built and deployed with, as in version_info.txt.
+func NewAdminServer(srv *Server, tracker *RPCTracker, toolchain []string) *AdminServer {
+	return &AdminServer{
+		srv:       srv,
+		tracker:   tracker,
+		toolchain: toolchain,
+		startedAt: time.Now(),
+	}
+}
+
+func requireAdmin(ctx context.Context) error {
+	c, ok := CallerFrom(ctx)
+	if !ok {
+		return status.Error(codes.Unauthenticated, "request has no caller")
+	}
+	if !c.HasRole(ADMIN_ROLE) {
+		return status.Errorf(codes.PermissionDenied, "requires the %s role", ADMIN_ROLE)
+	}
+	return nil
+}
+
+func (a *AdminServer) GetServerInfo(ctx context.Context, _ *empty.Empty) (*pb.ServerInfo, error) {
+	debugf("GetServerInfo invoked\n")
+	if err := requireAdmin(ctx); err != nil {
+		return nil, err
+	}
+
+	pool, err := a.poolStats()
+	if err != nil {
+		return nil, toStatus(err)
+	}
+
+	inFlight := []*pb.InFlight{}
+	if a.tracker != nil {
+		for method, n := range a.tracker.InFlight() {
+			inFlight = append(inFlight, &pb.InFlight{Method: method, Count: n})
+		}
+		sort.Slice(inFlight, func(i, j int) bool { return inFlight[i].Method < inFlight[j].Method })
+	}
+
+	return &pb.ServerInfo{
+		Build:     a.buildInfo(),
+		StartedAt: timestamppb.New(a.startedAt),
+		Uptime:    durationpb.New(time.Since(a.startedAt)),
+		Pool:      pool,
+		ReadCache: a.cacheStats(),
+		InFlight:  inFlight,
+		LogLevel:  LogLevel(),
+	}, nil
+}
+
+func (a *AdminServer) buildInfo() *pb.BuildInfo {
+	info := &pb.BuildInfo{
+		Version:   Version,
+		Commit:    Commit,
+		GoVersion: runtime.Version(),
+		Toolchain: a.toolchain,
+	}
+	if bi, ok := debug.ReadBuildInfo(); ok {
+		info.Module = bi.Main.Path
+		if info.Version == "" && bi.Main.Version != "(devel)" {
+			info.Version = bi.Main.Version
+		}
+		for _, setting := range bi.Settings {
+			if setting.Key == "vcs.revision" && info.Commit == "" {
+				info.Commit = setting.Value
+			}
+		}
+	}
+	return info
+}
+
+func (a *AdminServer) poolStats() (*pb.PoolStats, error) {
+	sqlDB, err := a.srv.db.DB()
+	if err != nil {
+		return nil, err
+	}
+
+	stats := sqlDB.Stats()
+	return &pb.PoolStats{
+		MaxOpen:           int32(stats.MaxOpenConnections),
+		Open:              int32(stats.OpenConnections),
+		InUse:             int32(stats.InUse),
+		Idle:              int32(stats.Idle),
+		WaitCount:         stats.WaitCount,
+		WaitDuration:      durationpb.New(stats.WaitDuration),
+		MaxIdleClosed:     stats.MaxIdleClosed,
+		MaxIdleTimeClosed: stats.MaxIdleTimeClosed,
+		MaxLifetimeClosed: stats.MaxLifetimeClosed,
+	}, nil
+}
+
+func (a *AdminServer) cacheStats() *pb.CacheStats {
+	if a.srv.readCache == nil {
+		return &pb.CacheStats{}
+	}
+
+	stats := a.srv.readCache.Stats()
+	return &pb.CacheStats{
+		Enabled:  true,
+		Size:     stats.Size,
+		Capacity: stats.Capacity,
+		Hits:     stats.Hits,
+		Misses:   stats.Misses,
+	}
+}
+
+func (a *AdminServer) SetLogLevel(ctx context.Context, req *pb.LogLevel) (*pb.SetLogLevelResponse, error) {
+	debugf("SetLogLevel invoked\n")
+	if err := requireAdmin(ctx); err != nil {
+		return nil, err
+	}
+
+	level := strings.ToLower(strings.TrimSpace(req.Level))
+	previous, err := SetLogLevel(level)
+	if err != nil {
+		return nil, badRequest("invalid log level", []*errdetails.BadRequest_FieldViolation{{
+			Field:       "level",
+			Description: "must be one of: " + strings.Join(logLevels, ", "),
+		}})
+	}
+
+	infof("log level changed from %s to %s\n", previous, level)
+	return &pb.SetLogLevelResponse{Previous: previous, Level: level}, nil
+}
+
+func (a *AdminServer) FlushReadCache(ctx context.Context, _ *empty.Empty) (*pb.FlushReadCacheResponse, error) {
+	debugf("FlushReadCache invoked\n")
+	if err := requireAdmin(ctx); err != nil {
+		return nil, err
+	}
+	if a.srv.readCache == nil {
+		return nil, status.Error(codes.FailedPrecondition, "the read cache is disabled")
+	}
+
+	n := a.srv.readCache.Purge()
+	infof("flushed %d posts from the read cache\n", n)
+	return &pb.FlushReadCacheResponse{Flushed: int64(n)}, nil
+}
+
+func (a *AdminServer) PurgeDeletedPosts(ctx context.Context, req *pb.PurgeDeletedPostsRequest) (*pb.PurgeDeletedPostsResponse, error) {
+	debugf("PurgeDeletedPosts invoked\n")
+	if err := requireAdmin(ctx); err != nil {
+		return nil, err
+	}
+	if req.OlderThan != nil && (req.OlderThan.CheckValid() != nil || req.OlderThan.AsDuration() < 0) {
+		return nil, badRequest("invalid purge request", []*errdetails.BadRequest_FieldViolation{{
+			Field:       "older_than",
+			Description: "must be a non-negative duration",
+		}})
+	}
+
+	// The purge spans every tenant rather than the admin's; the admin role is what permits that.
+	n, err := PurgeDeletedPosts(ctx, a.srv.db, req.OlderThan.AsDuration())
+	if err != nil {
+		errorf("error purging deleted posts: %v\n", err)
+		return nil, toStatus(err)
+	}
+
+	infof("purged %d deleted posts\n", n)
+	return &pb.PurgeDeletedPostsResponse{Purged: n}, nil
+}
@@ -0,0 +1,145 @@
+package endpoints
+
+import (
+	"context"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+
+	pb "go_grpc_example/proto"
+
+	empty "github.com/golang/protobuf/ptypes/empty"
+	. "github.com/smartystreets/goconvey/convey"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+)
+
+func TestAdminServer(t *testing.T) {
+	Convey("AdminServer tests", t, func() {
+		db, err := ConnectSQLite(filepath.Join(t.TempDir(), "test.db"))
+		So(err, ShouldBeNil)
+		cache, err := NewLRUPostCache(10)
+		So(err, ShouldBeNil)
+		s := NewServer(db, WithReadCache(cache))
+		tracker := NewRPCTracker()
+		a := NewAdminServer(s, tracker, []string{"k3d version v5.1.0"})
+
+		ctx := tenantContext(DEFAULT_TENANT)
+		adminCtx := WithCaller(context.Background(), &Caller{Tenant: DEFAULT_TENANT, Roles: []string{ADMIN_ROLE}})
+		for _, id := range []string{"1", "2"} {
+			_, err := s.CreatePost(ctx, &pb.Post{Id: id, AuthorId: "jose", Title: "title " + id})
+			So(err, ShouldBeNil)
+		}
+
+		Convey("Every rpc requires the admin role", func() {
+			_, err := a.GetServerInfo(context.Background(), &empty.Empty{})
+			So(status.Code(err), ShouldEqual, codes.Unauthenticated)
+			_, err = a.GetServerInfo(ctx, &empty.Empty{})
+			So(status.Code(err), ShouldEqual, codes.PermissionDenied)
+			_, err = a.SetLogLevel(ctx, &pb.LogLevel{Level: LOG_ERROR})
+			So(status.Code(err), ShouldEqual, codes.PermissionDenied)
+			_, err = a.FlushReadCache(ctx, &empty.Empty{})
+			So(status.Code(err), ShouldEqual, codes.PermissionDenied)
+			_, err = a.PurgeDeletedPosts(ctx, &pb.PurgeDeletedPostsRequest{})
+			So(status.Code(err), ShouldEqual, codes.PermissionDenied)
+			So(LogLevel(), ShouldEqual, LOG_LEVEL_DEFAULT)
+		})
+
+		Convey("Server info reports the build, pool, cache, and rpcs in flight", func() {
+			_, err := s.ReadPost(ctx, &pb.PostID{Id: "1"})
+			So(err, ShouldBeNil)
+			_, err = s.ReadPost(ctx, &pb.PostID{Id: "1"})
+			So(err, ShouldBeNil)
+
+			done := tracker.track("/crud.CrudService/ListPosts")
+			tracker.track("/crud.CrudService/ListPosts")()
+			info, err := a.GetServerInfo(adminCtx, &empty.Empty{})
+			So(err, ShouldBeNil)
+			done()
+
+			So(info.Build.GoVersion, ShouldEqual, runtime.Version())
+			So(info.Build.Toolchain, ShouldResemble, []string{"k3d version v5.1.0"})
+			So(info.Uptime.AsDuration(), ShouldBeGreaterThan, 0)
+			So(info.Pool.Open, ShouldBeGreaterThanOrEqualTo, 1)
+			So(info.ReadCache.Enabled, ShouldBeTrue)
+			So(info.ReadCache.Capacity, ShouldEqual, 10)
+			So(info.ReadCache.Size, ShouldEqual, 1)
+			So(info.ReadCache.Hits, ShouldEqual, 1)
+			So(info.ReadCache.Misses, ShouldEqual, 1)
+			So(info.InFlight, ShouldHaveLength, 1)
+			So(info.InFlight[0].Method, ShouldEqual, "/crud.CrudService/ListPosts")
+			So(info.InFlight[0].Count, ShouldEqual, 1)
+			So(info.LogLevel, ShouldEqual, LOG_LEVEL_DEFAULT)
+			So(tracker.InFlight(), ShouldBeEmpty)
+		})
+
+		Convey("The log level is changed at runtime", func() {
+			defer SetLogLevel(LOG_LEVEL_DEFAULT)
+
+			res, err := a.SetLogLevel(adminCtx, &pb.LogLevel{Level: " WARN "})
+			So(err, ShouldBeNil)
+			So(res.Previous, ShouldEqual, LOG_LEVEL_DEFAULT)
+			So(res.Level, ShouldEqual, LOG_WARN)
+			So(LogLevel(), ShouldEqual, LOG_WARN)
+
+			_, err = a.SetLogLevel(adminCtx, &pb.LogLevel{Level: "verbose"})
+			So(violations(err), ShouldResemble, map[string]string{
+				"level": "must be one of: debug, info, warn, error",
+			})
+			So(LogLevel(), ShouldEqual, LOG_WARN)
+		})
+
+		Convey("The read cache is flushed", func() {
+			for _, id := range []string{"1", "2"} {
+				_, err := s.ReadPost(ctx, &pb.PostID{Id: id})
+				So(err, ShouldBeNil)
+			}
+
+			res, err := a.FlushReadCache(adminCtx, &empty.Empty{})
+			So(err, ShouldBeNil)
+			So(res.Flushed, ShouldEqual, 2)
+			So(cache.Stats().Size, ShouldEqual, 0)
+			_, ok := cache.Get(DEFAULT_TENANT, "1")
+			So(ok, ShouldBeFalse)
+
+			a = NewAdminServer(NewServer(db), nil, nil)
+			_, err = a.FlushReadCache(adminCtx, &empty.Empty{})
+			So(status.Code(err), ShouldEqual, codes.FailedPrecondition)
+			info, err := a.GetServerInfo(adminCtx, &empty.Empty{})
+			So(err, ShouldBeNil)
+			So(info.ReadCache.Enabled, ShouldBeFalse)
+			So(info.InFlight, ShouldBeEmpty)
+		})
+
+		Convey("Deleted posts of every tenant are purged", func() {
+			other := tenantContext("other")
+			_, err := s.CreatePost(other, &pb.Post{Id: "1", AuthorId: "jose", Title: "title"})
+			So(err, ShouldBeNil)
+			_, err = s.DeletePost(ctx, &pb.PostID{Id: "1"})
+			So(err, ShouldBeNil)
+			_, err = s.DeletePost(other, &pb.PostID{Id: "1"})
+			So(err, ShouldBeNil)
+
+			res, err := a.PurgeDeletedPosts(adminCtx, &pb.PurgeDeletedPostsRequest{OlderThan: durationpb.New(time.Hour)})
+			So(err, ShouldBeNil)
+			So(res.Purged, ShouldEqual, 0)
+
+			_, err = a.PurgeDeletedPosts(adminCtx, &pb.PurgeDeletedPostsRequest{OlderThan: durationpb.New(-time.Hour)})
+			So(violations(err), ShouldContainKey, "older_than")
+
+			res, err = a.PurgeDeletedPosts(adminCtx, &pb.PurgeDeletedPostsRequest{})
+			So(err, ShouldBeNil)
+			So(res.Purged, ShouldEqual, 2)
+
+			var n int64
+			So(db.WithContext(AllTenants(ctx)).Unscoped().Model(&Post{}).Count(&n).Error, ShouldBeNil)
+			So(n, ShouldEqual, 1)
+
+			// The purged post-id is free again.
+			_, err = s.CreatePost(ctx, &pb.Post{Id: "1", AuthorId: "jose", Title: "title"})
+			So(err, ShouldBeNil)
+		})
+	})
+}
@@ -0,0 +1,389 @@
+package endpoints
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"go_grpc_example/blobstore"
+	pb "go_grpc_example/proto"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	"gorm.io/gorm"
+)
+
+const (
+	// ATTACHMENT_MAX_BYTES_DEFAULT bounds the size of an attachment by default.
+	ATTACHMENT_MAX_BYTES_DEFAULT = 10 << 20
+	// ATTACHMENT_CHUNK_MAX bounds upload chunks, well below grpc's 4MiB message limit.
+	ATTACHMENT_CHUNK_MAX = 1 << 20
+	// ATTACHMENT_CHUNK_SIZE is the size of download chunks.
+	ATTACHMENT_CHUNK_SIZE = 64 << 10
+	FILENAME_MAX_LEN      = 255
+)
+
+// ATTACHMENT_CONTENT_TYPES_DEFAULT are the content types accepted by default. Each must be
+// recognized by http.DetectContentType, since uploads are checked against their sniffed type.
+var ATTACHMENT_CONTENT_TYPES_DEFAULT = []string{
+	"image/png",
+	"image/jpeg",
+	"image/gif",
+	"image/webp",
+	"application/pdf",
+	"text/plain",
+}
+
+var sha256Pattern = regexp.MustCompile(`^[0-9a-f]{64}$`)
+
+// Attachment records a file attached to a post, whose bytes are stored in the blob store
+// under BlobKey.
+// FUTURE: attachments of purged posts are kept; a purge should delete their rows and blobs.
+type Attachment struct {
+	ID        uint `gorm:"primaryKey;autoIncrement"`
+	CreatedAt time.Time
+	// TenantID is set and filtered by the TenantScope plugin, as for posts.
+	TenantID string `gorm:"index:idx_attachments_tenant_post,priority:1;size:64;not null"`
+	// AttachmentId is random, so that ids cannot be guessed from one another.
+	AttachmentId string `gorm:"uniqueIndex;size:32;not null"`
+	PostId       string `gorm:"index:idx_attachments_tenant_post,priority:2;not null"`
+	Filename     string `gorm:"not null"`
+	ContentType  string `gorm:"not null"`
+	Size         int64  `gorm:"not null"`
+	Sha256       string `gorm:"size:64;not null"`
+	BlobKey      string `gorm:"not null"`
+}
+
+func (Attachment) tenantScoped() {}
+
+func NewPbAttachment(a *Attachment) *pb.Attachment {
+	return &pb.Attachment{
+		Id:          a.AttachmentId,
+		PostId:      a.PostId,
+		Filename:    a.Filename,
+		ContentType: a.ContentType,
+		Size:        a.Size,
+		Sha256:      a.Sha256,
+		CreatedAt:   timestamppb.New(a.CreatedAt),
+	}
+}
+
+// WithBlobStore enables attachments, storing their bytes in store.
+func WithBlobStore(store blobstore.BlobStore) ServerOption {
+	return func(s *Server) {
+		s.blobs = store
+	}
+}
+
+// WithAttachmentLimits sets the maximum size and the accepted content types of attachments.
+func WithAttachmentLimits(maxBytes int64, contentTypes []string) ServerOption {
+	return func(s *Server) {
+		s.attachmentMaxBytes = maxBytes
+		s.attachmentTypes = map[string]bool{}
+		for _, t := range contentTypes {
+			s.attachmentTypes[t] = true
+		}
+	}
+}
+
+// metadataViolations checks the metadata heading an upload against the server's limits.
+func (s *Server) metadataViolations(meta *pb.AttachmentMetadata) []*errdetails.BadRequest_FieldViolation {
+	violations := []*errdetails.BadRequest_FieldViolation{}
+	violation := func(field, desc string) {
+		violations = append(violations, &errdetails.BadRequest_FieldViolation{Field: "metadata." + field, Description: desc})
+	}
+
+	if meta.PostId == "" {
+		violation("post_id", "is required")
+	}
+
+	switch {
+	case meta.Filename == "":
+		violation("filename", "is required")
+	case !utf8.ValidString(meta.Filename) || hasControlChars(meta.Filename, false):
+		violation("filename", "must be valid utf-8 without control characters")
+	case utf8.RuneCountInString(meta.Filename) > FILENAME_MAX_LEN:
+		violation("filename", fmt.Sprintf("must be at most %d characters", FILENAME_MAX_LEN))
+	case strings.ContainsAny(meta.Filename, `/\`) || meta.Filename == "." || meta.Filename == "..":
+		violation("filename", "must be a file name without a directory")
+	}
+
+	if !s.attachmentTypes[mediaType(meta.ContentType)] {
+		violation("content_type", fmt.Sprintf("must be one of: %s", strings.Join(s.contentTypes(), ", ")))
+	}
+
+	switch {
+	case meta.Size <= 0:
+		violation("size", "must be positive")
+	case meta.Size > s.attachmentMaxBytes:
+		violation("size", fmt.Sprintf("must be at most %d bytes, got %d", s.attachmentMaxBytes, meta.Size))
+	}
+
+	if !sha256Pattern.MatchString(meta.Sha256) {
+		violation("sha256", "must be the lower-case hex sha256 of the attachment")
+	}
+	return violations
+}
+
+// contentTypes returns the accepted content types, sorted.
+func (s *Server) contentTypes() []string {
+	types := make([]string, 0, len(s.attachmentTypes))
+	for t := range s.attachmentTypes {
+		types = append(types, t)
+	}
+	sort.Strings(types)
+	return types
+}
+
+// mediaType returns the lower-case media type of a content type, without parameters such as charset.
+func mediaType(contentType string) string {
+	mt, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return ""
+	}
+	return mt
+}
+
+func (s *Server) requireBlobs() error {
+	if s.blobs == nil {
+		return status.Error(codes.Unimplemented, "attachments are not enabled on this service")
+	}
+	return nil
+}
+
+// requirePost returns NotFound unless the caller's tenant has a post with the post-id.
+func (s *Server) requirePost(ctx context.Context, postID string) error {
+	return toStatus(s.db.WithContext(ctx).Select("id").Where("post_id = ?", postID).First(&Post{}).Error)
+}
+
+// UploadAttachment attaches a file to a post. The stream's first message is the metadata,
+// declaring the file's name, content type, size, and sha256, and the rest are its chunks.
+// The chunks are spooled to a temporary file and checked against the metadata, and against
+// the content type sniffed from the bytes, before anything is written to the blob store.
+func (s *Server) UploadAttachment(stream pb.CrudService_UploadAttachmentServer) error {
+	debugf("UploadAttachment invoked\n")
+
+	if err := s.requireBlobs(); err != nil {
+		return err
+	}
+	ctx := stream.Context()
+
+	first, err := stream.Recv()
+	if err != nil {
+		return err
+	}
+	meta := first.GetMetadata()
+	if meta == nil {
+		return status.Error(codes.InvalidArgument, "the first message must carry the attachment metadata")
+	}
+	if err := badRequest("invalid attachment metadata", s.metadataViolations(meta)); err != nil {
+		return err
+	}
+	audit(ctx, meta.PostId)
+	if err := s.requirePost(ctx, meta.PostId); err != nil {
+		return err
+	}
+
+	spool, err := os.CreateTemp("", "attachment-*")
+	if err != nil {
+		return status.Errorf(codes.Internal, "unable to spool attachment: %v", err)
+	}
+	defer os.Remove(spool.Name())
+	defer spool.Close()
+
+	hash := sha256.New()
+	head := make([]byte, 0, 512)
+	var size int64
+	for {
+		req, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		chunk := req.GetChunk()
+		if len(chunk) > ATTACHMENT_CHUNK_MAX {
+			return status.Errorf(codes.InvalidArgument, "chunks must be at most %d bytes, got %d", ATTACHMENT_CHUNK_MAX, len(chunk))
+		}
+		size += int64(len(chunk))
+		if size > meta.Size {
+			return status.Errorf(codes.InvalidArgument, "received more than the declared %d bytes", meta.Size)
+		}
+
+		if n := cap(head) - len(head); n > 0 {
+			if n > len(chunk) {
+				n = len(chunk)
+			}
+			head = append(head, chunk[:n]...)
+		}
+		hash.Write(chunk)
+		if _, err := spool.Write(chunk); err != nil {
+			return status.Errorf(codes.Internal, "unable to spool attachment: %v", err)
+		}
+	}
+
+	violations := []*errdetails.BadRequest_FieldViolation{}
+	if size != meta.Size {
+		violations = append(violations, &errdetails.BadRequest_FieldViolation{
+			Field:       "metadata.size",
+			Description: fmt.Sprintf("declared %d bytes, received %d", meta.Size, size),
+		})
+	}
+	if sum := hex.EncodeToString(hash.Sum(nil)); sum != meta.Sha256 {
+		violations = append(violations, &errdetails.BadRequest_FieldViolation{
+			Field:       "metadata.sha256",
+			Description: "does not match the received bytes, whose sha256 is " + sum,
+		})
+	}
+	if sniffed := mediaType(http.DetectContentType(head)); sniffed != mediaType(meta.ContentType) {
+		violations = append(violations, &errdetails.BadRequest_FieldViolation{
+			Field:       "metadata.content_type",
+			Description: "does not match the received bytes, which look like " + sniffed,
+		})
+	}
+	if err := badRequest("attachment does not match its metadata", violations); err != nil {
+		return err
+	}
+
+	id, err := newAttachmentID()
+	if err != nil {
+		return status.Errorf(codes.Internal, "unable to generate attachment id: %v", err)
+	}
+	attachment := &Attachment{
+		AttachmentId: id,
+		PostId:       meta.PostId,
+		Filename:     meta.Filename,
+		ContentType:  meta.ContentType,
+		Size:         size,
+		Sha256:       meta.Sha256,
+		// Fan blobs out over 256 prefixes, which keeps fs directories small.
+		BlobKey: id[:2] + "/" + id,
+	}
+
+	if _, err := spool.Seek(0, io.SeekStart); err != nil {
+		return status.Errorf(codes.Internal, "unable to spool attachment: %v", err)
+	}
+	if err := s.blobs.Put(ctx, attachment.BlobKey, spool, size, meta.ContentType); err != nil {
+		errorf("error storing attachment blob: %v\n", err)
+		return toStatus(err)
+	}
+
+	// The blob is stored first, as it is too large to hold in the transaction, but the quota is
+	// checked in the same transaction as the row is inserted, like for posts.
+	err = s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		u, err := s.usage(tx)
+		if err != nil {
+			return err
+		}
+		if err := u.add(0, size); err != nil {
+			return err
+		}
+		return tx.Create(attachment).Error
+	})
+	if err != nil {
+		// Do not leak the blob; the upload failed, so the client will retry with a new id.
+		if err := s.blobs.Delete(context.Background(), attachment.BlobKey); err != nil {
+			errorf("error deleting blob of failed attachment: %v\n", err)
+		}
+		return toStatus(err)
+	}
+
+	audit(ctx, meta.PostId, "attachments")
+	return stream.SendAndClose(NewPbAttachment(attachment))
+}
+
+// DownloadAttachment streams an attachment as its metadata followed by its chunks.
+func (s *Server) DownloadAttachment(req *pb.AttachmentID, stream pb.CrudService_DownloadAttachmentServer) error {
+	debugf("DownloadAttachment invoked\n")
+
+	if err := s.requireBlobs(); err != nil {
+		return err
+	}
+	ctx := stream.Context()
+
+	attachment := &Attachment{}
+	if err := s.db.WithContext(ctx).Where("attachment_id = ?", req.Id).First(attachment).Error; err != nil {
+		return toStatus(err)
+	}
+
+	blob, err := s.blobs.Get(ctx, attachment.BlobKey)
+	if errors.Is(err, blobstore.ErrNotFound) {
+		errorf("blob of attachment %s is missing\n", attachment.AttachmentId)
+		return status.Error(codes.DataLoss, "the attachment's content is missing")
+	}
+	if err != nil {
+		return toStatus(err)
+	}
+	defer blob.Close()
+
+	err = stream.Send(&pb.DownloadAttachmentResponse{
+		Data: &pb.DownloadAttachmentResponse_Attachment{Attachment: NewPbAttachment(attachment)},
+	})
+	if err != nil {
+		return err
+	}
+
+	buf := make([]byte, ATTACHMENT_CHUNK_SIZE)
+	for {
+		n, err := io.ReadFull(blob, buf)
+		if n > 0 {
+			chunk := &pb.DownloadAttachmentResponse{Data: &pb.DownloadAttachmentResponse_Chunk{Chunk: buf[:n]}}
+			if err := stream.Send(chunk); err != nil {
+				return err
+			}
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return nil
+		}
+		if err != nil {
+			return toStatus(err)
+		}
+	}
+}
+
+// ListAttachments returns the attachments of a post, oldest first.
+func (s *Server) ListAttachments(ctx context.Context, req *pb.PostID) (*pb.ListAttachmentsResponse, error) {
+	debugf("ListAttachments invoked\n")
+
+	if err := s.requireBlobs(); err != nil {
+		return nil, err
+	}
+	if err := s.requirePost(ctx, req.Id); err != nil {
+		return nil, err
+	}
+
+	attachments := []Attachment{}
+	if err := s.db.WithContext(ctx).Where("post_id = ?", req.Id).Order("id").Find(&attachments).Error; err != nil {
+		return nil, toStatus(err)
+	}
+
+	res := &pb.ListAttachmentsResponse{}
+	for i := range attachments {
+		res.Attachments = append(res.Attachments, NewPbAttachment(&attachments[i]))
+	}
+	return res, nil
+}
+
+func newAttachmentID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,239 @@
+package endpoints
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"go_grpc_example/blobstore"
+	pb "go_grpc_example/proto"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// pngBytes returns n bytes that sniff as a png.
+func pngBytes(n int) []byte {
+	b := bytes.Repeat([]byte{0x42}, n)
+	copy(b, "\x89PNG\r\n\x1a\n")
+	return b
+}
+
+func sha256Hex(b []byte) string {
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
+
+// upload sends meta followed by data in chunks of chunkSize.
+func upload(ctx context.Context, cli pb.CrudServiceClient, meta *pb.AttachmentMetadata, data []byte, chunkSize int) (*pb.Attachment, error) {
+	stream, err := cli.UploadAttachment(ctx)
+	So(err, ShouldBeNil)
+
+	reqs := []*pb.UploadAttachmentRequest{{Data: &pb.UploadAttachmentRequest_Metadata{Metadata: meta}}}
+	for len(data) > 0 {
+		n := chunkSize
+		if n > len(data) {
+			n = len(data)
+		}
+		reqs = append(reqs, &pb.UploadAttachmentRequest{Data: &pb.UploadAttachmentRequest_Chunk{Chunk: data[:n]}})
+		data = data[n:]
+	}
+	for _, req := range reqs {
+		if err := stream.Send(req); err != nil {
+			break
+		}
+	}
+	return stream.CloseAndRecv()
+}
+
+// download returns the attachment and content streamed by DownloadAttachment.
+func download(ctx context.Context, cli pb.CrudServiceClient, id string) (*pb.Attachment, []byte, error) {
+	stream, err := cli.DownloadAttachment(ctx, &pb.AttachmentID{Id: id})
+	So(err, ShouldBeNil)
+
+	var attachment *pb.Attachment
+	content := []byte{}
+	for {
+		res, err := stream.Recv()
+		if err == io.EOF {
+			return attachment, content, nil
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		if a := res.GetAttachment(); a != nil {
+			attachment = a
+		}
+		content = append(content, res.GetChunk()...)
+	}
+}
+
+func TestAttachments(t *testing.T) {
+	Convey("Attachment tests", t, func() {
+		db, err := ConnectSQLite(filepath.Join(t.TempDir(), "test.db"))
+		So(err, ShouldBeNil)
+		blobDir := t.TempDir()
+		store, err := blobstore.NewFSStore(blobDir)
+		So(err, ShouldBeNil)
+
+		s := NewServer(db, WithBlobStore(store), WithAttachmentLimits(2<<20, ATTACHMENT_CONTENT_TYPES_DEFAULT))
+		_, err = s.CreatePost(tenantContext(DEFAULT_TENANT), &pb.Post{Id: "1", AuthorId: "jose", Title: "title"})
+		So(err, ShouldBeNil)
+
+		cli, stop := serve(s)
+		defer stop()
+		ctx := context.Background()
+
+		png := pngBytes(300 * 1024)
+		meta := func() *pb.AttachmentMetadata {
+			return &pb.AttachmentMetadata{
+				PostId:      "1",
+				Filename:    "cover.png",
+				ContentType: "image/png",
+				Size:        int64(len(png)),
+				Sha256:      sha256Hex(png),
+			}
+		}
+		blobs := func() int {
+			n := 0
+			filepath.Walk(blobDir, func(_ string, info os.FileInfo, _ error) error {
+				if info != nil && !info.IsDir() {
+					n++
+				}
+				return nil
+			})
+			return n
+		}
+
+		Convey("Attachments count against the storage quota", func() {
+			WithQuota(0, int64(len(png))+100)(s)
+			_, err := upload(ctx, cli, meta(), png, 100*1024)
+			So(err, ShouldBeNil)
+
+			_, err = upload(ctx, cli, meta(), png, 100*1024)
+			So(status.Code(err), ShouldEqual, codes.ResourceExhausted)
+			So(blobs(), ShouldEqual, 1)
+			_, err = s.UpdatePost(tenantContext(DEFAULT_TENANT), &pb.Post{Id: "1", FullText: strings.Repeat("a", 100)})
+			So(status.Code(err), ShouldEqual, codes.ResourceExhausted)
+		})
+
+		Convey("Uploaded attachments are listed and downloaded intact", func() {
+			attachment, err := upload(ctx, cli, meta(), png, 100*1024)
+			So(err, ShouldBeNil)
+			So(attachment.Id, ShouldHaveLength, 32)
+			So(attachment.Size, ShouldEqual, len(png))
+			So(blobs(), ShouldEqual, 1)
+
+			text := []byte("notes")
+			_, err = upload(ctx, cli, &pb.AttachmentMetadata{
+				PostId: "1", Filename: "notes.txt", ContentType: "text/plain; charset=utf-8",
+				Size: int64(len(text)), Sha256: sha256Hex(text),
+			}, text, 1024)
+			So(err, ShouldBeNil)
+
+			res, err := cli.ListAttachments(ctx, &pb.PostID{Id: "1"})
+			So(err, ShouldBeNil)
+			So(res.Attachments, ShouldHaveLength, 2)
+			So(res.Attachments[0].Filename, ShouldEqual, "cover.png")
+			So(res.Attachments[1].Filename, ShouldEqual, "notes.txt")
+
+			downloaded, content, err := download(ctx, cli, attachment.Id)
+			So(err, ShouldBeNil)
+			So(downloaded.Sha256, ShouldEqual, attachment.Sha256)
+			So(bytes.Equal(content, png), ShouldBeTrue)
+		})
+
+		Convey("Metadata is checked before any content is read", func() {
+			bad := meta()
+			bad.Filename = "../cover.png"
+			bad.ContentType = "application/x-sh"
+			bad.Size = 3 << 20
+			bad.Sha256 = "abc"
+			_, err := upload(ctx, cli, bad, png, 100*1024)
+			So(violations(err), ShouldResemble, map[string]string{
+				"metadata.filename":     "must be a file name without a directory",
+				"metadata.content_type": "must be one of: application/pdf, image/gif, image/jpeg, image/png, image/webp, text/plain",
+				"metadata.size":         "must be at most 2097152 bytes, got 3145728",
+				"metadata.sha256":       "must be the lower-case hex sha256 of the attachment",
+			})
+
+			stream, err := cli.UploadAttachment(ctx)
+			So(err, ShouldBeNil)
+			So(stream.Send(&pb.UploadAttachmentRequest{Data: &pb.UploadAttachmentRequest_Chunk{Chunk: png}}), ShouldBeNil)
+			_, err = stream.CloseAndRecv()
+			So(status.Code(err), ShouldEqual, codes.InvalidArgument)
+
+			missing := meta()
+			missing.PostId = "missing"
+			_, err = upload(ctx, cli, missing, png, 100*1024)
+			So(status.Code(err), ShouldEqual, codes.NotFound)
+		})
+
+		Convey("Content that does not match its metadata is not stored", func() {
+			corrupt := append([]byte{}, png...)
+			corrupt[len(corrupt)-1] ^= 0xff
+			_, err := upload(ctx, cli, meta(), corrupt, 100*1024)
+			So(violations(err), ShouldContainKey, "metadata.sha256")
+
+			_, err = upload(ctx, cli, meta(), png[:1000], 100*1024)
+			So(violations(err), ShouldContainKey, "metadata.size")
+
+			_, err = upload(ctx, cli, meta(), append(png, 0), 100*1024)
+			So(status.Code(err), ShouldEqual, codes.InvalidArgument)
+
+			text := []byte(strings.Repeat("not a png ", 10))
+			disguised := meta()
+			disguised.Size, disguised.Sha256 = int64(len(text)), sha256Hex(text)
+			_, err = upload(ctx, cli, disguised, text, 1024)
+			So(violations(err), ShouldResemble, map[string]string{
+				"metadata.content_type": "does not match the received bytes, which look like text/plain",
+			})
+
+			large := pngBytes(ATTACHMENT_CHUNK_MAX + 1)
+			largeMeta := meta()
+			largeMeta.Size, largeMeta.Sha256 = int64(len(large)), sha256Hex(large)
+			_, err = upload(ctx, cli, largeMeta, large, len(large))
+			So(status.Code(err), ShouldEqual, codes.InvalidArgument)
+
+			So(blobs(), ShouldEqual, 0)
+			res, err := cli.ListAttachments(ctx, &pb.PostID{Id: "1"})
+			So(err, ShouldBeNil)
+			So(res.Attachments, ShouldBeEmpty)
+		})
+
+		Convey("Attachments are scoped to their tenant", func() {
+			attachment, err := upload(ctx, cli, meta(), png, 100*1024)
+			So(err, ShouldBeNil)
+
+			other := metadata.AppendToOutgoingContext(ctx, "x-tenant-id", "other")
+			_, _, err = download(other, cli, attachment.Id)
+			So(status.Code(err), ShouldEqual, codes.NotFound)
+			_, err = cli.ListAttachments(other, &pb.PostID{Id: "1"})
+			So(status.Code(err), ShouldEqual, codes.NotFound)
+		})
+
+		Convey("Missing blobs are reported as data loss", func() {
+			attachment, err := upload(ctx, cli, meta(), png, 100*1024)
+			So(err, ShouldBeNil)
+			So(store.Delete(ctx, attachment.Id[:2]+"/"+attachment.Id), ShouldBeNil)
+
+			_, _, err = download(ctx, cli, attachment.Id)
+			So(status.Code(err), ShouldEqual, codes.DataLoss)
+		})
+
+		Convey("Attachments are unimplemented without a blob store", func() {
+			cli, stop := serve(NewServer(db))
+			defer stop()
+			_, err := cli.ListAttachments(ctx, &pb.PostID{Id: "1"})
+			So(status.Code(err), ShouldEqual, codes.Unimplemented)
+		})
+	})
+}
@@ -0,0 +1,459 @@
+package endpoints
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	pb "go_grpc_example/proto"
+	crudv2 "go_grpc_example/proto/v2"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	"gorm.io/gorm"
+)
+
+const (
+	// AUDIT_SINK_DB and AUDIT_SINK_FILE name the sinks the audit log may be written to.
+	AUDIT_SINK_DB   = "db"
+	AUDIT_SINK_FILE = "file"
+	// REQUEST_ID_HEADER carries the id correlating a request with its audit records. Audited
+	// requests without one are given a random one, returned in the response header.
+	REQUEST_ID_HEADER         = "x-request-id"
+	AUDIT_QUERY_LIMIT_DEFAULT = 100
+	AUDIT_QUERY_LIMIT_MAX     = 1000
+)
+
+// ErrAuditAppendOnly is returned for attempts to change or delete audit records via gorm.
+var ErrAuditAppendOnly = errors.New("audit records are append-only")
+
+// auditedMethods are the mutating rpcs recorded in the audit log.
+var auditedMethods = map[string]bool{
+	"/crud.CrudService/CreatePost":         true,
+	"/crud.CrudService/UpdatePost":         true,
+	"/crud.CrudService/DeletePost":         true,
+	"/crud.CrudService/PublishPost":        true,
+	"/crud.CrudService/UnpublishPost":      true,
+	"/crud.CrudService/ArchivePost":        true,
+	"/crud.CrudService/ImportPosts":        true,
+	"/crud.CrudService/UploadAttachment":   true,
+	"/crud.v2.CrudService/CreatePost":      true,
+	"/crud.v2.CrudService/UpdatePost":      true,
+	"/crud.v2.CrudService/DeletePost":      true,
+	"/crud.v2.CrudService/PublishPost":     true,
+	"/crud.v2.CrudService/UnpublishPost":   true,
+	"/crud.v2.CrudService/ArchivePost":     true,
+	"/crud.AdminService/SetLogLevel":       true,
+	"/crud.AdminService/FlushReadCache":    true,
+	"/crud.AdminService/PurgeDeletedPosts": true,
+}
+
+// AuditRecord records a post changed by a mutating rpc, or the rpc itself if it changed none,
+// e.g. as it failed. Records are only ever inserted; the table is not tenant-scoped, since the
+// audit log is queried by admins across tenants.
+type AuditRecord struct {
+	ID uint `gorm:"primaryKey;autoIncrement" json:"id,omitempty"`
+	// Time is stored as recorded_at, as time is a sql keyword.
+	Time   time.Time `gorm:"column:recorded_at;not null;index" json:"time"`
+	Method string    `gorm:"size:128;not null" json:"method"`
+	// Tenant and Subject identify the caller; Subject is empty for header-based tenants.
+	Tenant    string `gorm:"size:64;index" json:"tenant,omitempty"`
+	Subject   string `gorm:"index" json:"subject,omitempty"`
+	Peer      string `json:"peer,omitempty"`
+	RequestId string `gorm:"size:64;index" json:"request_id"`
+	PostId    string `gorm:"index" json:"post_id,omitempty"`
+	// ChangedFields are the post fields changed, by their proto names.
+	ChangedFields []string `gorm:"serializer:json" json:"changed_fields,omitempty"`
+	// Code is the name of the grpc status code of the outcome, and Message its message.
+	Code    string `gorm:"size:32;not null" json:"code"`
+	Message string `json:"message,omitempty"`
+}
+
+// BeforeUpdate and BeforeDelete keep the service from changing audit records. This does not
+// stop raw sql; revoke UPDATE and DELETE on the table from the service's db user for that.
+func (*AuditRecord) BeforeUpdate(*gorm.DB) error {
+	return ErrAuditAppendOnly
+}
+
+func (*AuditRecord) BeforeDelete(*gorm.DB) error {
+	return ErrAuditAppendOnly
+}
+
+// NewPbAuditRecord returns the record as a pb.AuditRecord.
+func NewPbAuditRecord(r *AuditRecord) *pb.AuditRecord {
+	return &pb.AuditRecord{
+		Time:          timestamppb.New(r.Time),
+		Method:        r.Method,
+		Tenant:        r.Tenant,
+		Subject:       r.Subject,
+		Peer:          r.Peer,
+		RequestId:     r.RequestId,
+		PostId:        r.PostId,
+		ChangedFields: r.ChangedFields,
+		Code:          r.Code,
+		Message:       r.Message,
+	}
+}
+
+// AuditQuery filters audit records; its zero fields match every record.
+type AuditQuery struct {
+	PostId  string
+	Subject string
+	Tenant  string
+	// Start and End bound the records' times to [Start, End).
+	Start time.Time
+	End   time.Time
+	Limit int
+}
+
+func (q *AuditQuery) matches(r *AuditRecord) bool {
+	return (q.PostId == "" || r.PostId == q.PostId) &&
+		(q.Subject == "" || r.Subject == q.Subject) &&
+		(q.Tenant == "" || r.Tenant == q.Tenant) &&
+		(q.Start.IsZero() || !r.Time.Before(q.Start)) &&
+		(q.End.IsZero() || r.Time.Before(q.End))
+}
+
+// AuditSink stores audit records, append-only.
+type AuditSink interface {
+	Append(ctx context.Context, records []*AuditRecord) error
+	// Query returns up to q.Limit records matching q, newest first.
+	Query(ctx context.Context, q AuditQuery) ([]*AuditRecord, error)
+}
+
+// DBAuditSink stores audit records in the audit_records table, which is among the Models.
+type DBAuditSink struct {
+	db *gorm.DB
+}
+
+func NewDBAuditSink(db *gorm.DB) *DBAuditSink {
+	return &DBAuditSink{db: db}
+}
+
+func (s *DBAuditSink) Append(ctx context.Context, records []*AuditRecord) error {
+	return s.db.WithContext(ctx).Create(records).Error
+}
+
+func (s *DBAuditSink) Query(ctx context.Context, q AuditQuery) ([]*AuditRecord, error) {
+	query := s.db.WithContext(ctx)
+	if q.PostId != "" {
+		query = query.Where("post_id = ?", q.PostId)
+	}
+	if q.Subject != "" {
+		query = query.Where("subject = ?", q.Subject)
+	}
+	if q.Tenant != "" {
+		query = query.Where("tenant = ?", q.Tenant)
+	}
+	// The bounds are local times like the stored ones, which sqlite compares as text.
+	if !q.Start.IsZero() {
+		query = query.Where("recorded_at >= ?", q.Start.Local())
+	}
+	if !q.End.IsZero() {
+		query = query.Where("recorded_at < ?", q.End.Local())
+	}
+
+	records := []*AuditRecord{}
+	err := query.Order("recorded_at DESC, id DESC").Limit(q.Limit).Find(&records).Error
+	return records, err
+}
+
+// FileAuditSink appends audit records to a file as json lines, e.g. one shipped to a log
+// store. Queries scan the whole file, so prefer the db sink for large logs to be queried.
+type FileAuditSink struct {
+	mu   sync.Mutex
+	path string
+	f    *os.File
+}
+
+// NewFileAuditSink opens the file at path for appending, creating it if needed.
+func NewFileAuditSink(path string) (*FileAuditSink, error) {
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	return &FileAuditSink{path: path, f: f}, nil
+}
+
+func (s *FileAuditSink) Append(_ context.Context, records []*AuditRecord) error {
+	buf := []byte{}
+	for _, r := range records {
+		b, err := json.Marshal(r)
+		if err != nil {
+			return err
+		}
+		buf = append(append(buf, b...), '\n')
+	}
+
+	// A single write per rpc keeps its records together in the file.
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, err := s.f.Write(buf)
+	return err
+}
+
+func (s *FileAuditSink) Query(ctx context.Context, q AuditQuery) ([]*AuditRecord, error) {
+	f, err := os.Open(s.path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	// Records are appended in time order, so the newest matches are the last ones.
+	matches := []*AuditRecord{}
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		r := &AuditRecord{}
+		if err := json.Unmarshal(scanner.Bytes(), r); err != nil {
+			return nil, err
+		}
+		if q.matches(r) {
+			matches = append(matches, r)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	records := []*AuditRecord{}
+	for i := len(matches) - 1; i >= 0 && len(records) < q.Limit; i-- {
+		records = append(records, matches[i])
+	}
+	return records, nil
+}
+
+func (s *FileAuditSink) Close() error {
+	return s.f.Close()
+}
+
+// WithAuditLog lets the AdminService query the audit log in sink, which an Auditor writes.
+func WithAuditLog(sink AuditSink) ServerOption {
+	return func(s *Server) {
+		s.auditLog = sink
+	}
+}
+
+// auditEntry collects the posts an rpc changed, as reported by the handler via audit.
+type auditEntry struct {
+	requestID string
+	changes   []auditChange
+}
+
+type auditChange struct {
+	postID string
+	fields []string
+}
+
+type auditKey struct{}
+
+// audit reports that the audited rpc of ctx targets the post, having changed the fields, if
+// any. Handlers report changes once committed, so that records only list actual changes.
+func audit(ctx context.Context, postID string, fields ...string) {
+	e, ok := ctx.Value(auditKey{}).(*auditEntry)
+	if !ok {
+		return
+	}
+	for i := range e.changes {
+		if e.changes[i].postID == postID {
+			e.changes[i].fields = append(e.changes[i].fields, fields...)
+			return
+		}
+	}
+	e.changes = append(e.changes, auditChange{postID: postID, fields: fields})
+}
+
+// postChanges returns the proto names of the fields differing between the posts.
+func postChanges(before, after *Post) []string {
+	var fields []string
+	add := func(changed bool, field string) {
+		if changed {
+			fields = append(fields, field)
+		}
+	}
+	add(before.AuthorId != after.AuthorId, "author_id")
+	add(before.Title != after.Title, "title")
+	add(before.Description != after.Description, "description")
+	add(before.FullText != after.FullText, "full_text")
+	add(before.Status != after.Status, "status")
+	add((before.PublishAt == nil) != (after.PublishAt == nil) ||
+		before.PublishAt != nil && !before.PublishAt.Equal(*after.PublishAt), "publish_at")
+	add(!equalTags(before.Tags, after.Tags), "tags")
+	add(before.DeletedAt.Valid != after.DeletedAt.Valid ||
+		before.DeletedAt.Valid && !before.DeletedAt.Time.Equal(after.DeletedAt.Time), "deleted_at")
+	return fields
+}
+
+// requestPostID returns the post id named by an rpc's request, for rpcs failing before their
+// handler reports the post.
+func requestPostID(req interface{}) string {
+	switch r := req.(type) {
+	case interface{ GetId() string }:
+		return r.GetId()
+	case interface{ GetPost() *crudv2.Post }:
+		return r.GetPost().GetId()
+	}
+	return ""
+}
+
+// Auditor records the mutating rpcs in an audit log, via its interceptors. A failure to write
+// the log is logged rather than failing the rpc, whose changes are already committed.
+// FUTURE: write the records in the transactions of the changes, for db sinks.
+type Auditor struct {
+	sink AuditSink
+}
+
+func NewAuditor(sink AuditSink) *Auditor {
+	return &Auditor{sink: sink}
+}
+
+// begin returns ctx carrying a new entry for the rpc, whose request id is sent via setHeader.
+func (a *Auditor) begin(ctx context.Context, setHeader func(metadata.MD) error) (context.Context, *auditEntry) {
+	e := &auditEntry{}
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if ids := md.Get(REQUEST_ID_HEADER); len(ids) > 0 && len(ids[0]) <= 64 {
+			e.requestID = ids[0]
+		}
+	}
+	if e.requestID == "" {
+		b := make([]byte, 16)
+		rand.Read(b)
+		e.requestID = hex.EncodeToString(b)
+	}
+	if err := setHeader(metadata.Pairs(REQUEST_ID_HEADER, e.requestID)); err != nil {
+		warnf("error setting the %s header: %v\n", REQUEST_ID_HEADER, err)
+	}
+	return context.WithValue(ctx, auditKey{}, e), e
+}
+
+// record appends the records of the rpc's entry to the sink.
+func (a *Auditor) record(ctx context.Context, method string, e *auditEntry, req interface{}, err error) {
+	st := status.Convert(err)
+	base := AuditRecord{
+		Time:      time.Now(),
+		Method:    method,
+		RequestId: e.requestID,
+		Code:      st.Code().String(),
+		Message:   st.Message(),
+	}
+	if c, ok := CallerFrom(ctx); ok {
+		base.Tenant, base.Subject = c.Tenant, c.Subject
+	}
+	if p, ok := peer.FromContext(ctx); ok {
+		base.Peer = p.Addr.String()
+	}
+
+	changes := e.changes
+	if len(changes) == 0 {
+		changes = []auditChange{{postID: requestPostID(req)}}
+	}
+	records := make([]*AuditRecord, len(changes))
+	for i, change := range changes {
+		r := base
+		r.PostId, r.ChangedFields = change.postID, change.fields
+		records[i] = &r
+	}
+
+	// The rpc's context may be canceled by now, which should not lose its records.
+	if err := a.sink.Append(context.Background(), records); err != nil {
+		errorf("error writing %d audit records of %s %s: %v\n", len(records), method, e.requestID, err)
+	}
+}
+
+// UnaryInterceptor records mutating unary rpcs.
+func (a *Auditor) UnaryInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if !auditedMethods[info.FullMethod] {
+			return handler(ctx, req)
+		}
+
+		ctx, e := a.begin(ctx, func(md metadata.MD) error { return grpc.SetHeader(ctx, md) })
+		res, err := handler(ctx, req)
+		a.record(ctx, info.FullMethod, e, req, err)
+		return res, err
+	}
+}
+
+// StreamInterceptor records mutating streaming rpcs.
+func (a *Auditor) StreamInterceptor() grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if !auditedMethods[info.FullMethod] {
+			return handler(srv, ss)
+		}
+
+		ctx, e := a.begin(ss.Context(), ss.SetHeader)
+		err := handler(srv, &contextStream{ServerStream: ss, ctx: ctx})
+		a.record(ctx, info.FullMethod, e, nil, err)
+		return err
+	}
+}
+
+// ServerOptions returns the grpc server options installing the interceptors. Install them
+// after the TenantResolver's, so that records identify the caller.
+func (a *Auditor) ServerOptions() []grpc.ServerOption {
+	return []grpc.ServerOption{
+		grpc.ChainUnaryInterceptor(a.UnaryInterceptor()),
+		grpc.ChainStreamInterceptor(a.StreamInterceptor()),
+	}
+}
+
+func (a *AdminServer) QueryAuditLog(ctx context.Context, req *pb.QueryAuditLogRequest) (*pb.QueryAuditLogResponse, error) {
+	debugf("QueryAuditLog invoked\n")
+	if err := requireAdmin(ctx); err != nil {
+		return nil, err
+	}
+	if a.srv.auditLog == nil {
+		return nil, status.Error(codes.FailedPrecondition, "the audit log is disabled")
+	}
+
+	violations := []*errdetails.BadRequest_FieldViolation{}
+	if req.Start != nil && req.End != nil && !req.End.AsTime().After(req.Start.AsTime()) {
+		violations = append(violations, &errdetails.BadRequest_FieldViolation{Field: "end", Description: "must be after start"})
+	}
+	if req.Limit < 0 || req.Limit > AUDIT_QUERY_LIMIT_MAX {
+		violations = append(violations, &errdetails.BadRequest_FieldViolation{
+			Field:       "limit",
+			Description: fmt.Sprintf("must be between 0 and %d", AUDIT_QUERY_LIMIT_MAX),
+		})
+	}
+	if err := badRequest("invalid audit log query", violations); err != nil {
+		return nil, err
+	}
+
+	q := AuditQuery{PostId: req.PostId, Subject: req.Subject, Tenant: req.Tenant, Limit: int(req.Limit)}
+	if q.Limit == 0 {
+		q.Limit = AUDIT_QUERY_LIMIT_DEFAULT
+	}
+	if req.Start != nil {
+		q.Start = req.Start.AsTime()
+	}
+	if req.End != nil {
+		q.End = req.End.AsTime()
+	}
+
+	records, err := a.srv.auditLog.Query(ctx, q)
+	if err != nil {
+		errorf("error querying the audit log: %v\n", err)
+		return nil, toStatus(err)
+	}
+	res := &pb.QueryAuditLogResponse{Records: make([]*pb.AuditRecord, len(records))}
+	for i, r := range records {
+		res.Records[i] = NewPbAuditRecord(r)
+	}
+	return res, nil
+}
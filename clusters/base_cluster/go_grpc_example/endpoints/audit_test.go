@@ -0,0 +1,193 @@
+package endpoints
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	pb "go_grpc_example/proto"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+func TestAuditLog(t *testing.T) {
+	Convey("Audit log tests", t, func() {
+		db, err := ConnectSQLite(filepath.Join(t.TempDir(), "test.db"))
+		So(err, ShouldBeNil)
+		sink := NewDBAuditSink(db)
+		s := NewServer(db, WithAuditLog(sink))
+		cli, stop := serve(s, NewAuditor(sink).ServerOptions()...)
+		defer stop()
+		ctx := context.Background()
+		admin := NewAdminServer(s, nil, nil)
+		adminCtx := WithCaller(ctx, &Caller{Tenant: DEFAULT_TENANT, Roles: []string{ADMIN_ROLE}})
+
+		// query returns the records of the post, oldest first.
+		query := func(postID string) []*pb.AuditRecord {
+			res, err := admin.QueryAuditLog(adminCtx, &pb.QueryAuditLogRequest{PostId: postID})
+			So(err, ShouldBeNil)
+			records := res.Records
+			for i, j := 0, len(records)-1; i < j; i, j = i+1, j-1 {
+				records[i], records[j] = records[j], records[i]
+			}
+			return records
+		}
+
+		Convey("Mutating rpcs are recorded with their changed fields and outcome", func() {
+			_, err := cli.CreatePost(ctx, &pb.Post{Id: "1", AuthorId: "jose", Title: "title"})
+			So(err, ShouldBeNil)
+			_, err = cli.UpdatePost(ctx, &pb.Post{Id: "1", Title: "new title", Description: "desc"})
+			So(err, ShouldBeNil)
+			_, err = cli.UpdatePost(ctx, &pb.Post{Id: "1", Title: "new title"})
+			So(err, ShouldBeNil)
+			_, err = cli.ArchivePost(ctx, &pb.PostID{Id: "1"})
+			So(err, ShouldBeNil)
+			_, err = cli.DeletePost(ctx, &pb.PostID{Id: "1"})
+			So(err, ShouldBeNil)
+			_, err = cli.ReadPost(ctx, &pb.PostID{Id: "1"})
+			So(status.Code(err), ShouldEqual, codes.NotFound)
+			_, err = cli.UpdatePost(ctx, &pb.Post{Id: "1", Title: "gone"})
+			So(status.Code(err), ShouldEqual, codes.NotFound)
+
+			records := query("1")
+			So(records, ShouldHaveLength, 6)
+			methods, fields, codes := []string{}, [][]string{}, []string{}
+			for _, r := range records {
+				methods = append(methods, r.Method)
+				fields = append(fields, r.ChangedFields)
+				codes = append(codes, r.Code)
+				So(r.Tenant, ShouldEqual, DEFAULT_TENANT)
+				So(r.Peer, ShouldNotBeEmpty)
+				So(r.RequestId, ShouldHaveLength, 32)
+			}
+			So(methods, ShouldResemble, []string{
+				"/crud.CrudService/CreatePost",
+				"/crud.CrudService/UpdatePost",
+				"/crud.CrudService/UpdatePost",
+				"/crud.CrudService/ArchivePost",
+				"/crud.CrudService/DeletePost",
+				"/crud.CrudService/UpdatePost",
+			})
+			So(fields, ShouldResemble, [][]string{
+				{"author_id", "title", "status", "publish_at"},
+				{"title", "description"},
+				nil,
+				{"status"},
+				{"deleted_at"},
+				nil,
+			})
+			So(codes, ShouldResemble, []string{"OK", "OK", "OK", "OK", "OK", "NotFound"})
+			So(records[5].Message, ShouldNotBeEmpty)
+		})
+
+		Convey("Rejected requests are recorded against the post they name", func() {
+			_, err := cli.CreatePost(ctx, &pb.Post{Id: "2"})
+			So(status.Code(err), ShouldEqual, codes.InvalidArgument)
+
+			records := query("2")
+			So(records, ShouldHaveLength, 1)
+			So(records[0].Code, ShouldEqual, "InvalidArgument")
+			So(records[0].ChangedFields, ShouldBeEmpty)
+		})
+
+		Convey("Request ids are taken from the request or returned in the header", func() {
+			reqCtx := metadata.AppendToOutgoingContext(ctx, REQUEST_ID_HEADER, "req-1")
+			header := metadata.MD{}
+			_, err := cli.CreatePost(reqCtx, &pb.Post{Id: "3", AuthorId: "jose", Title: "title"}, grpc.Header(&header))
+			So(err, ShouldBeNil)
+			So(header.Get(REQUEST_ID_HEADER), ShouldResemble, []string{"req-1"})
+
+			_, err = cli.DeletePost(ctx, &pb.PostID{Id: "3"}, grpc.Header(&header))
+			So(err, ShouldBeNil)
+			generated := header.Get(REQUEST_ID_HEADER)
+			So(generated, ShouldHaveLength, 1)
+
+			records := query("3")
+			So(records, ShouldHaveLength, 2)
+			So(records[0].RequestId, ShouldEqual, "req-1")
+			So(records[1].RequestId, ShouldEqual, generated[0])
+		})
+
+		Convey("Imports are recorded per post, and reads not at all", func() {
+			_, err := importRecords(cli, pb.ConflictMode_CONFLICT_MODE_UNSPECIFIED, []*pb.PostRecord{
+				{Post: &pb.Post{Id: "4", AuthorId: "jose", Title: "title 4"}},
+				{Post: &pb.Post{Id: "5", AuthorId: "jose", Title: "title 5"}},
+			})
+			So(err, ShouldBeNil)
+			_, err = cli.ReadPost(ctx, &pb.PostID{Id: "4"})
+			So(err, ShouldBeNil)
+
+			for _, id := range []string{"4", "5"} {
+				records := query(id)
+				So(records, ShouldHaveLength, 1)
+				So(records[0].Method, ShouldEqual, "/crud.CrudService/ImportPosts")
+				So(records[0].ChangedFields, ShouldContain, "title")
+			}
+		})
+
+		Convey("Records are append-only", func() {
+			_, err := cli.CreatePost(ctx, &pb.Post{Id: "6", AuthorId: "jose", Title: "title"})
+			So(err, ShouldBeNil)
+			So(db.Where("post_id = ?", "6").Delete(&AuditRecord{}).Error, ShouldEqual, ErrAuditAppendOnly)
+			So(db.Model(&AuditRecord{}).Where("post_id = ?", "6").Update("code", "Internal").Error, ShouldEqual, ErrAuditAppendOnly)
+			So(query("6")[0].Code, ShouldEqual, "OK")
+		})
+
+		Convey("Queries require the admin role and valid bounds", func() {
+			_, err := admin.QueryAuditLog(tenantContext(DEFAULT_TENANT), &pb.QueryAuditLogRequest{})
+			So(status.Code(err), ShouldEqual, codes.PermissionDenied)
+
+			now := timestamppb.Now()
+			_, err = admin.QueryAuditLog(adminCtx, &pb.QueryAuditLogRequest{Start: now, End: now, Limit: -1})
+			So(violations(err), ShouldContainKey, "end")
+			So(violations(err), ShouldContainKey, "limit")
+
+			_, err = NewAdminServer(NewServer(db), nil, nil).QueryAuditLog(adminCtx, &pb.QueryAuditLogRequest{})
+			So(status.Code(err), ShouldEqual, codes.FailedPrecondition)
+		})
+
+		fileSink, err := NewFileAuditSink(filepath.Join(t.TempDir(), "audit.log"))
+		So(err, ShouldBeNil)
+		defer fileSink.Close()
+
+		for name, sink := range map[string]AuditSink{"db": NewDBAuditSink(db), "file": fileSink} {
+			Convey("The "+name+" sink filters by post, actor, tenant, and time", func() {
+				start := time.Now().Add(-time.Hour)
+				at := func(minutes int) time.Time { return start.Add(time.Duration(minutes) * time.Minute) }
+				So(sink.Append(ctx, []*AuditRecord{
+					{Time: at(0), Method: "m", Tenant: "a", Subject: "alice", PostId: "p1", Code: "OK"},
+					{Time: at(1), Method: "m", Tenant: "a", Subject: "bob", PostId: "p1", Code: "OK"},
+					{Time: at(2), Method: "m", Tenant: "b", Subject: "alice", PostId: "p2", Code: "OK"},
+				}), ShouldBeNil)
+
+				ids := func(q AuditQuery) []string {
+					if q.Limit == 0 {
+						q.Limit = 10
+					}
+					if q.Start.IsZero() {
+						q.Start = start
+					}
+					records, err := sink.Query(ctx, q)
+					So(err, ShouldBeNil)
+					ids := []string{}
+					for _, r := range records {
+						ids = append(ids, r.PostId+"/"+r.Subject)
+					}
+					return ids
+				}
+				So(ids(AuditQuery{}), ShouldResemble, []string{"p2/alice", "p1/bob", "p1/alice"})
+				So(ids(AuditQuery{PostId: "p1"}), ShouldResemble, []string{"p1/bob", "p1/alice"})
+				So(ids(AuditQuery{Subject: "alice"}), ShouldResemble, []string{"p2/alice", "p1/alice"})
+				So(ids(AuditQuery{Tenant: "b"}), ShouldResemble, []string{"p2/alice"})
+				So(ids(AuditQuery{Start: at(1), End: at(2)}), ShouldResemble, []string{"p1/bob"})
+				So(ids(AuditQuery{Limit: 1}), ShouldResemble, []string{"p2/alice"})
+			})
+		}
+	})
+}
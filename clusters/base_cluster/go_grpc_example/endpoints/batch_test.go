@@ -0,0 +1,108 @@
+package endpoints
+
+import (
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	pb "go_grpc_example/proto"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"gorm.io/gorm"
+)
+
+func TestBatchGetPosts(t *testing.T) {
+	Convey("BatchGetPosts tests", t, func() {
+		db, err := ConnectSQLite(filepath.Join(t.TempDir(), "test.db"))
+		So(err, ShouldBeNil)
+
+		queries := 0
+		err = db.Callback().Query().After("gorm:query").Register("test:count", func(tx *gorm.DB) {
+			if tx.Statement.Table == PostsTable {
+				queries++
+			}
+		})
+		So(err, ShouldBeNil)
+
+		cache, err := NewLRUPostCache(10)
+		So(err, ShouldBeNil)
+		s := NewServer(db, WithBatchGetMax(5), WithReadCache(cache))
+		ctx := tenantContext(DEFAULT_TENANT)
+		for _, id := range []string{"1", "2", "3"} {
+			_, err := s.CreatePost(ctx, &pb.Post{Id: id, AuthorId: "jose", Title: "title " + id})
+			So(err, ShouldBeNil)
+		}
+		queries = 0
+
+		Convey("Posts are returned in request order with missing ids marked", func() {
+			res, err := s.BatchGetPosts(ctx, &pb.BatchGetPostsRequest{Ids: []string{"3", "missing", "1", "3"}})
+			So(err, ShouldBeNil)
+			So(queries, ShouldEqual, 1)
+			So(res.Results, ShouldHaveLength, 4)
+
+			So(res.Results[0].Found, ShouldBeTrue)
+			So(res.Results[0].Post.Title, ShouldEqual, "title 3")
+			So(res.Results[1].Id, ShouldEqual, "missing")
+			So(res.Results[1].Found, ShouldBeFalse)
+			So(res.Results[1].Post, ShouldBeNil)
+			So(res.Results[2].Post.Title, ShouldEqual, "title 1")
+			So(res.Results[3].Post.Title, ShouldEqual, "title 3")
+		})
+
+		Convey("Requests beyond the maximum are rejected", func() {
+			ids := []string{}
+			for i := 0; i < 6; i++ {
+				ids = append(ids, strconv.Itoa(i))
+			}
+			_, err := s.BatchGetPosts(ctx, &pb.BatchGetPostsRequest{Ids: ids})
+			So(status.Code(err), ShouldEqual, codes.InvalidArgument)
+
+			res, err := s.BatchGetPosts(ctx, &pb.BatchGetPostsRequest{})
+			So(err, ShouldBeNil)
+			So(res.Results, ShouldBeEmpty)
+			So(queries, ShouldEqual, 0)
+		})
+
+		Convey("Cached posts are not queried", func() {
+			_, err := s.ReadPost(ctx, &pb.PostID{Id: "1"})
+			So(err, ShouldBeNil)
+			So(queries, ShouldEqual, 1)
+
+			res, err := s.BatchGetPosts(ctx, &pb.BatchGetPostsRequest{Ids: []string{"1", "2"}})
+			So(err, ShouldBeNil)
+			So(queries, ShouldEqual, 2)
+			So(res.Results[1].Found, ShouldBeTrue)
+
+			res, err = s.BatchGetPosts(ctx, &pb.BatchGetPostsRequest{Ids: []string{"2", "1"}})
+			So(err, ShouldBeNil)
+			So(queries, ShouldEqual, 2)
+			So(res.Results[0].Post.Title, ShouldEqual, "title 2")
+		})
+
+		Convey("Writes invalidate cached posts", func() {
+			_, err := s.BatchGetPosts(ctx, &pb.BatchGetPostsRequest{Ids: []string{"1", "2"}})
+			So(err, ShouldBeNil)
+
+			_, err = s.UpdatePost(ctx, &pb.Post{Id: "1", Title: "new title"})
+			So(err, ShouldBeNil)
+			_, err = s.DeletePost(ctx, &pb.PostID{Id: "2"})
+			So(err, ShouldBeNil)
+
+			res, err := s.BatchGetPosts(ctx, &pb.BatchGetPostsRequest{Ids: []string{"1", "2"}})
+			So(err, ShouldBeNil)
+			So(res.Results[0].Post.Title, ShouldEqual, "new title")
+			So(res.Results[1].Found, ShouldBeFalse)
+		})
+
+		Convey("Cached posts are scoped to their tenant", func() {
+			_, err := s.ReadPost(ctx, &pb.PostID{Id: "1"})
+			So(err, ShouldBeNil)
+
+			res, err := s.BatchGetPosts(tenantContext("other"), &pb.BatchGetPostsRequest{Ids: []string{"1"}})
+			So(err, ShouldBeNil)
+			So(res.Results[0].Found, ShouldBeFalse)
+		})
+	})
+}
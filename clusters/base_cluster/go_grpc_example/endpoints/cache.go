@@ -0,0 +1,152 @@
+package endpoints
+
+import (
+	"context"
+	"sync/atomic"
+
+	"go_grpc_example/lru_cache"
+)
+
+// PostCache caches posts by tenant and post-id for the reads of every api version.
+// Cached posts are shared, so callers must not modify them.
+type PostCache interface {
+	Get(tenant, postID string) (*Post, bool)
+	Put(tenant, postID string, post *Post)
+	Remove(tenant, postID string)
+	Stats() CacheStats
+	// Purge removes every post, returning how many were removed.
+	Purge() int
+}
+
+// CacheStats describe the occupancy of a PostCache, and its hits and misses since it was created.
+type CacheStats struct {
+	Size     int64
+	Capacity int64
+	Hits     int64
+	Misses   int64
+}
+
+// WithReadCache serves reads from the cache where possible. Writes remove the posts they
+// change from the cache once committed.
+// FUTURE: the cache is per replica, so with several replicas a post updated via another
+// replica is served stale until evicted. A read racing a write may also re-cache the old post.
+// Both call for a ttl or a shared cache invalidated by the post change events.
+func WithReadCache(cache PostCache) ServerOption {
+	return func(s *Server) {
+		s.readCache = cache
+	}
+}
+
+const (
+	// CACHE_SHARDS is how many independently locked shards the post and render caches spread
+	// their posts over, so that concurrent reads of different posts rarely contend.
+	CACHE_SHARDS = 16
+	// CACHE_SHARD_MIN_SIZE is how many posts each shard holds at least, as each evicts its least
+	// recently used post rather than the cache's; smaller caches have fewer shards, down to one.
+	CACHE_SHARD_MIN_SIZE = 64
+)
+
+// newShardedCache returns an lru_cache of size items over up to CACHE_SHARDS shards.
+func newShardedCache[V any](size int) (*lru_cache.Sharded[string, V], error) {
+	shards := size / CACHE_SHARD_MIN_SIZE
+	if shards > CACHE_SHARDS {
+		shards = CACHE_SHARDS
+	}
+	if shards < 1 {
+		shards = 1
+	}
+	return lru_cache.NewSharded[string, V](size, shards, lru_cache.HashString)
+}
+
+// NewLRUPostCache returns a PostCache holding up to size posts, evicting the least recently
+// used of each shard.
+func NewLRUPostCache(size int) (PostCache, error) {
+	cache, err := newShardedCache[*Post](size)
+	if err != nil {
+		return nil, err
+	}
+	return &lruPostCache{cache: cache}, nil
+}
+
+// lruPostCache adapts lru_cache, keying posts by their tenant and post-id.
+type lruPostCache struct {
+	cache  *lru_cache.Sharded[string, *Post]
+	hits   atomic.Int64
+	misses atomic.Int64
+}
+
+func postCacheKey(tenant, postID string) string {
+	return tenant + "\x00" + postID
+}
+
+func (c *lruPostCache) Get(tenant, postID string) (*Post, bool) {
+	post, ok := c.cache.Get(postCacheKey(tenant, postID))
+	if !ok {
+		c.misses.Add(1)
+		return nil, false
+	}
+	c.hits.Add(1)
+	return post, true
+}
+
+func (c *lruPostCache) Put(tenant, postID string, post *Post) {
+	c.cache.Put(postCacheKey(tenant, postID), post)
+}
+
+func (c *lruPostCache) Remove(tenant, postID string) {
+	c.cache.Remove(postCacheKey(tenant, postID))
+}
+
+func (c *lruPostCache) Stats() CacheStats {
+	return CacheStats{
+		Size:     int64(c.cache.Len()),
+		Capacity: int64(c.cache.Capacity()),
+		Hits:     c.hits.Load(),
+		Misses:   c.misses.Load(),
+	}
+}
+
+func (c *lruPostCache) Purge() int {
+	return c.cache.Purge()
+}
+
+// cached returns the cached post of the request's tenant, if any. Requests pinned to the
+// primary skip the cache, which another service replica's write may not have reached, so that
+// they read their writes.
+func (s *Server) cached(ctx context.Context, postID string) (*Post, bool) {
+	if s.readCache == nil || s.replicas != nil && s.replicas.pinned(ctx) {
+		return nil, false
+	}
+	tenant, err := tenantFrom(ctx)
+	if err != nil {
+		return nil, false
+	}
+	return s.readCache.Get(tenant, postID)
+}
+
+// cache adds the post of the request's tenant to the read cache, if any.
+func (s *Server) cache(ctx context.Context, post *Post) {
+	if s.readCache == nil {
+		return
+	}
+	if tenant, err := tenantFrom(ctx); err == nil {
+		s.readCache.Put(tenant, post.PostId, post)
+	}
+}
+
+// uncache removes the posts of the request's tenant from the read and render caches, if any.
+func (s *Server) uncache(ctx context.Context, postIDs ...string) {
+	if s.readCache == nil && s.renders == nil {
+		return
+	}
+	if tenant, err := tenantFrom(ctx); err == nil {
+		for _, id := range postIDs {
+			if s.readCache != nil {
+				s.readCache.Remove(tenant, id)
+			}
+			if s.renders != nil {
+				s.renders.remove(tenant, id)
+			}
+		}
+	}
+}
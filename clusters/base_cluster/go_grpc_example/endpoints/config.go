@@ -3,8 +3,14 @@ package endpoints
 import (
 	"errors"
 	"fmt"
+	"net/url"
 	"os"
+	"strconv"
 	"strings"
+	"time"
+
+	"go_grpc_example/blobstore"
+	"go_grpc_example/envelope"
 
 	"github.com/spf13/viper"
 )
@@ -19,13 +25,162 @@ const (
 	SERV_PORT_DEFAULT = "80"
 	HTTPS_CERT_PATH   = "/etc/secrets/host.cert"
 	HTTPS_KEY_PATH    = "/etc/secrets/host.key"
+	// ENV_IDEMPOTENCY_WINDOW is how long idempotency keys are remembered, e.g. "24h".
+	ENV_IDEMPOTENCY_WINDOW = "IDEMPOTENCY_WINDOW"
+	// ENV_OUTBOX_PUBLISHER enables post change events: memory, file, or webhook. Empty disables them.
+	ENV_OUTBOX_PUBLISHER = "OUTBOX_PUBLISHER"
+	// ENV_OUTBOX_TARGET is the ndjson file path or webhook url of the outbox publisher.
+	ENV_OUTBOX_TARGET = "OUTBOX_TARGET"
+	// ENV_METRICS_ADDR is the address serving expvar metrics at /debug/vars. Empty disables it.
+	ENV_METRICS_ADDR = "METRICS_ADDR"
+	// ENV_TENANT_DEFAULT is the tenant of requests naming none; empty rejects them.
+	ENV_TENANT_DEFAULT = "TENANT_DEFAULT"
+	// ENV_TENANT_TRUST_HEADER accepts the x-tenant-id header; false by default, set it true
+	// only if a gateway sets or strips the header.
+	ENV_TENANT_TRUST_HEADER = "TENANT_TRUST_HEADER"
+	// ENV_TENANT_MAX_POSTS and ENV_TENANT_MAX_BYTES are the default tenant quotas; 0 is unlimited.
+	ENV_TENANT_MAX_POSTS = "TENANT_MAX_POSTS"
+	ENV_TENANT_MAX_BYTES = "TENANT_MAX_BYTES"
+	// ENV_RPC_MAX_DEADLINE and ENV_STREAM_MAX_DEADLINE cap how long unary and streaming rpcs
+	// may run, e.g. "30s"; 0 leaves them uncapped.
+	ENV_RPC_MAX_DEADLINE    = "RPC_MAX_DEADLINE"
+	ENV_STREAM_MAX_DEADLINE = "STREAM_MAX_DEADLINE"
+	// ENV_RPC_METHOD_DEADLINES overrides the caps of individual rpcs, e.g. "ExportPosts=1h".
+	ENV_RPC_METHOD_DEADLINES = "RPC_METHOD_DEADLINES"
+	// ENV_KEEPALIVE_MIN_TIME is the shortest interval at which clients may ping the server.
+	ENV_KEEPALIVE_MIN_TIME = "KEEPALIVE_MIN_TIME"
+	// ENV_KEEPALIVE_TIME and ENV_KEEPALIVE_TIMEOUT are when the server pings idle clients, and
+	// how long it waits for their ack.
+	ENV_KEEPALIVE_TIME    = "KEEPALIVE_TIME"
+	ENV_KEEPALIVE_TIMEOUT = "KEEPALIVE_TIMEOUT"
+	// ENV_MAX_CONNECTION_AGE is how long client connections may live before they are asked to
+	// reconnect, e.g. "5m", and ENV_MAX_CONNECTION_AGE_GRACE how long their rpcs may then run;
+	// 0 is forever.
+	ENV_MAX_CONNECTION_AGE       = "MAX_CONNECTION_AGE"
+	ENV_MAX_CONNECTION_AGE_GRACE = "MAX_CONNECTION_AGE_GRACE"
+	// ENV_READ_CACHE_SIZE is how many posts the read cache holds; 0 disables it.
+	ENV_READ_CACHE_SIZE = "READ_CACHE_SIZE"
+	// ENV_STATS_CACHE_TTL is how long GetPostStats responses are cached, e.g. "30s"; 0 disables it.
+	ENV_STATS_CACHE_TTL = "STATS_CACHE_TTL"
+	// ENV_RENDER_CACHE_SIZE is how many posts' rendered html is cached; 0 disables it.
+	ENV_RENDER_CACHE_SIZE = "RENDER_CACHE_SIZE"
+	// ENV_BATCH_GET_MAX is how many ids a BatchGetPosts request may name.
+	ENV_BATCH_GET_MAX = "BATCH_GET_MAX"
+	// ENV_SCHEDULER_INTERVAL is how often scheduled posts are checked for publishing.
+	ENV_SCHEDULER_INTERVAL = "SCHEDULER_INTERVAL"
+	// ENV_BLOB_STORE enables attachments, stored in a blob store: fs or s3. Empty disables them.
+	ENV_BLOB_STORE = "BLOB_STORE"
+	// ENV_BLOB_TARGET is the directory of the fs blob store, or the bucket url of the s3 one.
+	ENV_BLOB_TARGET = "BLOB_TARGET"
+	// ENV_S3_ACCESS_KEY and ENV_S3_SECRET_KEY are insecure alternatives to the S3_*_PATH files.
+	ENV_S3_ACCESS_KEY = "S3_ACCESS_KEY"
+	ENV_S3_SECRET_KEY = "S3_SECRET_KEY"
+	ENV_S3_REGION     = "S3_REGION"
+	// ENV_ATTACHMENT_MAX_BYTES bounds the size of attachments.
+	ENV_ATTACHMENT_MAX_BYTES = "ATTACHMENT_MAX_BYTES"
+	// ENV_ATTACHMENT_CONTENT_TYPES lists the accepted content types, comma-separated.
+	ENV_ATTACHMENT_CONTENT_TYPES = "ATTACHMENT_CONTENT_TYPES"
+	// ENV_LOG_LEVEL is the initial log level: debug, info, warn, or error.
+	ENV_LOG_LEVEL = "LOG_LEVEL"
+	// ENV_ADMIN_ADDR serves the AdminService on its own address, e.g. one only reachable
+	// within the cluster. Empty serves it alongside the CrudService.
+	ENV_ADMIN_ADDR = "ADMIN_ADDR"
+	// ENV_VERSION_INFO_PATH is the version_info.txt reported by the AdminService, if present.
+	ENV_VERSION_INFO_PATH     = "VERSION_INFO_PATH"
+	VERSION_INFO_PATH_DEFAULT = "/etc/version_info.txt"
+	// ENV_ENCRYPTION_KEYS is an insecure alternative to the ENCRYPTION_KEYS_PATH file.
+	ENV_ENCRYPTION_KEYS = "ENCRYPTION_KEYS"
+	// ENV_REENCRYPT_INTERVAL is how often posts not sealed by the active key are re-sealed.
+	ENV_REENCRYPT_INTERVAL = "REENCRYPT_INTERVAL"
+	// ENV_GRPC_WEB_ADDR serves the rpcs to browsers via gRPC-Web over HTTP/1.1. Empty disables it.
+	ENV_GRPC_WEB_ADDR = "GRPC_WEB_ADDR"
+	// ENV_GRPC_WEB_ORIGINS lists the origins allowed cross-origin gRPC-Web requests, comma-separated,
+	// e.g. "https://admin.example.com", or "*" for any. Empty only allows same-origin requests.
+	ENV_GRPC_WEB_ORIGINS = "GRPC_WEB_ORIGINS"
+	// ENV_AUDIT_SINK records mutating rpcs in an audit log: db, or file for ENV_AUDIT_FILE.
+	// Empty disables it.
+	ENV_AUDIT_SINK = "AUDIT_SINK"
+	ENV_AUDIT_FILE = "AUDIT_FILE"
+	// ENCRYPTION_KEYS_PATH holds the master keys encrypting post text, one <id>:<base64 key>
+	// per line with the active key first. If absent, post text is stored as plaintext.
+	ENCRYPTION_KEYS_PATH = "/etc/secrets/encryption/keys"
+	// JWT_KEY_PATH holds the HMAC key verifying bearer tokens; if absent, tokens are ignored.
+	JWT_KEY_PATH = "/etc/secrets/jwt.key"
+	// S3_ACCESS_KEY_PATH and S3_SECRET_KEY_PATH hold the credentials of the s3 blob store.
+	S3_ACCESS_KEY_PATH = "/etc/secrets/s3/access_key"
+	S3_SECRET_KEY_PATH = "/etc/secrets/s3/secret_key"
 )
 
 type AppConfig struct {
-	DbCreds DBCreds
-	Addr    string
-	Cert    string
-	Key     string
+	DbCreds    DBCreds
+	DbPool     DBPoolConfig
+	DbReplicas DBReplicaConfig
+	Addr       string
+	Cert       string
+	Key        string
+	// IdempotencyWindow is how long CreatePost idempotency keys are remembered.
+	IdempotencyWindow time.Duration
+	OutboxPublisher   string
+	OutboxTarget      string
+	MetricsAddr       string
+	Tenants           TenantConfig
+	Deadlines         Deadlines
+	Keepalive         Keepalive
+	// ReadCacheSize is how many posts the read cache holds; 0 disables it.
+	ReadCacheSize int
+	// StatsCacheTTL is how long GetPostStats responses are cached; 0 disables it.
+	StatsCacheTTL time.Duration
+	// RenderCacheSize is how many posts' rendered html is cached; 0 disables it.
+	RenderCacheSize int
+	BatchGetMax     int
+	// SchedulerInterval is how often scheduled posts are checked for publishing.
+	SchedulerInterval time.Duration
+	Attachments       AttachmentConfig
+	Admin             AdminConfig
+	Encryption        EncryptionConfig
+	GrpcWeb           GrpcWebConfig
+	Audit             AuditConfig
+}
+
+// AuditConfig configures the audit log of mutating rpcs.
+type AuditConfig struct {
+	// Sink is db, file, or empty if the audit log is disabled.
+	Sink string
+	// File is the path of the file sink's log.
+	File string
+}
+
+// GrpcWebConfig configures the serving of gRPC-Web to browsers.
+type GrpcWebConfig struct {
+	// Addr is empty if gRPC-Web is disabled.
+	Addr           string
+	AllowedOrigins []string
+}
+
+// EncryptionConfig configures the encryption of post text at rest.
+type EncryptionConfig struct {
+	// Keys is nil if encryption is disabled.
+	Keys              *envelope.Keyring
+	ReencryptInterval time.Duration
+}
+
+// AdminConfig configures the AdminService.
+type AdminConfig struct {
+	// Addr serves the AdminService apart from the CrudService, if set.
+	Addr     string
+	LogLevel string
+	// Toolchain holds the lines of the version info file.
+	Toolchain []string
+}
+
+// AttachmentConfig configures the blob store of attachments and their limits.
+type AttachmentConfig struct {
+	// BlobStore is fs, s3, or empty if attachments are disabled.
+	BlobStore    string
+	BlobTarget   string
+	S3Creds      blobstore.S3Creds
+	MaxBytes     int64
+	ContentTypes []string
 }
 
 func GetEnv(envVar, defaultVal string) string {
@@ -52,6 +207,16 @@ func ReadAppConfig() (*AppConfig, error) {
 		return nil, err
 	}
 
+	dbPool, err := ReadDBPoolConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	dbReplicas, err := ReadDBReplicaConfig()
+	if err != nil {
+		return nil, err
+	}
+
 	host := GetEnv(ENV_SERV_HOST, SERV_HOST_DEFAULT)
 	port := GetEnv(ENV_SERV_PORT, SERV_PORT_DEFAULT)
 	addr := fmt.Sprintf("%s:%s", host, port)
@@ -60,10 +225,295 @@ func ReadAppConfig() (*AppConfig, error) {
 	cert := GetEnv(HTTPS_CERT_PATH, "")
 	key := GetEnv(HTTPS_KEY_PATH, "")
 
+	window, err := time.ParseDuration(GetEnv(ENV_IDEMPOTENCY_WINDOW, IDEMPOTENCY_WINDOW_DEFAULT.String()))
+	if err != nil || window <= 0 {
+		return nil, fmt.Errorf("invalid %s: must be a positive duration", ENV_IDEMPOTENCY_WINDOW)
+	}
+
+	tenants, err := readTenantConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	deadlines, err := readDeadlines()
+	if err != nil {
+		return nil, err
+	}
+
+	keepalive, err := readKeepalive()
+	if err != nil {
+		return nil, err
+	}
+
+	cacheSize, err := strconv.Atoi(GetEnv(ENV_READ_CACHE_SIZE, "0"))
+	if err != nil || cacheSize < 0 {
+		return nil, fmt.Errorf("invalid %s: must be a non-negative integer", ENV_READ_CACHE_SIZE)
+	}
+	statsCacheTTL, err := time.ParseDuration(GetEnv(ENV_STATS_CACHE_TTL, "0"))
+	if err != nil || statsCacheTTL < 0 {
+		return nil, fmt.Errorf("invalid %s: must be a non-negative duration", ENV_STATS_CACHE_TTL)
+	}
+	renderCacheSize, err := strconv.Atoi(GetEnv(ENV_RENDER_CACHE_SIZE, strconv.Itoa(RENDER_CACHE_SIZE_DEFAULT)))
+	if err != nil || renderCacheSize < 0 {
+		return nil, fmt.Errorf("invalid %s: must be a non-negative integer", ENV_RENDER_CACHE_SIZE)
+	}
+	batchGetMax, err := strconv.Atoi(GetEnv(ENV_BATCH_GET_MAX, strconv.Itoa(BATCH_GET_MAX_DEFAULT)))
+	if err != nil || batchGetMax <= 0 {
+		return nil, fmt.Errorf("invalid %s: must be a positive integer", ENV_BATCH_GET_MAX)
+	}
+
+	schedulerInterval, err := time.ParseDuration(GetEnv(ENV_SCHEDULER_INTERVAL, SCHEDULER_INTERVAL_DEFAULT.String()))
+	if err != nil || schedulerInterval <= 0 {
+		return nil, fmt.Errorf("invalid %s: must be a positive duration", ENV_SCHEDULER_INTERVAL)
+	}
+
+	attachments, err := readAttachmentConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	admin, err := readAdminConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	encryption, err := readEncryptionConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	grpcWeb, err := readGrpcWebConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	audit, err := readAuditConfig()
+	if err != nil {
+		return nil, err
+	}
+
 	return &AppConfig{
-		DbCreds: *dbCreds,
-		Addr:    addr,
-		Cert:    cert,
-		Key:     key,
+		DbCreds:           *dbCreds,
+		DbPool:            *dbPool,
+		DbReplicas:        *dbReplicas,
+		Addr:              addr,
+		Cert:              cert,
+		Key:               key,
+		IdempotencyWindow: window,
+		OutboxPublisher:   GetEnv(ENV_OUTBOX_PUBLISHER, ""),
+		OutboxTarget:      GetEnv(ENV_OUTBOX_TARGET, ""),
+		MetricsAddr:       GetEnv(ENV_METRICS_ADDR, ""),
+		Tenants:           *tenants,
+		Deadlines:         *deadlines,
+		Keepalive:         *keepalive,
+		ReadCacheSize:     cacheSize,
+		StatsCacheTTL:     statsCacheTTL,
+		RenderCacheSize:   renderCacheSize,
+		BatchGetMax:       batchGetMax,
+		SchedulerInterval: schedulerInterval,
+		Attachments:       *attachments,
+		Admin:             *admin,
+		Encryption:        *encryption,
+		GrpcWeb:           *grpcWeb,
+		Audit:             *audit,
+	}, nil
+}
+
+func readAuditConfig() (*AuditConfig, error) {
+	cfg := &AuditConfig{
+		Sink: GetEnv(ENV_AUDIT_SINK, ""),
+		File: GetEnv(ENV_AUDIT_FILE, ""),
+	}
+	switch cfg.Sink {
+	case "", AUDIT_SINK_DB:
+	case AUDIT_SINK_FILE:
+		if cfg.File == "" {
+			return nil, fmt.Errorf("%s is required for the %s audit sink", ENV_AUDIT_FILE, AUDIT_SINK_FILE)
+		}
+	default:
+		return nil, fmt.Errorf("invalid %s: must be %s or %s", ENV_AUDIT_SINK, AUDIT_SINK_DB, AUDIT_SINK_FILE)
+	}
+	return cfg, nil
+}
+
+func readGrpcWebConfig() (*GrpcWebConfig, error) {
+	origins := []string{}
+	for _, o := range strings.Split(GetEnv(ENV_GRPC_WEB_ORIGINS, ""), ",") {
+		if o = strings.TrimSpace(o); o == "" {
+			continue
+		}
+		// Origins are compared verbatim to the Origin header: a scheme and host, with no path.
+		if u, err := url.Parse(o); o != "*" && (err != nil || u.Scheme == "" || u.Host == "" || u.Path != "") {
+			return nil, fmt.Errorf("invalid %s: %q is not an origin like https://example.com", ENV_GRPC_WEB_ORIGINS, o)
+		}
+		origins = append(origins, o)
+	}
+
+	return &GrpcWebConfig{
+		Addr:           GetEnv(ENV_GRPC_WEB_ADDR, ""),
+		AllowedOrigins: origins,
+	}, nil
+}
+
+func readEncryptionConfig() (*EncryptionConfig, error) {
+	interval, err := time.ParseDuration(GetEnv(ENV_REENCRYPT_INTERVAL, REENCRYPT_INTERVAL_DEFAULT.String()))
+	if err != nil || interval <= 0 {
+		return nil, fmt.Errorf("invalid %s: must be a positive duration", ENV_REENCRYPT_INTERVAL)
+	}
+	cfg := &EncryptionConfig{ReencryptInterval: interval}
+
+	keys := GetEnv(ENV_ENCRYPTION_KEYS, "")
+	if keys == "" {
+		keys, err = GetTrimmedConfig(ENCRYPTION_KEYS_PATH, "")
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		warnf("Warning: encryption keys taken from insecure env. In prod, keys should be transferred via tempfs instead.\n")
+	}
+	if keys == "" {
+		return cfg, nil
+	}
+
+	if cfg.Keys, err = envelope.ParseKeyring(keys); err != nil {
+		return nil, fmt.Errorf("invalid encryption keys: %w", err)
+	}
+	return cfg, nil
+}
+
+func readAdminConfig() (*AdminConfig, error) {
+	level := GetEnv(ENV_LOG_LEVEL, LOG_LEVEL_DEFAULT)
+	known := false
+	for _, l := range logLevels {
+		known = known || l == level
+	}
+	if !known {
+		return nil, fmt.Errorf("invalid %s: must be one of %v", ENV_LOG_LEVEL, logLevels)
+	}
+
+	versionInfo, err := GetTrimmedConfig(GetEnv(ENV_VERSION_INFO_PATH, VERSION_INFO_PATH_DEFAULT), "")
+	if err != nil {
+		return nil, err
+	}
+	toolchain := []string{}
+	for _, line := range strings.Split(versionInfo, "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			toolchain = append(toolchain, line)
+		}
+	}
+
+	return &AdminConfig{
+		Addr:      GetEnv(ENV_ADMIN_ADDR, ""),
+		LogLevel:  level,
+		Toolchain: toolchain,
+	}, nil
+}
+
+func readAttachmentConfig() (*AttachmentConfig, error) {
+	maxBytes, err := strconv.ParseInt(GetEnv(ENV_ATTACHMENT_MAX_BYTES, strconv.Itoa(ATTACHMENT_MAX_BYTES_DEFAULT)), 10, 64)
+	if err != nil || maxBytes <= 0 {
+		return nil, fmt.Errorf("invalid %s: must be a positive integer", ENV_ATTACHMENT_MAX_BYTES)
+	}
+
+	contentTypes := []string{}
+	for _, t := range strings.Split(GetEnv(ENV_ATTACHMENT_CONTENT_TYPES, strings.Join(ATTACHMENT_CONTENT_TYPES_DEFAULT, ",")), ",") {
+		if t = strings.TrimSpace(t); t != "" {
+			contentTypes = append(contentTypes, t)
+		}
+	}
+
+	cfg := &AttachmentConfig{
+		BlobStore:    GetEnv(ENV_BLOB_STORE, ""),
+		BlobTarget:   GetEnv(ENV_BLOB_TARGET, ""),
+		MaxBytes:     maxBytes,
+		ContentTypes: contentTypes,
+	}
+	if cfg.BlobStore != blobstore.STORE_S3 {
+		return cfg, nil
+	}
+
+	cfg.S3Creds.Region = GetEnv(ENV_S3_REGION, blobstore.S3_REGION_DEFAULT)
+	cfg.S3Creds.AccessKey = GetEnv(ENV_S3_ACCESS_KEY, "")
+	if cfg.S3Creds.AccessKey == "" {
+		if cfg.S3Creds.AccessKey, err = GetTrimmedConfig(S3_ACCESS_KEY_PATH, ""); err != nil {
+			return nil, err
+		}
+	}
+	cfg.S3Creds.SecretKey = GetEnv(ENV_S3_SECRET_KEY, "")
+	if cfg.S3Creds.SecretKey == "" {
+		if cfg.S3Creds.SecretKey, err = GetTrimmedConfig(S3_SECRET_KEY_PATH, ""); err != nil {
+			return nil, err
+		}
+	} else {
+		warnf("Warning: s3 secret key taken from insecure env. In prod, it should be transferred via tempfs instead.\n")
+	}
+	return cfg, nil
+}
+
+func readTenantConfig() (*TenantConfig, error) {
+	key, err := GetTrimmedConfig(JWT_KEY_PATH, "")
+	if err != nil {
+		return nil, err
+	}
+
+	trustHeader, err := strconv.ParseBool(GetEnv(ENV_TENANT_TRUST_HEADER, "false"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s: %w", ENV_TENANT_TRUST_HEADER, err)
+	}
+
+	maxPosts, err := strconv.ParseInt(GetEnv(ENV_TENANT_MAX_POSTS, "0"), 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s: %w", ENV_TENANT_MAX_POSTS, err)
+	}
+	maxBytes, err := strconv.ParseInt(GetEnv(ENV_TENANT_MAX_BYTES, "0"), 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s: %w", ENV_TENANT_MAX_BYTES, err)
+	}
+
+	return &TenantConfig{
+		JWTKey:      []byte(key),
+		TrustHeader: trustHeader,
+		Default:     GetEnv(ENV_TENANT_DEFAULT, DEFAULT_TENANT),
+		MaxPosts:    maxPosts,
+		MaxBytes:    maxBytes,
 	}, nil
 }
+
+func readDeadlines() (*Deadlines, error) {
+	unary, err := time.ParseDuration(GetEnv(ENV_RPC_MAX_DEADLINE, RPC_MAX_DEADLINE_DEFAULT.String()))
+	if err != nil || unary < 0 {
+		return nil, fmt.Errorf("invalid %s: must be a non-negative duration", ENV_RPC_MAX_DEADLINE)
+	}
+	stream, err := time.ParseDuration(GetEnv(ENV_STREAM_MAX_DEADLINE, STREAM_MAX_DEADLINE_DEFAULT.String()))
+	if err != nil || stream < 0 {
+		return nil, fmt.Errorf("invalid %s: must be a non-negative duration", ENV_STREAM_MAX_DEADLINE)
+	}
+	methods, err := ParseMethodDeadlines(GetEnv(ENV_RPC_METHOD_DEADLINES, ""))
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s: %w", ENV_RPC_METHOD_DEADLINES, err)
+	}
+
+	return &Deadlines{Unary: unary, Stream: stream, Methods: methods}, nil
+}
+
+func readKeepalive() (*Keepalive, error) {
+	k := &Keepalive{}
+	for _, d := range []struct {
+		env string
+		def time.Duration
+		val *time.Duration
+	}{
+		{ENV_KEEPALIVE_MIN_TIME, KEEPALIVE_MIN_TIME_DEFAULT, &k.MinTime},
+		{ENV_KEEPALIVE_TIME, KEEPALIVE_TIME_DEFAULT, &k.Time},
+		{ENV_KEEPALIVE_TIMEOUT, KEEPALIVE_TIMEOUT_DEFAULT, &k.Timeout},
+		{ENV_MAX_CONNECTION_AGE, MAX_CONNECTION_AGE_DEFAULT, &k.MaxConnectionAge},
+		{ENV_MAX_CONNECTION_AGE_GRACE, MAX_CONNECTION_AGE_GRACE_DEFAULT, &k.MaxConnectionAgeGrace},
+	} {
+		val, err := time.ParseDuration(GetEnv(d.env, d.def.String()))
+		if err != nil || val < 0 {
+			return nil, fmt.Errorf("invalid %s: must be a non-negative duration", d.env)
+		}
+		*d.val = val
+	}
+	return k, nil
+}
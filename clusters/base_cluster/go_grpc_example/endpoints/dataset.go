@@ -0,0 +1,299 @@
+package endpoints
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	pb "go_grpc_example/proto"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	"gorm.io/gorm"
+)
+
+// IMPORT_BATCH_SIZE is the number of imported records written per transaction.
+const IMPORT_BATCH_SIZE = 500
+
+// NewPostRecord returns the export record of post, optionally with its timestamps.
+// The deleted_at timestamp is always set for soft-deleted posts.
+func NewPostRecord(post *Post, timestamps bool) *pb.PostRecord {
+	pbPost := NewPbPost(post)
+	rec := &pb.PostRecord{Post: &pbPost, Tags: post.Tags, Version: post.Version}
+	if timestamps {
+		rec.CreatedAt = timestamppb.New(post.CreatedAt)
+		rec.UpdatedAt = timestamppb.New(post.UpdatedAt)
+	}
+	if post.DeletedAt.Valid {
+		rec.DeletedAt = timestamppb.New(post.DeletedAt.Time)
+	}
+	return rec
+}
+
+// ExportPosts streams every post in insertion order, optionally including soft-deleted posts.
+func (s *Server) ExportPosts(req *pb.ExportPostsRequest, stream pb.CrudService_ExportPostsServer) error {
+	debugf("ExportPosts invoked\n")
+
+	q := s.db.
+		WithContext(stream.Context()).
+		Model(&Post{}).
+		Order("id")
+	if req.IncludeDeleted {
+		q = q.Unscoped()
+	}
+
+	rows, err := q.Rows()
+	if err != nil {
+		return toStatus(err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		post := &Post{}
+		if err := s.db.ScanRows(rows, post); err != nil {
+			return toStatus(err)
+		}
+
+		if err := stream.Send(NewPostRecord(post, req.IncludeTimestamps)); err != nil {
+			return err
+		}
+	}
+
+	if err := rows.Err(); err != nil {
+		return toStatus(err)
+	}
+	return toStatus(stream.Context().Err())
+}
+
+// ImportPosts validates and writes the streamed records, resolving existing post-ids per the conflict mode
+// of the first message. Records are written in batches of IMPORT_BATCH_SIZE per transaction, so a
+// failed import may have written some batches, whose counts are then attached to its status as an
+// ImportPostsResponse detail. Since both conflict modes give the same result when repeated, the
+// import can simply be rerun, e.g. in skip mode to resume it.
+func (s *Server) ImportPosts(stream pb.CrudService_ImportPostsServer) error {
+	debugf("ImportPosts invoked\n")
+
+	res := &pb.ImportPostsResponse{}
+	if err := s.importPosts(stream, res); err != nil {
+		return importError(err, res)
+	}
+
+	infof("ImportPosts created %d, updated %d, skipped %d\n", res.Created, res.Updated, res.Skipped)
+	return stream.SendAndClose(res)
+}
+
+// importError returns err as a status carrying the counts of the batches already committed.
+func importError(err error, res *pb.ImportPostsResponse) error {
+	if res.Created+res.Updated+res.Skipped == 0 {
+		return err
+	}
+	warnf("ImportPosts failed after creating %d, updating %d, and skipping %d: %v\n", res.Created, res.Updated, res.Skipped, err)
+	st, detailErr := status.Convert(err).WithDetails(res)
+	if detailErr != nil {
+		return err
+	}
+	return st.Err()
+}
+
+// importPosts writes the streamed records in batches, adding the counts of each to res once
+// committed.
+func (s *Server) importPosts(stream pb.CrudService_ImportPostsServer, res *pb.ImportPostsResponse) error {
+	ctx := stream.Context()
+	mode := pb.ConflictMode_CONFLICT_MODE_UNSPECIFIED
+	batch := make([]*pb.PostRecord, 0, IMPORT_BATCH_SIZE)
+	for n := 0; ; n++ {
+		req, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		if n == 0 {
+			mode = req.ConflictMode
+		}
+		violations := postViolations(req.Record.GetPost(), false, "record.post.")
+		violations = append(violations, tagViolations(req.Record.GetTags(), "record.tags")...)
+		if req.Record.GetVersion() < 0 {
+			violations = append(violations, &errdetails.BadRequest_FieldViolation{
+				Field:       "record.version",
+				Description: "must not be negative",
+			})
+		}
+		if err := badRequest(fmt.Sprintf("invalid record %d", n), violations); err != nil {
+			return err
+		}
+
+		batch = append(batch, req.Record)
+		if len(batch) == IMPORT_BATCH_SIZE {
+			if err := s.importBatch(ctx, mode, batch, res); err != nil {
+				return toStatus(err)
+			}
+			batch = batch[:0]
+		}
+	}
+
+	return toStatus(s.importBatch(ctx, mode, batch, res))
+}
+
+// importBatch writes the records in one transaction, adding the outcomes to res only if it commits.
+// FUTURE: the existing posts could be fetched with a single IN query rather than one per record.
+func (s *Server) importBatch(ctx context.Context, mode pb.ConflictMode, batch []*pb.PostRecord, res *pb.ImportPostsResponse) error {
+	if len(batch) == 0 {
+		return nil
+	}
+
+	counts := &pb.ImportPostsResponse{}
+	changes := []auditChange{}
+	err := s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		u, err := s.usage(tx)
+		if err != nil {
+			return err
+		}
+
+		for _, rec := range batch {
+			existing := &Post{}
+			err := tx.
+				Unscoped().
+				Where("post_id = ?", rec.Post.Id).
+				First(existing).Error
+			if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+				return err
+			}
+
+			switch {
+			case err != nil:
+				counts.Created++
+				fields, err := s.importCreate(tx, u, rec)
+				if err != nil {
+					return err
+				}
+				changes = append(changes, auditChange{postID: rec.Post.Id, fields: fields})
+			case mode == pb.ConflictMode_CONFLICT_MODE_UPSERT:
+				counts.Updated++
+				fields, err := s.importUpsert(tx, u, existing, rec)
+				if err != nil {
+					return err
+				}
+				changes = append(changes, auditChange{postID: rec.Post.Id, fields: fields})
+			default:
+				counts.Skipped++
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, change := range changes {
+		audit(ctx, change.postID, change.fields...)
+	}
+	if counts.Updated > 0 {
+		for _, rec := range batch {
+			s.uncache(ctx, rec.Post.Id)
+		}
+	}
+
+	res.Created += counts.Created
+	res.Updated += counts.Updated
+	res.Skipped += counts.Skipped
+	return nil
+}
+
+// recordTimes returns the record's timestamps, defaulting to now when not given.
+func recordTimes(rec *pb.PostRecord) (created, updated time.Time, deleted gorm.DeletedAt) {
+	now := time.Now()
+	created, updated = now, now
+	if rec.CreatedAt != nil {
+		created = rec.CreatedAt.AsTime()
+	}
+	if rec.UpdatedAt != nil {
+		updated = rec.UpdatedAt.AsTime()
+	}
+	if rec.DeletedAt != nil {
+		deleted = gorm.DeletedAt{Time: rec.DeletedAt.AsTime(), Valid: true}
+	}
+	return created, updated, deleted
+}
+
+// importCreate creates the record's post, returning the fields it set.
+func (s *Server) importCreate(tx *gorm.DB, u *quotaUsage, rec *pb.PostRecord) ([]string, error) {
+	dto := NewPost(rec.Post)
+	dto.CreatedAt, dto.UpdatedAt, dto.DeletedAt = recordTimes(rec)
+	// A zero version is set to 1 on create.
+	dto.Tags, dto.Version = rec.Tags, rec.Version
+	if !dto.DeletedAt.Valid {
+		// Soft-deleted posts do not count against the quota.
+		if err := u.add(1, dto.size()); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := tx.Create(&dto).Error; err != nil {
+		return nil, err
+	}
+	fields := postChanges(&Post{}, &dto)
+
+	if dto.DeletedAt.Valid {
+		return fields, nil
+	}
+	created := NewPbPost(&dto)
+	return fields, s.enqueue(tx, EVENT_POST_CREATED, dto.PostId, &created)
+}
+
+// importUpsert overwrites every field of existing with the record, unlike UpdatePost which
+// only merges non-empty fields, returning the fields it changed. A soft-deleted post is
+// restored unless the record is deleted too.
+func (s *Server) importUpsert(tx *gorm.DB, u *quotaUsage, existing *Post, rec *pb.PostRecord) ([]string, error) {
+	created, updated, deleted := recordTimes(rec)
+	if rec.CreatedAt == nil {
+		created = existing.CreatedAt
+	}
+
+	var posts, bytes int64
+	if !existing.DeletedAt.Valid {
+		posts, bytes = -1, -existing.size()
+	}
+	replacement := NewPost(rec.Post)
+	if !deleted.Valid {
+		posts, bytes = posts+1, bytes+replacement.size()
+	}
+	if err := u.add(posts, bytes); err != nil {
+		return nil, err
+	}
+
+	// UpdateColumns, unlike Save, keeps the passed updated_at rather than setting it to now. It
+	// is passed a struct rather than a map, so that encrypted fields go through their serializer,
+	// and runs no hooks, so Bytes and FullTextLength are set here.
+	// The version still increases, so that writers holding the existing version fail.
+	replacement.CreatedAt, replacement.UpdatedAt, replacement.DeletedAt = created, updated, deleted
+	replacement.Bytes = replacement.size()
+	replacement.FullTextLength = replacement.fullTextLength()
+	replacement.Version = existing.Version + 1
+	if rec.Version > replacement.Version {
+		replacement.Version = rec.Version
+	}
+	replacement.Tags = rec.Tags
+	fields := postChanges(existing, &replacement)
+	err := tx.
+		Unscoped().
+		Model(existing).
+		Select("author_id", "title", "description", "full_text", "bytes", "full_text_length", "status",
+			"publish_at", "created_at", "updated_at", "deleted_at", "version", "tags").
+		UpdateColumns(&replacement).Error
+	if err != nil {
+		return nil, err
+	}
+
+	if deleted.Valid {
+		return fields, s.enqueue(tx, EVENT_POST_DELETED, rec.Post.Id, &pb.PostID{Id: rec.Post.Id})
+	}
+	// The event carries the post as stored, e.g. with its status defaulted, not the record.
+	post := NewPbPost(&replacement)
+	return fields, s.enqueue(tx, EVENT_POST_UPDATED, rec.Post.Id, &post)
+}
@@ -0,0 +1,217 @@
+package endpoints
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+
+	pb "go_grpc_example/proto"
+	crudv2 "go_grpc_example/proto/v2"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// serve serves s over an in-memory listener and returns a client for it.
+// The opts are installed after the tenant resolver's.
+func serve(s *Server, opts ...grpc.ServerOption) (pb.CrudServiceClient, func()) {
+	conn, stop := serveConn(s, opts...)
+	return pb.NewCrudServiceClient(conn), stop
+}
+
+// serveConn serves s as both crud and crud.v2 over an in-memory listener, like serve, and
+// returns a connection to it.
+func serveConn(s *Server, opts ...grpc.ServerOption) (*grpc.ClientConn, func()) {
+	lis := bufconn.Listen(1024 * 1024)
+	opts = append(NewTenantResolver(TenantConfig{Default: DEFAULT_TENANT, TrustHeader: true}).ServerOptions(), opts...)
+	gs := grpc.NewServer(opts...)
+	pb.RegisterCrudServiceServer(gs, s)
+	crudv2.RegisterCrudServiceServer(gs, NewServerV2(s))
+	go gs.Serve(lis)
+
+	conn, err := grpc.Dial("bufnet",
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) {
+			return lis.Dial()
+		}))
+	if err != nil {
+		panic(err)
+	}
+	return conn, func() {
+		conn.Close()
+		gs.Stop()
+	}
+}
+
+func export(cli pb.CrudServiceClient, req *pb.ExportPostsRequest) []*pb.PostRecord {
+	stream, err := cli.ExportPosts(context.Background(), req)
+	So(err, ShouldBeNil)
+
+	recs := []*pb.PostRecord{}
+	for {
+		rec, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			return recs
+		}
+		So(err, ShouldBeNil)
+		recs = append(recs, rec)
+	}
+}
+
+func importRecords(cli pb.CrudServiceClient, mode pb.ConflictMode, recs []*pb.PostRecord) (*pb.ImportPostsResponse, error) {
+	stream, err := cli.ImportPosts(context.Background())
+	So(err, ShouldBeNil)
+	for i, rec := range recs {
+		req := &pb.ImportPostsRequest{Record: rec}
+		if i == 0 {
+			req.ConflictMode = mode
+		}
+		So(stream.Send(req), ShouldBeNil)
+	}
+	return stream.CloseAndRecv()
+}
+
+func TestDataset(t *testing.T) {
+	Convey("Export and import tests", t, func() {
+		ctx := context.Background()
+		src, err := ConnectSQLite(filepath.Join(t.TempDir(), "src.db"))
+		So(err, ShouldBeNil)
+		srcCli, stopSrc := serve(NewServer(src))
+		defer stopSrc()
+
+		for _, id := range []string{"1", "2", "3"} {
+			_, err := srcCli.CreatePost(ctx, &pb.Post{Id: id, AuthorId: "jose", Title: "title " + id})
+			So(err, ShouldBeNil)
+		}
+		_, err = srcCli.DeletePost(ctx, &pb.PostID{Id: "2"})
+		So(err, ShouldBeNil)
+
+		Convey("Exports omit deleted posts and timestamps by default", func() {
+			recs := export(srcCli, &pb.ExportPostsRequest{})
+			So(recs, ShouldHaveLength, 2)
+			So(recs[0].Post.Id, ShouldEqual, "1")
+			So(recs[1].Post.Id, ShouldEqual, "3")
+			So(recs[0].CreatedAt, ShouldBeNil)
+			So(recs[0].DeletedAt, ShouldBeNil)
+		})
+
+		Convey("Exports may include deleted posts and timestamps", func() {
+			recs := export(srcCli, &pb.ExportPostsRequest{IncludeDeleted: true, IncludeTimestamps: true})
+			So(recs, ShouldHaveLength, 3)
+			So(recs[1].Post.Id, ShouldEqual, "2")
+			So(recs[1].DeletedAt, ShouldNotBeNil)
+			So(recs[0].CreatedAt, ShouldNotBeNil)
+			So(recs[0].DeletedAt, ShouldBeNil)
+		})
+
+		Convey("Imports restore an export, including deleted posts and timestamps", func() {
+			dst, err := ConnectSQLite(filepath.Join(t.TempDir(), "dst.db"))
+			So(err, ShouldBeNil)
+			dstCli, stopDst := serve(NewServer(dst))
+			defer stopDst()
+
+			_, err = srcCli.UpdatePost(ctx, &pb.Post{Id: "1", Title: "title 1 v2"})
+			So(err, ShouldBeNil)
+			err = src.WithContext(tenantContext(DEFAULT_TENANT)).
+				Where("post_id = ?", "1").Updates(&Post{Tags: []string{"go", "grpc"}}).Error
+			So(err, ShouldBeNil)
+
+			recs := export(srcCli, &pb.ExportPostsRequest{IncludeDeleted: true, IncludeTimestamps: true})
+			So(recs[0].Tags, ShouldResemble, []string{"go", "grpc"})
+			So(recs[0].Version, ShouldEqual, 2)
+			res, err := importRecords(dstCli, pb.ConflictMode_CONFLICT_MODE_SKIP, recs)
+			So(err, ShouldBeNil)
+			So(res.Created, ShouldEqual, 3)
+
+			restored := export(dstCli, &pb.ExportPostsRequest{IncludeDeleted: true, IncludeTimestamps: true})
+			So(restored, ShouldHaveLength, 3)
+			for i := range recs {
+				So(restored[i].Post.Id, ShouldEqual, recs[i].Post.Id)
+				So(restored[i].CreatedAt.AsTime().Equal(recs[i].CreatedAt.AsTime()), ShouldBeTrue)
+				So(restored[i].UpdatedAt.AsTime().Equal(recs[i].UpdatedAt.AsTime()), ShouldBeTrue)
+				So(restored[i].DeletedAt != nil, ShouldEqual, recs[i].DeletedAt != nil)
+				So(restored[i].Tags, ShouldResemble, recs[i].Tags)
+				So(restored[i].Version, ShouldEqual, recs[i].Version)
+			}
+
+			_, err = dstCli.ReadPost(ctx, &pb.PostID{Id: "2"})
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("Skip mode keeps existing posts", func() {
+			res, err := importRecords(srcCli, pb.ConflictMode_CONFLICT_MODE_SKIP, []*pb.PostRecord{
+				{Post: &pb.Post{Id: "1", AuthorId: "jose", Title: "new"}},
+				{Post: &pb.Post{Id: "4", AuthorId: "jose", Title: "new"}},
+			})
+			So(err, ShouldBeNil)
+			So(res.Created, ShouldEqual, 1)
+			So(res.Skipped, ShouldEqual, 1)
+
+			post, err := srcCli.ReadPost(ctx, &pb.PostID{Id: "1"})
+			So(err, ShouldBeNil)
+			So(post.Title, ShouldEqual, "title 1")
+		})
+
+		Convey("Upsert mode overwrites existing posts, restoring deleted ones", func() {
+			updated := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+			res, err := importRecords(srcCli, pb.ConflictMode_CONFLICT_MODE_UPSERT, []*pb.PostRecord{
+				{Post: &pb.Post{Id: "1", AuthorId: "jose", Title: "new"}, UpdatedAt: timestamppb.New(updated),
+					Tags: []string{"go"}, Version: 5},
+				{Post: &pb.Post{Id: "2", AuthorId: "jose", Title: "restored"}},
+			})
+			So(err, ShouldBeNil)
+			So(res.Updated, ShouldEqual, 2)
+
+			recs := export(srcCli, &pb.ExportPostsRequest{IncludeTimestamps: true})
+			So(recs, ShouldHaveLength, 3)
+			So(recs[0].Post.Title, ShouldEqual, "new")
+			So(recs[0].UpdatedAt.AsTime().Equal(updated), ShouldBeTrue)
+			So(recs[0].Tags, ShouldResemble, []string{"go"})
+			So(recs[0].Version, ShouldEqual, 5)
+			// A version behind the existing post's is not restored.
+			So(recs[1].Post.Title, ShouldEqual, "restored")
+			So(recs[1].Version, ShouldEqual, 2)
+		})
+
+		Convey("Records without a post id, or with invalid tags, are rejected", func() {
+			_, err := importRecords(srcCli, pb.ConflictMode_CONFLICT_MODE_SKIP, []*pb.PostRecord{{}})
+			So(err, ShouldNotBeNil)
+			_, err = importRecords(srcCli, pb.ConflictMode_CONFLICT_MODE_SKIP, []*pb.PostRecord{
+				{Post: &pb.Post{Id: "4", AuthorId: "jose", Title: "title"}, Tags: []string{"Not A Tag"}},
+			})
+			So(violations(err), ShouldContainKey, "record.tags[0]")
+		})
+
+		Convey("Imports failing after a committed batch report its counts, and resume in skip mode", func() {
+			recs := make([]*pb.PostRecord, 0, IMPORT_BATCH_SIZE+2)
+			for i := 0; i < IMPORT_BATCH_SIZE+1; i++ {
+				recs = append(recs, &pb.PostRecord{Post: &pb.Post{Id: fmt.Sprintf("import-%d", i), AuthorId: "jose", Title: "title"}})
+			}
+			_, err := importRecords(srcCli, pb.ConflictMode_CONFLICT_MODE_SKIP, append(recs, &pb.PostRecord{}))
+			So(status.Code(err), ShouldEqual, codes.InvalidArgument)
+			var partial *pb.ImportPostsResponse
+			for _, detail := range status.Convert(err).Details() {
+				if res, ok := detail.(*pb.ImportPostsResponse); ok {
+					partial = res
+				}
+			}
+			So(partial, ShouldNotBeNil)
+			So(partial.Created, ShouldEqual, IMPORT_BATCH_SIZE)
+
+			res, err := importRecords(srcCli, pb.ConflictMode_CONFLICT_MODE_SKIP, recs)
+			So(err, ShouldBeNil)
+			So(res.Created, ShouldEqual, 1)
+			So(res.Skipped, ShouldEqual, IMPORT_BATCH_SIZE)
+		})
+	})
+}
@@ -1,13 +1,21 @@
 package endpoints
 
 import (
+	"context"
 	"fmt"
-	"log"
+	"math/rand"
+	"net/url"
+	"strconv"
+	"strings"
 	"time"
 
+	"go_grpc_example/outbox"
 	pb "go_grpc_example/proto"
 
+	"google.golang.org/grpc/health"
+	"google.golang.org/protobuf/types/known/timestamppb"
 	"gorm.io/driver/postgres"
+	"gorm.io/driver/sqlite"
 	"gorm.io/gorm"
 )
 
@@ -34,14 +42,33 @@ type Post struct {
 	UpdatedAt time.Time      `json:"updated_at,omitempty"`
 	DeletedAt gorm.DeletedAt `gorm:"index" json:"deleted_at,omitempty"`
 	//gorm.Model
+	// TenantID is set and filtered by the TenantScope plugin, never by endpoints themselves.
+	// Posts that predate tenants belong to the default tenant.
+	TenantID string `gorm:"uniqueIndex:idx_posts_tenant_post,priority:1;size:64;not null;default:'default'" json:"tenant_id,omitempty"`
 	// PostId is redundant wrt to ID, but seems about right to hide the internal id by default.
 	// Id's represent something to their consumer, in this case the app layer; hence it could be
 	// something like the hash of post fields, a concatenation of logical ones, whatever ones reqs.
-	PostId      string `json:"post_id,omitempty"`
+	// PostIds are unique per tenant, including those of soft-deleted posts.
+	PostId      string `gorm:"uniqueIndex:idx_posts_tenant_post,priority:2" json:"post_id,omitempty"`
 	AuthorId    string `json:"author_id,omitempty"`
 	Title       string `json:"title,omitempty"`
-	Description string `json:"description,omitempty"`
-	FullText    string `json:"full_text,omitempty"`
+	Description string `gorm:"serializer:encrypted" json:"description,omitempty"`
+	FullText    string `gorm:"serializer:encrypted" json:"full_text,omitempty"`
+	// Description and FullText are sealed at rest once encryption keys are configured, while
+	// the other fields stay plaintext so ListPosts can still filter and order by them. Bytes is
+	// size(), and FullTextLength the length of FullText in characters, stored by BeforeSave
+	// since sql cannot compute them from sealed fields.
+	Bytes          int64 `gorm:"not null;default:0" json:"-"`
+	FullTextLength int64 `gorm:"not null;default:0" json:"-"`
+	// Status is one of the STATUS_* constants; posts that predate statuses are published.
+	Status    string     `gorm:"size:16;not null;default:'published';index" json:"status,omitempty"`
+	PublishAt *time.Time `gorm:"index" json:"publish_at,omitempty"`
+	// Version counts the changes to a post, starting at 1; posts that predate it are at 1.
+	// On a Post passed to updatePost, a non-zero Version is the version the post must be at.
+	Version int64 `gorm:"not null;default:1" json:"version,omitempty"`
+	// Tags are stored as a json array, which ListPosts matches with LIKE, so tags may not
+	// contain '"', '%', or '_'.
+	Tags []string `gorm:"serializer:json" json:"tags,omitempty"`
 }
 
 const (
@@ -55,61 +82,169 @@ const (
 	DB_PORT_DEFAULT = "5432"
 	DB_USER_PATH    = "/etc/secrets/db/user"
 	DB_PASS_PATH    = "/etc/secrets/db/passwd"
+
+	// DB_MAX_OPEN_CONNS and DB_MAX_IDLE_CONNS bound the connection pool; 0 is unlimited open
+	// connections, and no idle connections respectively.
+	DB_MAX_OPEN_CONNS = "DB_MAX_OPEN_CONNS"
+	DB_MAX_IDLE_CONNS = "DB_MAX_IDLE_CONNS"
+	// DB_CONN_MAX_LIFETIME and DB_CONN_MAX_IDLE_TIME recycle connections, e.g. "30m"; 0 never does.
+	DB_CONN_MAX_LIFETIME  = "DB_CONN_MAX_LIFETIME"
+	DB_CONN_MAX_IDLE_TIME = "DB_CONN_MAX_IDLE_TIME"
+	// DB_STATEMENT_TIMEOUT makes postgres cancel statements running longer, e.g. "30s"; 0 disables it.
+	DB_STATEMENT_TIMEOUT = "DB_STATEMENT_TIMEOUT"
+	// DB_CONNECT_TIMEOUT is how long Connect retries at startup while postgres is unreachable, e.g. "1m".
+	DB_CONNECT_TIMEOUT = "DB_CONNECT_TIMEOUT"
+	// DB_REPLICA_ADDRS lists the host:port of read replicas, comma-separated, which share the
+	// primary's creds and db name. Empty reads everything from the primary.
+	DB_REPLICA_ADDRS = "DB_REPLICA_ADDRS"
+	// DB_READ_YOUR_WRITES is how long clients read from the primary after writing, e.g. "5s";
+	// 0 disables it, leaving clients to read their writes only once replicated.
+	DB_READ_YOUR_WRITES = "DB_READ_YOUR_WRITES"
+	// DB_REPLICA_CHECK_INTERVAL is how often replicas are pinged to take them in and out of rotation.
+	DB_REPLICA_CHECK_INTERVAL = "DB_REPLICA_CHECK_INTERVAL"
+
+	DB_MAX_OPEN_CONNS_DEFAULT     = 25
+	DB_MAX_IDLE_CONNS_DEFAULT     = 10
+	DB_CONN_MAX_LIFETIME_DEFAULT  = 30 * time.Minute
+	DB_CONN_MAX_IDLE_TIME_DEFAULT = 5 * time.Minute
+	DB_STATEMENT_TIMEOUT_DEFAULT  = 30 * time.Second
+	DB_CONNECT_TIMEOUT_DEFAULT    = time.Minute
+
+	// Connect attempts back off exponentially, with jitter, between these bounds.
+	connectBackoffMin = 250 * time.Millisecond
+	connectBackoffMax = 5 * time.Second
 )
 
+// DBPoolConfig configures the connection pool and startup retries of Connect.
+// The zero value keeps the database/sql defaults and makes a single connection attempt.
+type DBPoolConfig struct {
+	MaxOpenConns    int
+	MaxIdleConns    int
+	ConnMaxLifetime time.Duration
+	ConnMaxIdleTime time.Duration
+	// StatementTimeout is set as the statement_timeout of every connection, so it also
+	// applies to connections reopened after a postgres restart.
+	StatementTimeout time.Duration
+	// ConnectTimeout is how long Connect keeps retrying the first connection.
+	ConnectTimeout time.Duration
+}
+
+// Models are the gorm models migrated by EnsureDB and ConnectSQLite.
+var Models = append([]interface{}{
+	&Post{},
+	&IdempotencyRecord{},
+	&TenantQuota{},
+	&Attachment{},
+	&AuditRecord{},
+}, outbox.Models...)
+
 func NewPost(pbPost *pb.Post) Post {
-	return Post{
+	post := Post{
 		PostId:      pbPost.Id,
 		AuthorId:    pbPost.AuthorId,
 		Title:       pbPost.Title,
 		Description: pbPost.Description,
 		FullText:    pbPost.FullText,
+		Status:      statusName(pbPost.Status),
 	}
+	if pbPost.PublishAt != nil {
+		publishAt := pbPost.PublishAt.AsTime()
+		post.PublishAt = &publishAt
+	}
+	return post
 }
 
 func NewPbPost(post *Post) pb.Post {
+	var publishAt *timestamppb.Timestamp
+	if post.PublishAt != nil {
+		publishAt = timestamppb.New(*post.PublishAt)
+	}
 	return pb.Post{
 		Id:          post.PostId,
 		AuthorId:    post.AuthorId,
 		Title:       post.Title,
 		Description: post.Description,
 		FullText:    post.FullText,
+		Status:      statusEnum(post.Status),
+		PublishAt:   publishAt,
+	}
+}
+
+// BeforeCreate starts new posts at version 1.
+func (p *Post) BeforeCreate(tx *gorm.DB) error {
+	if p.Version == 0 {
+		p.Version = 1
 	}
+	return nil
 }
 
 // Merge updates fields in dest with the non-empty fields of src.
 // The return value indicates if any update occurred.
-// Afterward, dest will contain the id, post-id, and other mandatory fields of src.
+// Afterward, dest will contain the id and post-id of src, and keep its own timestamps, which
+// an update request does not carry.
 func Merge(src, dest *Post) (updated bool) {
 	dest.ID = src.ID
 	dest.PostId = src.PostId
-	dest.CreatedAt = src.CreatedAt
-	dest.DeletedAt = src.DeletedAt
-	dest.UpdatedAt = src.UpdatedAt
 
 	if src.AuthorId != "" && src.AuthorId != dest.AuthorId {
-		log.Println("updating authorID")
+		debugf("updating authorID\n")
 		dest.AuthorId = src.AuthorId
 		updated = true
 	}
 	if src.Description != "" && src.Description != dest.Description {
-		log.Println("updating description")
+		debugf("updating description\n")
 		dest.Description = src.Description
 		updated = true
 	}
 	if src.FullText != "" && src.FullText != dest.FullText {
-		log.Println("updating fulltext")
+		debugf("updating fulltext\n")
 		dest.FullText = src.FullText
 		updated = true
 	}
 	if src.Title != "" && src.Title != dest.Title {
-		log.Println("updating title")
+		debugf("updating title\n")
 		dest.Title = src.Title
 		updated = true
 	}
+	if len(src.Tags) > 0 && !equalTags(src.Tags, dest.Tags) {
+		debugf("updating tags\n")
+		dest.Tags = src.Tags
+		updated = true
+	}
 	return
 }
 
+// clearFields clears the named fields of dest, as UpdatePost does for the fields of its
+// update_mask that are unset, returning whether any were set.
+func clearFields(dest *Post, fields []string) (updated bool) {
+	for _, field := range fields {
+		switch field {
+		case "description":
+			updated = updated || dest.Description != ""
+			dest.Description = ""
+		case "full_text":
+			updated = updated || dest.FullText != ""
+			dest.FullText = ""
+		case "tags":
+			updated = updated || len(dest.Tags) > 0
+			dest.Tags = nil
+		}
+	}
+	return
+}
+
+func equalTags(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
 func ReadDBConfig() (*DBCreds, error) {
 	dbHost := GetEnv(DB_HOST, DB_HOST_DEFAULT)
 	dbPort := GetEnv(DB_PORT, DB_PORT_DEFAULT)
@@ -122,7 +257,7 @@ func ReadDBConfig() (*DBCreds, error) {
 			return nil, err
 		}
 	} else {
-		log.Println("Warning: db cred taken from insecure env. In prod, creds should be transferred via tempfs instead.")
+		warnf("Warning: db cred taken from insecure env. In prod, creds should be transferred via tempfs instead.\n")
 	}
 
 	dbPass := GetEnv(DB_PASSWORD, "")
@@ -132,7 +267,7 @@ func ReadDBConfig() (*DBCreds, error) {
 			return nil, err
 		}
 	} else {
-		log.Println("Warning: db cred taken from insecure env. In prod, creds should be transferred via tempfs instead.")
+		warnf("Warning: db cred taken from insecure env. In prod, creds should be transferred via tempfs instead.\n")
 	}
 
 	return &DBCreds{
@@ -143,46 +278,277 @@ func ReadDBConfig() (*DBCreds, error) {
 	}, nil
 }
 
-// Connect returns a gorm.DB for the passed creds.
-func Connect(creds *DBCreds) (*gorm.DB, error) {
-	dsn := fmt.Sprintf("postgres://%s:%s@%s/%s", ///posts?sslmode=disable",
-		creds.User,
-		creds.Pass,
-		creds.Addr,
-		creds.DbName)
-	log.Println("Connecting to dsn " + dsn)
+// DBReplicaConfig configures the read replicas of the primary db.
+type DBReplicaConfig struct {
+	Addrs          []string
+	ReadYourWrites time.Duration
+	CheckInterval  time.Duration
+}
+
+// ReadDBReplicaConfig reads the replica config from the env.
+func ReadDBReplicaConfig() (*DBReplicaConfig, error) {
+	cfg := &DBReplicaConfig{}
+	for _, addr := range strings.Split(GetEnv(DB_REPLICA_ADDRS, ""), ",") {
+		if addr = strings.TrimSpace(addr); addr != "" {
+			cfg.Addrs = append(cfg.Addrs, addr)
+		}
+	}
+
+	var err error
+	cfg.ReadYourWrites, err = time.ParseDuration(GetEnv(DB_READ_YOUR_WRITES, DB_READ_YOUR_WRITES_DEFAULT.String()))
+	if err != nil || cfg.ReadYourWrites < 0 {
+		return nil, fmt.Errorf("invalid %s: must be a non-negative duration", DB_READ_YOUR_WRITES)
+	}
+	cfg.CheckInterval, err = time.ParseDuration(GetEnv(DB_REPLICA_CHECK_INTERVAL, DB_REPLICA_CHECK_INTERVAL_DEFAULT.String()))
+	if err != nil || cfg.CheckInterval <= 0 {
+		return nil, fmt.Errorf("invalid %s: must be a positive duration", DB_REPLICA_CHECK_INTERVAL)
+	}
+	return cfg, nil
+}
+
+// ReadDBPoolConfig reads the pool config from the env, defaulting to values suited to a single
+// service replica in front of a default postgres (max_connections=100).
+func ReadDBPoolConfig() (*DBPoolConfig, error) {
+	cfg := &DBPoolConfig{}
+	ints := []struct {
+		env string
+		def int
+		dst *int
+	}{
+		{DB_MAX_OPEN_CONNS, DB_MAX_OPEN_CONNS_DEFAULT, &cfg.MaxOpenConns},
+		{DB_MAX_IDLE_CONNS, DB_MAX_IDLE_CONNS_DEFAULT, &cfg.MaxIdleConns},
+	}
+	for _, v := range ints {
+		n, err := strconv.Atoi(GetEnv(v.env, strconv.Itoa(v.def)))
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("invalid %s: must be a non-negative integer", v.env)
+		}
+		*v.dst = n
+	}
+
+	durations := []struct {
+		env string
+		def time.Duration
+		dst *time.Duration
+	}{
+		{DB_CONN_MAX_LIFETIME, DB_CONN_MAX_LIFETIME_DEFAULT, &cfg.ConnMaxLifetime},
+		{DB_CONN_MAX_IDLE_TIME, DB_CONN_MAX_IDLE_TIME_DEFAULT, &cfg.ConnMaxIdleTime},
+		{DB_STATEMENT_TIMEOUT, DB_STATEMENT_TIMEOUT_DEFAULT, &cfg.StatementTimeout},
+		{DB_CONNECT_TIMEOUT, DB_CONNECT_TIMEOUT_DEFAULT, &cfg.ConnectTimeout},
+	}
+	for _, v := range durations {
+		d, err := time.ParseDuration(GetEnv(v.env, v.def.String()))
+		if err != nil || d < 0 {
+			return nil, fmt.Errorf("invalid %s: must be a non-negative duration", v.env)
+		}
+		*v.dst = d
+	}
+	return cfg, nil
+}
+
+// postgresDSN returns the connection url for the creds, and the same url with the password redacted
+// for logging.
+func postgresDSN(creds *DBCreds, pool *DBPoolConfig) (dsn, redacted string) {
+	u := &url.URL{
+		Scheme: "postgres",
+		User:   url.UserPassword(creds.User, creds.Pass),
+		Host:   creds.Addr,
+		Path:   "/" + creds.DbName,
+	}
+	if pool.StatementTimeout > 0 {
+		// pgx sends unrecognized url params as runtime params on every new connection.
+		q := url.Values{}
+		q.Set("statement_timeout", strconv.FormatInt(pool.StatementTimeout.Milliseconds(), 10))
+		u.RawQuery = q.Encode()
+	}
+	return u.String(), u.Redacted()
+}
+
+// Connect returns a gorm.DB for the passed creds, configured per the pool config if not nil.
+// While postgres is unreachable, as when the pod starts alongside it, Connect retries with
+// exponential backoff for up to pool.ConnectTimeout.
+//
+// Once connected, database/sql replaces broken connections transparently, e.g. after a postgres
+// restart: the statement on a dead connection fails, and is retried on a new one if it had not
+// been sent; otherwise it fails with Unavailable, which clients may retry. See MonitorDB.
+func Connect(creds *DBCreds, pool *DBPoolConfig) (*gorm.DB, error) {
+	cfg := DBPoolConfig{}
+	if pool != nil {
+		cfg = *pool
+	}
+
+	dsn, redacted := postgresDSN(creds, &cfg)
+	infof("Connecting to dsn %s\n", redacted)
+
+	deadline := time.Now().Add(cfg.ConnectTimeout)
+	backoff := connectBackoffMin
+	for attempt := 1; ; attempt++ {
+		db, err := openPostgres(dsn, &cfg, true)
+		if err == nil {
+			return db, nil
+		}
+
+		wait := backoff/2 + time.Duration(rand.Int63n(int64(backoff/2)+1))
+		if time.Now().Add(wait).After(deadline) {
+			return nil, fmt.Errorf("connecting to %s failed after %d attempts: %w", redacted, attempt, err)
+		}
+		warnf("db connection attempt %d failed, retrying in %v: %v\n", attempt, wait.Round(time.Millisecond), err)
+		time.Sleep(wait)
+
+		if backoff *= 2; backoff > connectBackoffMax {
+			backoff = connectBackoffMax
+		}
+	}
+}
+
+// ConnectReplica returns a gorm.DB for the replica at addr, sharing the primary's creds and db
+// name. Unlike Connect it neither pings nor retries: a replica that is unreachable at startup
+// is simply kept out of rotation by the ReplicaSet until it comes up.
+func ConnectReplica(creds *DBCreds, pool *DBPoolConfig, addr string) (*gorm.DB, error) {
+	cfg := DBPoolConfig{}
+	if pool != nil {
+		cfg = *pool
+	}
+
+	replicaCreds := *creds
+	replicaCreds.Addr = addr
+	dsn, redacted := postgresDSN(&replicaCreds, &cfg)
+	infof("Connecting to replica dsn %s\n", redacted)
+	return openPostgres(dsn, &cfg, false)
+}
 
-	return gorm.Open(
+// openPostgres opens a connection pool and, if ping is set, pings it, closing it if postgres
+// is unreachable.
+func openPostgres(dsn string, cfg *DBPoolConfig, ping bool) (*gorm.DB, error) {
+	db, err := gorm.Open(
 		postgres.New(
 			postgres.Config{
 				DSN:                  dsn,
 				PreferSimpleProtocol: true, // disables implicit prepared statement usage
-			}), &gorm.Config{})
+			}), &gorm.Config{DisableAutomaticPing: !ping})
+	if err != nil {
+		// gorm pings on open, so the pool exists even though postgres is unreachable.
+		if db != nil {
+			if sqlDB, dbErr := db.DB(); dbErr == nil {
+				sqlDB.Close()
+			}
+		}
+		return nil, err
+	}
+
+	sqlDB, err := db.DB()
+	if err != nil {
+		return nil, err
+	}
+	sqlDB.SetMaxOpenConns(cfg.MaxOpenConns)
+	sqlDB.SetMaxIdleConns(cfg.MaxIdleConns)
+	sqlDB.SetConnMaxLifetime(cfg.ConnMaxLifetime)
+	sqlDB.SetConnMaxIdleTime(cfg.ConnMaxIdleTime)
+
+	if err := db.Use(TenantScope{}); err != nil {
+		sqlDB.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
+// MonitorDB pings the db every interval until ctx is done, logging when it becomes unreachable
+// and when it recovers. The pool reconnects on its own; the log just makes outages visible.
+// Unless hs is nil, the crud services are reported as not serving while the db is unreachable,
+// so that balancing clients send their rpcs to other replicas.
+func MonitorDB(ctx context.Context, db *gorm.DB, interval time.Duration, hs *health.Server) {
+	sqlDB, err := db.DB()
+	if err != nil {
+		warnf("db monitor disabled: %v\n", err)
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	var down time.Time
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		pingCtx, cancel := context.WithTimeout(ctx, interval)
+		err := sqlDB.PingContext(pingCtx)
+		cancel()
+
+		switch {
+		case err != nil && down.IsZero():
+			down = time.Now()
+			errorf("db unreachable: %v\n", err)
+			if hs != nil {
+				setServing(hs, false)
+			}
+		case err == nil && !down.IsZero():
+			infof("db reachable again after %v\n", time.Since(down).Round(time.Second))
+			down = time.Time{}
+			if hs != nil {
+				setServing(hs, true)
+			}
+		}
+	}
+}
+
+// ConnectSQLite returns a gorm.DB backed by the sqlite file at path, with the Models migrated.
+// This is meant for local benchmarks and tests which should not require postgres. The db is
+// opened in WAL mode with a busy timeout so that concurrent writers wait rather than fail.
+func ConnectSQLite(path string) (*gorm.DB, error) {
+	dsn := fmt.Sprintf("file:%s?_journal_mode=WAL&_busy_timeout=5000&_txlock=immediate", path)
+	db, err := gorm.Open(sqlite.Open(dsn), &gorm.Config{})
+	if err != nil {
+		return nil, err
+	}
+
+	if err := db.Use(TenantScope{}); err != nil {
+		return nil, err
+	}
+	if err := db.AutoMigrate(Models...); err != nil {
+		return nil, err
+	}
+	if err := backfillPostBytes(db); err != nil {
+		return nil, err
+	}
+	if err := backfillFullTextLength(db); err != nil {
+		return nil, err
+	}
+	return db, nil
 }
 
 func DeleteDb(db *gorm.DB, dbName, tableName string) {
-	log.Println("WARNING: deleting existing table, if it exists. This is only for development.")
+	warnf("WARNING: deleting existing table, if it exists. This is only for development.\n")
 	tx := db.Exec(fmt.Sprintf("DROP TABLE %s;", tableName))
 	if tx.Error != nil {
-		log.Printf("DeleteDB dropping table %s: %v\n", tableName, tx.Error)
+		errorf("DeleteDB dropping table %s: %v\n", tableName, tx.Error)
 	}
 
-	log.Println("WARNING: deleting existing db, if it exists. This is only for development.")
+	warnf("WARNING: deleting existing db, if it exists. This is only for development.\n")
 	tx = db.Exec(fmt.Sprintf("DROP DATABASE %s;", dbName))
 	if tx.Error != nil {
-		log.Printf("DeleteDB error dropping db %s: %v\n", dbName, tx.Error)
+		errorf("DeleteDB error dropping db %s: %v\n", dbName, tx.Error)
 	}
 }
 
 // EnsureDB checks if a database exists by attempting to query the passed table.
 // This function is purely for development and is not a robust way to check.
-func EnsureDB(db *gorm.DB, dbName string, migrateObj interface{}) error {
+func EnsureDB(db *gorm.DB, dbName string, migrateObjs ...interface{}) error {
 	// TODO: what is this 'sql injection' of which thou speak?
 	tx := db.Exec(fmt.Sprintf("CREATE DATABASE %s;", dbName))
 	if tx.Error != nil {
-		log.Printf("%v\n", tx.Error)
+		errorf("%v\n", tx.Error)
 	}
 
 	// Migrate the schema
-	return db.AutoMigrate(migrateObj)
+	if err := db.AutoMigrate(migrateObjs...); err != nil {
+		return err
+	}
+	if err := backfillPostBytes(db); err != nil {
+		return err
+	}
+	return backfillFullTextLength(db)
 }
@@ -0,0 +1,103 @@
+package endpoints
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc"
+)
+
+const (
+	// RPC_MAX_DEADLINE_DEFAULT bounds unary rpcs, whose storage calls should take milliseconds.
+	RPC_MAX_DEADLINE_DEFAULT = 30 * time.Second
+	// STREAM_MAX_DEADLINE_DEFAULT bounds streaming rpcs such as ListPosts and ExportPosts.
+	STREAM_MAX_DEADLINE_DEFAULT = 10 * time.Minute
+)
+
+// Deadlines caps how long each rpc may run, regardless of the deadline the client asked for,
+// so that abandoned or runaway rpcs cannot hold db connections indefinitely. Since every
+// storage call is bound to the rpc context, the db statement is canceled when the cap expires.
+// A zero duration leaves rpcs uncapped.
+type Deadlines struct {
+	Unary  time.Duration
+	Stream time.Duration
+	// Methods overrides the cap of individual rpcs by their short name, e.g. "ExportPosts".
+	Methods map[string]time.Duration
+}
+
+// ParseMethodDeadlines parses a comma-separated list of per-rpc caps, e.g. "ExportPosts=1h,ListPosts=2m".
+func ParseMethodDeadlines(s string) (map[string]time.Duration, error) {
+	methods := map[string]time.Duration{}
+	for _, kv := range strings.Split(s, ",") {
+		kv = strings.TrimSpace(kv)
+		if kv == "" {
+			continue
+		}
+
+		method, val, ok := strings.Cut(kv, "=")
+		if !ok {
+			return nil, fmt.Errorf("%q is not method=duration", kv)
+		}
+		d, err := time.ParseDuration(strings.TrimSpace(val))
+		if err != nil || d < 0 {
+			return nil, fmt.Errorf("%q does not have a non-negative duration", kv)
+		}
+		methods[strings.TrimSpace(method)] = d
+	}
+	return methods, nil
+}
+
+// max returns the cap of the rpc with the full method name, e.g. "/proto.CrudService/ListPosts".
+// Health checks are uncapped.
+func (d Deadlines) max(fullMethod string, stream bool) time.Duration {
+	if isHealthCheck(fullMethod) {
+		return 0
+	}
+	name := fullMethod[strings.LastIndex(fullMethod, "/")+1:]
+	if max, ok := d.Methods[name]; ok {
+		return max
+	}
+	if stream {
+		return d.Stream
+	}
+	return d.Unary
+}
+
+// bound returns ctx with its deadline shortened to max, if it is later or missing.
+func bound(ctx context.Context, max time.Duration) (context.Context, context.CancelFunc) {
+	if max <= 0 {
+		return ctx, func() {}
+	}
+	if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) <= max {
+		return ctx, func() {}
+	}
+	return context.WithTimeout(ctx, max)
+}
+
+// UnaryInterceptor caps the deadline of unary rpcs.
+func (d Deadlines) UnaryInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		ctx, cancel := bound(ctx, d.max(info.FullMethod, false))
+		defer cancel()
+		return handler(ctx, req)
+	}
+}
+
+// StreamInterceptor caps the deadline of streaming rpcs.
+func (d Deadlines) StreamInterceptor() grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		ctx, cancel := bound(ss.Context(), d.max(info.FullMethod, true))
+		defer cancel()
+		return handler(srv, &contextStream{ServerStream: ss, ctx: ctx})
+	}
+}
+
+// ServerOptions returns the grpc server options installing the interceptors.
+func (d Deadlines) ServerOptions() []grpc.ServerOption {
+	return []grpc.ServerOption{
+		grpc.ChainUnaryInterceptor(d.UnaryInterceptor()),
+		grpc.ChainStreamInterceptor(d.StreamInterceptor()),
+	}
+}
@@ -0,0 +1,128 @@
+package endpoints
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	pb "go_grpc_example/proto"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"gorm.io/gorm"
+)
+
+func TestDeadlines(t *testing.T) {
+	Convey("Deadline and cancellation tests", t, func() {
+		db, err := ConnectSQLite(filepath.Join(t.TempDir(), "test.db"))
+		So(err, ShouldBeNil)
+		s := NewServer(db)
+		ctx := tenantContext(DEFAULT_TENANT)
+
+		_, err = s.CreatePost(ctx, &pb.Post{Id: "1", AuthorId: "jose", Title: "title"})
+		So(err, ShouldBeNil)
+
+		Convey("Per-rpc caps are parsed", func() {
+			methods, err := ParseMethodDeadlines("ExportPosts=1h, ListPosts = 2m,")
+			So(err, ShouldBeNil)
+			So(methods, ShouldResemble, map[string]time.Duration{"ExportPosts": time.Hour, "ListPosts": 2 * time.Minute})
+
+			d := Deadlines{Unary: time.Second, Stream: time.Minute, Methods: methods}
+			So(d.max("/proto.CrudService/ReadPost", false), ShouldEqual, time.Second)
+			So(d.max("/proto.CrudService/ImportPosts", true), ShouldEqual, time.Minute)
+			So(d.max("/proto.CrudService/ExportPosts", true), ShouldEqual, time.Hour)
+
+			_, err = ParseMethodDeadlines("ListPosts")
+			So(err, ShouldNotBeNil)
+			_, err = ParseMethodDeadlines("ListPosts=-1s")
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("Storage calls are bound to the rpc context", func() {
+			canceled, cancel := context.WithCancel(ctx)
+			cancel()
+
+			_, err := s.CreatePost(canceled, &pb.Post{Id: "2", AuthorId: "jose", Title: "title"})
+			So(status.Code(err), ShouldEqual, codes.Canceled)
+			_, err = s.ReadPost(canceled, &pb.PostID{Id: "1"})
+			So(status.Code(err), ShouldEqual, codes.Canceled)
+			_, err = s.UpdatePost(canceled, &pb.Post{Id: "1", Title: "new title"})
+			So(status.Code(err), ShouldEqual, codes.Canceled)
+			_, err = s.DeletePost(canceled, &pb.PostID{Id: "1"})
+			So(status.Code(err), ShouldEqual, codes.Canceled)
+
+			post, err := s.ReadPost(ctx, &pb.PostID{Id: "1"})
+			So(err, ShouldBeNil)
+			So(post.Title, ShouldEqual, "title")
+			_, err = s.ReadPost(ctx, &pb.PostID{Id: "2"})
+			So(status.Code(err), ShouldEqual, codes.NotFound)
+		})
+
+		Convey("Slow queries are canceled at the server's cap", func() {
+			// Stall queries until their context is done, as a slow statement would.
+			err := db.Callback().Query().Before("gorm:query").Register("test:stall", func(tx *gorm.DB) {
+				<-tx.Statement.Context.Done()
+			})
+			So(err, ShouldBeNil)
+
+			cli, stop := serve(s, Deadlines{Unary: 50 * time.Millisecond}.ServerOptions()...)
+			defer stop()
+
+			start := time.Now()
+			_, err = cli.ReadPost(context.Background(), &pb.PostID{Id: "1"})
+			So(status.Code(err), ShouldEqual, codes.DeadlineExceeded)
+			So(time.Since(start), ShouldBeLessThan, time.Second)
+
+			// Shorter client deadlines are kept: under a cap of a minute, the stalled read only
+			// returns in time if the client's deadline applied.
+			capped, stopCapped := serve(s, Deadlines{Unary: time.Minute}.ServerOptions()...)
+			defer stopCapped()
+			short, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+			defer cancel()
+			start = time.Now()
+			_, err = capped.ReadPost(short, &pb.PostID{Id: "1"})
+			So(status.Code(err), ShouldEqual, codes.DeadlineExceeded)
+			So(time.Since(start), ShouldBeLessThan, time.Second)
+		})
+
+		Convey("Canceled streams close their cursor and return", func() {
+			// Enough data to exceed the stream's flow control window, so the server blocks in Send.
+			text := strings.Repeat("x", 32*1024)
+			for i := 0; i < 64; i++ {
+				_, err := s.CreatePost(ctx, &pb.Post{Id: strings.Repeat("p", i+2), AuthorId: "jose", Title: "title", FullText: text})
+				So(err, ShouldBeNil)
+			}
+
+			done := make(chan error, 1)
+			cli, stop := serve(s, grpc.ChainStreamInterceptor(
+				func(srv interface{}, ss grpc.ServerStream, _ *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+					err := handler(srv, ss)
+					done <- err
+					return err
+				}))
+			defer stop()
+
+			streamCtx, cancel := context.WithCancel(context.Background())
+			stream, err := cli.ListPosts(streamCtx, &pb.ListPostsRequest{})
+			So(err, ShouldBeNil)
+			_, err = stream.Recv()
+			So(err, ShouldBeNil)
+			cancel()
+
+			select {
+			case err := <-done:
+				So(err, ShouldNotBeNil)
+			case <-time.After(time.Second):
+				So("ListPosts still running after cancellation", ShouldBeEmpty)
+			}
+
+			sqlDB, err := db.DB()
+			So(err, ShouldBeNil)
+			So(sqlDB.Stats().InUse, ShouldEqual, 0)
+		})
+	})
+}
@@ -0,0 +1,186 @@
+package endpoints
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"sync/atomic"
+	"time"
+
+	"go_grpc_example/envelope"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/schema"
+)
+
+const (
+	// REENCRYPT_INTERVAL_DEFAULT is how often posts not sealed by the active key are re-sealed.
+	REENCRYPT_INTERVAL_DEFAULT = time.Hour
+
+	reencryptBatchSize = 100
+)
+
+// fieldKeys is the keyring of the "encrypted" serializer, which gorm registers globally, so
+// the keyring is global too. Nil stores new values as plaintext.
+var fieldKeys atomic.Pointer[envelope.Keyring]
+
+func init() {
+	schema.RegisterSerializer("encrypted", encryptedSerializer{})
+}
+
+// SetFieldEncryption sets the keyring sealing the columns of models tagged
+// `gorm:"serializer:encrypted"`, or nil to stop sealing new values.
+func SetFieldEncryption(k *envelope.Keyring) {
+	fieldKeys.Store(k)
+}
+
+// encryptedSerializer seals string fields with the keyring, using the column name as aad, so a
+// sealed value only opens in the column it was sealed for. The aad does not bind the row: a
+// value copied into another row, even of another tenant, still opens there.
+// FUTURE: bind the tenant and post-id too; gorm scans columns in table order, in which
+// tenant_id may follow full_text, so Scan cannot read them from dst.
+// Plaintext values, e.g. written before encryption was enabled, are read as is, so
+// encryption can be enabled on an existing db and ReencryptPosts left to seal old rows.
+// FUTURE: plaintext that happens to start with envelope.PREFIX is mistaken for a sealed value.
+type encryptedSerializer struct{}
+
+func (encryptedSerializer) Scan(ctx context.Context, field *schema.Field, dst reflect.Value, dbValue interface{}) error {
+	var value string
+	switch v := dbValue.(type) {
+	case nil:
+	case string:
+		value = v
+	case []byte:
+		value = string(v)
+	default:
+		return fmt.Errorf("failed to scan encrypted column %s: %#v", field.DBName, dbValue)
+	}
+
+	if envelope.IsSealed(value) {
+		k := fieldKeys.Load()
+		if k == nil {
+			return fmt.Errorf("column %s is encrypted, but no encryption keys are configured", field.DBName)
+		}
+		plaintext, err := k.Open(value, []byte(field.DBName))
+		if err != nil {
+			return fmt.Errorf("decrypting column %s: %w", field.DBName, err)
+		}
+		value = string(plaintext)
+	}
+
+	field.ReflectValueOf(ctx, dst).SetString(value)
+	return nil
+}
+
+func (encryptedSerializer) Value(ctx context.Context, field *schema.Field, dst reflect.Value, fieldValue interface{}) (interface{}, error) {
+	value, _ := fieldValue.(string)
+	k := fieldKeys.Load()
+	if k == nil || value == "" {
+		return value, nil
+	}
+	return k.Seal([]byte(value), []byte(field.DBName))
+}
+
+// sealedPost holds the encrypted columns of a post as stored, bypassing the serializer.
+type sealedPost struct {
+	ID          uint
+	Description string
+	FullText    string
+}
+
+// ReencryptPosts re-seals the text of posts of every tenant, including soft-deleted ones,
+// that is plaintext or sealed by a retired key, returning how many posts it re-sealed. Once
+// it completes, retired keys may be dropped from the keyring. It does nothing without keys.
+// Rows are updated only if unchanged since read, so concurrent writes win, and updated_at is
+// kept, since the posts did not change.
+func ReencryptPosts(ctx context.Context, db *gorm.DB) (int64, error) {
+	k := fieldKeys.Load()
+	if k == nil {
+		return 0, nil
+	}
+	db = db.WithContext(AllTenants(ctx))
+
+	var resealed int64
+	var last uint
+	for {
+		rows := []sealedPost{}
+		err := db.
+			Table(PostsTable).
+			Select("id", "description", "full_text").
+			Where("id > ?", last).
+			Order("id").
+			Limit(reencryptBatchSize).
+			Find(&rows).Error
+		if err != nil {
+			return resealed, err
+		}
+
+		for _, row := range rows {
+			last = row.ID
+			description, err := reseal(k, "description", row.Description)
+			if err != nil {
+				return resealed, fmt.Errorf("post %d: %w", row.ID, err)
+			}
+			fullText, err := reseal(k, "full_text", row.FullText)
+			if err != nil {
+				return resealed, fmt.Errorf("post %d: %w", row.ID, err)
+			}
+			if description == row.Description && fullText == row.FullText {
+				continue
+			}
+
+			res := db.
+				Table(PostsTable).
+				Where("id = ? AND description = ? AND full_text = ?", row.ID, row.Description, row.FullText).
+				UpdateColumns(map[string]interface{}{"description": description, "full_text": fullText})
+			if res.Error != nil {
+				return resealed, res.Error
+			}
+			resealed += res.RowsAffected
+		}
+
+		if len(rows) < reencryptBatchSize {
+			return resealed, nil
+		}
+	}
+}
+
+// reseal returns value sealed by the active key, or value itself if it already is, or empty.
+func reseal(k *envelope.Keyring, column, value string) (string, error) {
+	if value == "" || envelope.KeyID(value) == k.Active() {
+		return value, nil
+	}
+
+	plaintext := []byte(value)
+	if envelope.IsSealed(value) {
+		var err error
+		if plaintext, err = k.Open(value, []byte(column)); err != nil {
+			return "", err
+		}
+	}
+	return k.Seal(plaintext, []byte(column))
+}
+
+// RunReencryption re-seals posts every interval until ctx is done, so that a rotation, or
+// enabling encryption, reaches every post without a deploy step.
+func RunReencryption(ctx context.Context, db *gorm.DB, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		n, err := ReencryptPosts(ctx, db)
+		if err != nil && !errors.Is(err, context.Canceled) {
+			errorf("re-encrypting posts failed: %v\n", err)
+		}
+		if n > 0 {
+			infof("re-encrypted %d posts\n", n)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,162 @@
+package endpoints
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"path/filepath"
+	"testing"
+
+	"go_grpc_example/envelope"
+	pb "go_grpc_example/proto"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// testKeyring returns a keyring of the given key ids, the first active, each key derived from
+// its id so that the same id always has the same key.
+func testKeyring(ids ...string) *envelope.Keyring {
+	text := ""
+	for _, id := range ids {
+		key := bytes.Repeat([]byte{id[len(id)-1]}, envelope.KEY_SIZE)
+		text += id + ":" + base64.StdEncoding.EncodeToString(key) + "\n"
+	}
+	k, err := envelope.ParseKeyring(text)
+	So(err, ShouldBeNil)
+	return k
+}
+
+func TestFieldEncryption(t *testing.T) {
+	Convey("Field encryption tests", t, func() {
+		defer SetFieldEncryption(nil)
+		path := filepath.Join(t.TempDir(), "test.db")
+		db, err := ConnectSQLite(path)
+		So(err, ShouldBeNil)
+		ctx := tenantContext(DEFAULT_TENANT)
+		s := NewServer(db)
+
+		stored := func(postID string) sealedPost {
+			row := sealedPost{}
+			err := db.WithContext(AllTenants(ctx)).Unscoped().
+				Table(PostsTable).Where("post_id = ?", postID).Take(&row).Error
+			So(err, ShouldBeNil)
+			return row
+		}
+		post := &pb.Post{Id: "1", AuthorId: "jose", Title: "title", Description: "desc", FullText: "secret text"}
+		k1 := testKeyring("k1")
+
+		Convey("Text fields are sealed at rest and read back as plaintext", func() {
+			SetFieldEncryption(k1)
+			_, err := s.CreatePost(ctx, post)
+			So(err, ShouldBeNil)
+
+			row := stored("1")
+			So(envelope.KeyID(row.Description), ShouldEqual, "k1")
+			So(envelope.KeyID(row.FullText), ShouldEqual, "k1")
+			So(row.FullText, ShouldNotContainSubstring, "secret")
+			title := ""
+			So(db.WithContext(ctx).Model(&Post{}).Where("post_id = ?", "1").Pluck("title", &title).Error, ShouldBeNil)
+			So(title, ShouldEqual, "title")
+
+			read, err := s.ReadPost(ctx, &pb.PostID{Id: "1"})
+			So(err, ShouldBeNil)
+			So(read.Description, ShouldEqual, "desc")
+			So(read.FullText, ShouldEqual, "secret text")
+
+			_, err = s.UpdatePost(ctx, &pb.Post{Id: "1", FullText: "new text"})
+			So(err, ShouldBeNil)
+			So(envelope.KeyID(stored("1").FullText), ShouldEqual, "k1")
+
+			cli, stop := serve(s)
+			defer stop()
+			posts, err := cli.ListPosts(context.Background(), &pb.ListPostsRequest{})
+			So(err, ShouldBeNil)
+			listed, err := posts.Recv()
+			So(err, ShouldBeNil)
+			So(listed.FullText, ShouldEqual, "new text")
+
+			// Without the keys, sealed posts cannot be read.
+			SetFieldEncryption(nil)
+			_, err = s.ReadPost(ctx, &pb.PostID{Id: "1"})
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("Quotas count the plaintext size of sealed posts", func() {
+			SetFieldEncryption(k1)
+			s := NewServer(db, WithQuota(0, 30))
+			_, err := s.CreatePost(ctx, post)
+			So(err, ShouldBeNil)
+
+			p := Post{}
+			So(db.WithContext(ctx).Where("post_id = ?", "1").First(&p).Error, ShouldBeNil)
+			So(p.Bytes, ShouldEqual, p.size())
+
+			_, err = s.CreatePost(ctx, &pb.Post{Id: "2", AuthorId: "jose", Title: "title"})
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("Imports seal the text they overwrite", func() {
+			SetFieldEncryption(k1)
+			_, err := s.CreatePost(ctx, post)
+			So(err, ShouldBeNil)
+
+			cli, stop := serve(s)
+			defer stop()
+			rec := &pb.PostRecord{Post: &pb.Post{Id: "1", AuthorId: "jose", Title: "title", FullText: "imported"}}
+			_, err = importRecords(cli, pb.ConflictMode_CONFLICT_MODE_UPSERT, []*pb.PostRecord{rec})
+			So(err, ShouldBeNil)
+
+			So(envelope.KeyID(stored("1").FullText), ShouldEqual, "k1")
+			read, err := s.ReadPost(ctx, &pb.PostID{Id: "1"})
+			So(err, ShouldBeNil)
+			So(read.FullText, ShouldEqual, "imported")
+		})
+
+		Convey("Re-encryption seals plaintext posts and rotates keys", func() {
+			_, err := s.CreatePost(ctx, post)
+			So(err, ShouldBeNil)
+			_, err = s.CreatePost(tenantContext("other"), &pb.Post{Id: "2", AuthorId: "jose", Title: "title"})
+			So(err, ShouldBeNil)
+			So(stored("1").FullText, ShouldEqual, "secret text")
+			before := Post{}
+			So(db.WithContext(ctx).Where("post_id = ?", "1").First(&before).Error, ShouldBeNil)
+
+			n, err := ReencryptPosts(context.Background(), db)
+			So(err, ShouldBeNil)
+			So(n, ShouldEqual, 0)
+
+			SetFieldEncryption(k1)
+			n, err = ReencryptPosts(context.Background(), db)
+			So(err, ShouldBeNil)
+			So(n, ShouldEqual, 1)
+			So(envelope.KeyID(stored("1").FullText), ShouldEqual, "k1")
+
+			SetFieldEncryption(testKeyring("k2", "k1"))
+			n, err = ReencryptPosts(context.Background(), db)
+			So(err, ShouldBeNil)
+			So(n, ShouldEqual, 1)
+			n, err = ReencryptPosts(context.Background(), db)
+			So(err, ShouldBeNil)
+			So(n, ShouldEqual, 0)
+
+			// The retired key is no longer needed, and the posts are otherwise unchanged.
+			SetFieldEncryption(testKeyring("k2"))
+			p := Post{}
+			So(db.WithContext(ctx).Where("post_id = ?", "1").First(&p).Error, ShouldBeNil)
+			So(p.FullText, ShouldEqual, "secret text")
+			So(p.UpdatedAt, ShouldEqual, before.UpdatedAt)
+		})
+
+		Convey("Sizes of posts predating the bytes column are backfilled", func() {
+			_, err := s.CreatePost(ctx, post)
+			So(err, ShouldBeNil)
+			So(db.Exec("UPDATE posts SET bytes = 0").Error, ShouldBeNil)
+
+			db, err := ConnectSQLite(path)
+			So(err, ShouldBeNil)
+			p := Post{}
+			So(db.WithContext(ctx).Where("post_id = ?", "1").First(&p).Error, ShouldBeNil)
+			So(p.Bytes, ShouldEqual, p.size())
+		})
+	})
+}
@@ -0,0 +1,76 @@
+package endpoints
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"net"
+	"strings"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"gorm.io/gorm"
+)
+
+// toStatus converts db and context errors into grpc status errors with meaningful codes,
+// so that clients can act on the code instead of comparing error strings.
+// Errors that are already statuses, and unrecognized errors, are returned as-is.
+func toStatus(err error) error {
+	if err == nil {
+		return nil
+	}
+	if _, ok := status.FromError(err); ok {
+		return err
+	}
+
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		return status.Error(codes.NotFound, err.Error())
+	case errors.Is(err, context.DeadlineExceeded):
+		return status.Error(codes.DeadlineExceeded, err.Error())
+	case errors.Is(err, context.Canceled):
+		return status.Error(codes.Canceled, err.Error())
+	case isDuplicateKey(err):
+		return status.Error(codes.AlreadyExists, err.Error())
+	case isStatementTimeout(err):
+		return status.Error(codes.DeadlineExceeded, err.Error())
+	case isConnectionLost(err):
+		return status.Error(codes.Unavailable, err.Error())
+	}
+	return err
+}
+
+// isStatementTimeout reports whether postgres canceled a statement for exceeding its
+// statement_timeout, SQLSTATE 57014.
+func isStatementTimeout(err error) bool {
+	return strings.Contains(err.Error(), "SQLSTATE 57014")
+}
+
+// isConnectionLost reports whether err stems from an unreachable db or a broken connection,
+// as while postgres restarts. The pool reconnects on its own, so such requests may be retried.
+// Postgres reports connection exceptions as SQLSTATE 08xxx, and shutdowns as 57P01-57P03.
+func isConnectionLost(err error) bool {
+	var netErr net.Error
+	if errors.As(err, &netErr) ||
+		errors.Is(err, driver.ErrBadConn) ||
+		errors.Is(err, sql.ErrConnDone) ||
+		errors.Is(err, io.ErrUnexpectedEOF) {
+		return true
+	}
+
+	msg := err.Error()
+	return strings.Contains(msg, "SQLSTATE 08") ||
+		strings.Contains(msg, "SQLSTATE 57P0")
+}
+
+// isDuplicateKey reports whether err is a unique constraint violation. This gorm
+// version does not translate driver errors, so the driver messages are matched:
+// postgres reports SQLSTATE 23505, sqlite reports 'UNIQUE constraint failed'.
+func isDuplicateKey(err error) bool {
+	msg := err.Error()
+	return strings.Contains(msg, "SQLSTATE 23505") ||
+		strings.Contains(msg, "duplicate key value") ||
+		strings.Contains(msg, "UNIQUE constraint failed")
+}
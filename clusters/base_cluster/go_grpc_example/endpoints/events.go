@@ -0,0 +1,88 @@
+package endpoints
+
+import (
+	"encoding/json"
+	"errors"
+
+	"go_grpc_example/envelope"
+	"go_grpc_example/outbox"
+
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"gorm.io/gorm"
+)
+
+// Post change events, written to the outbox when enabled with WithOutbox. The payload of
+// deleted events is the PostID; of the others, the post after the change. With field
+// encryption, payloads are sealed too, as a sealedPayload that OpenEvent opens.
+const (
+	EVENT_POST_CREATED = "post.created"
+	EVENT_POST_UPDATED = "post.updated"
+	EVENT_POST_DELETED = "post.deleted"
+	// Status changes, including scheduled posts published by the scheduler.
+	EVENT_POST_PUBLISHED   = "post.published"
+	EVENT_POST_SCHEDULED   = "post.scheduled"
+	EVENT_POST_UNPUBLISHED = "post.unpublished"
+	EVENT_POST_ARCHIVED    = "post.archived"
+)
+
+// WithOutbox makes post writes insert change events into the outbox, in the same transaction.
+// The events pile up unless an outbox.Relay publishes them, so only enable both together.
+func WithOutbox() ServerOption {
+	return func(s *Server) {
+		s.outbox = true
+	}
+}
+
+// enqueue adds an event for the change to the outbox in tx, if the outbox is enabled.
+func (s *Server) enqueue(tx *gorm.DB, typ, key string, payload proto.Message) error {
+	if !s.outbox {
+		return nil
+	}
+
+	tenant, err := tenantFrom(tx.Statement.Context)
+	if err != nil {
+		return err
+	}
+
+	b, err := protojson.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	// Posts carry their description and full_text, which must not sit in the outbox, its dead
+	// letters, or the publishers' files as plaintext when they are sealed in the posts table.
+	if k := fieldKeys.Load(); k != nil {
+		sealed, err := k.Seal(b, eventAAD(tenant, typ, key))
+		if err != nil {
+			return err
+		}
+		if b, err = json.Marshal(sealedPayload{Sealed: sealed}); err != nil {
+			return err
+		}
+	}
+	return outbox.Enqueue(tx, tenant, typ, key, b)
+}
+
+// sealedPayload is the payload of events enqueued with field encryption enabled.
+type sealedPayload struct {
+	Sealed string `json:"sealed"`
+}
+
+// eventAAD binds a sealed payload to its event, so it cannot be opened as another's.
+func eventAAD(tenant, typ, key string) []byte {
+	return []byte("event\x00" + tenant + "\x00" + typ + "\x00" + key)
+}
+
+// OpenEvent returns the protojson payload of a post change event, opening it with the keyring
+// if it was sealed. Unsealed payloads are returned as is, and k may be nil if none are sealed.
+// Since dead letters keep their payloads, retired keys are needed until they are redriven.
+func OpenEvent(k *envelope.Keyring, msg outbox.Message) (json.RawMessage, error) {
+	var p sealedPayload
+	if json.Unmarshal(msg.Payload, &p) != nil || !envelope.IsSealed(p.Sealed) {
+		return msg.Payload, nil
+	}
+	if k == nil {
+		return nil, errors.New("event payload is sealed, but no encryption keys are configured")
+	}
+	return k.Open(p.Sealed, eventAAD(msg.Tenant, msg.Type, msg.Key))
+}
@@ -0,0 +1,132 @@
+package endpoints
+
+import (
+	"path/filepath"
+	"testing"
+
+	"go_grpc_example/outbox"
+	pb "go_grpc_example/proto"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+func TestEvents(t *testing.T) {
+	Convey("Post change event tests", t, func() {
+		db, err := ConnectSQLite(filepath.Join(t.TempDir(), "test.db"))
+		So(err, ShouldBeNil)
+		s := NewServer(db, WithOutbox())
+		ctx := tenantContext("blog")
+
+		events := func() []outbox.Event {
+			evs := []outbox.Event{}
+			So(db.Order("id").Find(&evs).Error, ShouldBeNil)
+			return evs
+		}
+
+		post := &pb.Post{Id: "123", AuthorId: "jose", Title: "Gone With the Wind"}
+		_, err = s.CreatePost(ctx, post)
+		So(err, ShouldBeNil)
+
+		Convey("Creates, updates, and deletes each enqueue an event", func() {
+			_, err := s.UpdatePost(ctx, &pb.Post{Id: "123", Title: "Gone"})
+			So(err, ShouldBeNil)
+			_, err = s.DeletePost(ctx, &pb.PostID{Id: "123"})
+			So(err, ShouldBeNil)
+
+			evs := events()
+			So(evs, ShouldHaveLength, 3)
+			So(evs[0].Type, ShouldEqual, EVENT_POST_CREATED)
+			So(evs[1].Type, ShouldEqual, EVENT_POST_UPDATED)
+			So(evs[2].Type, ShouldEqual, EVENT_POST_DELETED)
+			for _, ev := range evs {
+				So(ev.Key, ShouldEqual, "123")
+				So(ev.Tenant, ShouldEqual, "blog")
+			}
+
+			updated := &pb.Post{}
+			So(protojson.Unmarshal(evs[1].Payload, updated), ShouldBeNil)
+			So(updated.Title, ShouldEqual, "Gone")
+			So(updated.AuthorId, ShouldEqual, "jose")
+		})
+
+		Convey("Upserting imports enqueue the post as stored", func() {
+			// Imports are made as the default tenant.
+			_, err := s.CreatePost(tenantContext(DEFAULT_TENANT), post)
+			So(err, ShouldBeNil)
+			cli, stop := serve(s)
+			defer stop()
+			_, err = importRecords(cli, pb.ConflictMode_CONFLICT_MODE_UPSERT, []*pb.PostRecord{
+				{Post: &pb.Post{Id: "123", AuthorId: "jose", Title: "Gone", Html: "<p>ignored</p>"}},
+			})
+			So(err, ShouldBeNil)
+
+			evs := events()
+			So(evs, ShouldHaveLength, 3)
+			So(evs[2].Type, ShouldEqual, EVENT_POST_UPDATED)
+			updated := &pb.Post{}
+			So(protojson.Unmarshal(evs[2].Payload, updated), ShouldBeNil)
+			So(updated.Title, ShouldEqual, "Gone")
+			So(updated.Status, ShouldEqual, pb.PostStatus_POST_STATUS_PUBLISHED)
+			So(updated.Html, ShouldBeEmpty)
+		})
+
+		Convey("No-op writes enqueue nothing", func() {
+			_, err := s.UpdatePost(ctx, post)
+			So(err, ShouldBeNil)
+			_, err = s.DeletePost(ctx, &pb.PostID{Id: "missing"})
+			So(err, ShouldBeNil)
+			So(events(), ShouldHaveLength, 1)
+		})
+
+		Convey("Replayed creates enqueue nothing", func() {
+			for i := 0; i < 2; i++ {
+				_, err := s.CreatePost(withKey("abc"), &pb.Post{Id: "456", AuthorId: "jose", Title: "title"})
+				So(err, ShouldBeNil)
+			}
+			So(events(), ShouldHaveLength, 2)
+		})
+
+		Convey("Payloads are sealed with field encryption", func() {
+			defer SetFieldEncryption(nil)
+			SetFieldEncryption(testKeyring("k1"))
+			_, err := s.UpdatePost(ctx, &pb.Post{Id: "123", FullText: "secret text"})
+			So(err, ShouldBeNil)
+			So(string(events()[1].Payload), ShouldNotContainSubstring, "secret")
+
+			pub := outbox.NewMemoryPublisher()
+			_, err = outbox.NewRelay(db, pub).Poll(ctx)
+			So(err, ShouldBeNil)
+			msgs := pub.Messages()
+			So(msgs, ShouldHaveLength, 2)
+
+			// Events enqueued before encryption was enabled are returned as is.
+			created := &pb.Post{}
+			payload, err := OpenEvent(nil, msgs[0])
+			So(err, ShouldBeNil)
+			So(protojson.Unmarshal(payload, created), ShouldBeNil)
+			So(created.Title, ShouldEqual, "Gone With the Wind")
+
+			updated := &pb.Post{}
+			payload, err = OpenEvent(testKeyring("k1"), msgs[1])
+			So(err, ShouldBeNil)
+			So(protojson.Unmarshal(payload, updated), ShouldBeNil)
+			So(updated.FullText, ShouldEqual, "secret text")
+
+			_, err = OpenEvent(nil, msgs[1])
+			So(err, ShouldNotBeNil)
+			// A payload does not open as another event's.
+			msgs[1].Key = "456"
+			_, err = OpenEvent(testKeyring("k1"), msgs[1])
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("A relay publishes the events", func() {
+			pub := outbox.NewMemoryPublisher()
+			_, err := outbox.NewRelay(db, pub).Poll(ctx)
+			So(err, ShouldBeNil)
+			So(pub.Messages(), ShouldHaveLength, 1)
+			So(events(), ShouldHaveLength, 0)
+		})
+	})
+}
@@ -0,0 +1,45 @@
+package endpoints
+
+import (
+	"strings"
+
+	pb "go_grpc_example/proto"
+	crudv2 "go_grpc_example/proto/v2"
+
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+// healthServices are the services whose health is reported, with "" for the server as a whole.
+var healthServices = []string{
+	"",
+	pb.CrudService_ServiceDesc.ServiceName,
+	crudv2.CrudService_ServiceDesc.ServiceName,
+}
+
+// NewHealthServer returns a grpc.health.v1 server reporting the crud services as serving.
+// Clients balancing across replicas watch it to skip replicas that are not, e.g. while
+// MonitorDB finds the db unreachable.
+func NewHealthServer() *health.Server {
+	hs := health.NewServer()
+	setServing(hs, true)
+	return hs
+}
+
+// setServing reports the crud services as serving or not.
+func setServing(hs *health.Server, serving bool) {
+	status := healthpb.HealthCheckResponse_SERVING
+	if !serving {
+		status = healthpb.HealthCheckResponse_NOT_SERVING
+	}
+	for _, service := range healthServices {
+		hs.SetServingStatus(service, status)
+	}
+}
+
+// isHealthCheck reports whether the rpc with the full method name is a health check. Health
+// checks are neither scoped to a tenant nor capped by the Deadlines, as clients watch them for
+// the life of their connections and any error takes the replica out of their rotation.
+func isHealthCheck(fullMethod string) bool {
+	return strings.HasPrefix(fullMethod, "/"+healthpb.Health_ServiceDesc.ServiceName+"/")
+}
@@ -0,0 +1,76 @@
+package endpoints
+
+import (
+	"context"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+func TestHealth(t *testing.T) {
+	Convey("Health check tests", t, func() {
+		db, err := ConnectSQLite(filepath.Join(t.TempDir(), "test.db"))
+		So(err, ShouldBeNil)
+
+		// Requests name no tenant and streams are capped far below the test's length.
+		hs := NewHealthServer()
+		opts := NewTenantResolver(TenantConfig{}).ServerOptions()
+		opts = append(opts, Deadlines{Unary: 50 * time.Millisecond, Stream: 50 * time.Millisecond}.ServerOptions()...)
+		gs := grpc.NewServer(opts...)
+		healthpb.RegisterHealthServer(gs, hs)
+		lis := bufconn.Listen(1024 * 1024)
+		go gs.Serve(lis)
+		defer gs.Stop()
+
+		conn, err := grpc.Dial("bufnet",
+			grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) { return lis.Dial() }),
+			grpc.WithTransportCredentials(insecure.NewCredentials()))
+		So(err, ShouldBeNil)
+		defer conn.Close()
+		cli := healthpb.NewHealthClient(conn)
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		Convey("Health checks are neither scoped to a tenant nor capped", func() {
+			res, err := cli.Check(ctx, &healthpb.HealthCheckRequest{Service: "crud.CrudService"})
+			So(err, ShouldBeNil)
+			So(res.Status, ShouldEqual, healthpb.HealthCheckResponse_SERVING)
+
+			watch, err := cli.Watch(ctx, &healthpb.HealthCheckRequest{Service: "crud.v2.CrudService"})
+			So(err, ShouldBeNil)
+			res, err = watch.Recv()
+			So(err, ShouldBeNil)
+			So(res.Status, ShouldEqual, healthpb.HealthCheckResponse_SERVING)
+
+			time.Sleep(100 * time.Millisecond)
+			setServing(hs, false)
+			res, err = watch.Recv()
+			So(err, ShouldBeNil)
+			So(res.Status, ShouldEqual, healthpb.HealthCheckResponse_NOT_SERVING)
+		})
+
+		Convey("Replicas losing their db are reported as not serving", func() {
+			watch, err := cli.Watch(ctx, &healthpb.HealthCheckRequest{Service: "crud.CrudService"})
+			So(err, ShouldBeNil)
+			res, err := watch.Recv()
+			So(err, ShouldBeNil)
+			So(res.Status, ShouldEqual, healthpb.HealthCheckResponse_SERVING)
+
+			sqlDB, err := db.DB()
+			So(err, ShouldBeNil)
+			So(sqlDB.Close(), ShouldBeNil)
+			go MonitorDB(ctx, db, 10*time.Millisecond, hs)
+
+			res, err = watch.Recv()
+			So(err, ShouldBeNil)
+			So(res.Status, ShouldEqual, healthpb.HealthCheckResponse_NOT_SERVING)
+		})
+	})
+}
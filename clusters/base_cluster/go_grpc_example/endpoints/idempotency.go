@@ -0,0 +1,182 @@
+package endpoints
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"gorm.io/gorm"
+)
+
+const (
+	// IDEMPOTENCY_KEY_HEADER is the metadata key under which clients send idempotency keys.
+	IDEMPOTENCY_KEY_HEADER     = "idempotency-key"
+	IDEMPOTENCY_WINDOW_DEFAULT = 24 * time.Hour
+	maxIdempotencyKeyLen       = 255
+)
+
+// IdempotencyRecord stores the response of a write made with an idempotency key, so that a
+// retry of the same request can be answered with the original response instead of being
+// applied twice. Records are scoped by tenant and method, so one table serves every write rpc.
+type IdempotencyRecord struct {
+	ID          uint      `gorm:"primaryKey;autoIncrement"`
+	TenantID    string    `gorm:"uniqueIndex:idx_idempotency_tenant_method_key;size:64;not null"`
+	Method      string    `gorm:"uniqueIndex:idx_idempotency_tenant_method_key;not null"`
+	Key         string    `gorm:"column:idempotency_key;uniqueIndex:idx_idempotency_tenant_method_key;size:255;not null"`
+	RequestHash string    `gorm:"not null"`
+	Response    []byte    `gorm:"not null"`
+	CreatedAt   time.Time `gorm:"not null"`
+	ExpiresAt   time.Time `gorm:"index;not null"`
+}
+
+// idempotencyKey returns the idempotency key of the incoming request, if any.
+func idempotencyKey(ctx context.Context) (string, error) {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return "", nil
+	}
+
+	vals := md.Get(IDEMPOTENCY_KEY_HEADER)
+	if len(vals) == 0 || vals[0] == "" {
+		return "", nil
+	}
+	if len(vals) > 1 {
+		return "", status.Error(codes.InvalidArgument, "multiple idempotency keys")
+	}
+	if len(vals[0]) > maxIdempotencyKeyLen {
+		return "", status.Errorf(codes.InvalidArgument, "idempotency key exceeds %d characters", maxIdempotencyKeyLen)
+	}
+	return vals[0], nil
+}
+
+func requestHash(req proto.Message) (string, error) {
+	b, err := proto.MarshalOptions{Deterministic: true}.Marshal(req)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// idempotent runs write in a transaction and returns its response. If the request carries an
+// idempotency key, the response is recorded in the same transaction, and a later request with
+// the same key and method is answered from the record without running write again:
+//   - same key, same payload: the original response is replayed
+//   - same key, different payload: InvalidArgument
+//
+// Concurrent requests with the same key race on the record's unique index; the loser's
+// transaction is rolled back and it replays the winner's response.
+func idempotent[T proto.Message](
+	s *Server,
+	ctx context.Context,
+	method string,
+	req proto.Message,
+	newResp func() T,
+	write func(tx *gorm.DB) (T, error),
+) (T, error) {
+	var zero T
+
+	key, err := idempotencyKey(ctx)
+	if err != nil {
+		return zero, err
+	}
+	if key == "" {
+		var resp T
+		err := s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+			resp, err = write(tx)
+			return err
+		})
+		return resp, toStatus(err)
+	}
+
+	hash, err := requestHash(req)
+	if err != nil {
+		return zero, status.Error(codes.Internal, err.Error())
+	}
+
+	if resp, found, err := s.replay(ctx, method, key, hash, newResp()); found || err != nil {
+		return resp.(T), err
+	}
+
+	var resp T
+	err = s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		// An expired record for this key no longer counts, but would violate the unique index.
+		if err := tx.
+			Where("method = ? AND idempotency_key = ? AND expires_at <= ?", method, key, time.Now()).
+			Delete(&IdempotencyRecord{}).Error; err != nil {
+			return err
+		}
+
+		if resp, err = write(tx); err != nil {
+			return err
+		}
+
+		b, err := proto.Marshal(resp)
+		if err != nil {
+			return err
+		}
+
+		now := time.Now()
+		return tx.Create(&IdempotencyRecord{
+			Method:      method,
+			Key:         key,
+			RequestHash: hash,
+			Response:    b,
+			CreatedAt:   now,
+			ExpiresAt:   now.Add(s.idempotencyWindow),
+		}).Error
+	})
+	if err != nil && isDuplicateKey(err) {
+		// Lost a race with a concurrent request using the same key; its write won.
+		if resp, found, err := s.replay(ctx, method, key, hash, newResp()); found || err != nil {
+			return resp.(T), err
+		}
+	}
+	if err != nil {
+		return zero, toStatus(err)
+	}
+	return resp, nil
+}
+
+// replay looks up an unexpired record for the key. If found, the recorded response is
+// unmarshaled into resp, or InvalidArgument is returned if the payload differs.
+func (s *Server) replay(ctx context.Context, method, key, hash string, resp proto.Message) (proto.Message, bool, error) {
+	record := &IdempotencyRecord{}
+	tx := s.db.
+		WithContext(ctx).
+		Where("method = ? AND idempotency_key = ? AND expires_at > ?", method, key, time.Now()).
+		First(record)
+	if errors.Is(tx.Error, gorm.ErrRecordNotFound) {
+		return resp, false, nil
+	}
+	if tx.Error != nil {
+		return resp, false, toStatus(tx.Error)
+	}
+
+	if record.RequestHash != hash {
+		return resp, true, status.Errorf(codes.InvalidArgument,
+			"idempotency key %q was already used with a different request", key)
+	}
+	if err := proto.Unmarshal(record.Response, resp); err != nil {
+		return resp, true, status.Error(codes.Internal, err.Error())
+	}
+
+	infof("%s replayed for idempotency key %q\n", method, key)
+	return resp, true, nil
+}
+
+// PurgeIdempotencyRecords deletes expired idempotency records and returns how many were deleted.
+// Expired records are already ignored, so this only reclaims space. Every tenant's are purged.
+func PurgeIdempotencyRecords(ctx context.Context, db *gorm.DB) (int64, error) {
+	tx := db.
+		WithContext(AllTenants(ctx)).
+		Where("expires_at <= ?", time.Now()).
+		Delete(&IdempotencyRecord{})
+	return tx.RowsAffected, tx.Error
+}
@@ -0,0 +1,94 @@
+package endpoints
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	pb "go_grpc_example/proto"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+func withKey(key string) context.Context {
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs(IDEMPOTENCY_KEY_HEADER, key))
+	return WithCaller(ctx, &Caller{Tenant: DEFAULT_TENANT})
+}
+
+func TestIdempotency(t *testing.T) {
+	Convey("CreatePost idempotency tests", t, func() {
+		db, err := ConnectSQLite(filepath.Join(t.TempDir(), "test.db"))
+		So(err, ShouldBeNil)
+		s := NewServer(db)
+
+		post := &pb.Post{Id: "123", AuthorId: "jose", Title: "Gone With the Wind"}
+		countPosts := func() int64 {
+			var n int64
+			So(db.WithContext(tenantContext(DEFAULT_TENANT)).Model(&Post{}).Count(&n).Error, ShouldBeNil)
+			return n
+		}
+
+		Convey("A repeated key and post replays the original response", func() {
+			first, err := s.CreatePost(withKey("abc"), post)
+			So(err, ShouldBeNil)
+			second, err := s.CreatePost(withKey("abc"), post)
+			So(err, ShouldBeNil)
+			So(second.Id, ShouldEqual, first.Id)
+			So(countPosts(), ShouldEqual, 1)
+		})
+
+		Convey("A repeated key with a different post is rejected", func() {
+			_, err := s.CreatePost(withKey("abc"), post)
+			So(err, ShouldBeNil)
+			_, err = s.CreatePost(withKey("abc"), &pb.Post{Id: "456", AuthorId: "jose", Title: "title"})
+			So(status.Code(err), ShouldEqual, codes.InvalidArgument)
+			So(countPosts(), ShouldEqual, 1)
+		})
+
+		Convey("Requests without a key are not deduplicated", func() {
+			ctx := tenantContext(DEFAULT_TENANT)
+			_, err := s.CreatePost(ctx, post)
+			So(err, ShouldBeNil)
+			_, err = s.CreatePost(ctx, post)
+			So(status.Code(err), ShouldEqual, codes.AlreadyExists)
+		})
+
+		Convey("Keys are scoped to the tenant", func() {
+			_, err := s.CreatePost(withKey("abc"), post)
+			So(err, ShouldBeNil)
+
+			ctx := metadata.NewIncomingContext(tenantContext("other"), metadata.Pairs(IDEMPOTENCY_KEY_HEADER, "abc"))
+			_, err = s.CreatePost(ctx, &pb.Post{Id: "456", AuthorId: "jose", Title: "title"})
+			So(err, ShouldBeNil)
+		})
+
+		Convey("Expired keys may be reused and are purged", func() {
+			s = NewServer(db, WithIdempotencyWindow(time.Millisecond))
+			_, err := s.CreatePost(withKey("abc"), post)
+			So(err, ShouldBeNil)
+			time.Sleep(5 * time.Millisecond)
+
+			_, err = s.CreatePost(withKey("abc"), &pb.Post{Id: "456", AuthorId: "jose", Title: "title"})
+			So(err, ShouldBeNil)
+			So(countPosts(), ShouldEqual, 2)
+
+			time.Sleep(5 * time.Millisecond)
+			n, err := PurgeIdempotencyRecords(context.Background(), db)
+			So(err, ShouldBeNil)
+			So(n, ShouldEqual, 1)
+		})
+
+		Convey("Oversized keys are rejected", func() {
+			key := make([]byte, maxIdempotencyKeyLen+1)
+			for i := range key {
+				key[i] = 'k'
+			}
+			_, err := s.CreatePost(withKey(string(key)), post)
+			So(status.Code(err), ShouldEqual, codes.InvalidArgument)
+		})
+	})
+}
@@ -0,0 +1,59 @@
+package endpoints
+
+import (
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/keepalive"
+)
+
+const (
+	// KEEPALIVE_MIN_TIME_DEFAULT is the shortest ping interval tolerated from clients, below the
+	// client library's DefaultKeepaliveTime.
+	KEEPALIVE_MIN_TIME_DEFAULT = 10 * time.Second
+	KEEPALIVE_TIME_DEFAULT     = time.Minute
+	KEEPALIVE_TIMEOUT_DEFAULT  = 20 * time.Second
+	// MAX_CONNECTION_AGE_DEFAULT bounds how long clients stay on one replica after scaling.
+	MAX_CONNECTION_AGE_DEFAULT = 5 * time.Minute
+	// MAX_CONNECTION_AGE_GRACE_DEFAULT lets streams started before a connection aged out run
+	// to their cap.
+	MAX_CONNECTION_AGE_GRACE_DEFAULT = STREAM_MAX_DEADLINE_DEFAULT
+)
+
+// Keepalive configures how the server keeps HTTP/2 connections alive, and when it ends them.
+// Clients keep their connections for as long as they can, so without a maximum age a replica
+// added by scaling up gets no traffic from clients connected before it, and one about to be
+// removed keeps all of its clients until it is gone. Aged connections are sent a GOAWAY, upon
+// which clients re-resolve their target and reconnect, spreading across the current replicas.
+// A zero MaxConnectionAge or MaxConnectionAgeGrace is infinite.
+type Keepalive struct {
+	// MinTime is the shortest interval at which clients may ping; clients pinging more often
+	// have their connection closed.
+	MinTime time.Duration
+	// Time is how long a connection may be idle before the server pings the client, and
+	// Timeout how long it waits for the ack before closing the connection.
+	Time    time.Duration
+	Timeout time.Duration
+	// MaxConnectionAge is how long a connection may live, give or take 10% so that clients
+	// connected at once do not all reconnect at once, and MaxConnectionAgeGrace how long its
+	// rpcs may then run before the connection is closed.
+	MaxConnectionAge      time.Duration
+	MaxConnectionAgeGrace time.Duration
+}
+
+// ServerOptions returns the grpc server options enforcing the keepalive policy.
+func (k Keepalive) ServerOptions() []grpc.ServerOption {
+	return []grpc.ServerOption{
+		grpc.KeepaliveEnforcementPolicy(keepalive.EnforcementPolicy{
+			MinTime: k.MinTime,
+			// Balancing clients keep connections to every replica, most of them idle.
+			PermitWithoutStream: true,
+		}),
+		grpc.KeepaliveParams(keepalive.ServerParameters{
+			Time:                  k.Time,
+			Timeout:               k.Timeout,
+			MaxConnectionAge:      k.MaxConnectionAge,
+			MaxConnectionAgeGrace: k.MaxConnectionAgeGrace,
+		}),
+	}
+}
@@ -0,0 +1,48 @@
+package endpoints
+
+import (
+	"fmt"
+	"log"
+	"sync/atomic"
+)
+
+const (
+	LOG_DEBUG = "debug"
+	LOG_INFO  = "info"
+	LOG_WARN  = "warn"
+	LOG_ERROR = "error"
+	// LOG_LEVEL_DEFAULT logs every rpc invocation, as the service always has.
+	LOG_LEVEL_DEFAULT = LOG_DEBUG
+)
+
+// logLevels are ordered by severity; messages below the current level are dropped.
+var logLevels = []string{LOG_DEBUG, LOG_INFO, LOG_WARN, LOG_ERROR}
+
+var logLevel atomic.Int32
+
+// SetLogLevel changes the level of the package's logs, returning the previous level.
+// It is safe to call while serving, e.g. from the AdminService.
+func SetLogLevel(level string) (string, error) {
+	for i, l := range logLevels {
+		if l == level {
+			return logLevels[logLevel.Swap(int32(i))], nil
+		}
+	}
+	return "", fmt.Errorf("unknown log level %q, must be one of %v", level, logLevels)
+}
+
+// LogLevel returns the current level of the package's logs.
+func LogLevel() string {
+	return logLevels[logLevel.Load()]
+}
+
+func logf(level int32, format string, v ...interface{}) {
+	if level >= logLevel.Load() {
+		log.Printf(format, v...)
+	}
+}
+
+func debugf(format string, v ...interface{}) { logf(0, format, v...) }
+func infof(format string, v ...interface{})  { logf(1, format, v...) }
+func warnf(format string, v ...interface{})  { logf(2, format, v...) }
+func errorf(format string, v ...interface{}) { logf(3, format, v...) }
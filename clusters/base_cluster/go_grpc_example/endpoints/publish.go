@@ -0,0 +1,289 @@
+package endpoints
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	pb "go_grpc_example/proto"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	"gorm.io/gorm"
+)
+
+// Post statuses, as stored in the status column.
+const (
+	STATUS_DRAFT     = "draft"
+	STATUS_SCHEDULED = "scheduled"
+	STATUS_PUBLISHED = "published"
+	STATUS_ARCHIVED  = "archived"
+
+	// SCHEDULER_INTERVAL_DEFAULT is how often scheduled posts are checked for publishing.
+	SCHEDULER_INTERVAL_DEFAULT = 30 * time.Second
+	// schedulerBatchSize bounds the scheduled posts published per transaction.
+	schedulerBatchSize = 100
+	// transitionAttempts bounds how often a status change is retried after losing a race.
+	transitionAttempts = 3
+)
+
+var statusNames = map[pb.PostStatus]string{
+	pb.PostStatus_POST_STATUS_UNSPECIFIED: STATUS_PUBLISHED,
+	pb.PostStatus_POST_STATUS_DRAFT:       STATUS_DRAFT,
+	pb.PostStatus_POST_STATUS_SCHEDULED:   STATUS_SCHEDULED,
+	pb.PostStatus_POST_STATUS_PUBLISHED:   STATUS_PUBLISHED,
+	pb.PostStatus_POST_STATUS_ARCHIVED:    STATUS_ARCHIVED,
+}
+
+// statusName returns the stored status of s; unspecified is published, for older clients.
+func statusName(s pb.PostStatus) string {
+	if name, ok := statusNames[s]; ok {
+		return name
+	}
+	return STATUS_PUBLISHED
+}
+
+func statusEnum(name string) pb.PostStatus {
+	switch name {
+	case STATUS_DRAFT:
+		return pb.PostStatus_POST_STATUS_DRAFT
+	case STATUS_SCHEDULED:
+		return pb.PostStatus_POST_STATUS_SCHEDULED
+	case STATUS_ARCHIVED:
+		return pb.PostStatus_POST_STATUS_ARCHIVED
+	}
+	return pb.PostStatus_POST_STATUS_PUBLISHED
+}
+
+// statusViolations checks the status and publish_at of a post to create: archived posts
+// cannot be created, and scheduled posts need a future publish_at, which no other status may set.
+func statusViolations(post *pb.Post, now time.Time) []*errdetails.BadRequest_FieldViolation {
+	violation := func(field, desc string) []*errdetails.BadRequest_FieldViolation {
+		return []*errdetails.BadRequest_FieldViolation{{Field: field, Description: desc}}
+	}
+
+	switch post.Status {
+	case pb.PostStatus_POST_STATUS_UNSPECIFIED, pb.PostStatus_POST_STATUS_DRAFT, pb.PostStatus_POST_STATUS_PUBLISHED:
+		if post.PublishAt != nil {
+			return violation("publish_at", "is only allowed for scheduled posts")
+		}
+	case pb.PostStatus_POST_STATUS_SCHEDULED:
+		if post.PublishAt == nil {
+			return violation("publish_at", "is required for scheduled posts")
+		}
+		if !post.PublishAt.AsTime().After(now) {
+			return violation("publish_at", "must be in the future")
+		}
+	default:
+		return violation("status", "must be draft, scheduled, or published on create")
+	}
+	return nil
+}
+
+// PublishPost publishes a post now, or schedules it if publish_at is in the future.
+// Publishing a published post now is a no-op, while scheduling it requires unpublishing it first.
+func (s *Server) PublishPost(ctx context.Context, req *pb.PublishPostRequest) (*pb.Post, error) {
+	debugf("PublishPost invoked\n")
+
+	return pbPostOf(s.publishPost(ctx, req.Id, req.PublishAt))
+}
+
+func (s *Server) publishPost(ctx context.Context, postID string, at *timestamppb.Timestamp) (*Post, error) {
+	now := time.Now()
+	publishAt, next := now, STATUS_PUBLISHED
+	if at != nil && at.AsTime().After(now) {
+		publishAt, next = at.AsTime(), STATUS_SCHEDULED
+	}
+
+	return s.transition(ctx, postID, func(post *Post) (string, error) {
+		if post.Status == STATUS_PUBLISHED {
+			if next == STATUS_SCHEDULED {
+				return "", status.Error(codes.FailedPrecondition, "post is already published; unpublish it before scheduling it")
+			}
+			return "", nil
+		}
+
+		post.Status, post.PublishAt = next, &publishAt
+		if next == STATUS_SCHEDULED {
+			return EVENT_POST_SCHEDULED, nil
+		}
+		return EVENT_POST_PUBLISHED, nil
+	})
+}
+
+// UnpublishPost returns a post to draft, unlisting it or canceling its scheduled publishing.
+func (s *Server) UnpublishPost(ctx context.Context, req *pb.PostID) (*pb.Post, error) {
+	debugf("UnpublishPost invoked\n")
+
+	return pbPostOf(s.unpublishPost(ctx, req.Id))
+}
+
+func (s *Server) unpublishPost(ctx context.Context, postID string) (*Post, error) {
+	return s.transition(ctx, postID, func(post *Post) (string, error) {
+		if post.Status == STATUS_DRAFT {
+			return "", nil
+		}
+		post.Status, post.PublishAt = STATUS_DRAFT, nil
+		return EVENT_POST_UNPUBLISHED, nil
+	})
+}
+
+// ArchivePost archives a post, unlisting it; archived posts may be published again.
+func (s *Server) ArchivePost(ctx context.Context, req *pb.PostID) (*pb.Post, error) {
+	debugf("ArchivePost invoked\n")
+
+	return pbPostOf(s.archivePost(ctx, req.Id))
+}
+
+func (s *Server) archivePost(ctx context.Context, postID string) (*Post, error) {
+	return s.transition(ctx, postID, func(post *Post) (string, error) {
+		if post.Status == STATUS_ARCHIVED {
+			return "", nil
+		}
+		post.Status = STATUS_ARCHIVED
+		return EVENT_POST_ARCHIVED, nil
+	})
+}
+
+// transition applies change to the post with the post-id in a transaction, saving the post
+// and enqueuing the returned event type unless it is empty, meaning the post is unchanged.
+// The post is read without a lock, so it is only saved if still at the version read, like
+// publishDue does; if another write got there first, change is applied again to the post as
+// that write left it, so that racing transitions to the same status enqueue one event.
+func (s *Server) transition(ctx context.Context, postID string, change func(*Post) (string, error)) (*Post, error) {
+	post := &Post{}
+	var changed []string
+	for attempt := 1; ; attempt++ {
+		conflict := false
+		err := s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+			*post = Post{}
+			changed = nil
+			if err := tx.Where("post_id = ?", postID).First(post).Error; err != nil {
+				return err
+			}
+
+			before := *post
+			event, err := change(post)
+			if err != nil || event == "" {
+				return err
+			}
+			changed = postChanges(&before, post)
+
+			post.Version++
+			res := tx.
+				Model(post).
+				Where("version = ?", before.Version).
+				Select("status", "publish_at", "updated_at", "version").
+				Updates(post)
+			if res.Error != nil {
+				return res.Error
+			}
+			if res.RowsAffected == 0 {
+				conflict = true
+				return nil
+			}
+
+			updated := NewPbPost(post)
+			return s.enqueue(tx, event, post.PostId, &updated)
+		})
+		if err != nil {
+			return nil, toStatus(err)
+		}
+		if !conflict {
+			break
+		}
+		if attempt == transitionAttempts {
+			return nil, status.Errorf(codes.Aborted, "post %s changed concurrently, retry", postID)
+		}
+	}
+
+	audit(ctx, postID, changed...)
+	s.uncache(ctx, postID)
+	return post, nil
+}
+
+// PublishScheduled publishes the scheduled posts of every tenant whose publish_at has passed,
+// and returns how many it published. Each post is published by a conditional update, so
+// replicas running the scheduler concurrently publish each post, and enqueue its event, once.
+func (s *Server) PublishScheduled(ctx context.Context) (int, error) {
+	published := 0
+	for {
+		due := []Post{}
+		err := s.db.
+			WithContext(AllTenants(ctx)).
+			Where("status = ? AND publish_at <= ?", STATUS_SCHEDULED, time.Now()).
+			Order("publish_at").
+			Limit(schedulerBatchSize).
+			Find(&due).Error
+		if err != nil {
+			return published, err
+		}
+
+		for i := range due {
+			ok, err := s.publishDue(ctx, &due[i])
+			if err != nil {
+				return published, err
+			}
+			if ok {
+				published++
+			}
+		}
+
+		if len(due) < schedulerBatchSize {
+			return published, nil
+		}
+	}
+}
+
+// publishDue publishes a due scheduled post within its tenant, reporting false if another
+// replica, or a concurrent UnpublishPost, got to it first.
+func (s *Server) publishDue(ctx context.Context, post *Post) (bool, error) {
+	tenantCtx := WithCaller(ctx, &Caller{Tenant: post.TenantID})
+
+	published := false
+	err := s.db.WithContext(tenantCtx).Transaction(func(tx *gorm.DB) error {
+		res := tx.
+			Model(&Post{}).
+			Where("id = ? AND status = ?", post.ID, STATUS_SCHEDULED).
+			Updates(map[string]interface{}{"status": STATUS_PUBLISHED, "version": gorm.Expr("version + 1")})
+		if res.Error != nil || res.RowsAffected == 0 {
+			return res.Error
+		}
+		published = true
+
+		post.Status = STATUS_PUBLISHED
+		post.Version++
+		changed := NewPbPost(post)
+		return s.enqueue(tx, EVENT_POST_PUBLISHED, post.PostId, &changed)
+	})
+	if err != nil || !published {
+		return false, err
+	}
+
+	s.uncache(tenantCtx, post.PostId)
+	return true, nil
+}
+
+// RunScheduler publishes due scheduled posts every interval until ctx is done.
+func (s *Server) RunScheduler(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		n, err := s.PublishScheduled(ctx)
+		if err != nil && !errors.Is(err, context.Canceled) {
+			errorf("publishing scheduled posts failed: %v\n", err)
+		}
+		if n > 0 {
+			infof("published %d scheduled posts\n", n)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
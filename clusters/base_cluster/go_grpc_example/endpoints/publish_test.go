@@ -0,0 +1,222 @@
+package endpoints
+
+import (
+	"context"
+	"io"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+
+	"go_grpc_example/outbox"
+	pb "go_grpc_example/proto"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// listIDs lists posts over cli and returns their sorted ids.
+func listIDs(cli pb.CrudServiceClient, req *pb.ListPostsRequest) ([]string, error) {
+	stream, err := cli.ListPosts(context.Background(), req)
+	So(err, ShouldBeNil)
+
+	ids := []string{}
+	for {
+		post, err := stream.Recv()
+		if err == io.EOF {
+			sort.Strings(ids)
+			return ids, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		ids = append(ids, post.Id)
+	}
+}
+
+// asSubject serves rpcs as the subject, as if named by the caller's jwt.
+func asSubject(subject string) grpc.ServerOption {
+	return grpc.ChainStreamInterceptor(
+		func(srv interface{}, ss grpc.ServerStream, _ *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+			c, _ := CallerFrom(ss.Context())
+			ctx := WithCaller(ss.Context(), &Caller{Tenant: c.Tenant, Subject: subject})
+			return handler(srv, &contextStream{ServerStream: ss, ctx: ctx})
+		})
+}
+
+func TestPublishing(t *testing.T) {
+	Convey("Publishing workflow tests", t, func() {
+		db, err := ConnectSQLite(filepath.Join(t.TempDir(), "test.db"))
+		So(err, ShouldBeNil)
+		s := NewServer(db, WithOutbox())
+		ctx := tenantContext(DEFAULT_TENANT)
+
+		post := func(id, author string, st pb.PostStatus, at *timestamppb.Timestamp) *pb.Post {
+			return &pb.Post{Id: id, AuthorId: author, Title: "title " + id, Status: st, PublishAt: at}
+		}
+		later := timestamppb.New(time.Now().Add(time.Hour))
+
+		Convey("Posts are published on create unless drafted or scheduled", func() {
+			_, err := s.CreatePost(ctx, post("1", "jose", pb.PostStatus_POST_STATUS_UNSPECIFIED, nil))
+			So(err, ShouldBeNil)
+			_, err = s.CreatePost(ctx, post("2", "jose", pb.PostStatus_POST_STATUS_DRAFT, nil))
+			So(err, ShouldBeNil)
+			_, err = s.CreatePost(ctx, post("3", "jose", pb.PostStatus_POST_STATUS_SCHEDULED, later))
+			So(err, ShouldBeNil)
+
+			read, err := s.ReadPost(ctx, &pb.PostID{Id: "1"})
+			So(err, ShouldBeNil)
+			So(read.Status, ShouldEqual, pb.PostStatus_POST_STATUS_PUBLISHED)
+			So(read.PublishAt, ShouldNotBeNil)
+
+			read, err = s.ReadPost(ctx, &pb.PostID{Id: "3"})
+			So(err, ShouldBeNil)
+			So(read.Status, ShouldEqual, pb.PostStatus_POST_STATUS_SCHEDULED)
+			So(read.PublishAt.AsTime().Unix(), ShouldEqual, later.AsTime().Unix())
+		})
+
+		Convey("Statuses and publish times are validated on create", func() {
+			_, err := s.CreatePost(ctx, post("1", "jose", pb.PostStatus_POST_STATUS_SCHEDULED, nil))
+			So(violations(err), ShouldResemble, map[string]string{"publish_at": "is required for scheduled posts"})
+
+			past := timestamppb.New(time.Now().Add(-time.Hour))
+			_, err = s.CreatePost(ctx, post("1", "jose", pb.PostStatus_POST_STATUS_SCHEDULED, past))
+			So(violations(err), ShouldResemble, map[string]string{"publish_at": "must be in the future"})
+
+			_, err = s.CreatePost(ctx, post("1", "jose", pb.PostStatus_POST_STATUS_DRAFT, later))
+			So(violations(err), ShouldContainKey, "publish_at")
+
+			_, err = s.CreatePost(ctx, post("1", "jose", pb.PostStatus_POST_STATUS_ARCHIVED, nil))
+			So(violations(err), ShouldContainKey, "status")
+		})
+
+		Convey("Posts move between statuses and enqueue an event per change", func() {
+			_, err := s.CreatePost(ctx, post("1", "jose", pb.PostStatus_POST_STATUS_DRAFT, nil))
+			So(err, ShouldBeNil)
+
+			scheduled, err := s.PublishPost(ctx, &pb.PublishPostRequest{Id: "1", PublishAt: later})
+			So(err, ShouldBeNil)
+			So(scheduled.Status, ShouldEqual, pb.PostStatus_POST_STATUS_SCHEDULED)
+
+			published, err := s.PublishPost(ctx, &pb.PublishPostRequest{Id: "1"})
+			So(err, ShouldBeNil)
+			So(published.Status, ShouldEqual, pb.PostStatus_POST_STATUS_PUBLISHED)
+
+			// Publishing again is a no-op, but scheduling a published post is refused.
+			_, err = s.PublishPost(ctx, &pb.PublishPostRequest{Id: "1"})
+			So(err, ShouldBeNil)
+			_, err = s.PublishPost(ctx, &pb.PublishPostRequest{Id: "1", PublishAt: later})
+			So(status.Code(err), ShouldEqual, codes.FailedPrecondition)
+
+			archived, err := s.ArchivePost(ctx, &pb.PostID{Id: "1"})
+			So(err, ShouldBeNil)
+			So(archived.Status, ShouldEqual, pb.PostStatus_POST_STATUS_ARCHIVED)
+
+			drafted, err := s.UnpublishPost(ctx, &pb.PostID{Id: "1"})
+			So(err, ShouldBeNil)
+			So(drafted.Status, ShouldEqual, pb.PostStatus_POST_STATUS_DRAFT)
+			So(drafted.PublishAt, ShouldBeNil)
+
+			_, err = s.UnpublishPost(ctx, &pb.PostID{Id: "missing"})
+			So(status.Code(err), ShouldEqual, codes.NotFound)
+
+			types := []string{}
+			So(db.Model(&outbox.Event{}).Order("id").Pluck("type", &types).Error, ShouldBeNil)
+			So(types, ShouldResemble, []string{
+				EVENT_POST_CREATED,
+				EVENT_POST_SCHEDULED,
+				EVENT_POST_PUBLISHED,
+				EVENT_POST_ARCHIVED,
+				EVENT_POST_UNPUBLISHED,
+			})
+		})
+
+		Convey("Racing status changes enqueue one event", func() {
+			_, err := s.CreatePost(ctx, post("1", "jose", pb.PostStatus_POST_STATUS_DRAFT, nil))
+			So(err, ShouldBeNil)
+
+			const n = 8
+			errs := make(chan error, n)
+			for i := 0; i < n; i++ {
+				go func() {
+					_, err := s.ArchivePost(ctx, &pb.PostID{Id: "1"})
+					errs <- err
+				}()
+			}
+			for i := 0; i < n; i++ {
+				So(<-errs, ShouldBeNil)
+			}
+
+			read, err := s.ReadPost(ctx, &pb.PostID{Id: "1"})
+			So(err, ShouldBeNil)
+			So(read.Status, ShouldEqual, pb.PostStatus_POST_STATUS_ARCHIVED)
+			types := []string{}
+			So(db.Model(&outbox.Event{}).Order("id").Pluck("type", &types).Error, ShouldBeNil)
+			So(types, ShouldResemble, []string{EVENT_POST_CREATED, EVENT_POST_ARCHIVED})
+		})
+
+		Convey("The scheduler publishes due posts once", func() {
+			_, err := s.CreatePost(ctx, post("due", "jose", pb.PostStatus_POST_STATUS_SCHEDULED, later))
+			So(err, ShouldBeNil)
+			_, err = s.CreatePost(ctx, post("later", "jose", pb.PostStatus_POST_STATUS_SCHEDULED, later))
+			So(err, ShouldBeNil)
+			_, err = s.CreatePost(tenantContext("other"), post("due", "ana", pb.PostStatus_POST_STATUS_SCHEDULED, later))
+			So(err, ShouldBeNil)
+
+			// Let both "due" posts come due.
+			err = db.WithContext(AllTenants(ctx)).
+				Model(&Post{}).
+				Where("post_id = ?", "due").
+				Update("publish_at", time.Now().Add(-time.Minute)).Error
+			So(err, ShouldBeNil)
+
+			n, err := s.PublishScheduled(context.Background())
+			So(err, ShouldBeNil)
+			So(n, ShouldEqual, 2)
+
+			n, err = s.PublishScheduled(context.Background())
+			So(err, ShouldBeNil)
+			So(n, ShouldEqual, 0)
+
+			read, err := s.ReadPost(tenantContext("other"), &pb.PostID{Id: "due"})
+			So(err, ShouldBeNil)
+			So(read.Status, ShouldEqual, pb.PostStatus_POST_STATUS_PUBLISHED)
+			read, err = s.ReadPost(ctx, &pb.PostID{Id: "later"})
+			So(err, ShouldBeNil)
+			So(read.Status, ShouldEqual, pb.PostStatus_POST_STATUS_SCHEDULED)
+		})
+
+		Convey("Lists show published posts, and optionally the caller's own drafts", func() {
+			_, err := s.CreatePost(ctx, post("1", "jose", pb.PostStatus_POST_STATUS_UNSPECIFIED, nil))
+			So(err, ShouldBeNil)
+			_, err = s.CreatePost(ctx, post("2", "jose", pb.PostStatus_POST_STATUS_DRAFT, nil))
+			So(err, ShouldBeNil)
+			_, err = s.CreatePost(ctx, post("3", "jose", pb.PostStatus_POST_STATUS_SCHEDULED, later))
+			So(err, ShouldBeNil)
+			_, err = s.CreatePost(ctx, post("4", "ana", pb.PostStatus_POST_STATUS_DRAFT, nil))
+			So(err, ShouldBeNil)
+			_, err = s.ArchivePost(ctx, &pb.PostID{Id: "1"})
+			So(err, ShouldBeNil)
+			_, err = s.CreatePost(ctx, post("5", "ana", pb.PostStatus_POST_STATUS_UNSPECIFIED, nil))
+			So(err, ShouldBeNil)
+
+			cli, stop := serve(s)
+			defer stop()
+			ids, err := listIDs(cli, &pb.ListPostsRequest{})
+			So(err, ShouldBeNil)
+			So(ids, ShouldResemble, []string{"5"})
+
+			_, err = listIDs(cli, &pb.ListPostsRequest{IncludeOwnDrafts: true})
+			So(status.Code(err), ShouldEqual, codes.Unauthenticated)
+
+			authorCli, stopAuthor := serve(s, asSubject("jose"))
+			defer stopAuthor()
+			ids, err = listIDs(authorCli, &pb.ListPostsRequest{IncludeOwnDrafts: true})
+			So(err, ShouldBeNil)
+			So(ids, ShouldResemble, []string{"2", "3", "5"})
+		})
+	})
+}
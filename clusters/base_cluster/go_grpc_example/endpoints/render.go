@@ -0,0 +1,93 @@
+package endpoints
+
+import (
+	"context"
+	"crypto/sha256"
+
+	"go_grpc_example/lru_cache"
+	"go_grpc_example/markdown"
+	pb "go_grpc_example/proto"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+)
+
+const (
+	// RENDER_CACHE_SIZE_DEFAULT is how many rendered posts NewServer caches by default.
+	RENDER_CACHE_SIZE_DEFAULT = 1000
+	// EXCERPT_MAX_RUNES bounds the excerpts of rendered posts, before their ellipsis.
+	EXCERPT_MAX_RUNES = 200
+)
+
+// WithRenderCache caches the html and excerpts of up to size posts, evicting the least recently
+// rendered; 0 disables the cache, so that every post is rendered when read.
+func WithRenderCache(size int) ServerOption {
+	return func(s *Server) {
+		s.renders = nil
+		if size > 0 {
+			// newShardedCache only fails for sizes that are not positive.
+			cache, _ := newShardedCache[*rendering](size)
+			s.renders = &renderCache{cache: cache}
+		}
+	}
+}
+
+// renderCache holds the renderings of posts by tenant and post-id, like lruPostCache. Each
+// records a hash of the full_text it was rendered from, so a post whose full_text changed is
+// rendered again even if its entry was not removed, e.g. when written via another replica.
+type renderCache struct {
+	cache *lru_cache.Sharded[string, *rendering]
+}
+
+type rendering struct {
+	sum     [sha256.Size]byte
+	html    string
+	excerpt string
+}
+
+func (c *renderCache) get(tenant, postID string, sum [sha256.Size]byte) (*rendering, bool) {
+	r, ok := c.cache.Get(postCacheKey(tenant, postID))
+	if !ok || r.sum != sum {
+		return nil, false
+	}
+	return r, true
+}
+
+func (c *renderCache) put(tenant, postID string, r *rendering) {
+	c.cache.Put(postCacheKey(tenant, postID), r)
+}
+
+func (c *renderCache) remove(tenant, postID string) {
+	c.cache.Remove(postCacheKey(tenant, postID))
+}
+
+// renderViolations returns the violation of an unknown render format, if any.
+func renderViolations(format pb.RenderFormat) []*errdetails.BadRequest_FieldViolation {
+	if _, ok := pb.RenderFormat_name[int32(format)]; !ok {
+		return []*errdetails.BadRequest_FieldViolation{{Field: "render", Description: "must be markdown or html"}}
+	}
+	return nil
+}
+
+// render sets the html and excerpt of the post if the format asks for them, from the render
+// cache of the request's tenant where possible.
+func (s *Server) render(ctx context.Context, post *pb.Post, format pb.RenderFormat) {
+	if format != pb.RenderFormat_RENDER_FORMAT_HTML {
+		return
+	}
+
+	tenant, err := tenantFrom(ctx)
+	cached := s.renders != nil && err == nil
+	sum := sha256.Sum256([]byte(post.FullText))
+	if cached {
+		if r, ok := s.renders.get(tenant, post.Id, sum); ok {
+			post.Html, post.Excerpt = r.html, r.excerpt
+			return
+		}
+	}
+
+	html, text := markdown.Render(post.FullText)
+	post.Html, post.Excerpt = html, markdown.Excerpt(text, EXCERPT_MAX_RUNES)
+	if cached {
+		s.renders.put(tenant, post.Id, &rendering{sum: sum, html: post.Html, excerpt: post.Excerpt})
+	}
+}
@@ -0,0 +1,109 @@
+package endpoints
+
+import (
+	"context"
+	"io"
+	"path/filepath"
+	"testing"
+
+	pb "go_grpc_example/proto"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"google.golang.org/grpc/metadata"
+)
+
+func TestRender(t *testing.T) {
+	Convey("Render tests", t, func() {
+		db, err := ConnectSQLite(filepath.Join(t.TempDir(), "test.db"))
+		So(err, ShouldBeNil)
+		s := NewServer(db)
+		cli, stop := serve(s)
+		defer stop()
+		ctx := metadata.AppendToOutgoingContext(context.Background(), TENANT_HEADER, DEFAULT_TENANT)
+		html := pb.RenderFormat_RENDER_FORMAT_HTML
+
+		_, err = cli.CreatePost(ctx, &pb.Post{Id: "1", AuthorId: "jose", Title: "title", FullText: "# Hi\n\n*some* <b>text</b>"})
+		So(err, ShouldBeNil)
+		_, err = cli.CreatePost(ctx, &pb.Post{Id: "2", AuthorId: "jose", Title: "title", FullText: "plain"})
+		So(err, ShouldBeNil)
+
+		Convey("Posts are only rendered if asked for html", func() {
+			post, err := cli.ReadPost(ctx, &pb.PostID{Id: "1"})
+			So(err, ShouldBeNil)
+			So(post.Html, ShouldBeEmpty)
+			So(post.Excerpt, ShouldBeEmpty)
+
+			post, err = cli.ReadPost(ctx, &pb.PostID{Id: "1", Render: html})
+			So(err, ShouldBeNil)
+			So(post.FullText, ShouldEqual, "# Hi\n\n*some* <b>text</b>")
+			So(post.Html, ShouldEqual, "<h1>Hi</h1>\n<p><em>some</em> &lt;b&gt;text&lt;/b&gt;</p>\n")
+			So(post.Excerpt, ShouldEqual, "Hi some <b>text</b>")
+		})
+
+		Convey("Listed posts are rendered", func() {
+			stream, err := cli.ListPosts(ctx, &pb.ListPostsRequest{Render: html})
+			So(err, ShouldBeNil)
+			excerpts := map[string]string{}
+			for {
+				post, err := stream.Recv()
+				if err != nil {
+					So(err, ShouldEqual, io.EOF)
+					break
+				}
+				excerpts[post.Id] = post.Excerpt
+			}
+			So(excerpts, ShouldResemble, map[string]string{"1": "Hi some <b>text</b>", "2": "plain"})
+		})
+
+		Convey("Renderings are cached until the full_text changes", func() {
+			_, err := cli.ReadPost(ctx, &pb.PostID{Id: "1", Render: html})
+			So(err, ShouldBeNil)
+			So(s.renders.cache.Len(), ShouldEqual, 1)
+
+			_, err = cli.UpdatePost(ctx, &pb.Post{Id: "1", Title: "title", FullText: "new"})
+			So(err, ShouldBeNil)
+			So(s.renders.cache.Len(), ShouldEqual, 0)
+			post, err := cli.ReadPost(ctx, &pb.PostID{Id: "1", Render: html})
+			So(err, ShouldBeNil)
+			So(post.Html, ShouldEqual, "<p>new</p>\n")
+
+			// Writes that bypass the server, e.g. via another replica, leave stale entries that are
+			// detected by their hash.
+			err = db.WithContext(tenantContext(DEFAULT_TENANT)).Model(&Post{}).
+				Where("post_id = ?", "1").UpdateColumn("full_text", "newer").Error
+			So(err, ShouldBeNil)
+			post, err = cli.ReadPost(ctx, &pb.PostID{Id: "1", Render: html})
+			So(err, ShouldBeNil)
+			So(post.Html, ShouldEqual, "<p>newer</p>\n")
+		})
+
+		Convey("Renderings are not shared between tenants", func() {
+			other := metadata.AppendToOutgoingContext(context.Background(), TENANT_HEADER, "other")
+			_, err := cli.CreatePost(other, &pb.Post{Id: "1", AuthorId: "jose", Title: "title", FullText: "other"})
+			So(err, ShouldBeNil)
+			for tenantCtx, want := range map[context.Context]string{ctx: "Hi some <b>text</b>", other: "other"} {
+				post, err := cli.ReadPost(tenantCtx, &pb.PostID{Id: "1", Render: html})
+				So(err, ShouldBeNil)
+				So(post.Excerpt, ShouldEqual, want)
+			}
+		})
+
+		Convey("Posts are rendered without a cache", func() {
+			uncached := NewServer(db, WithRenderCache(0))
+			So(uncached.renders, ShouldBeNil)
+			post, err := uncached.ReadPost(tenantContext(DEFAULT_TENANT), &pb.PostID{Id: "2", Render: html})
+			So(err, ShouldBeNil)
+			So(post.Html, ShouldEqual, "<p>plain</p>\n")
+		})
+
+		Convey("Unknown formats are rejected", func() {
+			_, err := cli.ReadPost(ctx, &pb.PostID{Id: "1", Render: pb.RenderFormat(7)})
+			So(violations(err), ShouldContainKey, "render")
+
+			stream, err := cli.ListPosts(ctx, &pb.ListPostsRequest{Render: pb.RenderFormat(7)})
+			So(err, ShouldBeNil)
+			_, err = stream.Recv()
+			So(violations(err), ShouldContainKey, "render")
+		})
+	})
+}
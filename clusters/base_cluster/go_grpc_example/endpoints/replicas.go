@@ -0,0 +1,194 @@
+package endpoints
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"gorm.io/gorm"
+)
+
+const (
+	// PRIMARY_UNTIL_HEADER is the response header by which rpcs that write tell the client to
+	// read from the primary until the time it holds, and the request header by which the client
+	// asks to, so that it reads its own writes despite replication lag.
+	PRIMARY_UNTIL_HEADER = "x-primary-until"
+
+	DB_READ_YOUR_WRITES_DEFAULT       = 5 * time.Second
+	DB_REPLICA_CHECK_INTERVAL_DEFAULT = 5 * time.Second
+)
+
+// Replica is a read-only replica of the primary db.
+type Replica struct {
+	// Name identifies the replica in logs, e.g. its address.
+	Name string
+	DB   *gorm.DB
+}
+
+type replica struct {
+	Replica
+	healthy atomic.Bool
+}
+
+// ReplicaSet routes the reads of rpcs that tolerate replication lag to the healthy replicas in
+// turn, falling back to the primary when none are healthy. Replicas start unhealthy, and are
+// added to and removed from rotation by Check, which Monitor runs periodically.
+//
+// With a read-your-writes window, every rpc that writes to the primary returns the
+// x-primary-until header, and requests echoing it back are read from the primary until then.
+// Pins are carried by the client rather than kept here, so they hold across service replicas.
+//
+// FUTURE: replicas are only checked for reachability; lagging ones could be removed too, via
+// pg_last_xact_replay_timestamp.
+type ReplicaSet struct {
+	primary  *gorm.DB
+	replicas []*replica
+	next     atomic.Uint32
+	window   time.Duration
+	// now is replaced by tests.
+	now func() time.Time
+}
+
+// NewReplicaSet returns a replica set of primary, whose writes pin their clients to it for
+// window; zero disables pinning.
+func NewReplicaSet(primary *gorm.DB, replicas []Replica, window time.Duration) (*ReplicaSet, error) {
+	r := &ReplicaSet{primary: primary, window: window, now: time.Now}
+	for _, rep := range replicas {
+		r.replicas = append(r.replicas, &replica{Replica: rep})
+	}
+	if window <= 0 {
+		return r, nil
+	}
+
+	cb := primary.Callback()
+	for _, err := range []error{
+		cb.Create().After("gorm:create").Register("replicas:pin_create", r.pin),
+		cb.Update().After("gorm:update").Register("replicas:pin_update", r.pin),
+		cb.Delete().After("gorm:delete").Register("replicas:pin_delete", r.pin),
+		cb.Raw().After("gorm:raw").Register("replicas:pin_raw", r.pin),
+	} {
+		if err != nil {
+			return nil, err
+		}
+	}
+	return r, nil
+}
+
+// pin sets the x-primary-until header of the rpc whose statement wrote to the primary.
+// Statements made outside an rpc, e.g. by the scheduler, have nowhere to set it and are ignored.
+func (r *ReplicaSet) pin(db *gorm.DB) {
+	if db.Error != nil || db.Statement.Context == nil || db.Statement.RowsAffected == 0 {
+		return
+	}
+	until := r.now().Add(r.window).UTC().Format(time.RFC3339Nano)
+	_ = grpc.SetHeader(db.Statement.Context, metadata.Pairs(PRIMARY_UNTIL_HEADER, until))
+}
+
+// pinned reports whether the request asks to read from the primary. Times further off than
+// twice the window, which allows for clock skew between service replicas, are ignored, so
+// clients cannot pin themselves indefinitely.
+func (r *ReplicaSet) pinned(ctx context.Context) bool {
+	if r.window <= 0 {
+		return false
+	}
+	md, _ := metadata.FromIncomingContext(ctx)
+	now := r.now()
+	for _, v := range md.Get(PRIMARY_UNTIL_HEADER) {
+		until, err := time.Parse(time.RFC3339Nano, v)
+		if err == nil && until.After(now) && until.Sub(now) <= 2*r.window {
+			return true
+		}
+	}
+	return false
+}
+
+// Reader returns the db to read from for the request: the primary if the request is pinned
+// to it or no replica is healthy, else the next healthy replica, reporting which.
+func (r *ReplicaSet) Reader(ctx context.Context) (db *gorm.DB, replica bool) {
+	if len(r.replicas) == 0 || r.pinned(ctx) {
+		return r.primary, false
+	}
+
+	start := r.next.Add(1)
+	for i := range r.replicas {
+		rep := r.replicas[(int(start)+i)%len(r.replicas)]
+		if rep.healthy.Load() {
+			return rep.DB, true
+		}
+	}
+	return r.primary, false
+}
+
+// Healthy returns the names of the replicas in rotation.
+func (r *ReplicaSet) Healthy() []string {
+	names := []string{}
+	for _, rep := range r.replicas {
+		if rep.healthy.Load() {
+			names = append(names, rep.Name)
+		}
+	}
+	return names
+}
+
+// Check pings every replica, taking those that fail out of rotation and returning those that
+// recovered to it.
+func (r *ReplicaSet) Check(ctx context.Context, timeout time.Duration) {
+	for _, rep := range r.replicas {
+		err := ping(ctx, rep.DB, timeout)
+		switch healthy := err == nil; {
+		case healthy && !rep.healthy.Swap(true):
+			infof("db replica %s added to rotation\n", rep.Name)
+		case !healthy && rep.healthy.Swap(false):
+			errorf("db replica %s removed from rotation: %v\n", rep.Name, err)
+		}
+	}
+}
+
+// Monitor checks the replicas now and every interval until ctx is done.
+func (r *ReplicaSet) Monitor(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		r.Check(ctx, interval)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func ping(ctx context.Context, db *gorm.DB, timeout time.Duration) error {
+	sqlDB, err := db.DB()
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	if err := sqlDB.PingContext(ctx); err != nil {
+		return fmt.Errorf("ping failed: %w", err)
+	}
+	return nil
+}
+
+// WithReplicas reads ReadPost, BatchGetPosts, and ListPosts from the replica set; all other
+// reads and every write use the primary, which must be the db of the Server.
+func WithReplicas(replicas *ReplicaSet) ServerOption {
+	return func(s *Server) {
+		s.replicas = replicas
+	}
+}
+
+// reader returns the db that reads tolerating replication lag are made with, reporting whether
+// it is a replica. Posts read from replicas must not be put in the read cache: after a write
+// uncaches a post, a lagging replica would put the old post back, for every client to read.
+func (s *Server) reader(ctx context.Context) (db *gorm.DB, replica bool) {
+	if s.replicas == nil {
+		return s.db, false
+	}
+	return s.replicas.Reader(ctx)
+}
@@ -0,0 +1,120 @@
+package endpoints
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	pb "go_grpc_example/proto"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+func TestReplicaSet(t *testing.T) {
+	Convey("Replica routing tests", t, func() {
+		primary, err := ConnectSQLite(filepath.Join(t.TempDir(), "primary.db"))
+		So(err, ShouldBeNil)
+		replica, err := ConnectSQLite(filepath.Join(t.TempDir(), "replica.db"))
+		So(err, ShouldBeNil)
+
+		// The replica lags, still holding an older title.
+		ctx := tenantContext(DEFAULT_TENANT)
+		_, err = NewServer(primary).CreatePost(ctx, &pb.Post{Id: "1", AuthorId: "jose", Title: "new"})
+		So(err, ShouldBeNil)
+		_, err = NewServer(replica).CreatePost(ctx, &pb.Post{Id: "1", AuthorId: "jose", Title: "old"})
+		So(err, ShouldBeNil)
+
+		rs, err := NewReplicaSet(primary, []Replica{{Name: "replica", DB: replica}}, time.Minute)
+		So(err, ShouldBeNil)
+		s := NewServer(primary, WithReplicas(rs))
+		cli, stop := serve(s)
+		defer stop()
+
+		title := func(ctx context.Context) string {
+			post, err := cli.ReadPost(ctx, &pb.PostID{Id: "1"})
+			So(err, ShouldBeNil)
+			return post.Title
+		}
+
+		Convey("Replicas only serve reads once checked healthy", func() {
+			So(title(context.Background()), ShouldEqual, "new")
+
+			rs.Check(context.Background(), time.Second)
+			So(rs.Healthy(), ShouldResemble, []string{"replica"})
+			So(title(context.Background()), ShouldEqual, "old")
+
+			res, err := cli.BatchGetPosts(context.Background(), &pb.BatchGetPostsRequest{Ids: []string{"1"}})
+			So(err, ShouldBeNil)
+			So(res.Results[0].Post.Title, ShouldEqual, "old")
+			posts, err := cli.ListPosts(context.Background(), &pb.ListPostsRequest{})
+			So(err, ShouldBeNil)
+			post, err := posts.Recv()
+			So(err, ShouldBeNil)
+			So(post.Title, ShouldEqual, "old")
+		})
+
+		Convey("Posts read from a lagging replica are not cached", func() {
+			cache, err := NewLRUPostCache(10)
+			So(err, ShouldBeNil)
+			cached := NewServer(primary, WithReplicas(rs), WithReadCache(cache))
+			cachedCli, stop := serve(cached)
+			defer stop()
+			rs.Check(context.Background(), time.Second)
+
+			header := metadata.MD{}
+			_, err = cachedCli.UpdatePost(context.Background(), &pb.Post{Id: "1", Title: "newer"}, grpc.Header(&header))
+			So(err, ShouldBeNil)
+			post, err := cachedCli.ReadPost(context.Background(), &pb.PostID{Id: "1"})
+			So(err, ShouldBeNil)
+			So(post.Title, ShouldEqual, "old")
+			res, err := cachedCli.BatchGetPosts(context.Background(), &pb.BatchGetPostsRequest{Ids: []string{"1"}})
+			So(err, ShouldBeNil)
+			So(res.Results[0].Post.Title, ShouldEqual, "old")
+			So(cache.Stats().Size, ShouldEqual, 0)
+
+			// The pinned client reads its write, which is cached, as read from the primary.
+			pinned := metadata.NewOutgoingContext(context.Background(), metadata.Pairs(PRIMARY_UNTIL_HEADER, header.Get(PRIMARY_UNTIL_HEADER)[0]))
+			post, err = cachedCli.ReadPost(pinned, &pb.PostID{Id: "1"})
+			So(err, ShouldBeNil)
+			So(post.Title, ShouldEqual, "newer")
+			So(cache.Stats().Size, ShouldEqual, 1)
+		})
+
+		Convey("Writes pin their client to the primary", func() {
+			rs.Check(context.Background(), time.Second)
+
+			header := metadata.MD{}
+			_, err := cli.UpdatePost(context.Background(), &pb.Post{Id: "1", Title: "newer"}, grpc.Header(&header))
+			So(err, ShouldBeNil)
+			So(header.Get(PRIMARY_UNTIL_HEADER), ShouldNotBeEmpty)
+
+			pinned := metadata.NewOutgoingContext(context.Background(), metadata.Pairs(PRIMARY_UNTIL_HEADER, header.Get(PRIMARY_UNTIL_HEADER)[0]))
+			So(title(pinned), ShouldEqual, "newer")
+			So(title(context.Background()), ShouldEqual, "old")
+
+			header = metadata.MD{}
+			_, err = cli.ReadPost(context.Background(), &pb.PostID{Id: "1"}, grpc.Header(&header))
+			So(err, ShouldBeNil)
+			So(header.Get(PRIMARY_UNTIL_HEADER), ShouldBeEmpty)
+
+			for _, until := range []time.Time{time.Now().Add(-time.Second), time.Now().Add(time.Hour)} {
+				stale := metadata.AppendToOutgoingContext(context.Background(), PRIMARY_UNTIL_HEADER, until.Format(time.RFC3339Nano))
+				So(title(stale), ShouldEqual, "old")
+			}
+		})
+
+		Convey("Unhealthy replicas are taken out of rotation", func() {
+			rs.Check(context.Background(), time.Second)
+			sqlDB, err := replica.DB()
+			So(err, ShouldBeNil)
+			So(sqlDB.Close(), ShouldBeNil)
+
+			rs.Check(context.Background(), time.Second)
+			So(rs.Healthy(), ShouldBeEmpty)
+			So(title(context.Background()), ShouldEqual, "new")
+		})
+	})
+}
@@ -2,133 +2,441 @@ package endpoints
 
 import (
 	"context"
-	"fmt"
-	"log"
+	"time"
 
+	"go_grpc_example/blobstore"
 	pb "go_grpc_example/proto"
 
 	empty "github.com/golang/protobuf/ptypes/empty"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
 	"gorm.io/gorm"
 )
 
+// BATCH_GET_MAX_DEFAULT is how many ids a BatchGetPosts request may name by default.
+const BATCH_GET_MAX_DEFAULT = 100
+
 // TODO: I left out a HUGE service requirement because the goal was merely to
 // learn gRPC itself. The service needs locking and other concurrency reqs
 // need to be considered and implemented.
 type Server struct {
-	db *gorm.DB
+	db                *gorm.DB
+	idempotencyWindow time.Duration
+	outbox            bool
+	maxPosts          int64
+	maxBytes          int64
+	readCache         PostCache
+	batchGetMax       int
+	// replicas serve the reads that tolerate replication lag; nil reads everything from db.
+	replicas *ReplicaSet
+	// blobs stores attachments; nil disables the attachment rpcs.
+	blobs              blobstore.BlobStore
+	attachmentMaxBytes int64
+	attachmentTypes    map[string]bool
+	// statsCache holds GetPostStats responses; nil disables it.
+	statsCache *statsCache
+	// auditLog is queried by the AdminService; nil if the audit log is disabled.
+	auditLog AuditSink
+	// renders caches the html of posts; nil renders them on every read.
+	renders *renderCache
 	pb.UnimplementedCrudServiceServer
 }
 
+// ServerOption configures optional Server behavior.
+type ServerOption func(*Server)
+
+// WithIdempotencyWindow sets how long idempotency keys are remembered.
+func WithIdempotencyWindow(window time.Duration) ServerOption {
+	return func(s *Server) {
+		s.idempotencyWindow = window
+	}
+}
+
+// WithBatchGetMax sets how many ids a BatchGetPosts request may name.
+func WithBatchGetMax(n int) ServerOption {
+	return func(s *Server) {
+		s.batchGetMax = n
+	}
+}
+
 // NewServer returns a server given the passed db.
-func NewServer(db *gorm.DB) *Server {
-	return &Server{db: db}
+func NewServer(db *gorm.DB, opts ...ServerOption) *Server {
+	s := &Server{
+		db:                db,
+		idempotencyWindow: IDEMPOTENCY_WINDOW_DEFAULT,
+		batchGetMax:       BATCH_GET_MAX_DEFAULT,
+	}
+	WithAttachmentLimits(ATTACHMENT_MAX_BYTES_DEFAULT, ATTACHMENT_CONTENT_TYPES_DEFAULT)(s)
+	WithRenderCache(RENDER_CACHE_SIZE_DEFAULT)(s)
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
 }
 
-// CreatePost creates and persists the passed post.
+// CreatePost creates and persists the passed post, if it satisfies the postRules.
+// If the request carries an idempotency-key header, retries with the same key and post
+// are answered with the original PostID rather than creating the post again.
 func (s *Server) CreatePost(ctx context.Context, post *pb.Post) (*pb.PostID, error) {
-	log.Printf("CreatePost invoked\n")
+	debugf("CreatePost invoked\n")
 
-	dto := NewPost(post)
-	tx := s.db.
-		WithContext(ctx).
-		Create(&dto)
-	if tx.Error != nil {
-		return nil, tx.Error
+	violations := append(postViolations(post, false, ""), statusViolations(post, time.Now())...)
+	if err := badRequest("invalid post", violations); err != nil {
+		return nil, err
+	}
+
+	return s.createPost(ctx, "CreatePost", post, NewPost(post))
+}
+
+// createPost creates the validated post, recording its PostID as the response to the method's
+// req for idempotency. Records are per method, so requests of each api version replay their own.
+func (s *Server) createPost(ctx context.Context, method string, req proto.Message, post Post) (*pb.PostID, error) {
+	// created is nil if the request was replayed, having changed nothing.
+	var created *Post
+	id, err := idempotent(s, ctx, method, req,
+		func() *pb.PostID { return &pb.PostID{} },
+		func(tx *gorm.DB) (*pb.PostID, error) {
+			dto := post
+			if dto.Status == STATUS_PUBLISHED {
+				now := time.Now()
+				dto.PublishAt = &now
+			}
+			u, err := s.usage(tx)
+			if err != nil {
+				return nil, err
+			}
+			if err := u.add(1, dto.size()); err != nil {
+				return nil, err
+			}
+
+			if err := tx.Create(&dto).Error; err != nil {
+				return nil, err
+			}
+			created = &dto
+
+			pbPost := NewPbPost(&dto)
+			if err := s.enqueue(tx, EVENT_POST_CREATED, dto.PostId, &pbPost); err != nil {
+				return nil, err
+			}
+
+			return &pb.PostID{
+				Id: dto.PostId,
+			}, nil
+		})
+	if err != nil {
+		return nil, err
 	}
 
-	return &pb.PostID{
-		Id: dto.PostId,
-	}, nil
+	if created != nil {
+		audit(ctx, id.Id, postChanges(&Post{}, created)...)
+	} else {
+		audit(ctx, id.Id)
+	}
+	return id, nil
 }
 
 // ReadPost returns the Post with the associated post-id.
 func (s *Server) ReadPost(ctx context.Context, postID *pb.PostID) (*pb.Post, error) {
-	log.Printf("ReadPost invoked\n")
+	debugf("ReadPost invoked\n")
+
+	if err := badRequest("invalid read request", renderViolations(postID.Render)); err != nil {
+		return nil, err
+	}
+
+	post, err := pbPostOf(s.readPost(ctx, postID.Id))
+	if err != nil {
+		return nil, err
+	}
+	s.render(ctx, post, postID.Render)
+	return post, nil
+}
+
+// readPost returns the post with the post-id from the read cache, or else reads and caches it.
+// Posts of any status are returned: unlike listPosts, reads by id do not hide drafts or archived
+// posts, so within a tenant a post-id is a capability to read the post, as it is to update it.
+func (s *Server) readPost(ctx context.Context, postID string) (*Post, error) {
+	if cached, ok := s.cached(ctx, postID); ok {
+		return cached, nil
+	}
 
 	post := &Post{}
-	tx := s.db.
+	db, replica := s.reader(ctx)
+	tx := db.
 		WithContext(ctx).
-		Where("post_id = ?", postID.Id).
+		Where("post_id = ?", postID).
 		First(&post)
 	if tx.Error != nil {
-		log.Printf("error in ReadPost: %v\n", tx.Error)
-		return nil, tx.Error
+		errorf("error in ReadPost: %v\n", tx.Error)
+		return nil, toStatus(tx.Error)
 	}
 
+	if !replica {
+		s.cache(ctx, post)
+	}
+	return post, nil
+}
+
+// pbPostOf returns post as a pb.Post, unless err is set.
+func pbPostOf(post *Post, err error) (*pb.Post, error) {
+	if err != nil {
+		return nil, err
+	}
 	pbPost := NewPbPost(post)
 	return &pbPost, nil
 }
 
+// BatchGetPosts returns the posts with the requested post-ids, in request order, with a result
+// per id reporting whether it was found, of any status, like ReadPost. Cached posts are served from the read cache, and the
+// rest are read with a single query.
+func (s *Server) BatchGetPosts(ctx context.Context, req *pb.BatchGetPostsRequest) (*pb.BatchGetPostsResponse, error) {
+	debugf("BatchGetPosts invoked with %d ids\n", len(req.Ids))
+
+	found, err := s.batchGetPosts(ctx, req.Ids)
+	if err != nil {
+		return nil, err
+	}
+
+	res := &pb.BatchGetPostsResponse{Results: make([]*pb.BatchGetPostsResult, len(req.Ids))}
+	for i, id := range req.Ids {
+		post := found[id]
+		res.Results[i] = &pb.BatchGetPostsResult{Id: id, Found: post != nil}
+		if post != nil {
+			pbPost := NewPbPost(post)
+			res.Results[i].Post = &pbPost
+		}
+	}
+	return res, nil
+}
+
+// batchGetPosts returns the posts with the post-ids by post-id, with nil for those not found.
+func (s *Server) batchGetPosts(ctx context.Context, ids []string) (map[string]*Post, error) {
+	if len(ids) > s.batchGetMax {
+		return nil, status.Errorf(codes.InvalidArgument, "at most %d ids may be requested, got %d", s.batchGetMax, len(ids))
+	}
+
+	found := make(map[string]*Post, len(ids))
+	uncached := []string{}
+	for _, id := range ids {
+		if _, ok := found[id]; ok {
+			continue
+		}
+		if cached, ok := s.cached(ctx, id); ok {
+			found[id] = cached
+			continue
+		}
+		// Mark the id as seen, so duplicates are only queried once.
+		found[id] = nil
+		uncached = append(uncached, id)
+	}
+
+	if len(uncached) > 0 {
+		posts := []Post{}
+		db, replica := s.reader(ctx)
+		tx := db.
+			WithContext(ctx).
+			Where("post_id IN ?", uncached).
+			Find(&posts)
+		if tx.Error != nil {
+			errorf("error in BatchGetPosts: %v\n", tx.Error)
+			return nil, toStatus(tx.Error)
+		}
+
+		for i := range posts {
+			found[posts[i].PostId] = &posts[i]
+			if !replica {
+				s.cache(ctx, &posts[i])
+			}
+		}
+	}
+	return found, nil
+}
+
 // UpdatePost updates the passed post with whatever fields are non-empty and differ from the existing ones.
+// The non-empty fields must satisfy the postRules.
 func (s *Server) UpdatePost(ctx context.Context, pbPost *pb.Post) (*empty.Empty, error) {
-	log.Printf("UpdatePost invoked\n")
+	debugf("UpdatePost invoked\n")
 
-	post := NewPost(pbPost)
-	dest := &Post{}
-	tx := s.db.
-		WithContext(ctx).
-		Where("post_id = ?", post.PostId).
-		First(dest)
-	if tx.Error != nil {
-		log.Printf("error in UpdatePost: %v\n", tx.Error)
-		return &empty.Empty{}, tx.Error
+	if err := validatePost(pbPost, true); err != nil {
+		return nil, err
 	}
 
-	post.ID = dest.ID
-	if !Merge(&post, dest) {
-		// No changes received, so just return
-		log.Println("no post changes in UpdatePost, returning")
-		return &empty.Empty{}, nil
-	}
+	_, err := s.updatePost(ctx, NewPost(pbPost), nil)
+	return &empty.Empty{}, err
+}
 
-	log.Println("new desc: " + fmt.Sprintf("%d ", dest.ID) + dest.Description)
-	tx = s.db.
-		WithContext(ctx).
-		Save(dest)
+// updatePost merges post into the post with its post-id, clearing the cleared fields too, and
+// returns the post as updated. If post.Version is set, the post must be at that version.
+func (s *Server) updatePost(ctx context.Context, post Post, cleared []string) (*Post, error) {
+	dest := &Post{}
+	var changed []string
+	err := s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.
+			Where("post_id = ?", post.PostId).
+			First(dest).Error; err != nil {
+			errorf("error in UpdatePost: %v\n", err)
+			return err
+		}
+		if post.Version != 0 && post.Version != dest.Version {
+			return status.Errorf(codes.Aborted, "post is at version %d, not %d", dest.Version, post.Version)
+		}
+
+		post.ID = dest.ID
+		before := *dest
+		oldSize := dest.size()
+		merged := Merge(&post, dest)
+		merged = clearFields(dest, cleared) || merged
+		if !merged {
+			// No changes received, so just return
+			debugf("no post changes in UpdatePost, returning\n")
+			return nil
+		}
+		changed = postChanges(&before, dest)
 
-	s.db.First(dest)
+		u, err := s.usage(tx)
+		if err != nil {
+			return err
+		}
+		if err := u.add(0, dest.size()-oldSize); err != nil {
+			return err
+		}
 
-	log.Printf("after update, got: %+v\n", dest)
+		debugf("new desc: %d %s\n", dest.ID, dest.Description)
+		// The post was read without a lock, so the update only applies if it is still at the
+		// version read, and only writes the changed columns, so that a concurrent update, or
+		// status change, is neither overwritten nor reverted. The changed fields of an update
+		// are named like their columns.
+		dest.Version++
+		columns := append([]string{"bytes", "full_text_length", "version", "updated_at"}, changed...)
+		res := tx.
+			Model(dest).
+			Where("version = ?", before.Version).
+			Select(columns).
+			Updates(dest)
+		if res.Error != nil {
+			return res.Error
+		}
+		if res.RowsAffected == 0 {
+			return status.Errorf(codes.Aborted, "post changed concurrently from version %d", before.Version)
+		}
+
+		updated := NewPbPost(dest)
+		return s.enqueue(tx, EVENT_POST_UPDATED, dest.PostId, &updated)
+	})
+	if err != nil {
+		return dest, toStatus(err)
+	}
+	audit(ctx, post.PostId, changed...)
+	if len(changed) == 0 {
+		return dest, nil
+	}
+	s.uncache(ctx, post.PostId)
+
+	if err := s.db.WithContext(ctx).First(dest).Error; err != nil {
+		errorf("error re-reading post after update: %v\n", err)
+	} else {
+		debugf("after update, got: %+v\n", dest)
+	}
 
-	return &empty.Empty{}, tx.Error
+	return dest, nil
 }
 
 // DeletePost deletes the post with the passed post-id.
 func (s *Server) DeletePost(ctx context.Context, postID *pb.PostID) (*empty.Empty, error) {
-	tx := s.db.
-		WithContext(ctx).
-		Where("post_id = ?", postID.Id).
-		Delete(&Post{})
+	deleted := false
+	err := s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		res := tx.
+			Where("post_id = ?", postID.Id).
+			Delete(&Post{})
+		if res.Error != nil || res.RowsAffected == 0 {
+			return res.Error
+		}
 
-	return &empty.Empty{}, tx.Error
+		deleted = true
+		return s.enqueue(tx, EVENT_POST_DELETED, postID.Id, postID)
+	})
+	if err == nil {
+		s.uncache(ctx, postID.Id)
+	}
+	if deleted && err == nil {
+		audit(ctx, postID.Id, "deleted_at")
+	}
+
+	return &empty.Empty{}, toStatus(err)
 }
 
-// ListPosts streams all of the posts.
+// PurgeDeletedPosts permanently deletes the posts soft-deleted at least olderThan ago, and
+// returns how many were deleted. Their post-ids become free for reuse. Every tenant's are purged.
+func PurgeDeletedPosts(ctx context.Context, db *gorm.DB, olderThan time.Duration) (int64, error) {
+	tx := db.
+		WithContext(AllTenants(ctx)).
+		Unscoped().
+		Where("deleted_at <= ?", time.Now().Add(-olderThan)).
+		Delete(&Post{})
+	return tx.RowsAffected, tx.Error
+}
+
+// ListPosts streams the published posts, including scheduled posts whose publish_at has passed
+// but which the scheduler has yet to publish. With include_own_drafts, the caller's own draft
+// and scheduled posts are streamed too.
+// The query is bound to the stream context: when the client cancels or disconnects, or the
+// deadline expires, the statement is canceled and the cursor closed, and ListPosts returns.
 // FUTURE: this could take a where-type clause or other query, omitted for simplicity.
-// TODO: I never tested this.
-func (s *Server) ListPosts(_ *empty.Empty, lps pb.CrudService_ListPostsServer) error {
-	log.Println("ListPosts invoked")
+func (s *Server) ListPosts(req *pb.ListPostsRequest, lps pb.CrudService_ListPostsServer) error {
+	debugf("ListPosts invoked\n")
+
+	if err := badRequest("invalid list request", renderViolations(req.Render)); err != nil {
+		return err
+	}
 
-	rows, err := s.db.
+	return s.listPosts(lps.Context(), req.IncludeOwnDrafts, "", func(post *Post) error {
+		pbPost := NewPbPost(post)
+		s.render(lps.Context(), &pbPost, req.Render)
+		return lps.Send(&pbPost)
+	})
+}
+
+// listPosts passes the posts ListPosts lists to send, only those with the tag if it is set.
+// The post passed to send is reused for the next one.
+func (s *Server) listPosts(ctx context.Context, includeOwnDrafts bool, tag string, send func(*Post) error) error {
+	listed := "(status = ? OR (status = ? AND publish_at <= ?))"
+	args := []interface{}{STATUS_PUBLISHED, STATUS_SCHEDULED, time.Now()}
+	if includeOwnDrafts {
+		c, ok := CallerFrom(ctx)
+		if !ok || c.Subject == "" {
+			return status.Error(codes.Unauthenticated, "include_own_drafts requires a jwt naming the author")
+		}
+		listed = "(" + listed + " OR (author_id = ? AND status IN ?))"
+		args = append(args, c.Subject, []string{STATUS_DRAFT, STATUS_SCHEDULED})
+	}
+
+	db, _ := s.reader(ctx)
+	query := db.
+		WithContext(ctx).
 		Model(&Post{}).
-		Rows()
+		Where(listed, args...)
+	if tag != "" {
+		query = query.Where("tags LIKE ?", `%"`+tag+`"%`)
+	}
+	rows, err := query.Rows()
 	if err != nil {
-		return err
+		return toStatus(err)
 	}
 	defer rows.Close()
 
-	// TODO: this function is incomplete, I think I'm missing some stream reqs or closure reqs (or maybe above as well)
 	post := &Post{}
 	for rows.Next() {
-		if err := s.db.ScanRows(rows, post); err != nil {
-			return err
+		if err := db.ScanRows(rows, post); err != nil {
+			return toStatus(err)
 		}
-
-		pbPost := NewPbPost(post)
-		if err := lps.Send(&pbPost); err != nil {
+		if err := send(post); err != nil {
 			return err
 		}
 	}
 
-	return nil
+	// A cancellation racing the last row may not surface in rows.Err, so check ctx as well.
+	if err := rows.Err(); err != nil {
+		return toStatus(err)
+	}
+	return toStatus(ctx.Err())
 }
@@ -0,0 +1,301 @@
+package endpoints
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	pb "go_grpc_example/proto"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	"gorm.io/gorm"
+)
+
+const (
+	// STATS_AUTHORS_MAX bounds the authors whose counts GetPostStats returns.
+	STATS_AUTHORS_MAX         = 1000
+	STATS_TOP_AUTHORS_DEFAULT = 10
+	STATS_TOP_AUTHORS_MAX     = 100
+	// STATS_CACHE_MAX_ENTRIES bounds the responses the stats cache holds.
+	STATS_CACHE_MAX_ENTRIES = 1000
+	// backfillBatchSize is how many posts backfillFullTextLength reads at once.
+	backfillBatchSize = 100
+)
+
+// fullTextLength returns the length of the post's full text in characters.
+func (p *Post) fullTextLength() int64 {
+	return int64(utf8.RuneCountInString(p.FullText))
+}
+
+// backfillFullTextLength sets the FullTextLength of posts written before the column existed.
+// Unlike their Bytes, it is computed in Go, since their text may already be sealed.
+func backfillFullTextLength(db *gorm.DB) error {
+	ctx := AllTenants(context.Background())
+	var lastID uint
+	for {
+		posts := []Post{}
+		err := db.
+			WithContext(ctx).
+			Unscoped().
+			Select("id", "full_text").
+			Where("full_text_length = 0 AND full_text <> '' AND id > ?", lastID).
+			Order("id").
+			Limit(backfillBatchSize).
+			Find(&posts).Error
+		if err != nil || len(posts) == 0 {
+			return err
+		}
+
+		for i := range posts {
+			err := db.
+				WithContext(ctx).
+				Unscoped().
+				Model(&Post{}).
+				Where("id = ?", posts[i].ID).
+				UpdateColumn("full_text_length", posts[i].fullTextLength()).Error
+			if err != nil {
+				return err
+			}
+		}
+		lastID = posts[len(posts)-1].ID
+	}
+}
+
+// statsCache holds the GetPostStats responses of each tenant's requests for a ttl. Writes do
+// not invalidate it, so stats may lag by up to the ttl, as their computed_at shows.
+type statsCache struct {
+	ttl     time.Duration
+	mu      sync.Mutex
+	entries map[string]statsEntry
+}
+
+type statsEntry struct {
+	res     *pb.GetPostStatsResponse
+	expires time.Time
+}
+
+// WithStatsCache caches GetPostStats responses for ttl, which should be short, e.g. 30s,
+// since dashboards refreshing every few seconds would otherwise aggregate the posts each time.
+func WithStatsCache(ttl time.Duration) ServerOption {
+	return func(s *Server) {
+		if ttl > 0 {
+			s.statsCache = &statsCache{ttl: ttl, entries: map[string]statsEntry{}}
+		}
+	}
+}
+
+func (c *statsCache) get(key string) (*pb.GetPostStatsResponse, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, ok := c.entries[key]
+	if !ok || time.Now().After(e.expires) {
+		return nil, false
+	}
+	return e.res, true
+}
+
+func (c *statsCache) put(key string, res *pb.GetPostStatsResponse) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	if len(c.entries) >= STATS_CACHE_MAX_ENTRIES {
+		for k, e := range c.entries {
+			if now.After(e.expires) {
+				delete(c.entries, k)
+			}
+		}
+	}
+	// Evicting arbitrary entries is fine, as they are all about to expire anyway.
+	for k := range c.entries {
+		if len(c.entries) < STATS_CACHE_MAX_ENTRIES {
+			break
+		}
+		delete(c.entries, k)
+	}
+	c.entries[key] = statsEntry{res: res, expires: now.Add(c.ttl)}
+}
+
+// GetPostStats aggregates the posts of the caller's tenant in the db, so that dashboards need
+// not export every post to compute them. The aggregation is sql for the postgres and sqlite
+// backends, the only stores the Server has; there is no in-memory store, and so no in-memory
+// aggregation.
+func (s *Server) GetPostStats(ctx context.Context, req *pb.GetPostStatsRequest) (*pb.GetPostStatsResponse, error) {
+	debugf("GetPostStats invoked\n")
+
+	violations := []*errdetails.BadRequest_FieldViolation{}
+	violation := func(field, description string) {
+		violations = append(violations, &errdetails.BadRequest_FieldViolation{Field: field, Description: description})
+	}
+	if req.Start != nil && req.End != nil && !req.End.AsTime().After(req.Start.AsTime()) {
+		violation("end", "must be after start")
+	}
+	if _, ok := pb.StatsInterval_name[int32(req.Interval)]; !ok {
+		violation("interval", "must be day or week")
+	}
+	if req.TopAuthors < 0 || req.TopAuthors > STATS_TOP_AUTHORS_MAX {
+		violation("top_authors", fmt.Sprintf("must be between 0 and %d", STATS_TOP_AUTHORS_MAX))
+	}
+	if _, ok := pb.PostStatus_name[int32(req.Status)]; !ok {
+		violation("status", "must be a known status")
+	}
+	if err := badRequest("invalid stats request", violations); err != nil {
+		return nil, err
+	}
+
+	if s.statsCache == nil {
+		return s.postStats(ctx, req)
+	}
+
+	tenant, err := tenantFrom(ctx)
+	if err != nil {
+		return nil, err
+	}
+	b, err := proto.MarshalOptions{Deterministic: true}.Marshal(req)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	key := tenant + "/" + string(b)
+	if res, ok := s.statsCache.get(key); ok {
+		return res, nil
+	}
+	res, err := s.postStats(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	s.statsCache.put(key, res)
+	return res, nil
+}
+
+// authorCount and periodCount are rows of the aggregating queries.
+type authorCount struct {
+	AuthorId string
+	Posts    int64
+}
+
+type periodCount struct {
+	Period string
+	Posts  int64
+}
+
+func newAuthorCounts(rows []authorCount) []*pb.AuthorPostCount {
+	counts := make([]*pb.AuthorPostCount, len(rows))
+	for i, row := range rows {
+		counts[i] = &pb.AuthorPostCount{AuthorId: row.AuthorId, Posts: row.Posts}
+	}
+	return counts
+}
+
+// periodSQL returns the sql computing the first day of the period of a post's created_at, in
+// UTC, as YYYY-MM-DD, for the dialect of db.
+func periodSQL(db *gorm.DB, interval pb.StatsInterval) string {
+	week := interval == pb.StatsInterval_STATS_INTERVAL_WEEK
+	if db.Dialector.Name() == "sqlite" {
+		// sqlite converts the stored offset to UTC. 'weekday 0' advances to the week's Sunday.
+		if week {
+			return "date(created_at, 'weekday 0', '-6 days')"
+		}
+		return "date(created_at)"
+	}
+
+	unit := "day"
+	if week {
+		unit = "week"
+	}
+	return fmt.Sprintf("to_char(date_trunc('%s', created_at AT TIME ZONE 'UTC'), 'YYYY-MM-DD')", unit)
+}
+
+// postStats runs the aggregating queries of req, on a replica if any.
+func (s *Server) postStats(ctx context.Context, req *pb.GetPostStatsRequest) (*pb.GetPostStatsResponse, error) {
+	res := &pb.GetPostStatsResponse{ComputedAt: timestamppb.Now()}
+	db, _ := s.reader(ctx)
+
+	// The bounds are local times like the stored ones, which sqlite compares as text.
+	end := res.ComputedAt.AsTime().Local()
+	if req.End != nil {
+		end = req.End.AsTime().Local()
+	}
+	posts := func() *gorm.DB {
+		query := db.
+			WithContext(ctx).
+			Model(&Post{}).
+			Where("created_at < ?", end)
+		if req.Start != nil {
+			query = query.Where("created_at >= ?", req.Start.AsTime().Local())
+		}
+		if req.Status != pb.PostStatus_POST_STATUS_UNSPECIFIED {
+			query = query.Where("status = ?", statusName(req.Status))
+		}
+		return query
+	}
+
+	totals := struct {
+		Posts         int64
+		AverageLength float64
+	}{}
+	err := posts().
+		Select("COUNT(*) AS posts, COALESCE(AVG(CAST(full_text_length AS DOUBLE PRECISION)), 0) AS average_length").
+		Scan(&totals).Error
+	if err != nil {
+		errorf("error in GetPostStats: %v\n", err)
+		return nil, toStatus(err)
+	}
+	res.TotalPosts, res.AverageFullTextLength = totals.Posts, totals.AverageLength
+
+	authors := []authorCount{}
+	err = posts().
+		Select("author_id, COUNT(*) AS posts").
+		Group("author_id").
+		Order("author_id").
+		Limit(STATS_AUTHORS_MAX + 1).
+		Scan(&authors).Error
+	if err != nil {
+		errorf("error in GetPostStats: %v\n", err)
+		return nil, toStatus(err)
+	}
+	if len(authors) > STATS_AUTHORS_MAX {
+		authors, res.AuthorsTruncated = authors[:STATS_AUTHORS_MAX], true
+	}
+	res.Authors = newAuthorCounts(authors)
+
+	top := int(req.TopAuthors)
+	if top == 0 {
+		top = STATS_TOP_AUTHORS_DEFAULT
+	}
+	authors = []authorCount{}
+	err = posts().
+		Select("author_id, COUNT(*) AS posts").
+		Group("author_id").
+		Order("posts DESC, author_id").
+		Limit(top).
+		Scan(&authors).Error
+	if err != nil {
+		errorf("error in GetPostStats: %v\n", err)
+		return nil, toStatus(err)
+	}
+	res.TopAuthors = newAuthorCounts(authors)
+
+	periods := []periodCount{}
+	err = posts().
+		Select(periodSQL(db, req.Interval) + " AS period, COUNT(*) AS posts").
+		Group("period").
+		Order("period").
+		Scan(&periods).Error
+	if err != nil {
+		errorf("error in GetPostStats: %v\n", err)
+		return nil, toStatus(err)
+	}
+	for _, period := range periods {
+		start, err := time.Parse("2006-01-02", period.Period)
+		if err != nil {
+			return nil, toStatus(fmt.Errorf("unexpected period %q: %w", period.Period, err))
+		}
+		res.Created = append(res.Created, &pb.PeriodPostCount{Start: timestamppb.New(start), Posts: period.Posts})
+	}
+	return res, nil
+}
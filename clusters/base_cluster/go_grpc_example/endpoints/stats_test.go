@@ -0,0 +1,140 @@
+package endpoints
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	pb "go_grpc_example/proto"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+func TestPostStats(t *testing.T) {
+	Convey("Post stats tests", t, func() {
+		db, err := ConnectSQLite(filepath.Join(t.TempDir(), "test.db"))
+		So(err, ShouldBeNil)
+		s := NewServer(db)
+		ctx := tenantContext(DEFAULT_TENANT)
+
+		day := func(d, hour int) time.Time { return time.Date(2024, 1, d, hour, 0, 0, 0, time.UTC) }
+		create := func(ctx context.Context, id, author, text string, st pb.PostStatus, at time.Time) {
+			_, err := s.CreatePost(ctx, &pb.Post{Id: id, AuthorId: author, Title: "title " + id, FullText: text, Status: st})
+			So(err, ShouldBeNil)
+			err = db.WithContext(AllTenants(context.Background())).Model(&Post{}).
+				Where("post_id = ?", id).UpdateColumn("created_at", at.Local()).Error
+			So(err, ShouldBeNil)
+		}
+		// 2024-01-08 is a Monday.
+		create(ctx, "1", "alice", "abcd", pb.PostStatus_POST_STATUS_PUBLISHED, day(8, 10))
+		create(ctx, "2", "alice", "héllo", pb.PostStatus_POST_STATUS_PUBLISHED, day(10, 23))
+		create(ctx, "3", "bob", "xyz", pb.PostStatus_POST_STATUS_DRAFT, day(15, 0))
+		create(ctx, "4", "bob", "deleted", pb.PostStatus_POST_STATUS_PUBLISHED, day(9, 0))
+		_, err = s.DeletePost(ctx, &pb.PostID{Id: "4"})
+		So(err, ShouldBeNil)
+		create(tenantContext("other"), "5", "carol", "other tenant", pb.PostStatus_POST_STATUS_PUBLISHED, day(9, 0))
+
+		Convey("Posts are counted by author and day, excluding deleted and other tenants' posts", func() {
+			res, err := s.GetPostStats(ctx, &pb.GetPostStatsRequest{})
+			So(err, ShouldBeNil)
+			So(res.TotalPosts, ShouldEqual, 3)
+			So(res.AverageFullTextLength, ShouldEqual, 4)
+			So(res.Authors, ShouldHaveLength, 2)
+			So(res.Authors[0].AuthorId, ShouldEqual, "alice")
+			So(res.Authors[0].Posts, ShouldEqual, 2)
+			So(res.Authors[1].AuthorId, ShouldEqual, "bob")
+			So(res.Authors[1].Posts, ShouldEqual, 1)
+			So(res.AuthorsTruncated, ShouldBeFalse)
+			So(res.ComputedAt, ShouldNotBeNil)
+
+			So(res.Created, ShouldHaveLength, 3)
+			for i, want := range []time.Time{day(8, 0), day(10, 0), day(15, 0)} {
+				So(res.Created[i].Start.AsTime(), ShouldEqual, want)
+				So(res.Created[i].Posts, ShouldEqual, 1)
+			}
+		})
+
+		Convey("Posts are counted by week, within the range and of the status", func() {
+			res, err := s.GetPostStats(ctx, &pb.GetPostStatsRequest{Interval: pb.StatsInterval_STATS_INTERVAL_WEEK})
+			So(err, ShouldBeNil)
+			So(res.Created, ShouldHaveLength, 2)
+			So(res.Created[0].Start.AsTime(), ShouldEqual, day(8, 0))
+			So(res.Created[0].Posts, ShouldEqual, 2)
+			So(res.Created[1].Start.AsTime(), ShouldEqual, day(15, 0))
+			So(res.Created[1].Posts, ShouldEqual, 1)
+
+			res, err = s.GetPostStats(ctx, &pb.GetPostStatsRequest{
+				Start: timestamppb.New(day(9, 0)),
+				End:   timestamppb.New(day(15, 0)),
+			})
+			So(err, ShouldBeNil)
+			So(res.TotalPosts, ShouldEqual, 1)
+			So(res.AverageFullTextLength, ShouldEqual, 5)
+
+			res, err = s.GetPostStats(ctx, &pb.GetPostStatsRequest{Status: pb.PostStatus_POST_STATUS_DRAFT})
+			So(err, ShouldBeNil)
+			So(res.TotalPosts, ShouldEqual, 1)
+			So(res.Authors[0].AuthorId, ShouldEqual, "bob")
+		})
+
+		Convey("Top authors are ordered by their posts", func() {
+			res, err := s.GetPostStats(ctx, &pb.GetPostStatsRequest{TopAuthors: 1})
+			So(err, ShouldBeNil)
+			So(res.TopAuthors, ShouldHaveLength, 1)
+			So(res.TopAuthors[0].AuthorId, ShouldEqual, "alice")
+
+			res, err = s.GetPostStats(ctx, &pb.GetPostStatsRequest{Start: timestamppb.New(day(20, 0))})
+			So(err, ShouldBeNil)
+			So(res.TotalPosts, ShouldEqual, 0)
+			So(res.AverageFullTextLength, ShouldEqual, 0)
+			So(res.TopAuthors, ShouldBeEmpty)
+		})
+
+		Convey("Invalid requests are rejected", func() {
+			_, err := s.GetPostStats(ctx, &pb.GetPostStatsRequest{
+				Start:      timestamppb.New(day(9, 0)),
+				End:        timestamppb.New(day(9, 0)),
+				Interval:   pb.StatsInterval(7),
+				TopAuthors: STATS_TOP_AUTHORS_MAX + 1,
+			})
+			So(violations(err), ShouldContainKey, "end")
+			So(violations(err), ShouldContainKey, "interval")
+			So(violations(err), ShouldContainKey, "top_authors")
+		})
+
+		Convey("Cached stats are served until their ttl expires", func() {
+			cached := NewServer(db, WithStatsCache(50*time.Millisecond))
+			req := &pb.GetPostStatsRequest{}
+			first, err := cached.GetPostStats(ctx, req)
+			So(err, ShouldBeNil)
+			create(ctx, "6", "dave", "new", pb.PostStatus_POST_STATUS_PUBLISHED, day(16, 0))
+
+			res, err := cached.GetPostStats(ctx, req)
+			So(err, ShouldBeNil)
+			So(res.TotalPosts, ShouldEqual, 3)
+			So(res.ComputedAt.AsTime(), ShouldEqual, first.ComputedAt.AsTime())
+
+			other, err := cached.GetPostStats(tenantContext("other"), req)
+			So(err, ShouldBeNil)
+			So(other.TotalPosts, ShouldEqual, 1)
+
+			time.Sleep(60 * time.Millisecond)
+			res, err = cached.GetPostStats(ctx, req)
+			So(err, ShouldBeNil)
+			So(res.TotalPosts, ShouldEqual, 4)
+		})
+
+		Convey("Lengths of posts predating the column are backfilled", func() {
+			err := db.WithContext(AllTenants(context.Background())).Model(&Post{}).
+				Where("post_id = ?", "2").UpdateColumn("full_text_length", 0).Error
+			So(err, ShouldBeNil)
+			So(backfillFullTextLength(db), ShouldBeNil)
+
+			post := Post{}
+			So(db.WithContext(ctx).Where("post_id = ?", "2").First(&post).Error, ShouldBeNil)
+			So(post.FullTextLength, ShouldEqual, 5)
+		})
+	})
+}
@@ -0,0 +1,241 @@
+package endpoints
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/golang-jwt/jwt/v4"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// TENANT_HEADER is the metadata key from which the tenant is taken, when trusted.
+	TENANT_HEADER = "x-tenant-id"
+	// TENANT_CLAIM is the jwt claim from which the tenant is taken.
+	TENANT_CLAIM = "tenant"
+	// ROLES_CLAIM is the jwt claim listing the caller's roles.
+	ROLES_CLAIM = "roles"
+	// DEFAULT_TENANT owns the posts that predate tenants, and requests that name no tenant
+	// unless TenantConfig.Default is cleared.
+	DEFAULT_TENANT = "default"
+	maxTenantLen   = 64
+)
+
+// Caller describes who a request is made by, as resolved by the TenantResolver.
+type Caller struct {
+	Tenant string
+	// Subject is the jwt subject, e.g. a user id; empty for header-based tenants.
+	Subject string
+	Roles   []string
+}
+
+// HasRole reports whether the caller has the role.
+func (c *Caller) HasRole(role string) bool {
+	for _, r := range c.Roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+type callerKey struct{}
+type allTenantsKey struct{}
+
+// WithCaller returns a context carrying the caller, whose tenant scopes every db query made with it.
+func WithCaller(ctx context.Context, c *Caller) context.Context {
+	return context.WithValue(ctx, callerKey{}, c)
+}
+
+// CallerFrom returns the caller carried by ctx, if any.
+func CallerFrom(ctx context.Context) (*Caller, bool) {
+	c, ok := ctx.Value(callerKey{}).(*Caller)
+	return c, ok
+}
+
+// AllTenants returns a context whose db queries are not scoped to a tenant, for background
+// jobs such as purges. Never derive it from a request context.
+func AllTenants(ctx context.Context) context.Context {
+	return context.WithValue(ctx, allTenantsKey{}, true)
+}
+
+func isAllTenants(ctx context.Context) bool {
+	all, _ := ctx.Value(allTenantsKey{}).(bool)
+	return all
+}
+
+// tenantFrom returns the tenant of the request, or Unauthenticated if it has none.
+func tenantFrom(ctx context.Context) (string, error) {
+	c, ok := CallerFrom(ctx)
+	if !ok || c.Tenant == "" {
+		return "", status.Error(codes.Unauthenticated, "request has no tenant")
+	}
+	return c.Tenant, nil
+}
+
+// TenantConfig configures how requests are mapped to tenants, and the tenants' quotas.
+type TenantConfig struct {
+	// JWTKey is the HMAC key verifying bearer tokens. If set, every request needs a valid token;
+	// if empty, bearer tokens are ignored.
+	JWTKey []byte
+	// TrustHeader accepts the tenant from the x-tenant-id header, which is only safe if
+	// a gateway in front of the service sets or strips it.
+	TrustHeader bool
+	// Default is the tenant of requests that name none, without a JWTKey. If empty, such
+	// requests are rejected.
+	Default string
+	// MaxPosts and MaxBytes are the quotas of tenants without a TenantQuota row; 0 is unlimited.
+	MaxPosts int64
+	MaxBytes int64
+}
+
+// TenantResolver resolves the Caller of each request, via its interceptors:
+//   - a valid bearer jwt yields the tenant of its 'tenant' claim, along with its subject and roles;
+//     a trusted header naming a different tenant is rejected
+//   - else, if a jwt key is configured, the request is rejected, whatever header it sends, so a
+//     caller cannot name another tenant by omitting its token
+//   - else a trusted x-tenant-id header yields its tenant
+//   - else the default tenant, if any
+type TenantResolver struct {
+	cfg TenantConfig
+}
+
+func NewTenantResolver(cfg TenantConfig) *TenantResolver {
+	return &TenantResolver{cfg: cfg}
+}
+
+// Resolve returns the caller of the request with the passed incoming context.
+func (r *TenantResolver) Resolve(ctx context.Context) (*Caller, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+
+	header := ""
+	if vals := md.Get(TENANT_HEADER); len(vals) > 0 && r.cfg.TrustHeader {
+		header = vals[0]
+	}
+
+	if len(r.cfg.JWTKey) > 0 {
+		token := bearerToken(md)
+		if token == "" {
+			return nil, status.Error(codes.Unauthenticated, "request has no bearer token")
+		}
+		c, err := r.parseToken(token)
+		if err != nil {
+			return nil, status.Errorf(codes.Unauthenticated, "invalid token: %v", err)
+		}
+		if header != "" && header != c.Tenant {
+			return nil, status.Error(codes.PermissionDenied, "tenant header does not match the token")
+		}
+		return c, nil
+	}
+
+	tenant := header
+	if tenant == "" {
+		tenant = r.cfg.Default
+	}
+	if tenant == "" {
+		return nil, status.Error(codes.Unauthenticated, "request names no tenant")
+	}
+	if err := validTenant(tenant); err != nil {
+		return nil, err
+	}
+	return &Caller{Tenant: tenant}, nil
+}
+
+func bearerToken(md metadata.MD) string {
+	for _, v := range md.Get("authorization") {
+		if strings.HasPrefix(v, "Bearer ") {
+			return strings.TrimPrefix(v, "Bearer ")
+		}
+	}
+	return ""
+}
+
+func (r *TenantResolver) parseToken(token string) (*Caller, error) {
+	claims := jwt.MapClaims{}
+	_, err := jwt.ParseWithClaims(token, claims, func(t *jwt.Token) (interface{}, error) {
+		if _, ok := t.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, fmt.Errorf("unexpected signing method %v", t.Header["alg"])
+		}
+		return r.cfg.JWTKey, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	tenant, _ := claims[TENANT_CLAIM].(string)
+	if tenant == "" {
+		return nil, errors.New("token has no tenant claim")
+	}
+	if err := validTenant(tenant); err != nil {
+		return nil, err
+	}
+
+	c := &Caller{Tenant: tenant}
+	c.Subject, _ = claims["sub"].(string)
+	if roles, ok := claims[ROLES_CLAIM].([]interface{}); ok {
+		for _, role := range roles {
+			if s, ok := role.(string); ok {
+				c.Roles = append(c.Roles, s)
+			}
+		}
+	}
+	return c, nil
+}
+
+func validTenant(tenant string) error {
+	if len(tenant) > maxTenantLen {
+		return status.Errorf(codes.InvalidArgument, "tenant exceeds %d characters", maxTenantLen)
+	}
+	return nil
+}
+
+// UnaryInterceptor resolves the caller of unary rpcs other than health checks; see Resolve.
+func (r *TenantResolver) UnaryInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if isHealthCheck(info.FullMethod) {
+			return handler(ctx, req)
+		}
+		c, err := r.Resolve(ctx)
+		if err != nil {
+			return nil, err
+		}
+		return handler(WithCaller(ctx, c), req)
+	}
+}
+
+// StreamInterceptor resolves the caller of streaming rpcs other than health checks; see Resolve.
+func (r *TenantResolver) StreamInterceptor() grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if isHealthCheck(info.FullMethod) {
+			return handler(srv, ss)
+		}
+		c, err := r.Resolve(ss.Context())
+		if err != nil {
+			return err
+		}
+		return handler(srv, &contextStream{ServerStream: ss, ctx: WithCaller(ss.Context(), c)})
+	}
+}
+
+// ServerOptions returns the grpc server options installing the interceptors.
+func (r *TenantResolver) ServerOptions() []grpc.ServerOption {
+	return []grpc.ServerOption{
+		grpc.ChainUnaryInterceptor(r.UnaryInterceptor()),
+		grpc.ChainStreamInterceptor(r.StreamInterceptor()),
+	}
+}
+
+// contextStream overrides the context of a server stream.
+type contextStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *contextStream) Context() context.Context {
+	return s.ctx
+}
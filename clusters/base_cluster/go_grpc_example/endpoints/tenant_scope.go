@@ -0,0 +1,105 @@
+package endpoints
+
+import (
+	"errors"
+	"reflect"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// ErrNoTenant is returned for queries of tenant-scoped models made without a tenant.
+var ErrNoTenant = errors.New("query of tenant-scoped model has no tenant")
+
+// tenantScoped is implemented by models with a TenantID column that TenantScope scopes.
+type tenantScoped interface {
+	tenantScoped()
+}
+
+func (Post) tenantScoped()              {}
+func (IdempotencyRecord) tenantScoped() {}
+
+// TenantScope is a gorm plugin that scopes every query of a tenant-scoped model to the
+// tenant of the Caller in the statement's context, so that no endpoint can forget to:
+//   - creates have their TenantID set to the caller's tenant, overwriting any other value
+//   - queries, rows, updates, and deletes are filtered by the caller's tenant
+//
+// Statements without a caller fail with ErrNoTenant, unless their context is AllTenants.
+// Raw sql (Exec, Raw) is not scoped, so endpoints must not use it for tenant-scoped models.
+type TenantScope struct{}
+
+func (TenantScope) Name() string {
+	return "tenant_scope"
+}
+
+func (TenantScope) Initialize(db *gorm.DB) error {
+	cb := db.Callback()
+	if err := cb.Create().Before("gorm:create").Register("tenant_scope:create", setTenant); err != nil {
+		return err
+	}
+	if err := cb.Query().Before("gorm:query").Register("tenant_scope:query", filterTenant); err != nil {
+		return err
+	}
+	if err := cb.Row().Before("gorm:row").Register("tenant_scope:row", filterTenant); err != nil {
+		return err
+	}
+	if err := cb.Update().Before("gorm:update").Register("tenant_scope:update", filterTenant); err != nil {
+		return err
+	}
+	return cb.Delete().Before("gorm:delete").Register("tenant_scope:delete", filterTenant)
+}
+
+// statementTenant returns the tenant to scope the statement to, and whether it needs scoping.
+func statementTenant(db *gorm.DB) (string, bool) {
+	stmt := db.Statement
+	if stmt.Schema == nil || db.Error != nil {
+		return "", false
+	}
+	if _, ok := reflect.New(stmt.Schema.ModelType).Interface().(tenantScoped); !ok {
+		return "", false
+	}
+	if isAllTenants(stmt.Context) {
+		return "", false
+	}
+
+	tenant, err := tenantFrom(stmt.Context)
+	if err != nil {
+		db.AddError(ErrNoTenant)
+		return "", false
+	}
+	return tenant, true
+}
+
+func filterTenant(db *gorm.DB) {
+	tenant, ok := statementTenant(db)
+	if !ok {
+		return
+	}
+
+	db.Statement.AddClause(clause.Where{Exprs: []clause.Expression{
+		clause.Eq{Column: clause.Column{Table: clause.CurrentTable, Name: "tenant_id"}, Value: tenant},
+	}})
+}
+
+func setTenant(db *gorm.DB) {
+	tenant, ok := statementTenant(db)
+	if !ok {
+		return
+	}
+
+	field := db.Statement.Schema.LookUpField("TenantID")
+	rv := db.Statement.ReflectValue
+	switch rv.Kind() {
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < rv.Len(); i++ {
+			if err := field.Set(db.Statement.Context, reflect.Indirect(rv.Index(i)), tenant); err != nil {
+				db.AddError(err)
+				return
+			}
+		}
+	case reflect.Struct:
+		if err := field.Set(db.Statement.Context, rv, tenant); err != nil {
+			db.AddError(err)
+		}
+	}
+}
@@ -0,0 +1,226 @@
+package endpoints
+
+import (
+	"context"
+	"io"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	pb "go_grpc_example/proto"
+
+	"github.com/golang-jwt/jwt/v4"
+	. "github.com/smartystreets/goconvey/convey"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+func tenantContext(tenant string) context.Context {
+	return WithCaller(context.Background(), &Caller{Tenant: tenant})
+}
+
+func incoming(kv ...string) context.Context {
+	return metadata.NewIncomingContext(context.Background(), metadata.Pairs(kv...))
+}
+
+func signToken(key []byte, claims jwt.MapClaims) string {
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(key)
+	So(err, ShouldBeNil)
+	return "Bearer " + token
+}
+
+func TestTenantResolver(t *testing.T) {
+	Convey("Tenant resolution tests", t, func() {
+		key := []byte("secret")
+		r := NewTenantResolver(TenantConfig{JWTKey: key, TrustHeader: true, Default: DEFAULT_TENANT})
+
+		// Without a jwt key, requests need no token.
+		open := NewTenantResolver(TenantConfig{TrustHeader: true, Default: DEFAULT_TENANT})
+
+		Convey("The header names the tenant", func() {
+			c, err := open.Resolve(incoming(TENANT_HEADER, "blog"))
+			So(err, ShouldBeNil)
+			So(c.Tenant, ShouldEqual, "blog")
+		})
+
+		Convey("Requests naming no tenant get the default", func() {
+			c, err := open.Resolve(context.Background())
+			So(err, ShouldBeNil)
+			So(c.Tenant, ShouldEqual, DEFAULT_TENANT)
+
+			r = NewTenantResolver(TenantConfig{TrustHeader: true})
+			_, err = r.Resolve(context.Background())
+			So(status.Code(err), ShouldEqual, codes.Unauthenticated)
+		})
+
+		Convey("Untrusted headers are ignored", func() {
+			r = NewTenantResolver(TenantConfig{Default: DEFAULT_TENANT})
+			c, err := r.Resolve(incoming(TENANT_HEADER, "blog"))
+			So(err, ShouldBeNil)
+			So(c.Tenant, ShouldEqual, DEFAULT_TENANT)
+		})
+
+		Convey("A jwt names the tenant, subject, and roles", func() {
+			token := signToken(key, jwt.MapClaims{"tenant": "blog", "sub": "jose", "roles": []string{"admin"}})
+			c, err := r.Resolve(incoming("authorization", token))
+			So(err, ShouldBeNil)
+			So(c.Tenant, ShouldEqual, "blog")
+			So(c.Subject, ShouldEqual, "jose")
+			So(c.HasRole("admin"), ShouldBeTrue)
+		})
+
+		Convey("A header contradicting the jwt is rejected", func() {
+			token := signToken(key, jwt.MapClaims{"tenant": "blog"})
+			_, err := r.Resolve(incoming("authorization", token, TENANT_HEADER, "other"))
+			So(status.Code(err), ShouldEqual, codes.PermissionDenied)
+		})
+
+		Convey("With a jwt key, requests without a token are rejected, whatever tenant they name", func() {
+			_, err := r.Resolve(incoming(TENANT_HEADER, "victim"))
+			So(status.Code(err), ShouldEqual, codes.Unauthenticated)
+			_, err = r.Resolve(context.Background())
+			So(status.Code(err), ShouldEqual, codes.Unauthenticated)
+		})
+
+		Convey("Invalid jwts are rejected", func() {
+			token := signToken([]byte("wrong"), jwt.MapClaims{"tenant": "blog"})
+			_, err := r.Resolve(incoming("authorization", token))
+			So(status.Code(err), ShouldEqual, codes.Unauthenticated)
+
+			token = signToken(key, jwt.MapClaims{"sub": "jose"})
+			_, err = r.Resolve(incoming("authorization", token))
+			So(status.Code(err), ShouldEqual, codes.Unauthenticated)
+		})
+
+		Convey("Oversized tenants are rejected", func() {
+			_, err := open.Resolve(incoming(TENANT_HEADER, strings.Repeat("t", maxTenantLen+1)))
+			So(status.Code(err), ShouldEqual, codes.InvalidArgument)
+		})
+	})
+}
+
+func TestTenantIsolation(t *testing.T) {
+	Convey("Tenant isolation tests", t, func() {
+		db, err := ConnectSQLite(filepath.Join(t.TempDir(), "test.db"))
+		So(err, ShouldBeNil)
+		s := NewServer(db)
+		a, b := tenantContext("a"), tenantContext("b")
+
+		_, err = s.CreatePost(a, &pb.Post{Id: "123", AuthorId: "jose", Title: "a's post"})
+		So(err, ShouldBeNil)
+
+		Convey("Posts are invisible to other tenants", func() {
+			_, err := s.ReadPost(b, &pb.PostID{Id: "123"})
+			So(status.Code(err), ShouldEqual, codes.NotFound)
+
+			_, err = s.UpdatePost(b, &pb.Post{Id: "123", Title: "b's title"})
+			So(status.Code(err), ShouldEqual, codes.NotFound)
+
+			_, err = s.DeletePost(b, &pb.PostID{Id: "123"})
+			So(err, ShouldBeNil)
+
+			post, err := s.ReadPost(a, &pb.PostID{Id: "123"})
+			So(err, ShouldBeNil)
+			So(post.Title, ShouldEqual, "a's post")
+		})
+
+		Convey("Post ids are unique per tenant", func() {
+			_, err := s.CreatePost(b, &pb.Post{Id: "123", AuthorId: "jose", Title: "b's post"})
+			So(err, ShouldBeNil)
+
+			_, err = s.CreatePost(a, &pb.Post{Id: "123", AuthorId: "jose", Title: "title"})
+			So(status.Code(err), ShouldEqual, codes.AlreadyExists)
+
+			post, err := s.ReadPost(b, &pb.PostID{Id: "123"})
+			So(err, ShouldBeNil)
+			So(post.Title, ShouldEqual, "b's post")
+		})
+
+		Convey("Streams are scoped to the tenant named by the header", func() {
+			cli, stop := serve(s)
+			defer stop()
+
+			for tenant, want := range map[string]int{"a": 1, "b": 0} {
+				ctx := metadata.AppendToOutgoingContext(context.Background(), TENANT_HEADER, tenant)
+				stream, err := cli.ListPosts(ctx, &pb.ListPostsRequest{})
+				So(err, ShouldBeNil)
+
+				n := 0
+				for {
+					if _, err := stream.Recv(); err != nil {
+						So(err, ShouldEqual, io.EOF)
+						break
+					}
+					n++
+				}
+				So(n, ShouldEqual, want)
+			}
+		})
+
+		Convey("Queries without a tenant fail", func() {
+			_, err := s.ReadPost(context.Background(), &pb.PostID{Id: "123"})
+			So(err, ShouldNotBeNil)
+			So(db.First(&Post{}).Error, ShouldEqual, ErrNoTenant)
+		})
+
+		Convey("Tenant ids in crafted rows are overwritten", func() {
+			post := &Post{PostId: "456", TenantID: "a"}
+			So(db.WithContext(b).Create(post).Error, ShouldBeNil)
+			So(post.TenantID, ShouldEqual, "b")
+		})
+	})
+}
+
+func TestQuotas(t *testing.T) {
+	Convey("Tenant quota tests", t, func() {
+		db, err := ConnectSQLite(filepath.Join(t.TempDir(), "test.db"))
+		So(err, ShouldBeNil)
+		ctx := tenantContext("blog")
+
+		Convey("Creates beyond the post quota are rejected", func() {
+			s := NewServer(db, WithQuota(2, 0))
+			for _, id := range []string{"1", "2"} {
+				_, err := s.CreatePost(ctx, &pb.Post{Id: id, AuthorId: "jose", Title: "title"})
+				So(err, ShouldBeNil)
+			}
+
+			_, err := s.CreatePost(ctx, &pb.Post{Id: "3", AuthorId: "jose", Title: "title"})
+			st := status.Convert(err)
+			So(st.Code(), ShouldEqual, codes.ResourceExhausted)
+			So(st.Details(), ShouldHaveLength, 1)
+			So(st.Details()[0].(*errdetails.QuotaFailure).Violations[0].Subject, ShouldEqual, "tenant:blog")
+
+			// Other tenants have their own quota, and deletes free up quota.
+			_, err = s.CreatePost(tenantContext("other"), &pb.Post{Id: "3", AuthorId: "jose", Title: "title"})
+			So(err, ShouldBeNil)
+			_, err = s.DeletePost(ctx, &pb.PostID{Id: "1"})
+			So(err, ShouldBeNil)
+			_, err = s.CreatePost(ctx, &pb.Post{Id: "3", AuthorId: "jose", Title: "title"})
+			So(err, ShouldBeNil)
+		})
+
+		Convey("Updates beyond the storage quota are rejected", func() {
+			s := NewServer(db, WithQuota(0, 10))
+			_, err := s.CreatePost(ctx, &pb.Post{Id: "1", AuthorId: "jose", Title: "12345"})
+			So(err, ShouldBeNil)
+
+			_, err = s.UpdatePost(ctx, &pb.Post{Id: "1", Title: "12345678901"})
+			So(status.Code(err), ShouldEqual, codes.ResourceExhausted)
+			_, err = s.UpdatePost(ctx, &pb.Post{Id: "1", Title: "123"})
+			So(err, ShouldBeNil)
+		})
+
+		Convey("TenantQuota rows override the defaults", func() {
+			s := NewServer(db, WithQuota(1, 0))
+			So(db.Create(&TenantQuota{TenantID: "blog", MaxPosts: 2}).Error, ShouldBeNil)
+			for _, id := range []string{"1", "2"} {
+				_, err := s.CreatePost(ctx, &pb.Post{Id: id, AuthorId: "jose", Title: "title"})
+				So(err, ShouldBeNil)
+			}
+			_, err := s.CreatePost(ctx, &pb.Post{Id: "3", AuthorId: "jose", Title: "title"})
+			So(status.Code(err), ShouldEqual, codes.ResourceExhausted)
+		})
+	})
+}
@@ -0,0 +1,236 @@
+package endpoints
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	pb "go_grpc_example/proto"
+	crudv2 "go_grpc_example/proto/v2"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// ServerV2 serves the crud.v2 api over the posts of a Server, sharing its storage, read cache,
+// quotas, and events, so that v1 and v2 clients see the same posts. Requests are converted to
+// v1 where the v1 rules apply, such as validation, and posts are read back with the fields
+// only v2 has.
+type ServerV2 struct {
+	srv *Server
+	crudv2.UnimplementedCrudServiceServer
+}
+
+// NewServerV2 returns a crud.v2 server over the posts of srv.
+func NewServerV2(srv *Server) *ServerV2 {
+	return &ServerV2{srv: srv}
+}
+
+// PostToV2 converts a v1 post to v2, leaving unset the fields v1 lacks: the timestamps,
+// version, and tags. The statuses of both versions share their numbers.
+func PostToV2(post *pb.Post) *crudv2.Post {
+	return &crudv2.Post{
+		Id:          post.GetId(),
+		AuthorId:    post.GetAuthorId(),
+		Title:       post.GetTitle(),
+		Description: post.GetDescription(),
+		FullText:    post.GetFullText(),
+		Status:      crudv2.PostStatus(post.GetStatus()),
+		PublishAt:   post.GetPublishAt(),
+	}
+}
+
+// PostFromV2 converts a v2 post to v1, dropping the fields v1 lacks.
+func PostFromV2(post *crudv2.Post) *pb.Post {
+	return &pb.Post{
+		Id:          post.GetId(),
+		AuthorId:    post.GetAuthorId(),
+		Title:       post.GetTitle(),
+		Description: post.GetDescription(),
+		FullText:    post.GetFullText(),
+		Status:      pb.PostStatus(post.GetStatus()),
+		PublishAt:   post.GetPublishAt(),
+	}
+}
+
+// newV2Post returns the stored post as a v2 post.
+func newV2Post(post *Post) *crudv2.Post {
+	v1 := NewPbPost(post)
+	v2 := PostToV2(&v1)
+	v2.CreatedAt = timestamppb.New(post.CreatedAt)
+	v2.UpdatedAt = timestamppb.New(post.UpdatedAt)
+	v2.Version = post.Version
+	// Stored posts may be cached, so the v2 post gets its own tags.
+	v2.Tags = append([]string(nil), post.Tags...)
+	return v2
+}
+
+// v2PostOf returns post as a v2 post, unless err is set.
+func v2PostOf(post *Post, err error) (*crudv2.Post, error) {
+	if err != nil {
+		return nil, err
+	}
+	return newV2Post(post), nil
+}
+
+// postV2Violations returns the violations of the post rules by post, reported under "post.",
+// as checked by CreatePost, or UpdatePost if partial.
+func postV2Violations(post *crudv2.Post, partial bool) []*errdetails.BadRequest_FieldViolation {
+	v1 := PostFromV2(post)
+	violations := postViolations(v1, partial, "post.")
+	if !partial {
+		for _, v := range statusViolations(v1, time.Now()) {
+			v.Field = "post." + v.Field
+			violations = append(violations, v)
+		}
+	}
+	return append(violations, tagViolations(post.GetTags(), "post.tags")...)
+}
+
+// clearableFields are the fields UpdatePost may clear via its update_mask, with whether they
+// are unset in a post.
+var clearableFields = map[string]func(*crudv2.Post) bool{
+	"description": func(p *crudv2.Post) bool { return p.GetDescription() == "" },
+	"full_text":   func(p *crudv2.Post) bool { return p.GetFullText() == "" },
+	"tags":        func(p *crudv2.Post) bool { return len(p.GetTags()) == 0 },
+}
+
+func (s *ServerV2) CreatePost(ctx context.Context, req *crudv2.CreatePostRequest) (*crudv2.CreatePostResponse, error) {
+	debugf("v2 CreatePost invoked\n")
+
+	if err := badRequest("invalid post", postV2Violations(req.GetPost(), false)); err != nil {
+		return nil, err
+	}
+
+	dto := NewPost(PostFromV2(req.Post))
+	dto.Tags = req.Post.Tags
+	id, err := s.srv.createPost(ctx, "v2.CreatePost", req, dto)
+	if err != nil {
+		return nil, err
+	}
+
+	// Read the post back from the primary, as replicas may not have it yet.
+	created := &Post{}
+	if err := s.srv.db.WithContext(ctx).Where("post_id = ?", id.Id).First(created).Error; err != nil {
+		return nil, toStatus(err)
+	}
+	return &crudv2.CreatePostResponse{Post: newV2Post(created)}, nil
+}
+
+func (s *ServerV2) GetPost(ctx context.Context, req *crudv2.GetPostRequest) (*crudv2.GetPostResponse, error) {
+	debugf("v2 GetPost invoked\n")
+
+	post, err := v2PostOf(s.srv.readPost(ctx, req.Id))
+	if err != nil {
+		return nil, err
+	}
+	return &crudv2.GetPostResponse{Post: post}, nil
+}
+
+// UpdatePost updates the set fields of the post, and clears those of the update_mask that are
+// unset. Setting the post's version makes the update fail with ABORTED unless the post is at it.
+func (s *ServerV2) UpdatePost(ctx context.Context, req *crudv2.UpdatePostRequest) (*crudv2.UpdatePostResponse, error) {
+	debugf("v2 UpdatePost invoked\n")
+
+	violations := postV2Violations(req.GetPost(), true)
+	cleared := []string{}
+	for i, field := range req.UpdateMask {
+		unset, ok := clearableFields[field]
+		if !ok {
+			violations = append(violations, &errdetails.BadRequest_FieldViolation{
+				Field:       fmt.Sprintf("update_mask[%d]", i),
+				Description: "must be one of: description, full_text, tags",
+			})
+			continue
+		}
+		if unset(req.Post) {
+			cleared = append(cleared, field)
+		}
+	}
+	if err := badRequest("invalid update", violations); err != nil {
+		return nil, err
+	}
+
+	dto := NewPost(PostFromV2(req.Post))
+	dto.Tags = req.Post.Tags
+	dto.Version = req.Post.Version
+	post, err := v2PostOf(s.srv.updatePost(ctx, dto, cleared))
+	if err != nil {
+		return nil, err
+	}
+	return &crudv2.UpdatePostResponse{Post: post}, nil
+}
+
+func (s *ServerV2) DeletePost(ctx context.Context, req *crudv2.DeletePostRequest) (*crudv2.DeletePostResponse, error) {
+	debugf("v2 DeletePost invoked\n")
+
+	if _, err := s.srv.DeletePost(ctx, &pb.PostID{Id: req.Id}); err != nil {
+		return nil, err
+	}
+	return &crudv2.DeletePostResponse{}, nil
+}
+
+func (s *ServerV2) BatchGetPosts(ctx context.Context, req *crudv2.BatchGetPostsRequest) (*crudv2.BatchGetPostsResponse, error) {
+	debugf("v2 BatchGetPosts invoked with %d ids\n", len(req.Ids))
+
+	found, err := s.srv.batchGetPosts(ctx, req.Ids)
+	if err != nil {
+		return nil, err
+	}
+
+	res := &crudv2.BatchGetPostsResponse{Results: make([]*crudv2.BatchGetPostsResult, len(req.Ids))}
+	for i, id := range req.Ids {
+		post := found[id]
+		res.Results[i] = &crudv2.BatchGetPostsResult{Id: id, Found: post != nil}
+		if post != nil {
+			res.Results[i].Post = newV2Post(post)
+		}
+	}
+	return res, nil
+}
+
+func (s *ServerV2) PublishPost(ctx context.Context, req *crudv2.PublishPostRequest) (*crudv2.PublishPostResponse, error) {
+	debugf("v2 PublishPost invoked\n")
+
+	post, err := v2PostOf(s.srv.publishPost(ctx, req.Id, req.PublishAt))
+	if err != nil {
+		return nil, err
+	}
+	return &crudv2.PublishPostResponse{Post: post}, nil
+}
+
+func (s *ServerV2) UnpublishPost(ctx context.Context, req *crudv2.UnpublishPostRequest) (*crudv2.UnpublishPostResponse, error) {
+	debugf("v2 UnpublishPost invoked\n")
+
+	post, err := v2PostOf(s.srv.unpublishPost(ctx, req.Id))
+	if err != nil {
+		return nil, err
+	}
+	return &crudv2.UnpublishPostResponse{Post: post}, nil
+}
+
+func (s *ServerV2) ArchivePost(ctx context.Context, req *crudv2.ArchivePostRequest) (*crudv2.ArchivePostResponse, error) {
+	debugf("v2 ArchivePost invoked\n")
+
+	post, err := v2PostOf(s.srv.archivePost(ctx, req.Id))
+	if err != nil {
+		return nil, err
+	}
+	return &crudv2.ArchivePostResponse{Post: post}, nil
+}
+
+// ListPosts streams the posts v1 ListPosts does, only those with the tag if it is set.
+func (s *ServerV2) ListPosts(req *crudv2.ListPostsRequest, lps crudv2.CrudService_ListPostsServer) error {
+	debugf("v2 ListPosts invoked\n")
+
+	if req.Tag != "" && (len(req.Tag) > TAG_MAX_LEN || !tagPattern.MatchString(req.Tag)) {
+		return badRequest("invalid request", []*errdetails.BadRequest_FieldViolation{{
+			Field:       "tag",
+			Description: "must be a valid tag",
+		}})
+	}
+
+	return s.srv.listPosts(lps.Context(), req.IncludeOwnDrafts, req.Tag, func(post *Post) error {
+		return lps.Send(&crudv2.ListPostsResponse{Post: newV2Post(post)})
+	})
+}
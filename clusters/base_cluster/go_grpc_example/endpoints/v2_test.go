@@ -0,0 +1,189 @@
+package endpoints
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+
+	pb "go_grpc_example/proto"
+	crudv2 "go_grpc_example/proto/v2"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+func TestServerV2(t *testing.T) {
+	Convey("crud.v2 tests", t, func() {
+		db, err := ConnectSQLite(filepath.Join(t.TempDir(), "test.db"))
+		So(err, ShouldBeNil)
+		cache, err := NewLRUPostCache(10)
+		So(err, ShouldBeNil)
+		conn, stop := serveConn(NewServer(db, WithReadCache(cache)))
+		defer stop()
+		v1 := pb.NewCrudServiceClient(conn)
+		v2 := crudv2.NewCrudServiceClient(conn)
+		ctx := context.Background()
+
+		created, err := v2.CreatePost(ctx, &crudv2.CreatePostRequest{Post: &crudv2.Post{
+			Id: "1", AuthorId: "jose", Title: "title", Description: "desc", Tags: []string{"go", "grpc"},
+		}})
+		So(err, ShouldBeNil)
+
+		Convey("Posts created via v2 carry their metadata and are readable via v1", func() {
+			post := created.Post
+			So(post.Version, ShouldEqual, 1)
+			So(post.Tags, ShouldResemble, []string{"go", "grpc"})
+			So(post.Status, ShouldEqual, crudv2.PostStatus_POST_STATUS_PUBLISHED)
+			So(post.CreatedAt.AsTime(), ShouldHappenWithin, time.Minute, time.Now())
+			So(post.UpdatedAt, ShouldNotBeNil)
+
+			read, err := v1.ReadPost(ctx, &pb.PostID{Id: "1"})
+			So(err, ShouldBeNil)
+			So(proto.Equal(read, PostFromV2(post)), ShouldBeTrue)
+
+			_, err = v1.CreatePost(ctx, &pb.Post{Id: "2", AuthorId: "jose", Title: "from v1"})
+			So(err, ShouldBeNil)
+			got, err := v2.GetPost(ctx, &crudv2.GetPostRequest{Id: "2"})
+			So(err, ShouldBeNil)
+			So(got.Post.Title, ShouldEqual, "from v1")
+			So(got.Post.Version, ShouldEqual, 1)
+			So(got.Post.Tags, ShouldBeEmpty)
+
+			res, err := v2.BatchGetPosts(ctx, &crudv2.BatchGetPostsRequest{Ids: []string{"2", "3", "1"}})
+			So(err, ShouldBeNil)
+			So(res.Results[0].Post.Title, ShouldEqual, "from v1")
+			So(res.Results[1].Found, ShouldBeFalse)
+			So(res.Results[2].Post.Tags, ShouldResemble, []string{"go", "grpc"})
+		})
+
+		Convey("Updates bump the version, and stale versions are aborted", func() {
+			updated, err := v2.UpdatePost(ctx, &crudv2.UpdatePostRequest{Post: &crudv2.Post{Id: "1", Title: "new", Version: 1}})
+			So(err, ShouldBeNil)
+			So(updated.Post.Title, ShouldEqual, "new")
+			So(updated.Post.Version, ShouldEqual, 2)
+			So(updated.Post.Tags, ShouldResemble, []string{"go", "grpc"})
+			So(updated.Post.CreatedAt.AsTime(), ShouldEqual, created.Post.CreatedAt.AsTime())
+
+			_, err = v2.UpdatePost(ctx, &crudv2.UpdatePostRequest{Post: &crudv2.Post{Id: "1", Title: "stale", Version: 1}})
+			So(status.Code(err), ShouldEqual, codes.Aborted)
+
+			// v1 updates keep the tags they cannot see, and bump the version too.
+			_, err = v1.UpdatePost(ctx, &pb.Post{Id: "1", Title: "from v1"})
+			So(err, ShouldBeNil)
+			_, err = v1.PublishPost(ctx, &pb.PublishPostRequest{Id: "1"})
+			So(err, ShouldBeNil)
+			archived, err := v2.ArchivePost(ctx, &crudv2.ArchivePostRequest{Id: "1"})
+			So(err, ShouldBeNil)
+			So(archived.Post.Status, ShouldEqual, crudv2.PostStatus_POST_STATUS_ARCHIVED)
+			So(archived.Post.Version, ShouldEqual, 4)
+			So(archived.Post.Tags, ShouldResemble, []string{"go", "grpc"})
+		})
+
+		Convey("Concurrent updates at the same version succeed once", func() {
+			const n = 8
+			errs := make(chan error, n)
+			for i := 0; i < n; i++ {
+				go func(i int) {
+					_, err := v2.UpdatePost(ctx, &crudv2.UpdatePostRequest{Post: &crudv2.Post{
+						Id: "1", Title: fmt.Sprint("title ", i), Version: 1,
+					}})
+					errs <- err
+				}(i)
+			}
+			succeeded := 0
+			for i := 0; i < n; i++ {
+				if err := <-errs; err == nil {
+					succeeded++
+				} else {
+					So(status.Code(err), ShouldEqual, codes.Aborted)
+				}
+			}
+			So(succeeded, ShouldEqual, 1)
+
+			got, err := v2.GetPost(ctx, &crudv2.GetPostRequest{Id: "1"})
+			So(err, ShouldBeNil)
+			So(got.Post.Version, ShouldEqual, 2)
+			So(got.Post.UpdatedAt.AsTime().After(created.Post.UpdatedAt.AsTime()), ShouldBeTrue)
+		})
+
+		Convey("The update mask clears unset fields", func() {
+			updated, err := v2.UpdatePost(ctx, &crudv2.UpdatePostRequest{
+				Post:       &crudv2.Post{Id: "1", Tags: []string{"rust"}},
+				UpdateMask: []string{"description", "tags"},
+			})
+			So(err, ShouldBeNil)
+			So(updated.Post.Tags, ShouldResemble, []string{"rust"})
+			So(updated.Post.Description, ShouldBeEmpty)
+			So(updated.Post.Title, ShouldEqual, "title")
+
+			updated, err = v2.UpdatePost(ctx, &crudv2.UpdatePostRequest{Post: &crudv2.Post{Id: "1"}, UpdateMask: []string{"tags"}})
+			So(err, ShouldBeNil)
+			So(updated.Post.Tags, ShouldBeEmpty)
+
+			_, err = v2.UpdatePost(ctx, &crudv2.UpdatePostRequest{Post: &crudv2.Post{Id: "1"}, UpdateMask: []string{"title"}})
+			So(violations(err), ShouldContainKey, "update_mask[0]")
+		})
+
+		Convey("Posts are validated by the v1 rules and the tag rules", func() {
+			_, err := v2.CreatePost(ctx, &crudv2.CreatePostRequest{Post: &crudv2.Post{
+				Id: "2", AuthorId: "jose", Tags: []string{"Go", "ok", "ok"},
+				Status: crudv2.PostStatus_POST_STATUS_SCHEDULED,
+			}})
+			So(violations(err), ShouldResemble, map[string]string{
+				"post.title":      "is required",
+				"post.publish_at": "is required for scheduled posts",
+				"post.tags[0]":    "must start with a lower-case letter or digit and contain only lower-case letters, digits, or '-'",
+				"post.tags[2]":    "must not repeat a tag",
+			})
+
+			_, err = v2.CreatePost(ctx, &crudv2.CreatePostRequest{})
+			So(violations(err), ShouldContainKey, "post.id")
+		})
+
+		Convey("ListPosts filters by tag", func() {
+			_, err := v2.CreatePost(ctx, &crudv2.CreatePostRequest{Post: &crudv2.Post{
+				Id: "2", AuthorId: "jose", Title: "title", Tags: []string{"go-kit"},
+			}})
+			So(err, ShouldBeNil)
+
+			list := func(tag string) []string {
+				stream, err := v2.ListPosts(ctx, &crudv2.ListPostsRequest{Tag: tag})
+				So(err, ShouldBeNil)
+				ids := []string{}
+				for {
+					res, err := stream.Recv()
+					if err != nil {
+						return ids
+					}
+					ids = append(ids, res.Post.Id)
+				}
+			}
+			So(list(""), ShouldResemble, []string{"1", "2"})
+			So(list("go"), ShouldResemble, []string{"1"})
+			So(list("go-kit"), ShouldResemble, []string{"2"})
+
+			stream, err := v2.ListPosts(ctx, &crudv2.ListPostsRequest{Tag: "%"})
+			So(err, ShouldBeNil)
+			_, err = stream.Recv()
+			So(violations(err), ShouldContainKey, "tag")
+		})
+
+		Convey("Posts convert between versions", func() {
+			post := &pb.Post{
+				Id: "1", AuthorId: "jose", Title: "title", Description: "desc", FullText: "text",
+				Status: pb.PostStatus_POST_STATUS_SCHEDULED, PublishAt: timestamppb.Now(),
+			}
+			So(proto.Equal(PostFromV2(PostToV2(post)), post), ShouldBeTrue)
+
+			for n, name := range pb.PostStatus_name {
+				So(crudv2.PostStatus_name[n], ShouldEqual, name)
+			}
+			So(crudv2.PostStatus_name, ShouldHaveLength, len(pb.PostStatus_name))
+		})
+	})
+}
@@ -0,0 +1,215 @@
+package endpoints
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	pb "go_grpc_example/proto"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// POST_ID_MAX_LEN bounds post and author ids, in characters.
+	POST_ID_MAX_LEN = 128
+	// TITLE_MAX_LEN and DESCRIPTION_MAX_LEN are in characters.
+	TITLE_MAX_LEN       = 256
+	DESCRIPTION_MAX_LEN = 4096
+	// FULL_TEXT_MAX_BYTES bounds the full text in bytes, since it is the bulk of a post's storage.
+	FULL_TEXT_MAX_BYTES = 1 << 20
+	// TAGS_MAX bounds the tags of a post, and TAG_MAX_LEN each tag, in characters.
+	TAGS_MAX    = 16
+	TAG_MAX_LEN = 32
+)
+
+var (
+	// idPattern is the character set of post and author ids: ascii letters and digits, and
+	// '.', '_', ':', or '-' after the first character, so ids are safe in urls and file names.
+	idPattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._:-]*$`)
+	// tagPattern is the character set of tags, which excludes the characters that are special
+	// in json strings and LIKE patterns, as tags are matched in their json column.
+	tagPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9-]*$`)
+)
+
+// fieldRule declares the constraints on one string field of a post.
+type fieldRule struct {
+	// field is the proto field name, as reported in BadRequest violations.
+	field string
+	value func(*pb.Post) string
+	// required fields must be set on create; key fields must be set on update too.
+	required bool
+	key      bool
+	// notBlank rejects values of only whitespace.
+	notBlank bool
+	// maxChars and maxBytes bound the length in characters and bytes; 0 is unbounded.
+	maxChars int
+	maxBytes int
+	// pattern, if set, must match the whole value, as described by patternDesc.
+	pattern     *regexp.Regexp
+	patternDesc string
+	// multiline allows newlines and tabs; other control characters are always rejected.
+	multiline bool
+}
+
+// postRules are the constraints on a pb.Post, checked in order.
+// FUTURE: these could be declared as protovalidate annotations in crud.proto, so that clients in
+// other languages could check posts before sending them.
+var postRules = []fieldRule{
+	{
+		field:       "id",
+		value:       func(p *pb.Post) string { return p.GetId() },
+		required:    true,
+		key:         true,
+		maxChars:    POST_ID_MAX_LEN,
+		pattern:     idPattern,
+		patternDesc: "must start with a letter or digit and contain only letters, digits, '.', '_', ':', or '-'",
+	},
+	{
+		field:       "author_id",
+		value:       func(p *pb.Post) string { return p.GetAuthorId() },
+		required:    true,
+		maxChars:    POST_ID_MAX_LEN,
+		pattern:     idPattern,
+		patternDesc: "must start with a letter or digit and contain only letters, digits, '.', '_', ':', or '-'",
+	},
+	{
+		field:    "title",
+		value:    func(p *pb.Post) string { return p.GetTitle() },
+		required: true,
+		notBlank: true,
+		maxChars: TITLE_MAX_LEN,
+	},
+	{
+		field:     "description",
+		value:     func(p *pb.Post) string { return p.GetDescription() },
+		maxChars:  DESCRIPTION_MAX_LEN,
+		multiline: true,
+	},
+	{
+		field:     "full_text",
+		value:     func(p *pb.Post) string { return p.GetFullText() },
+		maxBytes:  FULL_TEXT_MAX_BYTES,
+		multiline: true,
+	},
+}
+
+// check returns the violations of the rule by value, if any. Unset values are only checked
+// for presence: on updates, they leave the existing value unchanged.
+func (r *fieldRule) check(value string, partial bool) []string {
+	if value == "" {
+		if r.key || (r.required && !partial) {
+			return []string{"is required"}
+		}
+		return nil
+	}
+
+	if !utf8.ValidString(value) {
+		return []string{"must be valid utf-8"}
+	}
+
+	problems := []string{}
+	if r.notBlank && strings.TrimSpace(value) == "" {
+		problems = append(problems, "must not be blank")
+	}
+	if n := utf8.RuneCountInString(value); r.maxChars > 0 && n > r.maxChars {
+		problems = append(problems, fmt.Sprintf("must be at most %d characters, got %d", r.maxChars, n))
+	}
+	if r.maxBytes > 0 && len(value) > r.maxBytes {
+		problems = append(problems, fmt.Sprintf("must be at most %d bytes, got %d", r.maxBytes, len(value)))
+	}
+	if r.pattern != nil && !r.pattern.MatchString(value) {
+		problems = append(problems, r.patternDesc)
+	} else if r.pattern == nil && hasControlChars(value, r.multiline) {
+		problems = append(problems, "must not contain control characters")
+	}
+	return problems
+}
+
+func hasControlChars(s string, multiline bool) bool {
+	return strings.IndexFunc(s, func(r rune) bool {
+		if multiline && (r == '\n' || r == '\r' || r == '\t') {
+			return false
+		}
+		return unicode.IsControl(r)
+	}) >= 0
+}
+
+// postViolations returns every violation of the postRules by post, with field paths
+// prefixed by prefix. Partial posts, as passed to UpdatePost, need only set their id.
+func postViolations(post *pb.Post, partial bool, prefix string) []*errdetails.BadRequest_FieldViolation {
+	violations := []*errdetails.BadRequest_FieldViolation{}
+	for i := range postRules {
+		rule := &postRules[i]
+		for _, problem := range rule.check(rule.value(post), partial) {
+			violations = append(violations, &errdetails.BadRequest_FieldViolation{
+				Field:       prefix + rule.field,
+				Description: problem,
+			})
+		}
+	}
+	return violations
+}
+
+// tagViolations returns every violation by tags of the tag constraints, reported on field.
+func tagViolations(tags []string, field string) []*errdetails.BadRequest_FieldViolation {
+	violations := []*errdetails.BadRequest_FieldViolation{}
+	if len(tags) > TAGS_MAX {
+		violations = append(violations, &errdetails.BadRequest_FieldViolation{
+			Field:       field,
+			Description: fmt.Sprintf("must have at most %d tags, got %d", TAGS_MAX, len(tags)),
+		})
+	}
+
+	seen := map[string]bool{}
+	for i, tag := range tags {
+		problem := ""
+		switch {
+		case len(tag) > TAG_MAX_LEN:
+			problem = fmt.Sprintf("must be at most %d characters, got %d", TAG_MAX_LEN, len(tag))
+		case !tagPattern.MatchString(tag):
+			problem = "must start with a lower-case letter or digit and contain only lower-case letters, digits, or '-'"
+		case seen[tag]:
+			problem = "must not repeat a tag"
+		}
+		seen[tag] = true
+		if problem != "" {
+			violations = append(violations, &errdetails.BadRequest_FieldViolation{
+				Field:       fmt.Sprintf("%s[%d]", field, i),
+				Description: problem,
+			})
+		}
+	}
+	return violations
+}
+
+// validatePost returns InvalidArgument with a BadRequest detail listing every violation
+// of the postRules by post, or nil if it is valid.
+func validatePost(post *pb.Post, partial bool) error {
+	return badRequest("invalid post", postViolations(post, partial, ""))
+}
+
+// badRequest returns InvalidArgument with a BadRequest detail listing the violations, which
+// are also summarized in the message for clients that ignore details; nil if there are none.
+func badRequest(msg string, violations []*errdetails.BadRequest_FieldViolation) error {
+	if len(violations) == 0 {
+		return nil
+	}
+
+	summary := make([]string, len(violations))
+	for i, v := range violations {
+		summary[i] = v.Field + " " + v.Description
+	}
+	msg = msg + ": " + strings.Join(summary, "; ")
+
+	st, err := status.New(codes.InvalidArgument, msg).
+		WithDetails(&errdetails.BadRequest{FieldViolations: violations})
+	if err != nil {
+		return status.Error(codes.InvalidArgument, msg)
+	}
+	return st.Err()
+}
@@ -0,0 +1,114 @@
+package endpoints
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+
+	pb "go_grpc_example/proto"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// violations returns the BadRequest field violations of err by field.
+func violations(err error) map[string]string {
+	st := status.Convert(err)
+	So(st.Code(), ShouldEqual, codes.InvalidArgument)
+	So(st.Details(), ShouldHaveLength, 1)
+
+	fields := map[string]string{}
+	for _, v := range st.Details()[0].(*errdetails.BadRequest).FieldViolations {
+		fields[v.Field] = v.Description
+	}
+	return fields
+}
+
+func TestValidation(t *testing.T) {
+	Convey("Post validation tests", t, func() {
+		valid := func() *pb.Post {
+			return &pb.Post{
+				Id:          "post-1",
+				AuthorId:    "jose",
+				Title:       "Gone With the Wind",
+				Description: "A novel\nin two lines",
+				FullText:    "\tIt was the best of times.",
+			}
+		}
+
+		Convey("Valid posts pass", func() {
+			So(validatePost(valid(), false), ShouldBeNil)
+			So(validatePost(&pb.Post{Id: "post-1"}, true), ShouldBeNil)
+		})
+
+		Convey("Every violation is listed", func() {
+			err := validatePost(&pb.Post{
+				Id:          "not/an id",
+				Title:       "   ",
+				Description: strings.Repeat("é", DESCRIPTION_MAX_LEN+1),
+				FullText:    "bell\a",
+			}, false)
+			So(violations(err), ShouldResemble, map[string]string{
+				"id":          "must start with a letter or digit and contain only letters, digits, '.', '_', ':', or '-'",
+				"author_id":   "is required",
+				"title":       "must not be blank",
+				"description": "must be at most 4096 characters, got 4097",
+				"full_text":   "must not contain control characters",
+			})
+			So(status.Convert(err).Message(), ShouldContainSubstring, "author_id is required")
+		})
+
+		Convey("Lengths are checked in characters or bytes per field", func() {
+			post := valid()
+			post.Title = strings.Repeat("é", TITLE_MAX_LEN)
+			post.FullText = strings.Repeat("x", FULL_TEXT_MAX_BYTES)
+			So(validatePost(post, false), ShouldBeNil)
+
+			post.FullText = strings.Repeat("é", FULL_TEXT_MAX_BYTES/2+1)
+			So(violations(validatePost(post, false)), ShouldContainKey, "full_text")
+
+			post = valid()
+			post.Id = strings.Repeat("a", POST_ID_MAX_LEN+1)
+			So(violations(validatePost(post, false)), ShouldContainKey, "id")
+
+			post = valid()
+			post.Title = "line\nbreak"
+			So(violations(validatePost(post, false)), ShouldContainKey, "title")
+		})
+
+		Convey("Updates only check the fields they set", func() {
+			So(violations(validatePost(&pb.Post{Title: "new title"}, true)), ShouldResemble, map[string]string{"id": "is required"})
+			So(violations(validatePost(&pb.Post{Id: "post-1", Title: " "}, true)), ShouldContainKey, "title")
+		})
+
+		Convey("Rpcs enforce the rules", func() {
+			db, err := ConnectSQLite(filepath.Join(t.TempDir(), "test.db"))
+			So(err, ShouldBeNil)
+			s := NewServer(db)
+			ctx := tenantContext(DEFAULT_TENANT)
+
+			_, err = s.CreatePost(ctx, &pb.Post{Id: "post-1"})
+			So(violations(err), ShouldContainKey, "title")
+
+			_, err = s.CreatePost(ctx, valid())
+			So(err, ShouldBeNil)
+			_, err = s.UpdatePost(ctx, &pb.Post{Id: "post-1", Description: strings.Repeat("d", DESCRIPTION_MAX_LEN+1)})
+			So(violations(err), ShouldContainKey, "description")
+
+			cli, stop := serve(s)
+			defer stop()
+			_, err = importRecords(cli, pb.ConflictMode_CONFLICT_MODE_SKIP, []*pb.PostRecord{
+				{Post: &pb.Post{Id: "post-2", AuthorId: "jose", Title: "title"}},
+				{Post: &pb.Post{Id: "post-3", AuthorId: "jose"}},
+			})
+			So(violations(err), ShouldResemble, map[string]string{"record.post.title": "is required"})
+			So(status.Convert(err).Message(), ShouldStartWith, "invalid record 1")
+
+			post, err := s.ReadPost(ctx, &pb.PostID{Id: "post-1"})
+			So(err, ShouldBeNil)
+			So(post.Description, ShouldEqual, valid().Description)
+		})
+	})
+}
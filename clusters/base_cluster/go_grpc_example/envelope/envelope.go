@@ -0,0 +1,191 @@
+// Package envelope encrypts values with AES-256-GCM under envelope encryption: every value is
+// sealed with a fresh random data key, which is itself sealed ("wrapped") by a master key and
+// stored alongside the value. The master keys never touch the data, so rotating them only
+// requires re-wrapping, and a leaked data key exposes a single value.
+//
+// Sealed values are strings of the form
+//
+//	enc:v1:<master key id>:<base64 wrapped data key>:<base64 sealed value>
+//
+// so that they fit text columns and name the master key needed to open them.
+package envelope
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+const (
+	// PREFIX starts every sealed value.
+	PREFIX = "enc:v1:"
+	// KEY_SIZE is the size of master and data keys, selecting AES-256.
+	KEY_SIZE = 32
+)
+
+var (
+	// ErrUnknownKey is returned when opening a value sealed by a master key not in the keyring.
+	ErrUnknownKey = errors.New("value is sealed by an unknown master key")
+	// ErrMalformed is returned when opening a value that is not a sealed value.
+	ErrMalformed = errors.New("malformed sealed value")
+
+	keyIDPattern = regexp.MustCompile(`^[A-Za-z0-9_-]{1,32}$`)
+	encoding     = base64.RawStdEncoding
+)
+
+// Keyring holds the master keys: the active one seals new values, and the retired ones are
+// kept to open values sealed before a rotation, until they are re-sealed.
+type Keyring struct {
+	active string
+	keys   map[string]cipher.AEAD
+}
+
+// ParseKeyring parses master keys given one per line as <id>:<base64 key>, e.g. as generated
+// by `echo "k2:$(head -c 32 /dev/urandom | base64)"`. The first key is the active one.
+// Blank lines and lines starting with # are ignored.
+func ParseKeyring(text string) (*Keyring, error) {
+	k := &Keyring{keys: map[string]cipher.AEAD{}}
+	for i, line := range strings.Split(text, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		id, b64, ok := strings.Cut(line, ":")
+		if !ok || !keyIDPattern.MatchString(id) {
+			return nil, fmt.Errorf("line %d: want <id>:<base64 key>, with an id of letters, digits, - and _", i+1)
+		}
+		if _, dup := k.keys[id]; dup {
+			return nil, fmt.Errorf("line %d: duplicate key id %q", i+1, id)
+		}
+		key, err := base64.StdEncoding.DecodeString(b64)
+		if err != nil || len(key) != KEY_SIZE {
+			return nil, fmt.Errorf("line %d: key %q must be %d base64-encoded bytes", i+1, id, KEY_SIZE)
+		}
+
+		aead, err := newAEAD(key)
+		if err != nil {
+			return nil, err
+		}
+		k.keys[id] = aead
+		if k.active == "" {
+			k.active = id
+		}
+	}
+	if k.active == "" {
+		return nil, errors.New("keyring has no keys")
+	}
+	return k, nil
+}
+
+// Active returns the id of the master key sealing new values.
+func (k *Keyring) Active() string {
+	return k.active
+}
+
+// Seal encrypts plaintext under a fresh data key wrapped by the active master key. The aad,
+// e.g. the name of the column, must be passed to Open too, so a value only opens where the
+// same aad is passed: values may be moved between places that share an aad.
+func (k *Keyring) Seal(plaintext, aad []byte) (string, error) {
+	dataKey := make([]byte, KEY_SIZE)
+	if _, err := rand.Read(dataKey); err != nil {
+		return "", err
+	}
+	data, err := newAEAD(dataKey)
+	if err != nil {
+		return "", err
+	}
+
+	wrapped, err := seal(k.keys[k.active], dataKey, []byte(k.active))
+	if err != nil {
+		return "", err
+	}
+	sealed, err := seal(data, plaintext, aad)
+	if err != nil {
+		return "", err
+	}
+	return PREFIX + k.active + ":" + encoding.EncodeToString(wrapped) + ":" + encoding.EncodeToString(sealed), nil
+}
+
+// Open decrypts a value returned by Seal.
+func (k *Keyring) Open(value string, aad []byte) ([]byte, error) {
+	id, wrapped, sealed, err := parse(value)
+	if err != nil {
+		return nil, err
+	}
+	master, ok := k.keys[id]
+	if !ok {
+		return nil, fmt.Errorf("%w %q", ErrUnknownKey, id)
+	}
+
+	dataKey, err := open(master, wrapped, []byte(id))
+	if err != nil {
+		return nil, fmt.Errorf("unwrapping data key: %w", err)
+	}
+	data, err := newAEAD(dataKey)
+	if err != nil {
+		return nil, err
+	}
+	return open(data, sealed, aad)
+}
+
+// IsSealed reports whether value looks like a sealed value, rather than plaintext.
+func IsSealed(value string) bool {
+	return strings.HasPrefix(value, PREFIX)
+}
+
+// KeyID returns the id of the master key that sealed value, or "" if it is not sealed.
+func KeyID(value string) string {
+	id, _, _, err := parse(value)
+	if err != nil {
+		return ""
+	}
+	return id
+}
+
+func parse(value string) (id string, wrapped, sealed []byte, err error) {
+	if !IsSealed(value) {
+		return "", nil, nil, ErrMalformed
+	}
+	parts := strings.Split(strings.TrimPrefix(value, PREFIX), ":")
+	if len(parts) != 3 {
+		return "", nil, nil, ErrMalformed
+	}
+	if wrapped, err = encoding.DecodeString(parts[1]); err != nil {
+		return "", nil, nil, ErrMalformed
+	}
+	if sealed, err = encoding.DecodeString(parts[2]); err != nil {
+		return "", nil, nil, ErrMalformed
+	}
+	return parts[0], wrapped, sealed, nil
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// seal returns a random nonce followed by the sealed plaintext.
+func seal(aead cipher.AEAD, plaintext, aad []byte) ([]byte, error) {
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(plaintext)+aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, plaintext, aad), nil
+}
+
+func open(aead cipher.AEAD, sealed, aad []byte) ([]byte, error) {
+	if len(sealed) < aead.NonceSize() {
+		return nil, ErrMalformed
+	}
+	nonce, ciphertext := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+	return aead.Open(nil, nonce, ciphertext, aad)
+}
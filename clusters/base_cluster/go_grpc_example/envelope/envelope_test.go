@@ -0,0 +1,96 @@
+package envelope
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"strings"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func testKey(b byte) string {
+	return base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{b}, KEY_SIZE))
+}
+
+func TestKeyring(t *testing.T) {
+	Convey("Keyring tests", t, func() {
+		k1, err := ParseKeyring("k1:" + testKey(1))
+		So(err, ShouldBeNil)
+		So(k1.Active(), ShouldEqual, "k1")
+
+		Convey("Values are sealed and opened with the same aad", func() {
+			sealed, err := k1.Seal([]byte("hello"), []byte("full_text"))
+			So(err, ShouldBeNil)
+			So(IsSealed(sealed), ShouldBeTrue)
+			So(KeyID(sealed), ShouldEqual, "k1")
+			So(sealed, ShouldNotContainSubstring, "hello")
+
+			plaintext, err := k1.Open(sealed, []byte("full_text"))
+			So(err, ShouldBeNil)
+			So(string(plaintext), ShouldEqual, "hello")
+
+			_, err = k1.Open(sealed, []byte("description"))
+			So(err, ShouldNotBeNil)
+
+			again, err := k1.Seal([]byte("hello"), []byte("full_text"))
+			So(err, ShouldBeNil)
+			So(again, ShouldNotEqual, sealed)
+		})
+
+		Convey("Rotated keyrings seal with the new key and open with the old", func() {
+			old, err := k1.Seal([]byte("hello"), nil)
+			So(err, ShouldBeNil)
+
+			k2, err := ParseKeyring("# rotated\nk2:" + testKey(2) + "\n\nk1:" + testKey(1) + "\n")
+			So(err, ShouldBeNil)
+			So(k2.Active(), ShouldEqual, "k2")
+
+			plaintext, err := k2.Open(old, nil)
+			So(err, ShouldBeNil)
+			So(string(plaintext), ShouldEqual, "hello")
+
+			sealed, err := k2.Seal(plaintext, nil)
+			So(err, ShouldBeNil)
+			So(KeyID(sealed), ShouldEqual, "k2")
+			_, err = k1.Open(sealed, nil)
+			So(errors.Is(err, ErrUnknownKey), ShouldBeTrue)
+		})
+
+		Convey("Tampered and malformed values are rejected", func() {
+			sealed, err := k1.Seal([]byte("hello"), nil)
+			So(err, ShouldBeNil)
+
+			i := strings.LastIndex(sealed, ":") + 1
+			flipped := []byte(sealed)
+			if flipped[i] == 'A' {
+				flipped[i] = 'B'
+			} else {
+				flipped[i] = 'A'
+			}
+			_, err = k1.Open(string(flipped), nil)
+			So(err, ShouldNotBeNil)
+
+			for _, v := range []string{"hello", PREFIX + "k1:abc", PREFIX + "k1:!!:!!"} {
+				_, err := k1.Open(v, nil)
+				So(err, ShouldEqual, ErrMalformed)
+			}
+			So(KeyID("hello"), ShouldEqual, "")
+		})
+
+		Convey("Keyrings must hold well-formed, distinct keys", func() {
+			for _, text := range []string{
+				"",
+				"# none",
+				"k1",
+				"k 1:" + testKey(1),
+				"k1:" + base64.StdEncoding.EncodeToString([]byte("short")),
+				"k1:" + testKey(1) + "\nk1:" + testKey(2),
+			} {
+				_, err := ParseKeyring(text)
+				So(err, ShouldNotBeNil)
+			}
+		})
+	})
+}
@@ -0,0 +1,235 @@
+// Package grpcweb serves gRPC-Web over HTTP/1.1, so that browsers can call a grpc.Server
+// without a proxy such as Envoy. Requests are translated for the ServeHTTP of the server,
+// and its trailers, which HTTP/1.1 browsers cannot read, are sent as the last frame of the
+// response body. Both the binary and the base64 'text' encodings are served, and streaming
+// responses are flushed per message. Client and bidi streaming are not part of gRPC-Web.
+package grpcweb
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+const (
+	CONTENT_TYPE      = "application/grpc-web"
+	CONTENT_TYPE_TEXT = "application/grpc-web-text"
+	// TRAILER_FLAG marks the frame holding the trailers, in the first byte of its header.
+	TRAILER_FLAG = 0x80
+	// trailerPrefix is the prefix grpc gives the keys of trailers set after the headers were
+	// sent, as in http2.TrailerPrefix.
+	trailerPrefix = "Trailer:"
+	// PREFLIGHT_MAX_AGE is how long browsers may cache a preflight, in seconds.
+	PREFLIGHT_MAX_AGE = "600"
+)
+
+// grpcTrailers are the trailers grpc sets by name after the headers were sent.
+var grpcTrailers = []string{"Grpc-Status", "Grpc-Message", "Grpc-Status-Details-Bin"}
+
+// Handler serves the gRPC-Web requests of browsers from the allowed origins.
+type Handler struct {
+	grpc      http.Handler
+	origins   map[string]bool
+	anyOrigin bool
+}
+
+// New returns a Handler of gRPC-Web requests for grpc, typically a *grpc.Server. Cross-origin
+// requests are allowed from the origins, e.g. "https://admin.example.com", or from any
+// origin if they include "*"; without origins, only same-origin requests are.
+func New(grpc http.Handler, origins []string) *Handler {
+	h := &Handler{grpc: grpc, origins: map[string]bool{}}
+	for _, o := range origins {
+		h.origins[o] = true
+		h.anyOrigin = h.anyOrigin || o == "*"
+	}
+	return h
+}
+
+// IsGrpcWebRequest reports whether r is a gRPC-Web request, by its content type.
+func IsGrpcWebRequest(r *http.Request) bool {
+	_, _, ok := contentSubtype(r.Header.Get("Content-Type"))
+	return ok
+}
+
+// contentSubtype returns the subtype of a gRPC-Web content type, e.g. "+proto" or "", and
+// whether it is the text encoding.
+func contentSubtype(contentType string) (subtype string, text bool, ok bool) {
+	for _, prefix := range []string{CONTENT_TYPE_TEXT, CONTENT_TYPE} {
+		if rest := strings.TrimPrefix(contentType, prefix); rest != contentType &&
+			(rest == "" || strings.HasPrefix(rest, "+") || strings.HasPrefix(rest, ";")) {
+			// Parameters such as a charset are not meaningful for grpc.
+			subtype, _, _ = strings.Cut(rest, ";")
+			return strings.TrimSpace(subtype), prefix == CONTENT_TYPE_TEXT, true
+		}
+	}
+	return "", false, false
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	allowed := h.allowCORS(w, r)
+	if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+		if !allowed {
+			http.Error(w, "grpcweb: origin not allowed", http.StatusForbidden)
+			return
+		}
+		w.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS")
+		// Any header may carry metadata, e.g. authorization or x-tenant-id.
+		if headers := r.Header.Get("Access-Control-Request-Headers"); headers != "" {
+			w.Header().Set("Access-Control-Allow-Headers", headers)
+		}
+		w.Header().Set("Access-Control-Max-Age", PREFLIGHT_MAX_AGE)
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	subtype, text, ok := contentSubtype(r.Header.Get("Content-Type"))
+	if !ok {
+		http.Error(w, "grpcweb: not a gRPC-Web request", http.StatusUnsupportedMediaType)
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "grpcweb: method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// grpc only serves HTTP/2 requests, though it serves them the same as this one.
+	req := r.Clone(r.Context())
+	req.Proto, req.ProtoMajor, req.ProtoMinor = "HTTP/2", 2, 0
+	req.Header.Set("Content-Type", "application/grpc"+subtype)
+	req.Header.Del("Content-Length")
+	contentType := CONTENT_TYPE + subtype
+	if text {
+		req.Body = textBody{Reader: base64.NewDecoder(base64.StdEncoding, r.Body), Closer: r.Body}
+		contentType = CONTENT_TYPE_TEXT + subtype
+	}
+	if subtype == "" {
+		contentType += "+proto"
+	}
+
+	rw := &responseWriter{w: w, header: http.Header{}, contentType: contentType, text: text, cors: allowed}
+	h.grpc.ServeHTTP(rw, req)
+	rw.finish()
+}
+
+// allowCORS sets the CORS headers of the response to a request from an allowed origin,
+// returning whether it is one. Same-origin requests, which have no Origin header, are allowed.
+func (h *Handler) allowCORS(w http.ResponseWriter, r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return false
+	}
+	w.Header().Add("Vary", "Origin")
+	if !h.anyOrigin && !h.origins[origin] {
+		return false
+	}
+	w.Header().Set("Access-Control-Allow-Origin", origin)
+	return true
+}
+
+// textBody decodes a base64 request body, closing the original.
+type textBody struct {
+	io.Reader
+	io.Closer
+}
+
+// responseWriter translates the response of grpc to gRPC-Web: headers are sent with the
+// gRPC-Web content type, messages are passed through, and trailers are sent by finish.
+type responseWriter struct {
+	w           http.ResponseWriter
+	header      http.Header
+	contentType string
+	text        bool
+	// cors exposes the headers of the response to cross-origin callers.
+	cors bool
+	// code is the status sent, or 0 if the headers were not sent yet.
+	code int
+}
+
+func (rw *responseWriter) Header() http.Header {
+	return rw.header
+}
+
+func (rw *responseWriter) WriteHeader(code int) {
+	if rw.code != 0 {
+		return
+	}
+	rw.code = code
+
+	h := rw.w.Header()
+	exposed := []string{"grpc-status", "grpc-message"}
+	for k, v := range rw.header {
+		// Declared trailers are sent in the body instead.
+		if k == "Trailer" || strings.HasPrefix(k, trailerPrefix) {
+			continue
+		}
+		h[k] = v
+		if k != "Content-Type" {
+			exposed = append(exposed, strings.ToLower(k))
+		}
+	}
+	if code == http.StatusOK {
+		h.Set("Content-Type", rw.contentType)
+	}
+	if rw.cors {
+		sort.Strings(exposed[2:])
+		h.Set("Access-Control-Expose-Headers", strings.Join(exposed, ", "))
+	}
+	rw.w.WriteHeader(code)
+}
+
+func (rw *responseWriter) Write(p []byte) (int, error) {
+	rw.WriteHeader(http.StatusOK)
+	if rw.code != http.StatusOK || !rw.text {
+		return rw.w.Write(p)
+	}
+	// Each write is encoded on its own, with padding, as gRPC-Web clients decode by quads.
+	if _, err := io.WriteString(rw.w, base64.StdEncoding.EncodeToString(p)); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (rw *responseWriter) Flush() {
+	rw.WriteHeader(http.StatusOK)
+	if f, ok := rw.w.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// finish sends the trailers grpc set after the headers as the trailer frame.
+func (rw *responseWriter) finish() {
+	rw.WriteHeader(http.StatusOK)
+	if rw.code != http.StatusOK {
+		return
+	}
+
+	trailers := &strings.Builder{}
+	for _, k := range grpcTrailers {
+		if v := rw.header.Get(k); v != "" {
+			fmt.Fprintf(trailers, "%s: %s\r\n", strings.ToLower(k), v)
+		}
+	}
+	keys := []string{}
+	for k := range rw.header {
+		if strings.HasPrefix(k, trailerPrefix) {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		for _, v := range rw.header[k] {
+			fmt.Fprintf(trailers, "%s: %s\r\n", strings.ToLower(strings.TrimPrefix(k, trailerPrefix)), v)
+		}
+	}
+
+	frame := make([]byte, 5, 5+trailers.Len())
+	frame[0] = TRAILER_FLAG
+	binary.BigEndian.PutUint32(frame[1:], uint32(trailers.Len()))
+	frame = append(frame, trailers.String()...)
+	rw.Write(frame)
+	rw.Flush()
+}
@@ -0,0 +1,198 @@
+package grpcweb
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/protobuf/proto"
+)
+
+// frame is a message or the trailers of a gRPC-Web response.
+type frame struct {
+	trailer bool
+	payload []byte
+}
+
+// readFrame reads the next frame of a binary response body.
+func readFrame(r io.Reader) (frame, error) {
+	header := make([]byte, 5)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return frame{}, err
+	}
+	payload := make([]byte, binary.BigEndian.Uint32(header[1:]))
+	_, err := io.ReadFull(r, payload)
+	return frame{trailer: header[0]&TRAILER_FLAG != 0, payload: payload}, err
+}
+
+// trailers parses the payload of a trailer frame.
+func trailers(f frame) map[string]string {
+	So(f.trailer, ShouldBeTrue)
+	m := map[string]string{}
+	for _, line := range strings.Split(strings.TrimSuffix(string(f.payload), "\r\n"), "\r\n") {
+		k, v, _ := strings.Cut(line, ": ")
+		m[k] = v
+	}
+	return m
+}
+
+// encode returns msg as the body of a gRPC-Web request.
+func encode(msg proto.Message) []byte {
+	b, err := proto.Marshal(msg)
+	So(err, ShouldBeNil)
+	body := make([]byte, 5, 5+len(b))
+	binary.BigEndian.PutUint32(body[1:], uint32(len(b)))
+	return append(body, b...)
+}
+
+func TestHandler(t *testing.T) {
+	Convey("gRPC-Web tests", t, func() {
+		gs := grpc.NewServer()
+		hs := health.NewServer()
+		healthpb.RegisterHealthServer(gs, hs)
+		web := httptest.NewServer(New(gs, []string{"https://admin.example.com"}))
+		defer web.Close()
+
+		post := func(ctx context.Context, method, contentType string, body []byte, headers ...string) *http.Response {
+			req, err := http.NewRequestWithContext(ctx, http.MethodPost, web.URL+"/grpc.health.v1.Health/"+method, bytes.NewReader(body))
+			So(err, ShouldBeNil)
+			req.Header.Set("Content-Type", contentType)
+			req.Header.Set("X-Grpc-Web", "1")
+			for i := 0; i+1 < len(headers); i += 2 {
+				req.Header.Set(headers[i], headers[i+1])
+			}
+			res, err := http.DefaultClient.Do(req)
+			So(err, ShouldBeNil)
+			return res
+		}
+
+		Convey("Unary rpcs are served over HTTP/1.1 with their trailers in the body", func() {
+			res := post(context.Background(), "Check", CONTENT_TYPE+"+proto", encode(&healthpb.HealthCheckRequest{}))
+			defer res.Body.Close()
+			So(res.ProtoMajor, ShouldEqual, 1)
+			So(res.StatusCode, ShouldEqual, http.StatusOK)
+			So(res.Header.Get("Content-Type"), ShouldEqual, "application/grpc-web+proto")
+			So(res.Header.Get("Trailer"), ShouldBeEmpty)
+
+			msg, err := readFrame(res.Body)
+			So(err, ShouldBeNil)
+			So(msg.trailer, ShouldBeFalse)
+			check := &healthpb.HealthCheckResponse{}
+			So(proto.Unmarshal(msg.payload, check), ShouldBeNil)
+			So(check.Status, ShouldEqual, healthpb.HealthCheckResponse_SERVING)
+
+			last, err := readFrame(res.Body)
+			So(err, ShouldBeNil)
+			So(trailers(last), ShouldResemble, map[string]string{"grpc-status": "0"})
+			_, err = readFrame(res.Body)
+			So(err, ShouldEqual, io.EOF)
+		})
+
+		Convey("Errors are reported by the trailers", func() {
+			res := post(context.Background(), "Check", CONTENT_TYPE, encode(&healthpb.HealthCheckRequest{Service: "missing"}))
+			defer res.Body.Close()
+			So(res.StatusCode, ShouldEqual, http.StatusOK)
+
+			last, err := readFrame(res.Body)
+			So(err, ShouldBeNil)
+			So(trailers(last)["grpc-status"], ShouldEqual, "5")
+			So(trailers(last)["grpc-message"], ShouldEqual, "unknown service")
+		})
+
+		Convey("The text encoding is base64 in both directions", func() {
+			body := base64.StdEncoding.EncodeToString(encode(&healthpb.HealthCheckRequest{}))
+			res := post(context.Background(), "Check", CONTENT_TYPE_TEXT, []byte(body))
+			defer res.Body.Close()
+			So(res.Header.Get("Content-Type"), ShouldEqual, "application/grpc-web-text+proto")
+
+			// Clients decode by quads, as the frames are encoded apart.
+			text, err := io.ReadAll(res.Body)
+			So(err, ShouldBeNil)
+			decoded := []byte{}
+			for i := 0; i+4 <= len(text); i += 4 {
+				b, err := base64.StdEncoding.DecodeString(string(text[i : i+4]))
+				So(err, ShouldBeNil)
+				decoded = append(decoded, b...)
+			}
+			r := bytes.NewReader(decoded)
+			msg, err := readFrame(r)
+			So(err, ShouldBeNil)
+			So(msg.trailer, ShouldBeFalse)
+			last, err := readFrame(r)
+			So(err, ShouldBeNil)
+			So(trailers(last)["grpc-status"], ShouldEqual, "0")
+		})
+
+		Convey("Server-streaming messages are flushed as they are sent", func() {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			res := post(ctx, "Watch", CONTENT_TYPE, encode(&healthpb.HealthCheckRequest{}))
+			defer res.Body.Close()
+			body := bufio.NewReader(res.Body)
+
+			watch := func() healthpb.HealthCheckResponse_ServingStatus {
+				msg, err := readFrame(body)
+				So(err, ShouldBeNil)
+				So(msg.trailer, ShouldBeFalse)
+				update := &healthpb.HealthCheckResponse{}
+				So(proto.Unmarshal(msg.payload, update), ShouldBeNil)
+				return update.Status
+			}
+			So(watch(), ShouldEqual, healthpb.HealthCheckResponse_SERVING)
+			hs.SetServingStatus("", healthpb.HealthCheckResponse_NOT_SERVING)
+			So(watch(), ShouldEqual, healthpb.HealthCheckResponse_NOT_SERVING)
+		})
+
+		Convey("Allowed origins pass preflights and may read the response headers", func() {
+			preflight := func(origin string) *http.Response {
+				req, err := http.NewRequest(http.MethodOptions, web.URL+"/grpc.health.v1.Health/Check", nil)
+				So(err, ShouldBeNil)
+				req.Header.Set("Origin", origin)
+				req.Header.Set("Access-Control-Request-Method", "POST")
+				req.Header.Set("Access-Control-Request-Headers", "content-type,x-grpc-web,authorization")
+				res, err := http.DefaultClient.Do(req)
+				So(err, ShouldBeNil)
+				res.Body.Close()
+				return res
+			}
+			res := preflight("https://admin.example.com")
+			So(res.StatusCode, ShouldEqual, http.StatusNoContent)
+			So(res.Header.Get("Access-Control-Allow-Origin"), ShouldEqual, "https://admin.example.com")
+			So(res.Header.Get("Access-Control-Allow-Headers"), ShouldEqual, "content-type,x-grpc-web,authorization")
+			So(res.Header.Get("Access-Control-Allow-Methods"), ShouldContainSubstring, "POST")
+
+			So(preflight("https://evil.example.com").StatusCode, ShouldEqual, http.StatusForbidden)
+
+			res = post(context.Background(), "Check", CONTENT_TYPE, encode(&healthpb.HealthCheckRequest{}), "Origin", "https://admin.example.com")
+			res.Body.Close()
+			So(res.Header.Get("Access-Control-Allow-Origin"), ShouldEqual, "https://admin.example.com")
+			So(res.Header.Get("Access-Control-Expose-Headers"), ShouldStartWith, "grpc-status, grpc-message")
+
+			res = post(context.Background(), "Check", CONTENT_TYPE, encode(&healthpb.HealthCheckRequest{}), "Origin", "https://evil.example.com")
+			res.Body.Close()
+			So(res.Header.Get("Access-Control-Allow-Origin"), ShouldBeEmpty)
+		})
+
+		Convey("Other requests are rejected", func() {
+			res := post(context.Background(), "Check", "application/grpc", encode(&healthpb.HealthCheckRequest{}))
+			res.Body.Close()
+			So(res.StatusCode, ShouldEqual, http.StatusUnsupportedMediaType)
+
+			res, err := http.Get(web.URL + "/grpc.health.v1.Health/Check")
+			So(err, ShouldBeNil)
+			res.Body.Close()
+			So(res.StatusCode, ShouldEqual, http.StatusUnsupportedMediaType)
+		})
+	})
+}
@@ -20,6 +20,8 @@
 // akin to the ease of the httptest library.
 // One obvious observation is that the db should be injected into the gRPC service so the service
 // can be tested without the db, reducing potential test to mere milliseconds instead of seconds/minutes.
+// Done: the crudtest package serves the service in-process over bufconn with a sqlite db, for
+// tests that need not run against postgres.
 
 package integration_test
 
@@ -129,7 +131,7 @@ func TestMain(m *testing.M) {
 		},
 	}
 
-	db, err := ep.Connect(&cfg.DbCreds)
+	db, err := ep.Connect(&cfg.DbCreds, nil)
 	if err != nil {
 		log.Fatalf("db connection failed: %v\n", err)
 	}
@@ -137,7 +139,7 @@ func TestMain(m *testing.M) {
 	// Delete the existing db/tables, if any
 	ep.DeleteDb(db, cfg.DbCreds.DbName, ep.PostsTable)
 
-	if err = ep.EnsureDB(db, cfg.DbCreds.DbName, &ep.Post{}); err != nil {
+	if err = ep.EnsureDB(db, cfg.DbCreds.DbName, ep.Models...); err != nil {
 		log.Fatalf("%s db creation failed: %v\n", cfg.DbCreds.DbName, err)
 	} else {
 		log.Printf("%s db exists\n", cfg.DbCreds.DbName)
@@ -150,7 +152,7 @@ func TestMain(m *testing.M) {
 
 	log.Printf("Listening at %s\n", cfg.Addr)
 
-	opts := []grpc.ServerOption{}
+	opts := ep.NewTenantResolver(ep.TenantConfig{Default: ep.DEFAULT_TENANT}).ServerOptions()
 	gs := grpc.NewServer(opts...)
 	ep := ep.NewServer(db)
 	pb.RegisterCrudServiceServer(gs, ep)
@@ -235,20 +237,19 @@ func TestReadPost(t *testing.T) {
 		FullText:    "In the beginning...",
 	}
 
+	// Created once up front: convey reruns enclosing blocks for each leaf, and post-ids are unique.
+	res, createErr := client.CreatePost(context.Background(), post)
+	log.Printf("CreatePost response: %v\n", res)
+
 	Convey("ReadPost tests", t, func() {
 		Convey("When a non-existent post-id is requested", func() {
 			resultPost, err := client.ReadPost(context.Background(), &pb.PostID{Id: "junk"})
 			So(resultPost, ShouldBeNil)
-			// TODO: comparing error strings is poor practice and indicates that the api should be refactored.
-			// Leaving as-is because this app is purely a demo. In practice, the api should specify its
-			// errors as first-class definitions, e.g. ErrPostNotFound, per golang error best-practices.
-			So(err.Error(), ShouldEqual, "rpc error: code = Unknown desc = record not found")
+			So(status.Code(err), ShouldEqual, codes.NotFound)
 		})
 
 		Convey("When an existing post is requested", func() {
-			res, err := client.CreatePost(context.Background(), post)
-			log.Printf("CreatePost response: %v\n", res)
-			So(err, ShouldBeNil)
+			So(createErr, ShouldBeNil)
 			So(res.Id, ShouldEqual, post.Id)
 
 			postId := &pb.PostID{
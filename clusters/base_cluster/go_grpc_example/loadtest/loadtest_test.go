@@ -0,0 +1,146 @@
+package main
+
+import (
+	"context"
+	"math"
+	"testing"
+	"time"
+
+	pb "go_grpc_example/proto"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+func TestParseMix(t *testing.T) {
+	Convey("parseMix tests", t, func() {
+		Convey("When given a valid mix, omitted and zero-weight rpcs are dropped", func() {
+			m, err := parseMix("read=3, CREATE=1,list=0")
+			So(err, ShouldBeNil)
+			So(m.String(), ShouldEqual, "create=1,read=3")
+		})
+
+		Convey("When given an unknown rpc", func() {
+			_, err := parseMix("create=1,search=2")
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("When given a bad weight", func() {
+			_, err := parseMix("create=-1")
+			So(err, ShouldNotBeNil)
+			_, err = parseMix("create")
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("When no rpc has a positive weight", func() {
+			_, err := parseMix("create=0")
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
+
+func TestStats(t *testing.T) {
+	Convey("Stats tests", t, func() {
+		latencies := []time.Duration{}
+		for i := 1; i <= 100; i++ {
+			latencies = append(latencies, time.Duration(i)*time.Millisecond)
+		}
+
+		Convey("Percentiles use the nearest rank", func() {
+			So(percentile(latencies, 50), ShouldEqual, 50*time.Millisecond)
+			So(percentile(latencies, 95), ShouldEqual, 95*time.Millisecond)
+			So(percentile(latencies, 99), ShouldEqual, 99*time.Millisecond)
+			So(percentile(latencies, 100), ShouldEqual, 100*time.Millisecond)
+			So(percentile(nil, 50), ShouldEqual, 0)
+		})
+
+		Convey("The histogram accounts for every latency", func() {
+			buckets := histogram(append(latencies, 10*time.Second))
+			total := 0
+			for _, b := range buckets {
+				total += b.Count
+			}
+			So(total, ShouldEqual, 101)
+			So(math.IsInf(buckets[len(buckets)-1].LeMs, 1), ShouldBeTrue)
+		})
+
+		Convey("Recorders merge and report codes per rpc", func() {
+			a, b := newRecorder(), newRecorder()
+			a.record(OP_READ, time.Millisecond, nil)
+			b.record(OP_READ, 2*time.Millisecond, context.DeadlineExceeded)
+			b.record(OP_CREATE, time.Millisecond, nil)
+			a.merge(b)
+
+			report := a.report(time.Second)
+			So(report.Total.Count, ShouldEqual, 3)
+			So(report.RPCs, ShouldHaveLength, 2)
+			So(report.RPCs[1].Name, ShouldEqual, OP_READ)
+			So(report.RPCs[1].Errors, ShouldEqual, 1)
+			So(report.RPCs[1].Codes["OK"], ShouldEqual, 1)
+		})
+	})
+}
+
+func TestRunInProcess(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping load run in short mode")
+	}
+
+	Convey("Given an in-process service", t, func() {
+		addr, cleanup, err := startInProcess(&config{db: DB_SQLITE})
+		So(err, ShouldBeNil)
+		defer cleanup()
+
+		conn, err := grpc.Dial(addr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+		So(err, ShouldBeNil)
+		defer conn.Close()
+
+		Convey("A short run completes without errors", func() {
+			m, err := parseMix(MIX_DEFAULT)
+			So(err, ShouldBeNil)
+
+			r := newRunner(pb.NewCrudServiceClient(conn), runnerConfig{
+				duration:    500 * time.Millisecond,
+				rps:         100,
+				concurrency: 4,
+				mix:         m,
+				timeout:     5 * time.Second,
+				preload:     10,
+				seed:        1,
+			})
+			report, err := r.Run(context.Background())
+			So(err, ShouldBeNil)
+			So(report.Total.Count, ShouldBeGreaterThan, 0)
+			So(report.Total.Errors, ShouldEqual, 0)
+		})
+
+		Convey("Reads without posts to read are recorded as the creates they ran", func() {
+			m, err := parseMix("read=1")
+			So(err, ShouldBeNil)
+
+			r := newRunner(pb.NewCrudServiceClient(conn), runnerConfig{
+				duration:    200 * time.Millisecond,
+				rps:         50,
+				concurrency: 1,
+				mix:         m,
+				timeout:     5 * time.Second,
+				seed:        1,
+			})
+			report, err := r.Run(context.Background())
+			So(err, ShouldBeNil)
+			counts := map[string]int{}
+			for _, rpc := range report.RPCs {
+				counts[rpc.Name] = rpc.Count
+			}
+			So(counts[OP_CREATE], ShouldEqual, 1)
+			So(counts[OP_READ], ShouldEqual, report.Total.Count-1)
+		})
+	})
+}
+
+func TestFlags(t *testing.T) {
+	Convey("Non-positive timeouts are rejected", t, func() {
+		So(run([]string{"-timeout", "0"}), ShouldBeError, "timeout must be positive")
+	})
+}
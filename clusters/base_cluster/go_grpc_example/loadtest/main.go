@@ -0,0 +1,284 @@
+// The loadtest drives a configurable mix of CrudService rpcs for a fixed duration and
+// reports throughput, latency percentiles, and error codes per rpc.
+//
+// By default the service is run in-process on a loopback listener and backed by a
+// temporary sqlite db, which makes for reproducible local benchmarks. Pass -db postgres to
+// back the in-process service with the postgres db configured by the usual DB_* env vars,
+// or pass -addr to load a real service instead.
+//
+// Examples:
+//
+//	loadtest -duration 30s -concurrency 16
+//	loadtest -rps 500 -mix create=10,read=70,update=10,delete=5,list=5
+//	loadtest -addr 127.0.0.1:80 -duration 1m -json report.json
+//	loadtest -db postgres -cpuprofile cpu.prof -memprofile mem.prof
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"runtime"
+	"runtime/pprof"
+	"syscall"
+	"time"
+
+	ep "go_grpc_example/endpoints"
+	pb "go_grpc_example/proto"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"gorm.io/gorm"
+)
+
+const (
+	DB_SQLITE   = "sqlite"
+	DB_POSTGRES = "postgres"
+
+	MIX_DEFAULT = "create=20,read=50,update=15,delete=5,list=10"
+)
+
+type config struct {
+	addr        string
+	db          string
+	duration    time.Duration
+	rps         float64
+	concurrency int
+	mix         string
+	timeout     time.Duration
+	preload     int
+	seed        int64
+	jsonOut     string
+	cpuProfile  string
+	memProfile  string
+	verbose     bool
+}
+
+func main() {
+	if err := run(os.Args[1:]); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func run(args []string) error {
+	cfg := config{}
+	fs := flag.NewFlagSet("loadtest", flag.ContinueOnError)
+	fs.StringVar(&cfg.addr, "addr", "", "address of a running service; if empty, an in-process service is started")
+	fs.StringVar(&cfg.db, "db", DB_SQLITE, "db backing the in-process service: sqlite or postgres")
+	fs.DurationVar(&cfg.duration, "duration", 10*time.Second, "how long to generate load")
+	fs.Float64Var(&cfg.rps, "rps", 0, "target requests per second across all workers; 0 runs each worker as fast as possible")
+	fs.IntVar(&cfg.concurrency, "concurrency", runtime.NumCPU(), "number of concurrent workers")
+	fs.StringVar(&cfg.mix, "mix", MIX_DEFAULT, "relative weights of each rpc: create, read, update, delete, list")
+	fs.DurationVar(&cfg.timeout, "timeout", 5*time.Second, "deadline for each rpc")
+	fs.IntVar(&cfg.preload, "preload", 100, "number of posts to create before the run starts")
+	fs.Int64Var(&cfg.seed, "seed", 1, "seed for the rpc mix, for reproducible runs")
+	fs.StringVar(&cfg.jsonOut, "json", "", "also write the report as json to this `file`; use '-' for stdout")
+	fs.StringVar(&cfg.cpuProfile, "cpuprofile", "", "write a cpu profile to this `file`")
+	fs.StringVar(&cfg.memProfile, "memprofile", "", "write a heap profile to this `file` after the run")
+	fs.BoolVar(&cfg.verbose, "v", false, "keep the in-process service's logging")
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return nil
+		}
+		return err
+	}
+
+	mix, err := parseMix(cfg.mix)
+	if err != nil {
+		return err
+	}
+	if cfg.concurrency <= 0 {
+		return errors.New("concurrency must be positive")
+	}
+	if cfg.duration <= 0 {
+		return errors.New("duration must be positive")
+	}
+	if cfg.timeout <= 0 {
+		return errors.New("timeout must be positive")
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	addr := cfg.addr
+	if addr == "" {
+		inProc, cleanup, err := startInProcess(&cfg)
+		if err != nil {
+			return fmt.Errorf("unable to start in-process service: %w", err)
+		}
+		defer cleanup()
+		addr = inProc
+	}
+
+	conn, err := grpc.Dial(addr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		return fmt.Errorf("did not connect: %w", err)
+	}
+	defer conn.Close()
+
+	if cfg.cpuProfile != "" {
+		f, err := os.Create(cfg.cpuProfile)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		if err := pprof.StartCPUProfile(f); err != nil {
+			return err
+		}
+		defer pprof.StopCPUProfile()
+	}
+
+	r := newRunner(pb.NewCrudServiceClient(conn), runnerConfig{
+		duration:    cfg.duration,
+		rps:         cfg.rps,
+		concurrency: cfg.concurrency,
+		mix:         mix,
+		timeout:     cfg.timeout,
+		preload:     cfg.preload,
+		seed:        cfg.seed,
+	})
+
+	fmt.Fprintf(os.Stderr, "Loading %s for %s with %d workers (rps: %s, mix: %s)\n",
+		target(&cfg, addr), cfg.duration, cfg.concurrency, rpsString(cfg.rps), mix)
+
+	report, err := r.Run(ctx)
+	if err != nil {
+		return err
+	}
+
+	if cfg.memProfile != "" {
+		if err := writeHeapProfile(cfg.memProfile); err != nil {
+			return err
+		}
+	}
+
+	// Keep stdout clean for the json report when it is written there.
+	textOut := os.Stdout
+	if cfg.jsonOut == "-" {
+		textOut = os.Stderr
+	}
+
+	report.Target = target(&cfg, addr)
+	if err := report.WriteText(textOut); err != nil {
+		return err
+	}
+	if cfg.jsonOut != "" {
+		return writeJSONReport(report, cfg.jsonOut)
+	}
+	return nil
+}
+
+// startInProcess starts the CrudService on a loopback listener and returns its address
+// along with a func to stop the service and remove any temporary db files.
+func startInProcess(cfg *config) (string, func(), error) {
+	if !cfg.verbose {
+		log.SetOutput(io.Discard)
+	}
+
+	db, cleanupDB, err := openDB(cfg.db)
+	if err != nil {
+		return "", nil, err
+	}
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		cleanupDB()
+		return "", nil, err
+	}
+
+	gs := grpc.NewServer(ep.NewTenantResolver(ep.TenantConfig{Default: ep.DEFAULT_TENANT}).ServerOptions()...)
+	pb.RegisterCrudServiceServer(gs, ep.NewServer(db))
+	go func() {
+		if err := gs.Serve(lis); err != nil {
+			fmt.Fprintf(os.Stderr, "in-process service stopped: %v\n", err)
+		}
+	}()
+
+	return lis.Addr().String(), func() {
+		gs.Stop()
+		cleanupDB()
+	}, nil
+}
+
+// openDB opens the db backing the in-process service. Sqlite dbs are created in a
+// temporary directory so that every run starts from the same empty state.
+func openDB(kind string) (*gorm.DB, func(), error) {
+	switch kind {
+	case DB_SQLITE:
+		dir, err := os.MkdirTemp("", "loadtest")
+		if err != nil {
+			return nil, nil, err
+		}
+		cleanup := func() { os.RemoveAll(dir) }
+
+		db, err := ep.ConnectSQLite(filepath.Join(dir, "loadtest.db"))
+		if err != nil {
+			cleanup()
+			return nil, nil, err
+		}
+		return db, cleanup, nil
+
+	case DB_POSTGRES:
+		creds, err := ep.ReadDBConfig()
+		if err != nil {
+			return nil, nil, err
+		}
+		db, err := ep.Connect(creds, nil)
+		if err != nil {
+			return nil, nil, err
+		}
+		if err := ep.EnsureDB(db, creds.DbName, ep.Models...); err != nil {
+			return nil, nil, err
+		}
+		return db, func() {}, nil
+	}
+
+	return nil, nil, fmt.Errorf("unknown db %q, expected %s or %s", kind, DB_SQLITE, DB_POSTGRES)
+}
+
+func target(cfg *config, addr string) string {
+	if cfg.addr != "" {
+		return addr
+	}
+	return fmt.Sprintf("in-process service (%s) at %s", cfg.db, addr)
+}
+
+func rpsString(rps float64) string {
+	if rps <= 0 {
+		return "unlimited"
+	}
+	return fmt.Sprintf("%.0f", rps)
+}
+
+func writeHeapProfile(path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	runtime.GC()
+	return pprof.WriteHeapProfile(f)
+}
+
+func writeJSONReport(report *Report, path string) error {
+	if path == "-" {
+		return report.WriteJSON(os.Stdout)
+	}
+
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return report.WriteJSON(f)
+}
@@ -0,0 +1,403 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	pb "go_grpc_example/proto"
+)
+
+const (
+	OP_CREATE = "create"
+	OP_READ   = "read"
+	OP_UPDATE = "update"
+	OP_DELETE = "delete"
+	OP_LIST   = "list"
+)
+
+// opNames is the fixed report order of the rpcs.
+var opNames = []string{OP_CREATE, OP_READ, OP_UPDATE, OP_DELETE, OP_LIST}
+
+// opWeight is a single entry of the rpc mix.
+type opWeight struct {
+	name   string
+	weight int
+}
+
+// mix is the weighted set of rpcs that the workers choose from.
+type mix []opWeight
+
+func (m mix) String() string {
+	parts := make([]string, 0, len(m))
+	for _, op := range m {
+		parts = append(parts, fmt.Sprintf("%s=%d", op.name, op.weight))
+	}
+	return strings.Join(parts, ",")
+}
+
+// pick returns an rpc name chosen at random according to the mix weights.
+func (m mix) pick(rnd *rand.Rand) string {
+	total := 0
+	for _, op := range m {
+		total += op.weight
+	}
+
+	n := rnd.Intn(total)
+	for _, op := range m {
+		if n < op.weight {
+			return op.name
+		}
+		n -= op.weight
+	}
+	return m[len(m)-1].name
+}
+
+// parseMix parses a mix such as "create=20,read=50,list=10". Omitted rpcs are not run.
+func parseMix(s string) (mix, error) {
+	weights := map[string]int{}
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+
+		name, val, ok := strings.Cut(part, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid mix entry %q, expected rpc=weight", part)
+		}
+		name = strings.ToLower(strings.TrimSpace(name))
+		if !isOp(name) {
+			return nil, fmt.Errorf("unknown rpc %q in mix, expected one of %s", name, strings.Join(opNames, ", "))
+		}
+
+		weight, err := strconv.Atoi(strings.TrimSpace(val))
+		if err != nil || weight < 0 {
+			return nil, fmt.Errorf("invalid weight %q for %s", val, name)
+		}
+		weights[name] = weight
+	}
+
+	m := mix{}
+	for _, name := range opNames {
+		if weights[name] > 0 {
+			m = append(m, opWeight{name: name, weight: weights[name]})
+		}
+	}
+	if len(m) == 0 {
+		return nil, errors.New("mix must give at least one rpc a positive weight")
+	}
+	return m, nil
+}
+
+func isOp(name string) bool {
+	for _, op := range opNames {
+		if op == name {
+			return true
+		}
+	}
+	return false
+}
+
+type runnerConfig struct {
+	duration    time.Duration
+	rps         float64
+	concurrency int
+	mix         mix
+	timeout     time.Duration
+	preload     int
+	seed        int64
+}
+
+// runner generates load against a CrudServiceClient.
+type runner struct {
+	cli    pb.CrudServiceClient
+	cfg    runnerConfig
+	ids    *idPool
+	prefix string
+	next   int64
+	missed int64
+}
+
+func newRunner(cli pb.CrudServiceClient, cfg runnerConfig) *runner {
+	return &runner{
+		cli: cli,
+		cfg: cfg,
+		ids: newIDPool(),
+		// Post ids are prefixed per run so that runs against a real service do not collide.
+		prefix: fmt.Sprintf("loadtest-%d-", time.Now().UnixNano()),
+	}
+}
+
+// Run preloads posts, generates load for the configured duration, and returns the report.
+// The run stops early, reporting what was collected so far, if ctx is cancelled.
+func (r *runner) Run(ctx context.Context) (*Report, error) {
+	if err := r.preload(ctx); err != nil {
+		return nil, err
+	}
+
+	runCtx, cancel := context.WithTimeout(ctx, r.cfg.duration)
+	defer cancel()
+
+	var tokens chan struct{}
+	if r.cfg.rps > 0 {
+		tokens = make(chan struct{}, r.cfg.concurrency)
+		go r.dispatch(runCtx, tokens)
+	}
+
+	results := make([]*recorder, r.cfg.concurrency)
+	wg := sync.WaitGroup{}
+	start := time.Now()
+	for i := 0; i < r.cfg.concurrency; i++ {
+		results[i] = newRecorder()
+		wg.Add(1)
+		go func(rec *recorder, seed int64) {
+			defer wg.Done()
+			r.work(runCtx, tokens, rec, rand.New(rand.NewSource(seed)))
+		}(results[i], r.cfg.seed+int64(i))
+	}
+	wg.Wait()
+	elapsed := time.Since(start)
+
+	total := newRecorder()
+	for _, rec := range results {
+		total.merge(rec)
+	}
+
+	report := total.report(elapsed)
+	report.Concurrency = r.cfg.concurrency
+	report.TargetRPS = r.cfg.rps
+	report.Mix = r.cfg.mix.String()
+	report.MissedTicks = atomic.LoadInt64(&r.missed)
+	return report, nil
+}
+
+// preload creates posts so that reads, updates, and deletes have targets from the start.
+func (r *runner) preload(ctx context.Context) error {
+	for i := 0; i < r.cfg.preload; i++ {
+		if _, err := r.create(ctx); err != nil {
+			return fmt.Errorf("preload failed after %d posts: %w", i, err)
+		}
+	}
+	return nil
+}
+
+// dispatch issues rps tokens to the workers. When every worker is busy the token is
+// dropped and counted as missed, which means the service cannot sustain the target rate
+// at this concurrency; a closed-loop generator would instead silently lower the rate.
+func (r *runner) dispatch(ctx context.Context, tokens chan<- struct{}) {
+	defer close(tokens)
+
+	start := time.Now()
+	sent := int64(0)
+	ticker := time.NewTicker(time.Millisecond)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		due := int64(time.Since(start).Seconds() * r.cfg.rps)
+		for ; sent < due; sent++ {
+			select {
+			case tokens <- struct{}{}:
+			default:
+				atomic.AddInt64(&r.missed, 1)
+			}
+		}
+	}
+}
+
+func (r *runner) work(ctx context.Context, tokens <-chan struct{}, rec *recorder, rnd *rand.Rand) {
+	for {
+		if tokens != nil {
+			select {
+			case <-ctx.Done():
+				return
+			case _, ok := <-tokens:
+				if !ok {
+					return
+				}
+			}
+		} else if ctx.Err() != nil {
+			return
+		}
+
+		start := time.Now()
+		op, err := r.do(ctx, r.cfg.mix.pick(rnd), rnd)
+		latency := time.Since(start)
+
+		// Rpcs cut short by the end of the run are not the service's fault, so drop them.
+		if ctx.Err() != nil && err != nil {
+			return
+		}
+		rec.record(op, latency, err)
+	}
+}
+
+// do runs the rpc op, returning the rpc it actually ran: read, update, and delete create a post
+// instead while there are none, so they are recorded as the create they were.
+func (r *runner) do(ctx context.Context, op string, rnd *rand.Rand) (string, error) {
+	switch op {
+	case OP_CREATE:
+		_, err := r.create(ctx)
+		return op, err
+	case OP_READ:
+		return r.read(ctx, rnd)
+	case OP_UPDATE:
+		return r.update(ctx, rnd)
+	case OP_DELETE:
+		return r.delete(ctx, rnd)
+	case OP_LIST:
+		return op, r.list(ctx)
+	}
+	return op, fmt.Errorf("unknown rpc %s", op)
+}
+
+func (r *runner) rpcContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(ctx, r.cfg.timeout)
+}
+
+func (r *runner) create(ctx context.Context) (string, error) {
+	n := atomic.AddInt64(&r.next, 1)
+	id := r.prefix + strconv.FormatInt(n, 10)
+
+	rpcCtx, cancel := r.rpcContext(ctx)
+	defer cancel()
+
+	_, err := r.cli.CreatePost(rpcCtx, &pb.Post{
+		Id:          id,
+		AuthorId:    fmt.Sprintf("author-%d", n%10),
+		Title:       "Load test post " + id,
+		Description: "A post created by the load generator",
+		FullText:    strings.Repeat("All work and no play makes Jack a dull boy. ", 10),
+	})
+	if err == nil {
+		r.ids.add(id)
+	}
+	return id, err
+}
+
+func (r *runner) read(ctx context.Context, rnd *rand.Rand) (string, error) {
+	id, ok := r.ids.random(rnd)
+	if !ok {
+		_, err := r.create(ctx)
+		return OP_CREATE, err
+	}
+
+	rpcCtx, cancel := r.rpcContext(ctx)
+	defer cancel()
+
+	_, err := r.cli.ReadPost(rpcCtx, &pb.PostID{Id: id})
+	return OP_READ, err
+}
+
+func (r *runner) update(ctx context.Context, rnd *rand.Rand) (string, error) {
+	id, ok := r.ids.random(rnd)
+	if !ok {
+		_, err := r.create(ctx)
+		return OP_CREATE, err
+	}
+
+	rpcCtx, cancel := r.rpcContext(ctx)
+	defer cancel()
+
+	_, err := r.cli.UpdatePost(rpcCtx, &pb.Post{
+		Id:          id,
+		Description: fmt.Sprintf("Updated at %s", time.Now().Format(time.RFC3339Nano)),
+	})
+	return OP_UPDATE, err
+}
+
+func (r *runner) delete(ctx context.Context, rnd *rand.Rand) (string, error) {
+	id, ok := r.ids.take(rnd)
+	if !ok {
+		_, err := r.create(ctx)
+		return OP_CREATE, err
+	}
+
+	rpcCtx, cancel := r.rpcContext(ctx)
+	defer cancel()
+
+	_, err := r.cli.DeletePost(rpcCtx, &pb.PostID{Id: id})
+	return OP_DELETE, err
+}
+
+// list drains the ListPosts stream; its latency covers the entire stream.
+func (r *runner) list(ctx context.Context) error {
+	rpcCtx, cancel := r.rpcContext(ctx)
+	defer cancel()
+
+	stream, err := r.cli.ListPosts(rpcCtx, &pb.ListPostsRequest{})
+	if err != nil {
+		return err
+	}
+	for {
+		if _, err := stream.Recv(); err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return err
+		}
+	}
+}
+
+// idPool holds the ids of the posts created by the run, for random selection.
+type idPool struct {
+	mu    sync.Mutex
+	ids   []string
+	index map[string]int
+}
+
+func newIDPool() *idPool {
+	return &idPool{
+		index: map[string]int{},
+	}
+}
+
+func (p *idPool) add(id string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.index[id] = len(p.ids)
+	p.ids = append(p.ids, id)
+}
+
+func (p *idPool) random(rnd *rand.Rand) (string, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if len(p.ids) == 0 {
+		return "", false
+	}
+	return p.ids[rnd.Intn(len(p.ids))], true
+}
+
+// take removes and returns a random id, so that deleted posts are not read again.
+func (p *idPool) take(rnd *rand.Rand) (string, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if len(p.ids) == 0 {
+		return "", false
+	}
+
+	i := rnd.Intn(len(p.ids))
+	id := p.ids[i]
+	last := len(p.ids) - 1
+	p.ids[i] = p.ids[last]
+	p.index[p.ids[i]] = i
+	p.ids = p.ids[:last]
+	delete(p.index, id)
+	return id, true
+}
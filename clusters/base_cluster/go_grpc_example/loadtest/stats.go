@@ -0,0 +1,282 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// bucketBounds are the upper bounds of the latency histogram buckets, in milliseconds.
+// The last bucket is unbounded.
+var bucketBounds = []float64{0.1, 0.25, 0.5, 1, 2.5, 5, 10, 25, 50, 100, 250, 500, 1000, 2500, 5000}
+
+// opStats collects the latencies and result codes of a single rpc.
+type opStats struct {
+	latencies []time.Duration
+	codes     map[codes.Code]int
+}
+
+func newOpStats() *opStats {
+	return &opStats{
+		codes: map[codes.Code]int{},
+	}
+}
+
+// recorder collects results for a single worker, so that workers never contend on a lock.
+// Recorders are merged once the run completes.
+type recorder struct {
+	ops map[string]*opStats
+}
+
+func newRecorder() *recorder {
+	return &recorder{
+		ops: map[string]*opStats{},
+	}
+}
+
+func (rec *recorder) stats(op string) *opStats {
+	s, ok := rec.ops[op]
+	if !ok {
+		s = newOpStats()
+		rec.ops[op] = s
+	}
+	return s
+}
+
+func (rec *recorder) record(op string, latency time.Duration, err error) {
+	s := rec.stats(op)
+	s.latencies = append(s.latencies, latency)
+	s.codes[status.Code(err)]++
+}
+
+func (rec *recorder) merge(other *recorder) {
+	for op, o := range other.ops {
+		s := rec.stats(op)
+		s.latencies = append(s.latencies, o.latencies...)
+		for code, n := range o.codes {
+			s.codes[code] += n
+		}
+	}
+}
+
+// report summarizes the recorded results, with one entry per rpc plus a total.
+func (rec *recorder) report(elapsed time.Duration) *Report {
+	report := &Report{
+		DurationSec: elapsed.Seconds(),
+	}
+
+	all := newOpStats()
+	for _, op := range opNames {
+		s, ok := rec.ops[op]
+		if !ok {
+			continue
+		}
+		report.RPCs = append(report.RPCs, s.summarize(op, elapsed))
+
+		all.latencies = append(all.latencies, s.latencies...)
+		for code, n := range s.codes {
+			all.codes[code] += n
+		}
+	}
+	report.Total = all.summarize("total", elapsed)
+
+	return report
+}
+
+func (s *opStats) summarize(name string, elapsed time.Duration) RPCReport {
+	sort.Slice(s.latencies, func(i, j int) bool { return s.latencies[i] < s.latencies[j] })
+
+	r := RPCReport{
+		Name:  name,
+		Count: len(s.latencies),
+		Codes: map[string]int{},
+	}
+	for code, n := range s.codes {
+		r.Codes[code.String()] = n
+		if code != codes.OK {
+			r.Errors += n
+		}
+	}
+	if elapsed > 0 {
+		r.Throughput = float64(r.Count) / elapsed.Seconds()
+	}
+	if r.Count == 0 {
+		return r
+	}
+
+	var sum time.Duration
+	for _, l := range s.latencies {
+		sum += l
+	}
+	r.Latency = LatencyReport{
+		MeanMs: ms(sum / time.Duration(r.Count)),
+		P50Ms:  ms(percentile(s.latencies, 50)),
+		P95Ms:  ms(percentile(s.latencies, 95)),
+		P99Ms:  ms(percentile(s.latencies, 99)),
+		MaxMs:  ms(s.latencies[r.Count-1]),
+	}
+	r.Histogram = histogram(s.latencies)
+
+	return r
+}
+
+// percentile returns the pth percentile of the sorted latencies using the nearest-rank method.
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+
+	rank := int(math.Ceil(p / 100 * float64(len(sorted))))
+	if rank < 1 {
+		rank = 1
+	}
+	return sorted[rank-1]
+}
+
+// histogram buckets the sorted latencies. Empty buckets are omitted.
+func histogram(sorted []time.Duration) []Bucket {
+	buckets := []Bucket{}
+	i := 0
+	for _, bound := range bucketBounds {
+		n := 0
+		for i < len(sorted) && ms(sorted[i]) <= bound {
+			n++
+			i++
+		}
+		if n > 0 {
+			buckets = append(buckets, Bucket{LeMs: bound, Count: n})
+		}
+	}
+	if rest := len(sorted) - i; rest > 0 {
+		buckets = append(buckets, Bucket{LeMs: math.Inf(1), Count: rest})
+	}
+	return buckets
+}
+
+func ms(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+// Report is the result of a load test run.
+type Report struct {
+	Target      string      `json:"target"`
+	Mix         string      `json:"mix"`
+	Concurrency int         `json:"concurrency"`
+	TargetRPS   float64     `json:"target_rps"`
+	DurationSec float64     `json:"duration_sec"`
+	MissedTicks int64       `json:"missed_ticks"`
+	Total       RPCReport   `json:"total"`
+	RPCs        []RPCReport `json:"rpcs"`
+}
+
+// RPCReport summarizes the results of a single rpc, or of all of them.
+type RPCReport struct {
+	Name       string         `json:"name"`
+	Count      int            `json:"count"`
+	Errors     int            `json:"errors"`
+	Throughput float64        `json:"throughput_rps"`
+	Latency    LatencyReport  `json:"latency"`
+	Codes      map[string]int `json:"codes"`
+	Histogram  []Bucket       `json:"histogram"`
+}
+
+type LatencyReport struct {
+	MeanMs float64 `json:"mean_ms"`
+	P50Ms  float64 `json:"p50_ms"`
+	P95Ms  float64 `json:"p95_ms"`
+	P99Ms  float64 `json:"p99_ms"`
+	MaxMs  float64 `json:"max_ms"`
+}
+
+// Bucket is a latency histogram bucket holding the count of rpcs at or below LeMs.
+type Bucket struct {
+	LeMs  float64 `json:"le_ms"`
+	Count int     `json:"count"`
+}
+
+// MarshalJSON writes the unbounded bucket as "+Inf", since json has no infinity.
+func (b Bucket) MarshalJSON() ([]byte, error) {
+	le := interface{}(b.LeMs)
+	if math.IsInf(b.LeMs, 1) {
+		le = "+Inf"
+	}
+	return json.Marshal(map[string]interface{}{"le_ms": le, "count": b.Count})
+}
+
+func (r *Report) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(r)
+}
+
+// WriteText writes a human-readable summary of the report.
+func (r *Report) WriteText(w io.Writer) error {
+	fmt.Fprintf(w, "\nTarget:      %s\n", r.Target)
+	fmt.Fprintf(w, "Duration:    %.1fs\n", r.DurationSec)
+	fmt.Fprintf(w, "Workers:     %d\n", r.Concurrency)
+	fmt.Fprintf(w, "Mix:         %s\n", r.Mix)
+	if r.TargetRPS > 0 {
+		fmt.Fprintf(w, "Target rps:  %.0f (achieved %.1f, %d missed)\n", r.TargetRPS, r.Total.Throughput, r.MissedTicks)
+	}
+	fmt.Fprintln(w)
+
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(tw, "RPC\tCOUNT\tERRORS\tRPS\tMEAN\tP50\tP95\tP99\tMAX\t")
+	for _, rpc := range append(r.RPCs, r.Total) {
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%.1f\t%s\t%s\t%s\t%s\t%s\t\n",
+			rpc.Name, rpc.Count, rpc.Errors, rpc.Throughput,
+			fmtMs(rpc.Latency.MeanMs), fmtMs(rpc.Latency.P50Ms), fmtMs(rpc.Latency.P95Ms),
+			fmtMs(rpc.Latency.P99Ms), fmtMs(rpc.Latency.MaxMs))
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+
+	if r.Total.Errors > 0 {
+		fmt.Fprintf(w, "\nErrors by rpc:\n")
+		for _, rpc := range r.RPCs {
+			for _, code := range sortedCodes(rpc.Codes) {
+				if code != codes.OK.String() {
+					fmt.Fprintf(w, "  %-8s %-20s %d\n", rpc.Name, code, rpc.Codes[code])
+				}
+			}
+		}
+	}
+
+	fmt.Fprintf(w, "\nLatency histogram (all rpcs):\n")
+	writeHistogram(w, r.Total.Histogram, r.Total.Count)
+	return nil
+}
+
+func writeHistogram(w io.Writer, buckets []Bucket, total int) {
+	const width = 40
+	for _, b := range buckets {
+		label := fmt.Sprintf("<= %gms", b.LeMs)
+		if math.IsInf(b.LeMs, 1) {
+			label = fmt.Sprintf("> %gms", bucketBounds[len(bucketBounds)-1])
+		}
+		bar := strings.Repeat("#", int(math.Ceil(float64(b.Count)/float64(total)*width)))
+		fmt.Fprintf(w, "  %12s  %-*s %d\n", label, width, bar, b.Count)
+	}
+}
+
+func fmtMs(v float64) string {
+	return fmt.Sprintf("%.2fms", v)
+}
+
+func sortedCodes(m map[string]int) []string {
+	keys := make([]string, 0, len(m))
+	for code := range m {
+		keys = append(keys, code)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -21,135 +21,216 @@ var (
 	ErrItemNotFound error = errors.New("item id not found")
 )
 
-// CacheObject implements an ID() method for use as a map key.
-type CacheObject interface {
-	// ID() returns an int for use as a map key.
-	ID() int
-}
-
-// Cache is a least-recently-used cache.
-type Cache struct {
-	// TODO: locking
-	itemMap  map[int]*node
-	itemList *doublyLinkedList
+// LRU is a least-recently-used cache of values by key, safe for concurrent use. Every call
+// that reorders the list takes the lock exclusively, including Get, so under many goroutines
+// the lock is contended even by reads; Sharded spreads keys over several locks.
+type LRU[K comparable, V any] struct {
+	itemMap  map[K]*node[K, V]
+	itemList *doublyLinkedList[K, V]
 	capacity int
-	mu       sync.RWMutex
+	// mu guards the fields above. Only Peek, Contains, Len, Capacity, and Keys share it.
+	mu sync.RWMutex
 }
 
-// NewCache initializes a cache of the passed capacity.
-func NewCache(capacity int) (*Cache, error) {
+// New initializes a cache of the passed capacity.
+func New[K comparable, V any](capacity int) (*LRU[K, V], error) {
 	if capacity <= 0 {
 		return nil, ErrInvalidSize
 	}
 
-	return &Cache{
-		itemMap:  make(map[int]*node, capacity),
-		itemList: newDoublyLinkedList(),
+	return &LRU[K, V]{
+		itemMap:  make(map[K]*node[K, V], capacity),
+		itemList: newDoublyLinkedList[K, V](),
 		capacity: capacity,
-		mu:       sync.RWMutex{},
 	}, nil
 }
 
-// Put adds the passed item to the cache and evicts old items.
-// Put returns an error if the insertion failed or the object already exists.
-func (cache *Cache) Put(item CacheObject) (err error) {
+// Put adds the value to the front of the cache, replacing any value of the key, and evicts
+// least-recently-used items over capacity.
+func (cache *LRU[K, V]) Put(key K, value V) {
 	cache.mu.Lock()
 	defer cache.mu.Unlock()
 
-	if _, ok := cache.itemMap[item.ID()]; ok {
-		err = ErrDuplicateItem
+	if target, ok := cache.itemMap[key]; ok {
+		target.value = value
+		_ = cache.itemList.RotateFront(target)
 		return
 	}
 
-	newNode := &node{
-		item: item,
-	}
+	cache.insert(key, value)
+}
 
-	// TODO: error handling on insertion
-	// TODO: verify if indices are off by one (e.g. list evicts too many/few nodes)
+// add is Put for keys not in the cache, returning false rather than replacing their value.
+func (cache *LRU[K, V]) add(key K, value V) bool {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	if _, ok := cache.itemMap[key]; ok {
+		return false
+	}
+	cache.insert(key, value)
+	return true
+}
 
-	// Add the item to the front of the list
+// insert adds the key, which is not in the cache, to its front.
+func (cache *LRU[K, V]) insert(key K, value V) {
+	newNode := &node[K, V]{key: key, value: value}
 	cache.itemList.Prepend(newNode)
-	// Store the item in hash table
-	cache.itemMap[item.ID()] = newNode
+	cache.itemMap[key] = newNode
+	cache.evict()
+}
 
-	// Evict least-recently-used nodes over capacity
+// evict removes the least-recently-used items over capacity.
+func (cache *LRU[K, V]) evict() {
 	evicted := cache.itemList.TrimRight(cache.capacity)
 	for evicted != nil {
 		// TODO: underlying map size is not reduced after deletion, a memory leak.
-		delete(cache.itemMap, evicted.item.ID())
-		evicted.prev = nil
-		evicted = evicted.next
+		delete(cache.itemMap, evicted.key)
+		next := evicted.next
+		evicted.prev, evicted.next = nil, nil
+		evicted = next
 	}
-
-	return
 }
 
-// Get finds the passed item and returns it if it exists.
+// Get finds the value of the key and returns it if it exists.
 // If found, the item is rotated to the front of the cache.
-func (cache *Cache) Get(id int) (item CacheObject, exists bool) {
-	cache.mu.RLock()
-	defer cache.mu.RUnlock()
+func (cache *LRU[K, V]) Get(key K) (value V, exists bool) {
+	// Rotating the item mutates the list, so even Get needs the exclusive lock.
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
 
-	var target *node
-	target, exists = cache.itemMap[id]
+	target, exists := cache.itemMap[key]
 	if !exists {
 		return
 	}
 
 	// Rotate item to front of list
 	_ = cache.itemList.RotateFront(target)
-	item = target.item
+	return target.value, true
+}
 
-	return
+// Peek is Get without rotating the item to the front of the cache.
+func (cache *LRU[K, V]) Peek(key K) (value V, exists bool) {
+	cache.mu.RLock()
+	defer cache.mu.RUnlock()
+
+	target, exists := cache.itemMap[key]
+	if !exists {
+		return
+	}
+	return target.value, true
 }
 
-func (cache *Cache) Remove(id int) error {
+// Contains returns whether the key is in the cache, without rotating it to the front.
+func (cache *LRU[K, V]) Contains(key K) bool {
+	cache.mu.RLock()
+	defer cache.mu.RUnlock()
+
+	_, ok := cache.itemMap[key]
+	return ok
+}
+
+// Remove removes the key from the cache, returning whether it was in it.
+func (cache *LRU[K, V]) Remove(key K) bool {
 	cache.mu.Lock()
 	defer cache.mu.Unlock()
 
-	target, ok := cache.itemMap[id]
+	target, ok := cache.itemMap[key]
 	if !ok {
-		return ErrItemNotFound
+		return false
 	}
 
-	if err := cache.itemList.Remove(target); err != nil {
-		return err
+	_ = cache.itemList.Remove(target)
+	delete(cache.itemMap, key)
+	return true
+}
+
+// Len returns the number of items in the cache.
+func (cache *LRU[K, V]) Len() int {
+	cache.mu.RLock()
+	defer cache.mu.RUnlock()
+
+	return len(cache.itemMap)
+}
+
+// Capacity returns the number of items the cache holds before evicting.
+func (cache *LRU[K, V]) Capacity() int {
+	cache.mu.RLock()
+	defer cache.mu.RUnlock()
+
+	return cache.capacity
+}
+
+// Keys returns the keys in the cache, from the most to the least recently used.
+func (cache *LRU[K, V]) Keys() []K {
+	cache.mu.RLock()
+	defer cache.mu.RUnlock()
+
+	keys := make([]K, 0, len(cache.itemMap))
+	for n := cache.itemList.head; n != nil; n = n.next {
+		keys = append(keys, n.key)
 	}
+	return keys
+}
 
-	delete(cache.itemMap, target.item.ID())
+// Purge removes every item from the cache, returning how many were removed.
+func (cache *LRU[K, V]) Purge() int {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
 
-	return nil
+	n := len(cache.itemMap)
+	// Replacing the map also releases the memory the evictions left it holding.
+	cache.itemMap = make(map[K]*node[K, V], cache.capacity)
+	cache.itemList = newDoublyLinkedList[K, V]()
+	return n
 }
 
-type node struct {
-	next *node
-	prev *node
-	item CacheObject
+// Resize sets the capacity of the cache, evicting the least-recently-used items over it, and
+// returns how many were evicted.
+func (cache *LRU[K, V]) Resize(capacity int) (int, error) {
+	if capacity <= 0 {
+		return 0, ErrInvalidSize
+	}
+
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	n := len(cache.itemMap)
+	cache.capacity = capacity
+	cache.evict()
+	return n - len(cache.itemMap), nil
+}
+
+type node[K comparable, V any] struct {
+	next  *node[K, V]
+	prev  *node[K, V]
+	key   K
+	value V
 }
 
-type doublyLinkedList struct {
-	head  *node
-	tail  *node
+type doublyLinkedList[K comparable, V any] struct {
+	head  *node[K, V]
+	tail  *node[K, V]
 	count int
 }
 
-func newDoublyLinkedList() *doublyLinkedList {
-	return &doublyLinkedList{
+func newDoublyLinkedList[K comparable, V any]() *doublyLinkedList[K, V] {
+	return &doublyLinkedList[K, V]{
 		head:  nil,
 		tail:  nil,
 		count: 0,
 	}
 }
 
-// Prepend inserts the passed node to the front of the list
-// and evicts any items over capacity.
-func (list *doublyLinkedList) Prepend(newNode *node) {
+// Prepend inserts the passed node to the front of the list.
+func (list *doublyLinkedList[K, V]) Prepend(newNode *node[K, V]) {
+	newNode.prev = nil
+
 	// List is empty
 	if list.head == nil {
 		list.head = newNode
 		list.tail = newNode
-		newNode.prev, newNode.next = nil, nil
+		newNode.next = nil
 		list.count = 1
 		return
 	}
@@ -160,13 +241,13 @@ func (list *doublyLinkedList) Prepend(newNode *node) {
 	list.count++
 }
 
-func (list *doublyLinkedList) RotateFront(target *node) (err error) {
+func (list *doublyLinkedList[K, V]) RotateFront(target *node[K, V]) (err error) {
 	if target == nil {
 		return errItemNil
 	}
 
 	// Node is already at front, simply return
-	if target.prev == nil {
+	if target == list.head {
 		return
 	}
 
@@ -182,17 +263,17 @@ func (list *doublyLinkedList) RotateFront(target *node) (err error) {
 }
 
 // Slice the list at the zero-based nth position and return the first node from that position.
-func (list *doublyLinkedList) TrimRight(n int) (evicted *node) {
+// The evicted nodes stay linked to each other, so callers can walk them via next.
+func (list *doublyLinkedList[K, V]) TrimRight(n int) (evicted *node[K, V]) {
 	// Not at capacity, so just return.
 	if list.count <= n {
 		return
 	}
 
-	// TODO: reconsider list w/out count variable. I don't like trusting
-	// that I can iterate the list to nth position w/out nil checks.
-	evicted = list.head
-	for i := 0; i < n; i++ {
-		evicted = evicted.next
+	// Walk back from the tail, so evicting a few items from a large list stays cheap.
+	evicted = list.tail
+	for i := list.count - 1; i > n; i-- {
+		evicted = evicted.prev
 	}
 
 	// Evicted is the first node in the list
@@ -203,16 +284,7 @@ func (list *doublyLinkedList) TrimRight(n int) (evicted *node) {
 		return
 	}
 
-	// Evicted is the last item in the list
-	if evicted == list.tail {
-		list.tail.prev.next = nil
-		list.tail = list.tail.prev
-		evicted.prev = nil
-		list.count--
-		return
-	}
-
-	// Else: evicted is some node in the middle
+	// Else: the list ends before evicted
 	list.tail = evicted.prev
 	list.tail.next = nil
 	evicted.prev = nil
@@ -226,39 +298,26 @@ var errItemNil error = errors.New("node cannot be nil")
 // Remove removes the passed list node from the list and returns an
 // error if target is nil, otherwise returns nil on success.
 // If successful, no longer use the passed node to allow it to be removed.
-func (list *doublyLinkedList) Remove(target *node) (err error) {
+func (list *doublyLinkedList[K, V]) Remove(target *node[K, V]) (err error) {
 	if target == nil {
 		return errItemNil
 	}
 
-	defer func() {
-		// If no error, nullify target's pointers to prevent memory leaks via stale references.
-		if err == nil {
-			target.prev = nil
-			target.next = nil
-			list.count--
-		}
-	}()
-
-	// Target is the only list item
-	if target.prev == nil && target.next == nil {
-		list.head = nil
-		list.tail = nil
-		return
-	}
-	// Target is the first item in a list with successors.
 	if target.prev == nil {
 		list.head = target.next
-		return
+	} else {
+		target.prev.next = target.next
 	}
-	// Target is the last item in a list with predecessors.
 	if target.next == nil {
 		list.tail = target.prev
-		return
+	} else {
+		target.next.prev = target.prev
 	}
-	// Target is in the middle of a list with predecessors and successors.
-	target.prev.next = target.next
-	target.next.prev = target.prev
+
+	// Nullify target's pointers to prevent memory leaks via stale references.
+	target.prev = nil
+	target.next = nil
+	list.count--
 
 	return
 }
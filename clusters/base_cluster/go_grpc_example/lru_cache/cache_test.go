@@ -1,6 +1,7 @@
 package lru_cache
 
 import (
+	"sync"
 	"testing"
 
 	. "github.com/smartystreets/goconvey/convey"
@@ -27,11 +28,11 @@ Full interview strategy:
 func TestList(t *testing.T) {
 	Convey("List tests", t, func() {
 		Convey("TrimRight tests", func() {
-			l := newDoublyLinkedList()
-			nodes := []*node{
-				{item: &foo{id: 1}},
-				{item: &foo{id: 2}},
-				{item: &foo{id: 3}},
+			l := newDoublyLinkedList[int, CacheObject]()
+			nodes := []*node[int, CacheObject]{
+				{key: 1, value: &foo{id: 1}},
+				{key: 2, value: &foo{id: 2}},
+				{key: 3, value: &foo{id: 3}},
 			}
 			l.Prepend(nodes[2])
 			l.Prepend(nodes[1])
@@ -57,13 +58,13 @@ func TestList(t *testing.T) {
 		})
 
 		Convey("RotateFront tests", func() {
-			l := newDoublyLinkedList()
+			l := newDoublyLinkedList[int, CacheObject]()
 
 			Convey("When list is [1,2,3] and RotateFront is called on the last item", func() {
-				nodes := []*node{
-					{item: &foo{id: 1}},
-					{item: &foo{id: 2}},
-					{item: &foo{id: 3}},
+				nodes := []*node[int, CacheObject]{
+					{key: 1, value: &foo{id: 1}},
+					{key: 2, value: &foo{id: 2}},
+					{key: 3, value: &foo{id: 3}},
 				}
 				l.Prepend(nodes[2])
 				l.Prepend(nodes[1])
@@ -82,7 +83,7 @@ func TestList(t *testing.T) {
 			})
 
 			Convey("When only one item is in the list and RotateFront is called", func() {
-				item := &node{item: &foo{id: 1}}
+				item := &node[int, CacheObject]{key: 1, value: &foo{id: 1}}
 				l.Prepend(item)
 				err := l.RotateFront(item)
 				So(err, ShouldBeNil)
@@ -93,20 +94,20 @@ func TestList(t *testing.T) {
 		})
 
 		Convey("Initialization tests", func() {
-			l := newDoublyLinkedList()
+			l := newDoublyLinkedList[int, CacheObject]()
 			So(l.count, ShouldEqual, 0)
 			So(l.head, ShouldBeNil)
 			So(l.tail, ShouldBeNil)
 		})
 
 		Convey("Removal tests", func() {
-			l := newDoublyLinkedList()
+			l := newDoublyLinkedList[int, CacheObject]()
 			So(l.count, ShouldEqual, 0)
 
-			nodes := []*node{
-				{item: &foo{id: 1}},
-				{item: &foo{id: 2}},
-				{item: &foo{id: 3}},
+			nodes := []*node[int, CacheObject]{
+				{key: 1, value: &foo{id: 1}},
+				{key: 2, value: &foo{id: 2}},
+				{key: 3, value: &foo{id: 3}},
 			}
 			l.Prepend(nodes[2])
 			l.Prepend(nodes[1])
@@ -154,13 +155,13 @@ func TestList(t *testing.T) {
 		})
 
 		Convey("Prepend tests", func() {
-			l := newDoublyLinkedList()
+			l := newDoublyLinkedList[int, CacheObject]()
 			So(l.count, ShouldEqual, 0)
 
-			nodes := []*node{
-				{item: &foo{id: 1}},
-				{item: &foo{id: 2}},
-				{item: &foo{id: 3}},
+			nodes := []*node[int, CacheObject]{
+				{key: 1, value: &foo{id: 1}},
+				{key: 2, value: &foo{id: 2}},
+				{key: 3, value: &foo{id: 3}},
 			}
 
 			// Prepending to empty list
@@ -262,7 +263,7 @@ func TestCacheGet(t *testing.T) {
 				So(ok, ShouldBeTrue)
 				So(target.ID(), ShouldEqual, item.ID())
 				// The fetched item should now be at front of the list.
-				So(cache.itemList.head.item.ID(), ShouldEqual, item.ID())
+				So(cache.cache.itemList.head.key, ShouldEqual, item.ID())
 			}
 		})
 	})
@@ -348,3 +349,127 @@ func TestCacheAdd(t *testing.T) {
 		})
 	})
 }
+
+func TestGenericCache(t *testing.T) {
+	Convey("Generic cache tests", t, func() {
+		cache, err := New[string, int](3)
+		So(err, ShouldBeNil)
+		cache.Put("a", 1)
+		cache.Put("b", 2)
+		cache.Put("c", 3)
+
+		_, err = New[string, int](0)
+		So(err, ShouldBeError, ErrInvalidSize)
+
+		Convey("Keys are listed from the most recently used, which Get and Put update", func() {
+			So(cache.Keys(), ShouldResemble, []string{"c", "b", "a"})
+			v, ok := cache.Get("a")
+			So(ok, ShouldBeTrue)
+			So(v, ShouldEqual, 1)
+			So(cache.Keys(), ShouldResemble, []string{"a", "c", "b"})
+
+			cache.Put("b", 20)
+			So(cache.Keys(), ShouldResemble, []string{"b", "a", "c"})
+			So(cache.Len(), ShouldEqual, 3)
+			v, _ = cache.Get("b")
+			So(v, ShouldEqual, 20)
+		})
+
+		Convey("Peek and Contains do not update the recency", func() {
+			v, ok := cache.Peek("a")
+			So(ok, ShouldBeTrue)
+			So(v, ShouldEqual, 1)
+			So(cache.Contains("a"), ShouldBeTrue)
+			So(cache.Contains("z"), ShouldBeFalse)
+			_, ok = cache.Peek("z")
+			So(ok, ShouldBeFalse)
+			So(cache.Keys(), ShouldResemble, []string{"c", "b", "a"})
+		})
+
+		Convey("The least recently used key is evicted", func() {
+			cache.Get("a")
+			cache.Put("d", 4)
+			So(cache.Keys(), ShouldResemble, []string{"d", "a", "c"})
+			So(cache.Contains("b"), ShouldBeFalse)
+		})
+
+		Convey("Keys are removed from anywhere in the list", func() {
+			for _, key := range []string{"c", "a"} {
+				So(cache.Remove(key), ShouldBeTrue)
+				So(cache.Remove(key), ShouldBeFalse)
+			}
+			So(cache.Keys(), ShouldResemble, []string{"b"})
+			cache.Put("d", 4)
+			cache.Put("e", 5)
+			So(cache.Keys(), ShouldResemble, []string{"e", "d", "b"})
+		})
+
+		Convey("Purge removes every key", func() {
+			So(cache.Purge(), ShouldEqual, 3)
+			So(cache.Len(), ShouldEqual, 0)
+			So(cache.Keys(), ShouldBeEmpty)
+			cache.Put("a", 1)
+			So(cache.Keys(), ShouldResemble, []string{"a"})
+		})
+
+		Convey("Resize evicts the least recently used keys over the capacity", func() {
+			evicted, err := cache.Resize(1)
+			So(err, ShouldBeNil)
+			So(evicted, ShouldEqual, 2)
+			So(cache.Keys(), ShouldResemble, []string{"c"})
+			So(cache.Capacity(), ShouldEqual, 1)
+
+			evicted, err = cache.Resize(2)
+			So(err, ShouldBeNil)
+			So(evicted, ShouldEqual, 0)
+			cache.Put("d", 4)
+			So(cache.Keys(), ShouldResemble, []string{"d", "c"})
+
+			_, err = cache.Resize(0)
+			So(err, ShouldBeError, ErrInvalidSize)
+		})
+	})
+}
+
+func TestCacheConcurrency(t *testing.T) {
+	Convey("Concurrent calls keep the cache consistent", t, func() {
+		cache, err := New[int, int](64)
+		So(err, ShouldBeNil)
+		exercise(cache.Get, cache.Put, cache.Remove, func() { cache.Keys(); cache.Len(); cache.Peek(1) })
+
+		keys := cache.Keys()
+		So(len(keys), ShouldEqual, cache.Len())
+		So(len(keys), ShouldBeLessThanOrEqualTo, cache.Capacity())
+		So(cache.itemList.count, ShouldEqual, len(keys))
+		for _, key := range keys {
+			v, ok := cache.Peek(key)
+			So(ok, ShouldBeTrue)
+			So(v, ShouldEqual, key)
+		}
+	})
+}
+
+// exercise calls the cache's methods from many goroutines at once, for go test -race to check.
+func exercise(get func(int) (int, bool), put func(int, int), remove func(int) bool, read func()) {
+	var wg sync.WaitGroup
+	for g := 0; g < 16; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 2000; i++ {
+				key := (g*7 + i) % 128
+				switch i % 10 {
+				case 0:
+					put(key, key)
+				case 1:
+					remove(key)
+				case 2:
+					read()
+				default:
+					get(key)
+				}
+			}
+		}(g)
+	}
+	wg.Wait()
+}
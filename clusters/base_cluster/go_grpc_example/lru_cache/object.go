@@ -0,0 +1,57 @@
+package lru_cache
+
+// CacheObject implements an ID() method for use as a map key.
+type CacheObject interface {
+	// ID() returns an int for use as a map key.
+	ID() int
+}
+
+// Cache is an LRU of CacheObjects keyed by their ID(), with the API lru_cache had before it
+// was generic, under the same name, so that code naming *Cache still compiles.
+type Cache struct {
+	cache *LRU[int, CacheObject]
+}
+
+// NewCache initializes a Cache of the passed capacity.
+//
+// Deprecated: use New, which takes keys of any comparable type rather than an ID() int.
+func NewCache(capacity int) (*Cache, error) {
+	cache, err := New[int, CacheObject](capacity)
+	if err != nil {
+		return nil, err
+	}
+	return &Cache{cache: cache}, nil
+}
+
+// Put adds the passed item to the cache and evicts old items.
+// Put returns ErrDuplicateItem if an item with the same ID() exists.
+func (c *Cache) Put(item CacheObject) error {
+	if !c.cache.add(item.ID(), item) {
+		return ErrDuplicateItem
+	}
+	return nil
+}
+
+// Get finds the passed item and returns it if it exists.
+// If found, the item is rotated to the front of the cache.
+func (c *Cache) Get(id int) (CacheObject, bool) {
+	return c.cache.Get(id)
+}
+
+// Remove removes the item, or returns ErrItemNotFound.
+func (c *Cache) Remove(id int) error {
+	if !c.cache.Remove(id) {
+		return ErrItemNotFound
+	}
+	return nil
+}
+
+// Len returns the number of items in the cache.
+func (c *Cache) Len() int {
+	return c.cache.Len()
+}
+
+// Capacity returns the number of items the cache holds before evicting.
+func (c *Cache) Capacity() int {
+	return c.cache.Capacity()
+}
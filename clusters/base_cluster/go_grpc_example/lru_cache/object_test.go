@@ -0,0 +1,47 @@
+package lru_cache_test
+
+import (
+	"testing"
+
+	"go_grpc_example/lru_cache"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+type post struct {
+	id int
+}
+
+func (p *post) ID() int {
+	return p.id
+}
+
+// objectCache is the api of lru_cache before it was generic, as its importers named it.
+type objectCache interface {
+	Put(item lru_cache.CacheObject) error
+	Get(id int) (lru_cache.CacheObject, bool)
+	Remove(id int) error
+	Len() int
+}
+
+var _ objectCache = (*lru_cache.Cache)(nil)
+
+func TestObjectCacheAPI(t *testing.T) {
+	Convey("Code naming the old *lru_cache.Cache compiles and works", t, func() {
+		var cache *lru_cache.Cache
+		cache, err := lru_cache.NewCache(1)
+		So(err, ShouldBeNil)
+
+		So(cache.Put(&post{id: 1}), ShouldBeNil)
+		So(cache.Put(&post{id: 1}), ShouldBeError, lru_cache.ErrDuplicateItem)
+		So(cache.Put(&post{id: 2}), ShouldBeNil)
+		_, exists := cache.Get(1)
+		So(exists, ShouldBeFalse)
+		item, exists := cache.Get(2)
+		So(exists, ShouldBeTrue)
+		So(item.ID(), ShouldEqual, 2)
+		So(cache.Remove(2), ShouldBeNil)
+		So(cache.Remove(2), ShouldBeError, lru_cache.ErrItemNotFound)
+		So(cache.Len(), ShouldEqual, 0)
+	})
+}
@@ -0,0 +1,134 @@
+package lru_cache
+
+import (
+	"hash/maphash"
+)
+
+// Sharded is a least-recently-used cache whose keys are hashed across shards, each an
+// independently locked LRU, so that goroutines using different keys rarely contend.
+// Recency is tracked per shard: the evicted key is the least recently used of its shard,
+// which only approximates the least recently used overall.
+type Sharded[K comparable, V any] struct {
+	shards []*LRU[K, V]
+	hash   func(K) uint64
+}
+
+// NewSharded initializes a cache of the passed capacity over the passed number of shards,
+// which share the capacity evenly, hashing keys to shards with hash. The capacity must be at
+// least the number of shards.
+func NewSharded[K comparable, V any](capacity, shards int, hash func(K) uint64) (*Sharded[K, V], error) {
+	if shards <= 0 || capacity < shards {
+		return nil, ErrInvalidSize
+	}
+
+	s := &Sharded[K, V]{shards: make([]*LRU[K, V], shards), hash: hash}
+	for i := range s.shards {
+		// The capacity of each shard is positive, so New cannot fail.
+		s.shards[i], _ = New[K, V](shardCapacity(capacity, shards, i))
+	}
+	return s, nil
+}
+
+// shardCapacity returns the capacity of the ith of n shards sharing capacity.
+func shardCapacity(capacity, n, i int) int {
+	c := capacity / n
+	if i < capacity%n {
+		c++
+	}
+	return c
+}
+
+var stringSeed = maphash.MakeSeed()
+
+// HashString hashes strings for NewSharded, with a seed chosen when the process starts.
+func HashString(key string) uint64 {
+	return maphash.String(stringSeed, key)
+}
+
+func (s *Sharded[K, V]) shard(key K) *LRU[K, V] {
+	return s.shards[s.hash(key)%uint64(len(s.shards))]
+}
+
+// Put adds the value to the front of its shard, replacing any value of the key, and evicts
+// the least-recently-used items of the shard over its capacity.
+func (s *Sharded[K, V]) Put(key K, value V) {
+	s.shard(key).Put(key, value)
+}
+
+// Get finds the value of the key and returns it if it exists.
+// If found, the item is rotated to the front of its shard.
+func (s *Sharded[K, V]) Get(key K) (V, bool) {
+	return s.shard(key).Get(key)
+}
+
+// Peek is Get without rotating the item to the front of its shard.
+func (s *Sharded[K, V]) Peek(key K) (V, bool) {
+	return s.shard(key).Peek(key)
+}
+
+// Contains returns whether the key is in the cache, without rotating it to the front.
+func (s *Sharded[K, V]) Contains(key K) bool {
+	return s.shard(key).Contains(key)
+}
+
+// Remove removes the key from the cache, returning whether it was in it.
+func (s *Sharded[K, V]) Remove(key K) bool {
+	return s.shard(key).Remove(key)
+}
+
+// Len returns the number of items in the cache. Shards are counted one at a time, so the
+// count may be stale under concurrent writes.
+func (s *Sharded[K, V]) Len() int {
+	n := 0
+	for _, shard := range s.shards {
+		n += shard.Len()
+	}
+	return n
+}
+
+// Capacity returns the number of items the cache holds before evicting.
+func (s *Sharded[K, V]) Capacity() int {
+	n := 0
+	for _, shard := range s.shards {
+		n += shard.Capacity()
+	}
+	return n
+}
+
+// Keys returns the keys in the cache, shard by shard, each from the most to the least
+// recently used.
+func (s *Sharded[K, V]) Keys() []K {
+	keys := []K{}
+	for _, shard := range s.shards {
+		keys = append(keys, shard.Keys()...)
+	}
+	return keys
+}
+
+// Purge removes every item from the cache, returning how many were removed.
+func (s *Sharded[K, V]) Purge() int {
+	n := 0
+	for _, shard := range s.shards {
+		n += shard.Purge()
+	}
+	return n
+}
+
+// Resize sets the capacity of the cache, which must be at least the number of shards,
+// evicting the least-recently-used items of each shard over its share, and returns how many
+// were evicted.
+func (s *Sharded[K, V]) Resize(capacity int) (int, error) {
+	if capacity < len(s.shards) {
+		return 0, ErrInvalidSize
+	}
+
+	n := 0
+	for i, shard := range s.shards {
+		evicted, err := shard.Resize(shardCapacity(capacity, len(s.shards), i))
+		if err != nil {
+			return n, err
+		}
+		n += evicted
+	}
+	return n, nil
+}
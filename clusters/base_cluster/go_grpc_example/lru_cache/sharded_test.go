@@ -0,0 +1,142 @@
+package lru_cache
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func hashInt(key int) uint64 {
+	return uint64(key)
+}
+
+func TestSharded(t *testing.T) {
+	Convey("Sharded cache tests", t, func() {
+		_, err := NewSharded[int, int](3, 4, hashInt)
+		So(err, ShouldBeError, ErrInvalidSize)
+		_, err = NewSharded[int, int](3, 0, hashInt)
+		So(err, ShouldBeError, ErrInvalidSize)
+
+		// Keys 0, 4, 8, ... fall in the first of 4 shards, which holds 3 of the 10 items.
+		cache, err := NewSharded[int, int](10, 4, hashInt)
+		So(err, ShouldBeNil)
+		So(cache.Capacity(), ShouldEqual, 10)
+
+		Convey("Keys are evicted by the recency within their shard", func() {
+			for _, key := range []int{0, 4, 8, 1} {
+				cache.Put(key, key*10)
+			}
+			v, ok := cache.Get(0)
+			So(ok, ShouldBeTrue)
+			So(v, ShouldEqual, 0)
+			cache.Put(12, 120)
+
+			So(cache.Contains(4), ShouldBeFalse)
+			So(cache.Contains(1), ShouldBeTrue)
+			So(cache.Len(), ShouldEqual, 4)
+			So(cache.Keys(), ShouldResemble, []int{12, 0, 8, 1})
+
+			v, ok = cache.Peek(8)
+			So(ok, ShouldBeTrue)
+			So(v, ShouldEqual, 80)
+			So(cache.Remove(8), ShouldBeTrue)
+			So(cache.Remove(8), ShouldBeFalse)
+			So(cache.Purge(), ShouldEqual, 3)
+			So(cache.Len(), ShouldEqual, 0)
+		})
+
+		Convey("Resize shares the capacity between the shards", func() {
+			for key := 0; key < 10; key++ {
+				cache.Put(key, key)
+			}
+			evicted, err := cache.Resize(4)
+			So(err, ShouldBeNil)
+			So(evicted, ShouldEqual, 6)
+			So(cache.Keys(), ShouldResemble, []int{8, 9, 6, 7})
+
+			_, err = cache.Resize(3)
+			So(err, ShouldBeError, ErrInvalidSize)
+		})
+
+		Convey("String keys are spread over the shards", func() {
+			strings, err := NewSharded[string, int](1000, 8, HashString)
+			So(err, ShouldBeNil)
+			for i := 0; i < 800; i++ {
+				strings.Put(fmt.Sprint("key-", i), i)
+			}
+			for _, shard := range strings.shards {
+				So(shard.Len(), ShouldBeGreaterThan, 50)
+			}
+		})
+
+		Convey("Concurrent calls keep the cache consistent", func() {
+			exercise(cache.Get, cache.Put, cache.Remove, func() { cache.Keys(); cache.Len(); cache.Peek(1) })
+			So(cache.Len(), ShouldBeLessThanOrEqualTo, cache.Capacity())
+			So(cache.Keys(), ShouldHaveLength, cache.Len())
+		})
+	})
+}
+
+// lru is the api shared by LRU and Sharded that the benchmarks use.
+type lru interface {
+	Get(string) (int, bool)
+	Put(string, int)
+}
+
+// benchmarkReads runs a read-heavy workload, 9 Gets to each Put, over twice as many keys as
+// the cache holds, with each count of goroutines sharing the b.N operations.
+func benchmarkReads(b *testing.B, newCache func(capacity int) lru) {
+	const capacity = 10000
+	keys := make([]string, 2*capacity)
+	for i := range keys {
+		keys[i] = fmt.Sprint("post-", i)
+	}
+
+	for _, goroutines := range []int{1, 2, 4, 8, 16, 32, 64} {
+		b.Run(fmt.Sprintf("goroutines=%d", goroutines), func(b *testing.B) {
+			cache := newCache(capacity)
+			for i := 0; i < capacity; i++ {
+				cache.Put(keys[i], i)
+			}
+			b.ResetTimer()
+
+			var wg sync.WaitGroup
+			for g := 0; g < goroutines; g++ {
+				wg.Add(1)
+				go func(g int) {
+					defer wg.Done()
+					// A xorshift picks the keys, as math/rand's global source would contend too.
+					x := uint32(g + 1)
+					for i := g; i < b.N; i += goroutines {
+						x ^= x << 13
+						x ^= x >> 17
+						x ^= x << 5
+						key := keys[x%uint32(len(keys))]
+						if i%10 == 0 {
+							cache.Put(key, i)
+						} else {
+							cache.Get(key)
+						}
+					}
+				}(g)
+			}
+			wg.Wait()
+		})
+	}
+}
+
+func BenchmarkCache(b *testing.B) {
+	benchmarkReads(b, func(capacity int) lru {
+		cache, _ := New[string, int](capacity)
+		return cache
+	})
+}
+
+func BenchmarkSharded(b *testing.B) {
+	benchmarkReads(b, func(capacity int) lru {
+		cache, _ := NewSharded[string, int](capacity, 64, HashString)
+		return cache
+	})
+}
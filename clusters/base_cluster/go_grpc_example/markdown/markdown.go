@@ -0,0 +1,917 @@
+// Package markdown renders the Markdown of post text to sanitized HTML, and to plain text for
+// excerpts. It implements the commonly used subset of CommonMark rather than the whole spec:
+//   - ATX headings, paragraphs, hard line breaks, thematic breaks, block quotes, fenced and
+//     indented code blocks, and bullet and ordered lists, nested by indentation
+//   - emphasis, strong emphasis, code spans, links, images, and autolinks
+//
+// Setext headings, reference links, tables, and html blocks are rendered as paragraphs. The
+// HTML is safe to embed in a page as is, whatever the source: raw HTML is escaped rather than
+// passed through, and links and images only keep http, https, and mailto urls, or relative ones.
+//
+// It is written here rather than built on goldmark and bluemonday for three reasons. A renderer
+// that never emits raw html needs no sanitizing pass, which is simpler to trust than one that
+// parses its own output again against an allowlist. Excerpts need the plain text of the same
+// parse, which with goldmark means a second walk of its ast. And it bounds nesting and link scans
+// (MAX_DEPTH, maxLinkScan), so that any post renders in linear time, which TestRender checks,
+// while FuzzRender checks that no source yields a script, an event handler, or a javascript url.
+// Were posts to need the rest of CommonMark, e.g. tables, goldmark with a bluemonday policy on
+// its output would be the one to switch to.
+package markdown
+
+import (
+	"html"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+	// MAX_DEPTH bounds the nesting of block quotes, lists, and inline spans; deeper markup is
+	// rendered as text, so that no source can exhaust the stack.
+	MAX_DEPTH = 32
+	// maxLinkScan bounds how far a link's text and destination are scanned for their end, so
+	// that unclosed brackets keep rendering linear.
+	maxLinkScan = 4096
+	tabWidth    = 4
+)
+
+// allowedSchemes are the url schemes links and images may keep; urls without one are relative.
+var allowedSchemes = map[string]bool{"http": true, "https": true, "mailto": true}
+
+// Render returns the source rendered as HTML, and as plain text without markup or code blocks,
+// with blocks separated by newlines.
+func Render(src string) (string, string) {
+	w := &writer{}
+	w.blocks(splitLines(src), false, 0)
+	return w.html.String(), strings.TrimSpace(w.text.String())
+}
+
+// Excerpt returns the leading words of text, with its whitespace collapsed, up to max runes,
+// followed by an ellipsis if text is longer. A first word longer than max is cut.
+func Excerpt(text string, max int) string {
+	var b strings.Builder
+	n := 0
+	for _, word := range strings.Fields(text) {
+		runes := utf8.RuneCountInString(word)
+		if n > 0 {
+			runes++
+		}
+		if n+runes > max {
+			if n == 0 {
+				for i := range word {
+					if n == max {
+						b.WriteString(word[:i])
+						break
+					}
+					n++
+				}
+			}
+			b.WriteString("…")
+			return b.String()
+		}
+		if n > 0 {
+			b.WriteByte(' ')
+		}
+		b.WriteString(word)
+		n += runes
+	}
+	return b.String()
+}
+
+// writer accumulates the HTML and plain text renderings.
+type writer struct {
+	html strings.Builder
+	text strings.Builder
+}
+
+// tag writes markup, which only the HTML has.
+func (w *writer) tag(s string) {
+	w.html.WriteString(s)
+}
+
+// write writes text, escaped in the HTML.
+func (w *writer) write(s string) {
+	w.html.WriteString(html.EscapeString(s))
+	w.text.WriteString(s)
+}
+
+// endBlock separates blocks in the plain text.
+func (w *writer) endBlock() {
+	w.text.WriteByte('\n')
+}
+
+func splitLines(src string) []string {
+	src = strings.NewReplacer("\r\n", "\n", "\r", "\n", "\x00", "�").Replace(src)
+	lines := strings.Split(src, "\n")
+	for i, line := range lines {
+		if strings.IndexByte(line, '\t') >= 0 {
+			lines[i] = expandTabs(line)
+		}
+	}
+	return lines
+}
+
+func expandTabs(line string) string {
+	var b strings.Builder
+	col := 0
+	for _, r := range line {
+		if r == '\t' {
+			n := tabWidth - col%tabWidth
+			b.WriteString(strings.Repeat(" ", n))
+			col += n
+			continue
+		}
+		b.WriteRune(r)
+		col++
+	}
+	return b.String()
+}
+
+func isBlank(line string) bool {
+	return strings.TrimSpace(line) == ""
+}
+
+func allBlank(lines []string) bool {
+	for _, line := range lines {
+		if !isBlank(line) {
+			return false
+		}
+	}
+	return true
+}
+
+func indent(line string) int {
+	return len(line) - len(strings.TrimLeft(line, " "))
+}
+
+// dedent removes up to n leading spaces.
+func dedent(line string, n int) string {
+	if i := indent(line); i < n {
+		n = i
+	}
+	return line[n:]
+}
+
+// blocks renders the lines as blocks. Paragraphs of tight list items are not wrapped in <p>.
+func (w *writer) blocks(lines []string, tight bool, depth int) {
+	for i := 0; i < len(lines); {
+		line := lines[i]
+		switch {
+		case isBlank(line):
+			i++
+		case indent(line) >= 4:
+			i = w.indentedCode(lines, i)
+		case isFence(line):
+			i = w.fencedCode(lines, i)
+		case isHeading(line):
+			w.heading(line, depth)
+			i++
+		case isThematicBreak(line):
+			w.tag("<hr>\n")
+			i++
+		case isQuote(line) && depth < MAX_DEPTH:
+			i = w.quote(lines, i, depth)
+		case isListItem(line) && depth < MAX_DEPTH:
+			i = w.list(lines, i, depth)
+		default:
+			i = w.paragraph(lines, i, tight, depth)
+		}
+	}
+}
+
+// startsBlock reports whether the line interrupts a paragraph.
+func startsBlock(line string) bool {
+	return isFence(line) || isHeading(line) || isThematicBreak(line) || isQuote(line) || isListItem(line)
+}
+
+func (w *writer) paragraph(lines []string, i int, tight bool, depth int) int {
+	start := i
+	for i++; i < len(lines) && !isBlank(lines[i]) && !startsBlock(lines[i]); i++ {
+	}
+
+	para := make([]string, i-start)
+	for j, line := range lines[start:i] {
+		para[j] = strings.TrimLeft(line, " ")
+	}
+	text := strings.TrimRight(strings.Join(para, "\n"), " ")
+	if !tight {
+		w.tag("<p>")
+	}
+	w.inline(text, depth)
+	if !tight {
+		w.tag("</p>")
+	}
+	// The last paragraph of a tight item is closed on the same line as the item.
+	if !tight || !allBlank(lines[i:]) {
+		w.tag("\n")
+	}
+	w.endBlock()
+	return i
+}
+
+func isHeading(line string) bool {
+	_, _, ok := heading(line)
+	return ok
+}
+
+// heading returns the level and text of an ATX heading.
+func heading(line string) (int, string, bool) {
+	if indent(line) > 3 {
+		return 0, "", false
+	}
+	line = strings.TrimLeft(line, " ")
+	level := len(line) - len(strings.TrimLeft(line, "#"))
+	if level == 0 || level > 6 || (len(line) > level && line[level] != ' ') {
+		return 0, "", false
+	}
+
+	text := strings.TrimSpace(line[level:])
+	// A closing sequence of #s is dropped, if preceded by a space or alone.
+	if trimmed := strings.TrimRight(text, "#"); trimmed == "" || strings.HasSuffix(trimmed, " ") {
+		text = strings.TrimSpace(trimmed)
+	}
+	return level, text, true
+}
+
+func (w *writer) heading(line string, depth int) {
+	level, text, _ := heading(line)
+	n := strconv.Itoa(level)
+	w.tag("<h" + n + ">")
+	w.inline(text, depth)
+	w.tag("</h" + n + ">\n")
+	w.endBlock()
+}
+
+func isThematicBreak(line string) bool {
+	if indent(line) > 3 {
+		return false
+	}
+	line = strings.TrimSpace(line)
+	if line == "" || !strings.ContainsRune("-*_", rune(line[0])) {
+		return false
+	}
+	n := 0
+	for i := 0; i < len(line); i++ {
+		switch line[i] {
+		case line[0]:
+			n++
+		case ' ':
+		default:
+			return false
+		}
+	}
+	return n >= 3
+}
+
+// fence returns the fence character and length opening or closing a code block.
+func fence(line string) (byte, int, string) {
+	if indent(line) > 3 {
+		return 0, 0, ""
+	}
+	line = strings.TrimLeft(line, " ")
+	if line == "" || (line[0] != '`' && line[0] != '~') {
+		return 0, 0, ""
+	}
+	n := len(line) - len(strings.TrimLeft(line, line[:1]))
+	if n < 3 {
+		return 0, 0, ""
+	}
+	info := strings.TrimSpace(line[n:])
+	if line[0] == '`' && strings.IndexByte(info, '`') >= 0 {
+		return 0, 0, ""
+	}
+	return line[0], n, info
+}
+
+func isFence(line string) bool {
+	c, _, _ := fence(line)
+	return c != 0
+}
+
+// language returns the first word of a fence's info string, keeping only the characters
+// safe in a class name.
+func language(info string) string {
+	if fields := strings.Fields(info); len(fields) > 0 {
+		return strings.Map(func(r rune) rune {
+			if r < utf8.RuneSelf && (unicode.IsLetter(r) || unicode.IsDigit(r) || strings.ContainsRune("+-_.#", r)) {
+				return r
+			}
+			return -1
+		}, fields[0])
+	}
+	return ""
+}
+
+func (w *writer) fencedCode(lines []string, i int) int {
+	c, n, info := fence(lines[i])
+	offset := indent(lines[i])
+	code := []string{}
+	for i++; i < len(lines); i++ {
+		if cc, cn, cinfo := fence(lines[i]); cc == c && cn >= n && cinfo == "" {
+			i++
+			break
+		}
+		code = append(code, dedent(lines[i], offset))
+	}
+
+	if lang := language(info); lang != "" {
+		w.tag(`<pre><code class="language-` + lang + `">`)
+	} else {
+		w.tag("<pre><code>")
+	}
+	w.code(code)
+	w.tag("</code></pre>\n")
+	return i
+}
+
+func (w *writer) indentedCode(lines []string, i int) int {
+	code := []string{}
+	for ; i < len(lines) && (isBlank(lines[i]) || indent(lines[i]) >= 4); i++ {
+		code = append(code, dedent(lines[i], 4))
+	}
+	// Trailing blank lines separate the block from the next one.
+	for len(code) > 0 && isBlank(code[len(code)-1]) {
+		code = code[:len(code)-1]
+	}
+
+	w.tag("<pre><code>")
+	w.code(code)
+	w.tag("</code></pre>\n")
+	return i
+}
+
+// code writes the lines of a code block to the HTML only, as code is left out of excerpts.
+func (w *writer) code(lines []string) {
+	for _, line := range lines {
+		w.tag(html.EscapeString(line) + "\n")
+	}
+}
+
+func isQuote(line string) bool {
+	return indent(line) <= 3 && strings.HasPrefix(strings.TrimLeft(line, " "), ">")
+}
+
+func (w *writer) quote(lines []string, i int, depth int) int {
+	inner := []string{}
+	for ; i < len(lines); i++ {
+		line := lines[i]
+		if isQuote(line) {
+			line = strings.TrimPrefix(strings.TrimLeft(line, " "), ">")
+			inner = append(inner, strings.TrimPrefix(line, " "))
+			continue
+		}
+		// Lines continuing a quoted paragraph need no marker.
+		if isBlank(line) || startsBlock(line) || len(inner) == 0 || isBlank(inner[len(inner)-1]) {
+			break
+		}
+		inner = append(inner, line)
+	}
+
+	w.tag("<blockquote>\n")
+	w.blocks(inner, false, depth+1)
+	w.tag("</blockquote>\n")
+	return i
+}
+
+// listMarker describes the marker of a list item.
+type listMarker struct {
+	// delim is the bullet, or the delimiter following an ordered item's number.
+	delim   byte
+	ordered bool
+	start   int
+	// width is the indentation of the item's content.
+	width int
+}
+
+func parseListMarker(line string) (listMarker, bool) {
+	ind := indent(line)
+	if ind > 3 {
+		return listMarker{}, false
+	}
+	rest := line[ind:]
+	m := listMarker{}
+	n := 0
+	switch {
+	case rest == "":
+		return m, false
+	case strings.IndexByte("-*+", rest[0]) >= 0:
+		m.delim, n = rest[0], 1
+	default:
+		digits := len(rest) - len(strings.TrimLeft(rest, "0123456789"))
+		if digits == 0 || digits > 9 || digits == len(rest) || (rest[digits] != '.' && rest[digits] != ')') {
+			return m, false
+		}
+		m.ordered, m.delim, n = true, rest[digits], digits+1
+		m.start, _ = strconv.Atoi(rest[:digits])
+	}
+	if len(rest) > n && rest[n] != ' ' {
+		return m, false
+	}
+
+	// The content starts after 1 to 4 spaces; with more, the rest is indented code.
+	spaces := indent(rest[n:])
+	if spaces == 0 || spaces > 4 || strings.TrimSpace(rest[n:]) == "" {
+		spaces = 1
+	}
+	m.width = ind + n + spaces
+	return m, true
+}
+
+func isListItem(line string) bool {
+	_, ok := parseListMarker(line)
+	return ok
+}
+
+func (w *writer) list(lines []string, i int, depth int) int {
+	first, _ := parseListMarker(lines[i])
+	items := [][]string{}
+	loose := false
+	for i < len(lines) {
+		m, ok := parseListMarker(lines[i])
+		if !ok || m.ordered != first.ordered || m.delim != first.delim {
+			break
+		}
+
+		// An item holding only its marker starts empty.
+		item := []string{""}
+		if len(lines[i]) > m.width {
+			item[0] = lines[i][m.width:]
+		}
+		for i++; i < len(lines); i++ {
+			line := lines[i]
+			if isBlank(line) {
+				// A blank line continues the item only if the next content is indented into it.
+				next := i + 1
+				for next < len(lines) && isBlank(lines[next]) {
+					next++
+				}
+				if next == len(lines) || indent(lines[next]) < m.width {
+					break
+				}
+				item = append(item, "")
+				continue
+			}
+			if indent(line) >= m.width {
+				item = append(item, dedent(line, m.width))
+				continue
+			}
+			// Lines continuing the item's paragraph need no indentation.
+			if startsBlock(line) || isBlank(item[len(item)-1]) {
+				break
+			}
+			item = append(item, strings.TrimLeft(line, " "))
+		}
+		for j := 1; j < len(item); j++ {
+			loose = loose || (isBlank(item[j-1]) && !isBlank(item[j]))
+		}
+		items = append(items, item)
+
+		// Items separated by blank lines make the list loose.
+		next := i
+		for next < len(lines) && isBlank(lines[next]) {
+			next++
+		}
+		if next > i && next < len(lines) {
+			if m, ok := parseListMarker(lines[next]); ok && m.ordered == first.ordered && m.delim == first.delim {
+				loose = true
+			}
+		}
+		i = next
+	}
+
+	open, close := "<ul>\n", "</ul>\n"
+	if first.ordered {
+		open, close = "<ol>\n", "</ol>\n"
+		if first.start != 1 {
+			open = `<ol start="` + strconv.Itoa(first.start) + `">` + "\n"
+		}
+	}
+	w.tag(open)
+	for _, item := range items {
+		w.tag("<li>")
+		w.blocks(item, !loose, depth+1)
+		w.tag("</li>\n")
+	}
+	w.tag(close)
+	return i
+}
+
+// inline renders the inline markup of s.
+func (w *writer) inline(s string, depth int) {
+	if depth >= MAX_DEPTH {
+		w.write(s)
+		return
+	}
+
+	// unclosed records the delimiter runs found to have no closer, which none at a later
+	// position can have either, so that unclosed delimiters do not make rendering quadratic.
+	unclosed := map[string]bool{}
+	// linkBudget bounds the bytes scanned for links that turn out not to be, after which
+	// brackets are text, so that unclosed ones do not make rendering quadratic.
+	linkBudget := len(s) + maxLinkScan
+	plain := 0
+	flush := func(end int) {
+		if end > plain {
+			w.write(s[plain:end])
+		}
+	}
+
+	for i := 0; i < len(s); {
+		c := s[i]
+		switch c {
+		case '\\':
+			if i+1 < len(s) && isPunct(s[i+1]) {
+				flush(i)
+				w.write(s[i+1 : i+2])
+				i += 2
+				plain = i
+				continue
+			}
+			if i+1 < len(s) && s[i+1] == '\n' {
+				flush(i)
+				w.tag("<br>")
+				w.write("\n")
+				i += 2
+				plain = i
+				continue
+			}
+
+		case '\n':
+			// Two or more trailing spaces make a hard line break.
+			end := i
+			for end > plain && s[end-1] == ' ' {
+				end--
+			}
+			flush(end)
+			if i-end >= 2 {
+				w.tag("<br>")
+			}
+			w.write("\n")
+			i++
+			plain = i
+			continue
+
+		case '`':
+			n := runLength(s, i, '`')
+			key := strings.Repeat("`", n)
+			if !unclosed[key] {
+				if end := closingBackticks(s, i+n, n); end >= 0 {
+					flush(i)
+					w.codeSpan(s[i+n : end])
+					i = end + n
+					plain = i
+					continue
+				}
+				unclosed[key] = true
+			}
+			i += n
+			continue
+
+		case '!', '[':
+			start := i
+			if c == '!' {
+				if i+1 >= len(s) || s[i+1] != '[' {
+					break
+				}
+				start++
+			}
+			if linkBudget <= 0 {
+				break
+			}
+			l, scanned, ok := parseLink(s, start)
+			if ok {
+				flush(i)
+				if c == '!' {
+					w.image(l)
+				} else {
+					w.link(l, depth)
+				}
+				i = l.end
+				plain = i
+				continue
+			}
+			linkBudget -= scanned
+
+		case '<':
+			if url, end, ok := autolink(s, i); ok {
+				flush(i)
+				w.tag(`<a href="` + html.EscapeString(url) + `" rel="nofollow noopener">`)
+				w.write(s[i+1 : end-1])
+				w.tag("</a>")
+				i = end
+				plain = i
+				continue
+			}
+
+		case '*', '_':
+			n := runLength(s, i, c)
+			if end, size, ok := w.emphasis(s, i, n, unclosed); ok {
+				flush(i)
+				switch size {
+				case 1:
+					w.tag("<em>")
+				case 2:
+					w.tag("<strong>")
+				default:
+					w.tag("<em><strong>")
+				}
+				w.inline(s[i+size:end], depth+1)
+				switch size {
+				case 1:
+					w.tag("</em>")
+				case 2:
+					w.tag("</strong>")
+				default:
+					w.tag("</strong></em>")
+				}
+				i = end + size
+				plain = i
+				continue
+			}
+			i += n
+			continue
+		}
+		i++
+	}
+	flush(len(s))
+}
+
+func isPunct(c byte) bool {
+	return c < utf8.RuneSelf && unicode.IsPunct(rune(c)) || strings.IndexByte("$+<=>^`|~", c) >= 0
+}
+
+func runLength(s string, i int, c byte) int {
+	n := 0
+	for i+n < len(s) && s[i+n] == c {
+		n++
+	}
+	return n
+}
+
+// closingBackticks returns the index of the next run of exactly n backticks from i, or -1.
+func closingBackticks(s string, i, n int) int {
+	for i < len(s) {
+		j := strings.IndexByte(s[i:], '`')
+		if j < 0 {
+			return -1
+		}
+		i += j
+		run := runLength(s, i, '`')
+		if run == n {
+			return i
+		}
+		i += run
+	}
+	return -1
+}
+
+func (w *writer) codeSpan(code string) {
+	code = strings.ReplaceAll(code, "\n", " ")
+	if len(code) >= 2 && code[0] == ' ' && code[len(code)-1] == ' ' && strings.Trim(code, " ") != "" {
+		code = code[1 : len(code)-1]
+	}
+	w.tag("<code>")
+	w.write(code)
+	w.tag("</code>")
+}
+
+func isSpace(r rune) bool {
+	return r == utf8.RuneError || unicode.IsSpace(r)
+}
+
+func isAlnum(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+// emphasis returns the index of the closing run of the emphasis opened by the run of n
+// delimiters at i, and the size of the emphasis: 1 for <em>, 2 for <strong>, and 3 for both.
+func (w *writer) emphasis(s string, i, n int, unclosed map[string]bool) (int, int, bool) {
+	c := s[i]
+	next, _ := utf8.DecodeRuneInString(s[i+n:])
+	prev, _ := utf8.DecodeLastRuneInString(s[:i])
+	if i+n == len(s) || isSpace(next) || (c == '_' && i > 0 && isAlnum(prev)) {
+		return 0, 0, false
+	}
+
+	size := n
+	if size > 3 {
+		size = 3
+	}
+	for ; size > 0; size-- {
+		key := strings.Repeat(string(c), size)
+		if unclosed[key] {
+			continue
+		}
+		if end := closingDelimiter(s, i+size, c, size); end >= 0 {
+			return end, size, true
+		}
+		unclosed[key] = true
+	}
+	return 0, 0, false
+}
+
+// closingDelimiter returns the index of the next run of c, of exactly size delimiters, that
+// can close emphasis, skipping code spans and escaped characters, or -1.
+func closingDelimiter(s string, i int, c byte, size int) int {
+	start := i
+	for i < len(s) {
+		switch s[i] {
+		case '\\':
+			i += 2
+			continue
+		case '`':
+			n := runLength(s, i, '`')
+			if end := closingBackticks(s, i+n, n); end >= 0 {
+				i = end + n
+			} else {
+				i += n
+			}
+			continue
+		case c:
+			n := runLength(s, i, c)
+			prev, _ := utf8.DecodeLastRuneInString(s[:i])
+			next, _ := utf8.DecodeRuneInString(s[i+n:])
+			if n == size && i > start && !isSpace(prev) && (c != '_' || i+n == len(s) || !isAlnum(next)) {
+				return i
+			}
+			i += n
+			continue
+		}
+		i++
+	}
+	return -1
+}
+
+// link is a parsed inline link or image.
+type link struct {
+	text  string
+	url   string
+	title string
+	// end is the index following the link.
+	end int
+}
+
+// parseLink parses the inline link whose text opens with the '[' at i. If there is none, it
+// returns how many bytes it scanned for one.
+func parseLink(s string, i int) (link, int, bool) {
+	l := link{}
+	depth := 0
+	textEnd := -1
+	limit := i + maxLinkScan
+	if limit > len(s) {
+		limit = len(s)
+	}
+	j := i + 1
+	for ; j < limit && textEnd < 0; j++ {
+		switch s[j] {
+		case '\\':
+			j++
+		case '[':
+			depth++
+		case ']':
+			if depth == 0 {
+				textEnd = j
+			}
+			depth--
+		}
+	}
+	if textEnd < 0 || textEnd+1 >= len(s) || s[textEnd+1] != '(' {
+		return l, j - i, false
+	}
+	l.text = s[i+1 : textEnd]
+
+	j = skipSpaces(s, textEnd+2)
+	if limit = j + maxLinkScan; limit > len(s) {
+		limit = len(s)
+	}
+	if j < len(s) && s[j] == '<' {
+		end := strings.IndexAny(s[j+1:limit], ">\n")
+		if end < 0 || s[j+1+end] != '>' {
+			return l, limit - i, false
+		}
+		l.url = s[j+1 : j+1+end]
+		j += end + 2
+	} else {
+		start, parens := j, 0
+		for ; j < limit; j++ {
+			if c := s[j]; c == '\\' && j+1 < len(s) && isPunct(s[j+1]) {
+				j++
+			} else if c == '(' {
+				parens++
+			} else if c == ')' {
+				if parens == 0 {
+					break
+				}
+				parens--
+			} else if c == ' ' || c == '\n' || c < 0x20 {
+				break
+			}
+		}
+		l.url = unescape(s[start:j])
+	}
+
+	j = skipSpaces(s, j)
+	if limit = j + maxLinkScan; limit > len(s) {
+		limit = len(s)
+	}
+	if j < len(s) && (s[j] == '"' || s[j] == '\'') {
+		end := strings.IndexByte(s[j+1:limit], s[j])
+		if end < 0 {
+			return l, limit - i, false
+		}
+		l.title = unescape(s[j+1 : j+1+end])
+		j = skipSpaces(s, j+end+2)
+	}
+	if j >= len(s) || s[j] != ')' {
+		return l, j - i, false
+	}
+	l.end = j + 1
+	return l, 0, true
+}
+
+func skipSpaces(s string, i int) int {
+	for i < len(s) && (s[i] == ' ' || s[i] == '\n') {
+		i++
+	}
+	return i
+}
+
+// unescape removes the backslashes escaping punctuation.
+func unescape(s string) string {
+	if strings.IndexByte(s, '\\') < 0 {
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+1 < len(s) && isPunct(s[i+1]) {
+			i++
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
+
+// safeURL reports whether the url is relative or has an allowed scheme.
+func safeURL(url string) bool {
+	colon := strings.IndexByte(url, ':')
+	if colon < 0 || strings.ContainsAny(url[:colon], "/?#") {
+		return true
+	}
+	return allowedSchemes[strings.ToLower(url[:colon])]
+}
+
+func (w *writer) link(l link, depth int) {
+	if !safeURL(l.url) {
+		w.inline(l.text, depth+1)
+		return
+	}
+
+	w.tag(`<a href="` + html.EscapeString(l.url) + `"`)
+	if l.title != "" {
+		w.tag(` title="` + html.EscapeString(l.title) + `"`)
+	}
+	w.tag(` rel="nofollow noopener">`)
+	w.inline(l.text, depth+1)
+	w.tag("</a>")
+}
+
+// image writes the image to the HTML only, as the text of its alt is not part of excerpts.
+func (w *writer) image(l link) {
+	// The alt is the plain text of the image's description.
+	alt := &writer{}
+	alt.inline(l.text, MAX_DEPTH-1)
+	if !safeURL(l.url) {
+		w.tag(html.EscapeString(alt.text.String()))
+		return
+	}
+
+	w.tag(`<img src="` + html.EscapeString(l.url) + `" alt="` + html.EscapeString(alt.text.String()) + `"`)
+	if l.title != "" {
+		w.tag(` title="` + html.EscapeString(l.title) + `"`)
+	}
+	w.tag(">")
+}
+
+// autolink returns the url of the autolink opening with the '<' at i, and the index after it.
+func autolink(s string, i int) (string, int, bool) {
+	limit := i + 1 + maxLinkScan
+	if limit > len(s) {
+		limit = len(s)
+	}
+	end := strings.IndexAny(s[i+1:limit], "<> \n")
+	if end <= 0 || s[i+1+end] != '>' {
+		return "", 0, false
+	}
+	content := s[i+1 : i+1+end]
+	url := content
+	if colon := strings.IndexByte(content, ':'); colon < 0 {
+		if at := strings.IndexByte(content, '@'); at <= 0 || at == len(content)-1 {
+			return "", 0, false
+		}
+		url = "mailto:" + content
+	} else if colon == 0 || !allowedSchemes[strings.ToLower(content[:colon])] {
+		return "", 0, false
+	}
+	return url, i + end + 2, true
+}
@@ -0,0 +1,138 @@
+package markdown
+
+import (
+	"html"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestRender(t *testing.T) {
+	Convey("Render tests", t, func() {
+		render := func(src string) string {
+			html, _ := Render(src)
+			return html
+		}
+
+		Convey("Blocks are rendered", func() {
+			So(render("# Title #\n\nsome\ntext  \nbroken\\\ntwice"), ShouldEqual,
+				"<h1>Title</h1>\n<p>some\ntext<br>\nbroken<br>\ntwice</p>\n")
+			So(render("> quoted\ncontinued\n> > nested"), ShouldEqual,
+				"<blockquote>\n<p>quoted\ncontinued</p>\n<blockquote>\n<p>nested</p>\n</blockquote>\n</blockquote>\n")
+			So(render("```go onclick=x\nif a < b {}\n```\n\n    indented\n\n---"), ShouldEqual,
+				"<pre><code class=\"language-go\">if a &lt; b {}\n</code></pre>\n<pre><code>indented\n</code></pre>\n<hr>\n")
+			So(render("```\"><script>\nx\n```"), ShouldEqual, "<pre><code class=\"language-script\">x\n</code></pre>\n")
+		})
+
+		Convey("Lists are nested, and loose if their items are separated by blank lines", func() {
+			So(render("- a\n- b\n  - c\n- d"), ShouldEqual,
+				"<ul>\n<li>a</li>\n<li>b\n<ul>\n<li>c</li>\n</ul>\n</li>\n<li>d</li>\n</ul>\n")
+			So(render("3. a\n\n4. b"), ShouldEqual, "<ol start=\"3\">\n<li><p>a</p>\n</li>\n<li><p>b</p>\n</li>\n</ol>\n")
+			So(render("1) a\n- b"), ShouldEqual, "<ol>\n<li>a</li>\n</ol>\n<ul>\n<li>b</li>\n</ul>\n")
+		})
+
+		Convey("Inline markup is rendered", func() {
+			So(render("*a* _b_ **c** __d__ ***e*** snake_case_name"), ShouldEqual,
+				"<p><em>a</em> <em>b</em> <strong>c</strong> <strong>d</strong> <em><strong>e</strong></em> snake_case_name</p>\n")
+			So(render("`a *b* <c>` ``d`e`` \\*f\\* *unclosed"), ShouldEqual,
+				"<p><code>a *b* &lt;c&gt;</code> <code>d`e</code> *f* *unclosed</p>\n")
+			So(render("[a *b*](https://x.io/a_(b) \"t\") ![c](/c.png) <https://y.io> <me@z.io>"), ShouldEqual,
+				`<p><a href="https://x.io/a_(b)" title="t" rel="nofollow noopener">a <em>b</em></a> <img src="/c.png" alt="c"> `+
+					`<a href="https://y.io" rel="nofollow noopener">https://y.io</a> <a href="mailto:me@z.io" rel="nofollow noopener">me@z.io</a></p>`+"\n")
+		})
+
+		Convey("Html and unsafe urls are escaped or dropped", func() {
+			So(render("<script>alert(1)</script> <img src=x onerror=alert(1)>"), ShouldEqual,
+				"<p>&lt;script&gt;alert(1)&lt;/script&gt; &lt;img src=x onerror=alert(1)&gt;</p>\n")
+			So(render("[a](javascript:alert(1)) [b](JavaScript:x) ![c](data:image/png;base64,x) <javascript:x>"), ShouldEqual,
+				"<p>a b c &lt;javascript:x&gt;</p>\n")
+			So(render(`[a](/x" onclick="y)`), ShouldEqual, "<p>[a](/x&#34; onclick=&#34;y)</p>\n")
+			So(render(`[a](/x 't"><script>')`), ShouldEqual,
+				`<p><a href="/x" title="t&#34;&gt;&lt;script&gt;" rel="nofollow noopener">a</a></p>`+"\n")
+		})
+
+		Convey("The text has no markup or code blocks", func() {
+			_, text := Render("# Title\n\nSome *text* with [a link](https://x.io) & `code`.\n\n```\nblock\n```\n\n- item")
+			So(text, ShouldEqual, "Title\nSome text with a link & code.\nitem")
+		})
+
+		Convey("Deep nesting and unclosed markup render in linear time", func() {
+			for _, unit := range []string{"> ", "- ", "[", "[a](", "[a](<x", "*a ", "**a _b ", "`", "<a"} {
+				start := time.Now()
+				html, _ := Render(strings.Repeat(unit, (1<<20)/len(unit)))
+				So(html, ShouldNotBeEmpty)
+				So(time.Since(start), ShouldBeLessThan, 5*time.Second)
+			}
+		})
+	})
+}
+
+func TestExcerpt(t *testing.T) {
+	Convey("Excerpts are cut at word boundaries", t, func() {
+		So(Excerpt("  short \n text ", 20), ShouldEqual, "short text")
+		So(Excerpt("héllo wörld again", 11), ShouldEqual, "héllo wörld…")
+		So(Excerpt("héllo wörld again", 10), ShouldEqual, "héllo…")
+		So(Excerpt("abcdefgh", 3), ShouldEqual, "abc…")
+		So(Excerpt("", 3), ShouldEqual, "")
+	})
+}
+
+var (
+	// tagPattern matches a tag as Render writes them, with only quoted attributes.
+	tagPattern  = regexp.MustCompile(`^</?[a-z][a-z0-9]*((?:\s+[a-z-]+="[^"<>]*")*)\s*>`)
+	attrPattern = regexp.MustCompile(`([a-z-]+)="([^"]*)"`)
+	// renderedAttrs are the attributes Render writes.
+	renderedAttrs = map[string]bool{"href": true, "src": true, "alt": true, "title": true, "rel": true, "class": true, "start": true}
+)
+
+// FuzzRender checks that no source renders a script, an event handler attribute, or a
+// javascript url, however malformed.
+func FuzzRender(f *testing.F) {
+	for _, src := range []string{
+		"# Title #\n\nsome\ntext  \nbroken\\\ntwice",
+		"> quoted\n> > nested\n- a\n  - b\n3. c",
+		"```go onclick=x\nif a < b {}\n```",
+		"```\"><script>\nx\n```",
+		"*a* **b** `c` [d](https://x.io \"t\") ![e](/e.png) <https://y.io> <me@z.io>",
+		"<script>alert(1)</script> <img src=x onerror=alert(1)>",
+		"[a](javascript:alert(1)) [b](JavaScript:x) ![c](data:x) <javascript:x>",
+		"[a](java\tscript:x) [b](&#106;avascript:x) [c](<javascript:x>) [d](/x \"\" onclick=\"y\")",
+		`[a](/x" onclick="y) [b](/x 't"><script>')`,
+	} {
+		f.Add(src)
+	}
+
+	f.Fuzz(func(t *testing.T, src string) {
+		out, _ := Render(src)
+		if strings.Contains(strings.ToLower(out), "<script") {
+			t.Fatalf("%q rendered a script: %q", src, out)
+		}
+		for i := strings.IndexByte(out, '<'); i >= 0; i = strings.IndexByte(out, '<') {
+			out = out[i:]
+			tag := tagPattern.FindStringSubmatch(out)
+			if tag == nil {
+				t.Fatalf("%q rendered a malformed tag: %q", src, out)
+			}
+			for _, attr := range attrPattern.FindAllStringSubmatch(tag[1], -1) {
+				name, value := attr[1], html.UnescapeString(attr[2])
+				if strings.HasPrefix(name, "on") || !renderedAttrs[name] {
+					t.Fatalf("%q rendered the attribute %s: %q", src, name, tag[0])
+				}
+				// Browsers ignore tabs and newlines in urls, and whitespace before them.
+				url := strings.ToLower(strings.Map(func(r rune) rune {
+					if r <= ' ' {
+						return -1
+					}
+					return r
+				}, value))
+				if (name == "href" || name == "src") && strings.HasPrefix(url, "javascript:") {
+					t.Fatalf("%q rendered a javascript url: %q", src, tag[0])
+				}
+			}
+			out = out[len(tag[0]):]
+		}
+	})
+}
@@ -0,0 +1,104 @@
+// Package outbox implements a transactional outbox: writers insert an Event row in the same
+// transaction as the change it describes, and a Relay publishes pending events through a
+// Publisher. Delivery is at-least-once; an event is deleted only after it was published,
+// so a crash between publishing and deleting publishes it again. Consumers should dedupe
+// on Message.ID.
+package outbox
+
+import (
+	"encoding/json"
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// ErrInvalidPayload is returned by Enqueue for payloads that are not valid json.
+var ErrInvalidPayload = errors.New("outbox: payload is not valid json")
+
+// Event is a pending outbox row.
+type Event struct {
+	ID uint64 `gorm:"primaryKey;autoIncrement"`
+	// Tenant owns the changed entity; empty for single-tenant writers.
+	Tenant string `gorm:"size:64"`
+	// Type names the change, e.g. 'post.created'.
+	Type string `gorm:"not null"`
+	// Key identifies the changed entity, e.g. the post-id.
+	Key       string `gorm:"not null"`
+	Payload   []byte `gorm:"not null"`
+	CreatedAt time.Time
+	// Attempts counts failed publish attempts.
+	Attempts      int
+	NextAttemptAt time.Time `gorm:"index;not null"`
+	LastError     string
+}
+
+// TableName keeps the table name explicit, since 'events' is too generic for a shared db.
+func (Event) TableName() string {
+	return "outbox_events"
+}
+
+// DeadLetter is an event that could not be published after the relay's max attempts, or
+// whose publisher reported a Permanent error. See Redrive to publish them again.
+type DeadLetter struct {
+	// ID is the id of the original event, and remains the message id if redriven.
+	ID        uint64 `gorm:"primaryKey"`
+	Tenant    string `gorm:"size:64"`
+	Type      string `gorm:"not null"`
+	Key       string `gorm:"not null"`
+	Payload   []byte `gorm:"not null"`
+	CreatedAt time.Time
+	Attempts  int
+	LastError string
+	FailedAt  time.Time `gorm:"index"`
+}
+
+func (DeadLetter) TableName() string {
+	return "outbox_dead_letters"
+}
+
+// Models are the gorm models the outbox needs migrated.
+var Models = []interface{}{
+	&Event{},
+	&DeadLetter{},
+}
+
+// Message is an event as handed to publishers.
+type Message struct {
+	ID        uint64          `json:"id"`
+	Tenant    string          `json:"tenant,omitempty"`
+	Type      string          `json:"type"`
+	Key       string          `json:"key"`
+	Payload   json.RawMessage `json:"payload"`
+	CreatedAt time.Time       `json:"created_at"`
+}
+
+func (e *Event) message() Message {
+	return Message{
+		ID:        e.ID,
+		Tenant:    e.Tenant,
+		Type:      e.Type,
+		Key:       e.Key,
+		Payload:   json.RawMessage(e.Payload),
+		CreatedAt: e.CreatedAt,
+	}
+}
+
+// Enqueue inserts an event into the outbox. Pass the transaction of the change the event
+// describes, so that the event is recorded if and only if the change commits.
+// The payload must be valid json.
+func Enqueue(tx *gorm.DB, tenant, typ, key string, payload []byte) error {
+	if !json.Valid(payload) {
+		return ErrInvalidPayload
+	}
+
+	now := time.Now()
+	return tx.Create(&Event{
+		Tenant:        tenant,
+		Type:          typ,
+		Key:           key,
+		Payload:       payload,
+		CreatedAt:     now,
+		NextAttemptAt: now,
+	}).Error
+}
@@ -0,0 +1,243 @@
+package outbox
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+func openDB(t *testing.T) *gorm.DB {
+	path := filepath.Join(t.TempDir(), "outbox.db")
+	db, err := gorm.Open(sqlite.Open(path), &gorm.Config{Logger: logger.Discard})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.AutoMigrate(Models...); err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+// flakyPublisher fails each message the first failures times, with err.
+type flakyPublisher struct {
+	MemoryPublisher
+	failures int
+	err      error
+
+	mu       sync.Mutex
+	attempts map[uint64]int
+}
+
+func (p *flakyPublisher) Publish(ctx context.Context, msg Message) error {
+	p.mu.Lock()
+	p.attempts[msg.ID]++
+	n := p.attempts[msg.ID]
+	p.mu.Unlock()
+
+	if n <= p.failures {
+		return p.err
+	}
+	return p.MemoryPublisher.Publish(ctx, msg)
+}
+
+func count(db *gorm.DB, model interface{}) int64 {
+	var n int64
+	So(db.Model(model).Count(&n).Error, ShouldBeNil)
+	return n
+}
+
+func TestRelay(t *testing.T) {
+	Convey("Relay tests", t, func() {
+		db := openDB(t)
+		ctx := context.Background()
+		pub := &flakyPublisher{attempts: map[uint64]int{}, err: errors.New("downstream unavailable")}
+		newRelay := func(opts ...RelayOption) *Relay {
+			return NewRelay(db, pub, append([]RelayOption{WithBackoff(0, 0)}, opts...)...)
+		}
+
+		So(Enqueue(db, "blog", "post.created", "1", []byte(`{"id":"1"}`)), ShouldBeNil)
+		So(Enqueue(db, "blog", "post.deleted", "1", []byte(`{"id":"1"}`)), ShouldBeNil)
+
+		Convey("Events are published in order and removed", func() {
+			r := newRelay()
+			n, err := r.Poll(ctx)
+			So(err, ShouldBeNil)
+			So(n, ShouldEqual, 2)
+
+			msgs := pub.Messages()
+			So(msgs, ShouldHaveLength, 2)
+			So(msgs[0].Type, ShouldEqual, "post.created")
+			So(msgs[0].Tenant, ShouldEqual, "blog")
+			So(msgs[1].Type, ShouldEqual, "post.deleted")
+			So(string(msgs[0].Payload), ShouldEqual, `{"id":"1"}`)
+			So(count(db, &Event{}), ShouldEqual, 0)
+			So(r.Metrics().Published, ShouldEqual, 2)
+		})
+
+		Convey("Failed events are retried", func() {
+			pub.failures = 2
+			r := newRelay()
+			for i := 0; i < 3; i++ {
+				_, err := r.Poll(ctx)
+				So(err, ShouldBeNil)
+			}
+
+			So(pub.Messages(), ShouldHaveLength, 2)
+			So(count(db, &Event{}), ShouldEqual, 0)
+			m := r.Metrics()
+			So(m.Failed, ShouldEqual, 4)
+			So(m.Published, ShouldEqual, 2)
+		})
+
+		Convey("Failed events wait for their backoff", func() {
+			pub.failures = 1
+			r := NewRelay(db, pub, WithBackoff(time.Hour, time.Hour))
+			r.Poll(ctx)
+			n, err := r.Poll(ctx)
+			So(err, ShouldBeNil)
+			So(n, ShouldEqual, 0)
+
+			event := &Event{}
+			So(db.First(event).Error, ShouldBeNil)
+			So(event.Attempts, ShouldEqual, 1)
+			So(event.LastError, ShouldEqual, "downstream unavailable")
+		})
+
+		Convey("Events are dead-lettered after max attempts, and may be redriven", func() {
+			pub.failures = 3
+			r := newRelay(WithMaxAttempts(3))
+			for i := 0; i < 3; i++ {
+				r.Poll(ctx)
+			}
+
+			So(pub.Messages(), ShouldHaveLength, 0)
+			So(count(db, &Event{}), ShouldEqual, 0)
+			So(count(db, &DeadLetter{}), ShouldEqual, 2)
+			So(r.Metrics().DeadLettered, ShouldEqual, 2)
+
+			moved, err := Redrive(ctx, db, 1)
+			So(err, ShouldBeNil)
+			So(moved, ShouldEqual, 1)
+			r.Poll(ctx)
+			msgs := pub.Messages()
+			So(msgs, ShouldHaveLength, 1)
+			So(msgs[0].ID, ShouldEqual, 1)
+			So(count(db, &DeadLetter{}), ShouldEqual, 1)
+		})
+
+		Convey("Permanent errors are dead-lettered immediately", func() {
+			pub.failures = 1
+			pub.err = Permanent(errors.New("bad request"))
+			r := newRelay()
+			r.Poll(ctx)
+
+			So(count(db, &DeadLetter{}), ShouldEqual, 2)
+			dead := &DeadLetter{}
+			So(db.First(dead).Error, ShouldBeNil)
+			So(dead.Attempts, ShouldEqual, 1)
+			So(dead.LastError, ShouldEqual, "bad request")
+		})
+
+		Convey("Invalid payloads are rejected", func() {
+			So(Enqueue(db, "", "post.created", "1", []byte("{")), ShouldEqual, ErrInvalidPayload)
+		})
+	})
+}
+
+func TestPublishers(t *testing.T) {
+	Convey("Publisher tests", t, func() {
+		ctx := context.Background()
+		msg := Message{ID: 7, Tenant: "blog", Type: "post.updated", Key: "123", Payload: json.RawMessage(`{"id":"123"}`)}
+
+		Convey("The file publisher appends ndjson", func() {
+			path := filepath.Join(t.TempDir(), "events.ndjson")
+			pub, err := NewPublisher(PUBLISHER_FILE, path)
+			So(err, ShouldBeNil)
+			So(pub.Publish(ctx, msg), ShouldBeNil)
+			So(pub.Publish(ctx, msg), ShouldBeNil)
+			So(pub.(*FilePublisher).Close(), ShouldBeNil)
+
+			f, err := os.Open(path)
+			So(err, ShouldBeNil)
+			defer f.Close()
+			lines := 0
+			for sc := bufio.NewScanner(f); sc.Scan(); lines++ {
+				got := Message{}
+				So(json.Unmarshal(sc.Bytes(), &got), ShouldBeNil)
+				So(got.ID, ShouldEqual, 7)
+				So(string(got.Payload), ShouldEqual, `{"id":"123"}`)
+			}
+			So(lines, ShouldEqual, 2)
+		})
+
+		Convey("The file publisher recovers from a failed write", func() {
+			path := filepath.Join(t.TempDir(), "events.ndjson")
+			pub, err := NewFilePublisher(path)
+			So(err, ShouldBeNil)
+			defer pub.Close()
+
+			// A file opened read-only fails every write, like a full disk would.
+			file := pub.file
+			pub.file, err = os.Open(path)
+			So(err, ShouldBeNil)
+			So(pub.Publish(ctx, msg), ShouldNotBeNil)
+			pub.file.Close()
+			pub.file = file
+
+			So(pub.Publish(ctx, msg), ShouldBeNil)
+			b, err := os.ReadFile(path)
+			So(err, ShouldBeNil)
+			So(bytes.Count(b, []byte("\n")), ShouldEqual, 1)
+		})
+
+		Convey("The webhook publisher posts json and classifies failures", func() {
+			code := http.StatusOK
+			var got Message
+			var header http.Header
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				header = r.Header
+				json.NewDecoder(r.Body).Decode(&got)
+				w.WriteHeader(code)
+			}))
+			defer srv.Close()
+
+			pub, err := NewPublisher(PUBLISHER_WEBHOOK, srv.URL)
+			So(err, ShouldBeNil)
+			So(pub.Publish(ctx, msg), ShouldBeNil)
+			So(got.Key, ShouldEqual, "123")
+			So(header.Get(EVENT_ID_HEADER), ShouldEqual, "7")
+			So(header.Get(EVENT_TYPE_HEADER), ShouldEqual, "post.updated")
+			So(header.Get(TENANT_HEADER), ShouldEqual, "blog")
+
+			code = http.StatusServiceUnavailable
+			err = pub.Publish(ctx, msg)
+			So(err, ShouldNotBeNil)
+			So(IsPermanent(err), ShouldBeFalse)
+
+			code = http.StatusTooManyRequests
+			So(IsPermanent(pub.Publish(ctx, msg)), ShouldBeFalse)
+
+			code = http.StatusBadRequest
+			So(IsPermanent(pub.Publish(ctx, msg)), ShouldBeTrue)
+		})
+
+		Convey("Unknown publishers are rejected", func() {
+			_, err := NewPublisher("kafka", "")
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
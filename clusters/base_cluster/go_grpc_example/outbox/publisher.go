@@ -0,0 +1,198 @@
+package outbox
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	PUBLISHER_MEMORY  = "memory"
+	PUBLISHER_FILE    = "file"
+	PUBLISHER_WEBHOOK = "webhook"
+
+	WEBHOOK_TIMEOUT_DEFAULT = 10 * time.Second
+	// EVENT_ID_HEADER carries Message.ID on webhook requests, for consumers to dedupe on.
+	EVENT_ID_HEADER   = "X-Event-Id"
+	EVENT_TYPE_HEADER = "X-Event-Type"
+	// TENANT_HEADER carries Message.Tenant on webhook requests, if set.
+	TENANT_HEADER = "X-Tenant-Id"
+)
+
+// Publisher delivers messages downstream. Publish may be called again with the same message
+// after a failure or crash, so it need not be exactly-once. An error wrapped with Permanent
+// dead-letters the message immediately; any other error is retried with backoff.
+type Publisher interface {
+	Publish(ctx context.Context, msg Message) error
+}
+
+// NewPublisher returns a publisher of the passed kind: memory (target unused),
+// file (target is the ndjson file path), or webhook (target is the url).
+func NewPublisher(kind, target string) (Publisher, error) {
+	switch kind {
+	case PUBLISHER_MEMORY:
+		return NewMemoryPublisher(), nil
+	case PUBLISHER_FILE:
+		return NewFilePublisher(target)
+	case PUBLISHER_WEBHOOK:
+		if target == "" {
+			return nil, errors.New("webhook publisher requires a url")
+		}
+		return NewWebhookPublisher(target), nil
+	default:
+		return nil, fmt.Errorf("unknown publisher %q, must be one of: %s, %s, %s",
+			kind, PUBLISHER_MEMORY, PUBLISHER_FILE, PUBLISHER_WEBHOOK)
+	}
+}
+
+// permanentErr marks errors that retrying cannot fix.
+type permanentErr struct {
+	err error
+}
+
+func (e *permanentErr) Error() string { return e.err.Error() }
+func (e *permanentErr) Unwrap() error { return e.err }
+
+// Permanent wraps err to tell the relay not to retry the message.
+func Permanent(err error) error {
+	return &permanentErr{err: err}
+}
+
+// IsPermanent reports whether err was wrapped with Permanent.
+func IsPermanent(err error) bool {
+	var p *permanentErr
+	return errors.As(err, &p)
+}
+
+// MemoryPublisher keeps published messages in memory. It is meant for tests and development,
+// since nothing ever removes the messages.
+type MemoryPublisher struct {
+	mu       sync.Mutex
+	messages []Message
+}
+
+func NewMemoryPublisher() *MemoryPublisher {
+	return &MemoryPublisher{}
+}
+
+func (p *MemoryPublisher) Publish(_ context.Context, msg Message) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.messages = append(p.messages, msg)
+	return nil
+}
+
+// Messages returns a copy of the messages published so far, in publish order.
+func (p *MemoryPublisher) Messages() []Message {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]Message(nil), p.messages...)
+}
+
+// FilePublisher appends each message to a file as a line of json (ndjson).
+type FilePublisher struct {
+	mu   sync.Mutex
+	file *os.File
+}
+
+// NewFilePublisher opens path for appending, creating it if needed.
+func NewFilePublisher(path string) (*FilePublisher, error) {
+	if path == "" {
+		return nil, errors.New("file publisher requires a path")
+	}
+
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &FilePublisher{file: f}, nil
+}
+
+// Publish writes and syncs the message, so that it survives a crash once Publish returns.
+// Each line is written with a single unbuffered write, so that a failed publish leaves no
+// error behind to fail the next, as a bufio.Writer would.
+func (p *FilePublisher) Publish(_ context.Context, msg Message) error {
+	b, err := json.Marshal(msg)
+	if err != nil {
+		return Permanent(err)
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if _, err := p.file.Write(append(b, '\n')); err != nil {
+		return err
+	}
+	return p.file.Sync()
+}
+
+func (p *FilePublisher) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.file.Close()
+}
+
+// WebhookPublisher posts each message as json to a url. 2xx responses are success; 4xx
+// responses other than 408 and 429 are permanent failures; anything else is retried.
+type WebhookPublisher struct {
+	url    string
+	client *http.Client
+	// Header is added to every request, e.g. for an authorization token.
+	Header http.Header
+}
+
+func NewWebhookPublisher(url string) *WebhookPublisher {
+	return &WebhookPublisher{
+		url:    url,
+		client: &http.Client{Timeout: WEBHOOK_TIMEOUT_DEFAULT},
+		Header: http.Header{},
+	}
+}
+
+func (p *WebhookPublisher) Publish(ctx context.Context, msg Message) error {
+	b, err := json.Marshal(msg)
+	if err != nil {
+		return Permanent(err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.url, bytes.NewReader(b))
+	if err != nil {
+		return Permanent(err)
+	}
+	for k, vals := range p.Header {
+		req.Header[k] = vals
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(EVENT_ID_HEADER, strconv.FormatUint(msg.ID, 10))
+	req.Header.Set(EVENT_TYPE_HEADER, msg.Type)
+	if msg.Tenant != "" {
+		req.Header.Set(TENANT_HEADER, msg.Tenant)
+	}
+
+	res, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	// Drain the body so the connection can be reused.
+	io.Copy(io.Discard, io.LimitReader(res.Body, 1<<16))
+
+	if res.StatusCode >= 200 && res.StatusCode < 300 {
+		return nil
+	}
+
+	err = fmt.Errorf("webhook returned %s", res.Status)
+	if res.StatusCode >= 400 && res.StatusCode < 500 &&
+		res.StatusCode != http.StatusRequestTimeout && res.StatusCode != http.StatusTooManyRequests {
+		return Permanent(err)
+	}
+	return err
+}
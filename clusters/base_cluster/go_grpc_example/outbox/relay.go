@@ -0,0 +1,280 @@
+package outbox
+
+import (
+	"context"
+	"expvar"
+	"log"
+	"math"
+	"sync/atomic"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const (
+	BATCH_SIZE_DEFAULT      = 100
+	POLL_INTERVAL_DEFAULT   = time.Second
+	MAX_ATTEMPTS_DEFAULT    = 10
+	INITIAL_BACKOFF_DEFAULT = time.Second
+	MAX_BACKOFF_DEFAULT     = 5 * time.Minute
+	maxErrorLen             = 1024
+)
+
+// Relay publishes pending outbox events in id order, deleting each once published.
+// A failed event is retried with exponential backoff, and moved to the dead-letter table
+// after MaxAttempts failures. Retried events are published after newer ones, so consumers
+// should not rely on ordering across a failure.
+//
+// FUTURE: several replicas may each run a relay, which is safe but publishes some events
+// more than once. On postgres, claiming the batch with FOR UPDATE SKIP LOCKED would avoid that.
+type Relay struct {
+	db  *gorm.DB
+	pub Publisher
+	cfg relayConfig
+
+	published    atomic.Int64
+	failed       atomic.Int64
+	deadLettered atomic.Int64
+	pending      atomic.Int64
+	lastLatency  atomic.Int64
+}
+
+type relayConfig struct {
+	batchSize      int
+	pollInterval   time.Duration
+	maxAttempts    int
+	initialBackoff time.Duration
+	maxBackoff     time.Duration
+}
+
+// RelayOption configures a Relay.
+type RelayOption func(*relayConfig)
+
+// WithBatchSize sets the max number of events fetched per poll.
+func WithBatchSize(n int) RelayOption {
+	return func(c *relayConfig) { c.batchSize = n }
+}
+
+// WithPollInterval sets how long the relay sleeps once the outbox is drained.
+func WithPollInterval(d time.Duration) RelayOption {
+	return func(c *relayConfig) { c.pollInterval = d }
+}
+
+// WithMaxAttempts sets the number of failed attempts after which an event is dead-lettered.
+func WithMaxAttempts(n int) RelayOption {
+	return func(c *relayConfig) { c.maxAttempts = n }
+}
+
+// WithBackoff sets the wait after the first failure, which doubles per failure up to max.
+func WithBackoff(initial, max time.Duration) RelayOption {
+	return func(c *relayConfig) {
+		c.initialBackoff = initial
+		c.maxBackoff = max
+	}
+}
+
+func NewRelay(db *gorm.DB, pub Publisher, opts ...RelayOption) *Relay {
+	cfg := relayConfig{
+		batchSize:      BATCH_SIZE_DEFAULT,
+		pollInterval:   POLL_INTERVAL_DEFAULT,
+		maxAttempts:    MAX_ATTEMPTS_DEFAULT,
+		initialBackoff: INITIAL_BACKOFF_DEFAULT,
+		maxBackoff:     MAX_BACKOFF_DEFAULT,
+	}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	return &Relay{db: db, pub: pub, cfg: cfg}
+}
+
+// Run polls and publishes events until ctx is done. Database errors are logged and retried
+// on the next poll, so Run only returns ctx.Err().
+func (r *Relay) Run(ctx context.Context) error {
+	for {
+		n, err := r.Poll(ctx)
+		if err != nil && ctx.Err() == nil {
+			log.Printf("outbox relay poll failed: %v\n", err)
+		}
+
+		// A full batch likely means more are pending, so poll again right away.
+		wait := r.cfg.pollInterval
+		if err == nil && n == r.cfg.batchSize {
+			wait = 0
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(wait):
+		}
+	}
+}
+
+// Poll publishes one batch of due events and returns how many were attempted.
+func (r *Relay) Poll(ctx context.Context) (int, error) {
+	db := r.db.WithContext(ctx)
+
+	var pending int64
+	if err := db.Model(&Event{}).Count(&pending).Error; err != nil {
+		return 0, err
+	}
+	r.pending.Store(pending)
+
+	events := []Event{}
+	err := db.
+		Where("next_attempt_at <= ?", time.Now()).
+		Order("id").
+		Limit(r.cfg.batchSize).
+		Find(&events).Error
+	if err != nil {
+		return 0, err
+	}
+
+	for i := range events {
+		if err := r.publish(ctx, &events[i]); err != nil {
+			return i, err
+		}
+	}
+	return len(events), nil
+}
+
+// publish publishes a single event and records the outcome. Only database errors are returned.
+func (r *Relay) publish(ctx context.Context, event *Event) error {
+	start := time.Now()
+	pubErr := r.pub.Publish(ctx, event.message())
+	r.lastLatency.Store(int64(time.Since(start)))
+
+	db := r.db.WithContext(ctx)
+	if pubErr == nil {
+		r.published.Add(1)
+		return db.Delete(event).Error
+	}
+	if ctx.Err() != nil {
+		// Shutting down; the attempt does not count against the event.
+		return ctx.Err()
+	}
+
+	r.failed.Add(1)
+	event.Attempts++
+	event.LastError = truncate(pubErr.Error(), maxErrorLen)
+
+	if IsPermanent(pubErr) || event.Attempts >= r.cfg.maxAttempts {
+		log.Printf("outbox event %d (%s %s) dead-lettered after %d attempts: %v\n",
+			event.ID, event.Type, event.Key, event.Attempts, pubErr)
+		r.deadLettered.Add(1)
+		return db.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Create(&DeadLetter{
+				ID:        event.ID,
+				Tenant:    event.Tenant,
+				Type:      event.Type,
+				Key:       event.Key,
+				Payload:   event.Payload,
+				CreatedAt: event.CreatedAt,
+				Attempts:  event.Attempts,
+				LastError: event.LastError,
+				FailedAt:  time.Now(),
+			}).Error; err != nil {
+				return err
+			}
+			return tx.Delete(event).Error
+		})
+	}
+
+	event.NextAttemptAt = time.Now().Add(r.backoff(event.Attempts))
+	return db.
+		Model(event).
+		Updates(map[string]interface{}{
+			"attempts":        event.Attempts,
+			"last_error":      event.LastError,
+			"next_attempt_at": event.NextAttemptAt,
+		}).Error
+}
+
+// backoff returns the wait after the nth failure.
+func (r *Relay) backoff(n int) time.Duration {
+	d := float64(r.cfg.initialBackoff) * math.Pow(2, float64(n-1))
+	if d > float64(r.cfg.maxBackoff) {
+		return r.cfg.maxBackoff
+	}
+	return time.Duration(d)
+}
+
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	return s[:n]
+}
+
+// Metrics is a snapshot of relay counters since it was created.
+type Metrics struct {
+	Published    int64 `json:"published"`
+	Failed       int64 `json:"failed"`
+	DeadLettered int64 `json:"dead_lettered"`
+	// Pending is the number of events in the outbox as of the last poll.
+	Pending int64 `json:"pending"`
+	// LastPublishLatency is the duration of the most recent Publish call.
+	LastPublishLatency time.Duration `json:"last_publish_latency_ns"`
+}
+
+func (r *Relay) Metrics() Metrics {
+	return Metrics{
+		Published:          r.published.Load(),
+		Failed:             r.failed.Load(),
+		DeadLettered:       r.deadLettered.Load(),
+		Pending:            r.pending.Load(),
+		LastPublishLatency: time.Duration(r.lastLatency.Load()),
+	}
+}
+
+// PublishExpvar exposes the relay's metrics under name in expvar, i.e. /debug/vars.
+// Like expvar.Publish, it panics if name is already in use.
+func (r *Relay) PublishExpvar(name string) {
+	expvar.Publish(name, expvar.Func(func() interface{} {
+		return r.Metrics()
+	}))
+}
+
+// Redrive moves the passed dead letters back into the outbox, keeping their ids, and returns
+// how many were moved. With no ids, every dead letter is redriven.
+func Redrive(ctx context.Context, db *gorm.DB, ids ...uint64) (int64, error) {
+	var moved int64
+	err := db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		q := tx
+		if len(ids) > 0 {
+			q = q.Where("id IN ?", ids)
+		}
+
+		dead := []DeadLetter{}
+		if err := q.Find(&dead).Error; err != nil {
+			return err
+		}
+		if len(dead) == 0 {
+			return nil
+		}
+
+		now := time.Now()
+		events := make([]Event, 0, len(dead))
+		deadIDs := make([]uint64, 0, len(dead))
+		for _, d := range dead {
+			events = append(events, Event{
+				ID:            d.ID,
+				Tenant:        d.Tenant,
+				Type:          d.Type,
+				Key:           d.Key,
+				Payload:       d.Payload,
+				CreatedAt:     d.CreatedAt,
+				NextAttemptAt: now,
+			})
+			deadIDs = append(deadIDs, d.ID)
+		}
+		if err := tx.Create(&events).Error; err != nil {
+			return err
+		}
+
+		res := tx.Where("id IN ?", deadIDs).Delete(&DeadLetter{})
+		moved = res.RowsAffected
+		return res.Error
+	})
+	return moved, err
+}
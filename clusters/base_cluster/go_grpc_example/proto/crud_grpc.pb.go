@@ -25,14 +25,34 @@ const _ = grpc.SupportPackageIsVersion7
 type CrudServiceClient interface {
 	// Create a Post
 	CreatePost(ctx context.Context, in *Post, opts ...grpc.CallOption) (*PostID, error)
-	// Read a Post
+	// Read a Post of any status, including drafts, by its id
 	ReadPost(ctx context.Context, in *PostID, opts ...grpc.CallOption) (*Post, error)
 	// Update a Post
 	UpdatePost(ctx context.Context, in *Post, opts ...grpc.CallOption) (*empty.Empty, error)
 	// Delete a Post
 	DeletePost(ctx context.Context, in *PostID, opts ...grpc.CallOption) (*empty.Empty, error)
-	// List Posts
-	ListPosts(ctx context.Context, in *empty.Empty, opts ...grpc.CallOption) (CrudService_ListPostsClient, error)
+	// Read many Posts by id; missing ids are reported per result rather than failing the call
+	BatchGetPosts(ctx context.Context, in *BatchGetPostsRequest, opts ...grpc.CallOption) (*BatchGetPostsResponse, error)
+	// Publish a Post now or at a later time, returning the updated Post
+	PublishPost(ctx context.Context, in *PublishPostRequest, opts ...grpc.CallOption) (*Post, error)
+	// Return a Post to draft, e.g. to cancel its scheduled publishing
+	UnpublishPost(ctx context.Context, in *PostID, opts ...grpc.CallOption) (*Post, error)
+	// Archive a Post, which unlists it without deleting it
+	ArchivePost(ctx context.Context, in *PostID, opts ...grpc.CallOption) (*Post, error)
+	// List published Posts
+	ListPosts(ctx context.Context, in *ListPostsRequest, opts ...grpc.CallOption) (CrudService_ListPostsClient, error)
+	// Export all Posts, e.g. to move them between environments
+	ExportPosts(ctx context.Context, in *ExportPostsRequest, opts ...grpc.CallOption) (CrudService_ExportPostsClient, error)
+	// Import Posts, such as those from ExportPosts
+	ImportPosts(ctx context.Context, opts ...grpc.CallOption) (CrudService_ImportPostsClient, error)
+	// Attach a file to a Post, sent as metadata followed by chunks of at most 1MiB
+	UploadAttachment(ctx context.Context, opts ...grpc.CallOption) (CrudService_UploadAttachmentClient, error)
+	// Download an attachment as its metadata followed by chunks
+	DownloadAttachment(ctx context.Context, in *AttachmentID, opts ...grpc.CallOption) (CrudService_DownloadAttachmentClient, error)
+	// List the attachments of a Post
+	ListAttachments(ctx context.Context, in *PostID, opts ...grpc.CallOption) (*ListAttachmentsResponse, error)
+	// Aggregate the Posts for dashboards: counts by author and over time, and text lengths
+	GetPostStats(ctx context.Context, in *GetPostStatsRequest, opts ...grpc.CallOption) (*GetPostStatsResponse, error)
 }
 
 type crudServiceClient struct {
@@ -79,7 +99,43 @@ func (c *crudServiceClient) DeletePost(ctx context.Context, in *PostID, opts ...
 	return out, nil
 }
 
-func (c *crudServiceClient) ListPosts(ctx context.Context, in *empty.Empty, opts ...grpc.CallOption) (CrudService_ListPostsClient, error) {
+func (c *crudServiceClient) BatchGetPosts(ctx context.Context, in *BatchGetPostsRequest, opts ...grpc.CallOption) (*BatchGetPostsResponse, error) {
+	out := new(BatchGetPostsResponse)
+	err := c.cc.Invoke(ctx, "/crud.CrudService/BatchGetPosts", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *crudServiceClient) PublishPost(ctx context.Context, in *PublishPostRequest, opts ...grpc.CallOption) (*Post, error) {
+	out := new(Post)
+	err := c.cc.Invoke(ctx, "/crud.CrudService/PublishPost", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *crudServiceClient) UnpublishPost(ctx context.Context, in *PostID, opts ...grpc.CallOption) (*Post, error) {
+	out := new(Post)
+	err := c.cc.Invoke(ctx, "/crud.CrudService/UnpublishPost", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *crudServiceClient) ArchivePost(ctx context.Context, in *PostID, opts ...grpc.CallOption) (*Post, error) {
+	out := new(Post)
+	err := c.cc.Invoke(ctx, "/crud.CrudService/ArchivePost", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *crudServiceClient) ListPosts(ctx context.Context, in *ListPostsRequest, opts ...grpc.CallOption) (CrudService_ListPostsClient, error) {
 	stream, err := c.cc.NewStream(ctx, &CrudService_ServiceDesc.Streams[0], "/crud.CrudService/ListPosts", opts...)
 	if err != nil {
 		return nil, err
@@ -111,20 +167,190 @@ func (x *crudServiceListPostsClient) Recv() (*Post, error) {
 	return m, nil
 }
 
+func (c *crudServiceClient) ExportPosts(ctx context.Context, in *ExportPostsRequest, opts ...grpc.CallOption) (CrudService_ExportPostsClient, error) {
+	stream, err := c.cc.NewStream(ctx, &CrudService_ServiceDesc.Streams[1], "/crud.CrudService/ExportPosts", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &crudServiceExportPostsClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type CrudService_ExportPostsClient interface {
+	Recv() (*PostRecord, error)
+	grpc.ClientStream
+}
+
+type crudServiceExportPostsClient struct {
+	grpc.ClientStream
+}
+
+func (x *crudServiceExportPostsClient) Recv() (*PostRecord, error) {
+	m := new(PostRecord)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *crudServiceClient) ImportPosts(ctx context.Context, opts ...grpc.CallOption) (CrudService_ImportPostsClient, error) {
+	stream, err := c.cc.NewStream(ctx, &CrudService_ServiceDesc.Streams[2], "/crud.CrudService/ImportPosts", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &crudServiceImportPostsClient{stream}
+	return x, nil
+}
+
+type CrudService_ImportPostsClient interface {
+	Send(*ImportPostsRequest) error
+	CloseAndRecv() (*ImportPostsResponse, error)
+	grpc.ClientStream
+}
+
+type crudServiceImportPostsClient struct {
+	grpc.ClientStream
+}
+
+func (x *crudServiceImportPostsClient) Send(m *ImportPostsRequest) error {
+	return x.ClientStream.SendMsg(m)
+}
+
+func (x *crudServiceImportPostsClient) CloseAndRecv() (*ImportPostsResponse, error) {
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	m := new(ImportPostsResponse)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *crudServiceClient) UploadAttachment(ctx context.Context, opts ...grpc.CallOption) (CrudService_UploadAttachmentClient, error) {
+	stream, err := c.cc.NewStream(ctx, &CrudService_ServiceDesc.Streams[3], "/crud.CrudService/UploadAttachment", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &crudServiceUploadAttachmentClient{stream}
+	return x, nil
+}
+
+type CrudService_UploadAttachmentClient interface {
+	Send(*UploadAttachmentRequest) error
+	CloseAndRecv() (*Attachment, error)
+	grpc.ClientStream
+}
+
+type crudServiceUploadAttachmentClient struct {
+	grpc.ClientStream
+}
+
+func (x *crudServiceUploadAttachmentClient) Send(m *UploadAttachmentRequest) error {
+	return x.ClientStream.SendMsg(m)
+}
+
+func (x *crudServiceUploadAttachmentClient) CloseAndRecv() (*Attachment, error) {
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	m := new(Attachment)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *crudServiceClient) DownloadAttachment(ctx context.Context, in *AttachmentID, opts ...grpc.CallOption) (CrudService_DownloadAttachmentClient, error) {
+	stream, err := c.cc.NewStream(ctx, &CrudService_ServiceDesc.Streams[4], "/crud.CrudService/DownloadAttachment", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &crudServiceDownloadAttachmentClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type CrudService_DownloadAttachmentClient interface {
+	Recv() (*DownloadAttachmentResponse, error)
+	grpc.ClientStream
+}
+
+type crudServiceDownloadAttachmentClient struct {
+	grpc.ClientStream
+}
+
+func (x *crudServiceDownloadAttachmentClient) Recv() (*DownloadAttachmentResponse, error) {
+	m := new(DownloadAttachmentResponse)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *crudServiceClient) ListAttachments(ctx context.Context, in *PostID, opts ...grpc.CallOption) (*ListAttachmentsResponse, error) {
+	out := new(ListAttachmentsResponse)
+	err := c.cc.Invoke(ctx, "/crud.CrudService/ListAttachments", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *crudServiceClient) GetPostStats(ctx context.Context, in *GetPostStatsRequest, opts ...grpc.CallOption) (*GetPostStatsResponse, error) {
+	out := new(GetPostStatsResponse)
+	err := c.cc.Invoke(ctx, "/crud.CrudService/GetPostStats", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 // CrudServiceServer is the server API for CrudService service.
 // All implementations must embed UnimplementedCrudServiceServer
 // for forward compatibility
 type CrudServiceServer interface {
 	// Create a Post
 	CreatePost(context.Context, *Post) (*PostID, error)
-	// Read a Post
+	// Read a Post of any status, including drafts, by its id
 	ReadPost(context.Context, *PostID) (*Post, error)
 	// Update a Post
 	UpdatePost(context.Context, *Post) (*empty.Empty, error)
 	// Delete a Post
 	DeletePost(context.Context, *PostID) (*empty.Empty, error)
-	// List Posts
-	ListPosts(*empty.Empty, CrudService_ListPostsServer) error
+	// Read many Posts by id; missing ids are reported per result rather than failing the call
+	BatchGetPosts(context.Context, *BatchGetPostsRequest) (*BatchGetPostsResponse, error)
+	// Publish a Post now or at a later time, returning the updated Post
+	PublishPost(context.Context, *PublishPostRequest) (*Post, error)
+	// Return a Post to draft, e.g. to cancel its scheduled publishing
+	UnpublishPost(context.Context, *PostID) (*Post, error)
+	// Archive a Post, which unlists it without deleting it
+	ArchivePost(context.Context, *PostID) (*Post, error)
+	// List published Posts
+	ListPosts(*ListPostsRequest, CrudService_ListPostsServer) error
+	// Export all Posts, e.g. to move them between environments
+	ExportPosts(*ExportPostsRequest, CrudService_ExportPostsServer) error
+	// Import Posts, such as those from ExportPosts
+	ImportPosts(CrudService_ImportPostsServer) error
+	// Attach a file to a Post, sent as metadata followed by chunks of at most 1MiB
+	UploadAttachment(CrudService_UploadAttachmentServer) error
+	// Download an attachment as its metadata followed by chunks
+	DownloadAttachment(*AttachmentID, CrudService_DownloadAttachmentServer) error
+	// List the attachments of a Post
+	ListAttachments(context.Context, *PostID) (*ListAttachmentsResponse, error)
+	// Aggregate the Posts for dashboards: counts by author and over time, and text lengths
+	GetPostStats(context.Context, *GetPostStatsRequest) (*GetPostStatsResponse, error)
 	mustEmbedUnimplementedCrudServiceServer()
 }
 
@@ -144,9 +370,39 @@ func (UnimplementedCrudServiceServer) UpdatePost(context.Context, *Post) (*empty
 func (UnimplementedCrudServiceServer) DeletePost(context.Context, *PostID) (*empty.Empty, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method DeletePost not implemented")
 }
-func (UnimplementedCrudServiceServer) ListPosts(*empty.Empty, CrudService_ListPostsServer) error {
+func (UnimplementedCrudServiceServer) BatchGetPosts(context.Context, *BatchGetPostsRequest) (*BatchGetPostsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method BatchGetPosts not implemented")
+}
+func (UnimplementedCrudServiceServer) PublishPost(context.Context, *PublishPostRequest) (*Post, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method PublishPost not implemented")
+}
+func (UnimplementedCrudServiceServer) UnpublishPost(context.Context, *PostID) (*Post, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method UnpublishPost not implemented")
+}
+func (UnimplementedCrudServiceServer) ArchivePost(context.Context, *PostID) (*Post, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ArchivePost not implemented")
+}
+func (UnimplementedCrudServiceServer) ListPosts(*ListPostsRequest, CrudService_ListPostsServer) error {
 	return status.Errorf(codes.Unimplemented, "method ListPosts not implemented")
 }
+func (UnimplementedCrudServiceServer) ExportPosts(*ExportPostsRequest, CrudService_ExportPostsServer) error {
+	return status.Errorf(codes.Unimplemented, "method ExportPosts not implemented")
+}
+func (UnimplementedCrudServiceServer) ImportPosts(CrudService_ImportPostsServer) error {
+	return status.Errorf(codes.Unimplemented, "method ImportPosts not implemented")
+}
+func (UnimplementedCrudServiceServer) UploadAttachment(CrudService_UploadAttachmentServer) error {
+	return status.Errorf(codes.Unimplemented, "method UploadAttachment not implemented")
+}
+func (UnimplementedCrudServiceServer) DownloadAttachment(*AttachmentID, CrudService_DownloadAttachmentServer) error {
+	return status.Errorf(codes.Unimplemented, "method DownloadAttachment not implemented")
+}
+func (UnimplementedCrudServiceServer) ListAttachments(context.Context, *PostID) (*ListAttachmentsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListAttachments not implemented")
+}
+func (UnimplementedCrudServiceServer) GetPostStats(context.Context, *GetPostStatsRequest) (*GetPostStatsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetPostStats not implemented")
+}
 func (UnimplementedCrudServiceServer) mustEmbedUnimplementedCrudServiceServer() {}
 
 // UnsafeCrudServiceServer may be embedded to opt out of forward compatibility for this service.
@@ -232,8 +488,80 @@ func _CrudService_DeletePost_Handler(srv interface{}, ctx context.Context, dec f
 	return interceptor(ctx, in, info, handler)
 }
 
+func _CrudService_BatchGetPosts_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(BatchGetPostsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(CrudServiceServer).BatchGetPosts(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/crud.CrudService/BatchGetPosts",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(CrudServiceServer).BatchGetPosts(ctx, req.(*BatchGetPostsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _CrudService_PublishPost_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(PublishPostRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(CrudServiceServer).PublishPost(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/crud.CrudService/PublishPost",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(CrudServiceServer).PublishPost(ctx, req.(*PublishPostRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _CrudService_UnpublishPost_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(PostID)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(CrudServiceServer).UnpublishPost(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/crud.CrudService/UnpublishPost",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(CrudServiceServer).UnpublishPost(ctx, req.(*PostID))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _CrudService_ArchivePost_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(PostID)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(CrudServiceServer).ArchivePost(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/crud.CrudService/ArchivePost",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(CrudServiceServer).ArchivePost(ctx, req.(*PostID))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 func _CrudService_ListPosts_Handler(srv interface{}, stream grpc.ServerStream) error {
-	m := new(empty.Empty)
+	m := new(ListPostsRequest)
 	if err := stream.RecvMsg(m); err != nil {
 		return err
 	}
@@ -253,6 +581,136 @@ func (x *crudServiceListPostsServer) Send(m *Post) error {
 	return x.ServerStream.SendMsg(m)
 }
 
+func _CrudService_ExportPosts_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(ExportPostsRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(CrudServiceServer).ExportPosts(m, &crudServiceExportPostsServer{stream})
+}
+
+type CrudService_ExportPostsServer interface {
+	Send(*PostRecord) error
+	grpc.ServerStream
+}
+
+type crudServiceExportPostsServer struct {
+	grpc.ServerStream
+}
+
+func (x *crudServiceExportPostsServer) Send(m *PostRecord) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _CrudService_ImportPosts_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(CrudServiceServer).ImportPosts(&crudServiceImportPostsServer{stream})
+}
+
+type CrudService_ImportPostsServer interface {
+	SendAndClose(*ImportPostsResponse) error
+	Recv() (*ImportPostsRequest, error)
+	grpc.ServerStream
+}
+
+type crudServiceImportPostsServer struct {
+	grpc.ServerStream
+}
+
+func (x *crudServiceImportPostsServer) SendAndClose(m *ImportPostsResponse) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func (x *crudServiceImportPostsServer) Recv() (*ImportPostsRequest, error) {
+	m := new(ImportPostsRequest)
+	if err := x.ServerStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func _CrudService_UploadAttachment_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(CrudServiceServer).UploadAttachment(&crudServiceUploadAttachmentServer{stream})
+}
+
+type CrudService_UploadAttachmentServer interface {
+	SendAndClose(*Attachment) error
+	Recv() (*UploadAttachmentRequest, error)
+	grpc.ServerStream
+}
+
+type crudServiceUploadAttachmentServer struct {
+	grpc.ServerStream
+}
+
+func (x *crudServiceUploadAttachmentServer) SendAndClose(m *Attachment) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func (x *crudServiceUploadAttachmentServer) Recv() (*UploadAttachmentRequest, error) {
+	m := new(UploadAttachmentRequest)
+	if err := x.ServerStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func _CrudService_DownloadAttachment_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(AttachmentID)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(CrudServiceServer).DownloadAttachment(m, &crudServiceDownloadAttachmentServer{stream})
+}
+
+type CrudService_DownloadAttachmentServer interface {
+	Send(*DownloadAttachmentResponse) error
+	grpc.ServerStream
+}
+
+type crudServiceDownloadAttachmentServer struct {
+	grpc.ServerStream
+}
+
+func (x *crudServiceDownloadAttachmentServer) Send(m *DownloadAttachmentResponse) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _CrudService_ListAttachments_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(PostID)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(CrudServiceServer).ListAttachments(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/crud.CrudService/ListAttachments",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(CrudServiceServer).ListAttachments(ctx, req.(*PostID))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _CrudService_GetPostStats_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetPostStatsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(CrudServiceServer).GetPostStats(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/crud.CrudService/GetPostStats",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(CrudServiceServer).GetPostStats(ctx, req.(*GetPostStatsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 // CrudService_ServiceDesc is the grpc.ServiceDesc for CrudService service.
 // It's only intended for direct use with grpc.RegisterService,
 // and not to be introspected or modified (even as a copy)
@@ -276,6 +734,30 @@ var CrudService_ServiceDesc = grpc.ServiceDesc{
 			MethodName: "DeletePost",
 			Handler:    _CrudService_DeletePost_Handler,
 		},
+		{
+			MethodName: "BatchGetPosts",
+			Handler:    _CrudService_BatchGetPosts_Handler,
+		},
+		{
+			MethodName: "PublishPost",
+			Handler:    _CrudService_PublishPost_Handler,
+		},
+		{
+			MethodName: "UnpublishPost",
+			Handler:    _CrudService_UnpublishPost_Handler,
+		},
+		{
+			MethodName: "ArchivePost",
+			Handler:    _CrudService_ArchivePost_Handler,
+		},
+		{
+			MethodName: "ListAttachments",
+			Handler:    _CrudService_ListAttachments_Handler,
+		},
+		{
+			MethodName: "GetPostStats",
+			Handler:    _CrudService_GetPostStats_Handler,
+		},
 	},
 	Streams: []grpc.StreamDesc{
 		{
@@ -283,6 +765,26 @@ var CrudService_ServiceDesc = grpc.ServiceDesc{
 			Handler:       _CrudService_ListPosts_Handler,
 			ServerStreams: true,
 		},
+		{
+			StreamName:    "ExportPosts",
+			Handler:       _CrudService_ExportPosts_Handler,
+			ServerStreams: true,
+		},
+		{
+			StreamName:    "ImportPosts",
+			Handler:       _CrudService_ImportPosts_Handler,
+			ClientStreams: true,
+		},
+		{
+			StreamName:    "UploadAttachment",
+			Handler:       _CrudService_UploadAttachment_Handler,
+			ClientStreams: true,
+		},
+		{
+			StreamName:    "DownloadAttachment",
+			Handler:       _CrudService_DownloadAttachment_Handler,
+			ServerStreams: true,
+		},
 	},
 	Metadata: "crud.proto",
 }
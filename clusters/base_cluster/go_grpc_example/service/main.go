@@ -1,20 +1,33 @@
 package main
 
 import (
+	"context"
+	_ "expvar"
 	"log"
 	"net"
+	"net/http"
 	"os"
+	"time"
 
+	"go_grpc_example/blobstore"
 	ep "go_grpc_example/endpoints"
+	"go_grpc_example/grpcweb"
+	"go_grpc_example/outbox"
 	pb "go_grpc_example/proto"
+	crudv2 "go_grpc_example/proto/v2"
 
 	// Note: there is little to no reason to use viper in this app, I wanted to play with it.
 	// It does have terrific functions for reading and monitoring configs locally and remotely
 	// for interesting use-cases like hot reloads; none of that is needed in this app.
 
 	"google.golang.org/grpc"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"gorm.io/gorm"
 )
 
+// DB_MONITOR_INTERVAL is how often the db is pinged to log outages and recoveries.
+const DB_MONITOR_INTERVAL = 10 * time.Second
+
 // FUTURE: a bit awkward, the meat of main could live in the endpoints folder, however
 // this approximates an ideal main that binds together pkgs for the db, controller, and config.
 func main() {
@@ -22,23 +35,33 @@ func main() {
 	if err != nil {
 		log.Fatalf("error reading config: %v", err)
 	}
+	if _, err := ep.SetLogLevel(cfg.Admin.LogLevel); err != nil {
+		log.Fatalf("error setting log level: %v", err)
+	}
 
 	lis, err := net.Listen("tcp", cfg.Addr)
 	if err != nil {
 		log.Fatalf("Failed to listen: %v\n", err)
 	}
 
-	db, err := ep.Connect(&cfg.DbCreds)
+	db, err := ep.Connect(&cfg.DbCreds, &cfg.DbPool)
 	if err != nil {
 		log.Fatalf("db connection failed: %v\n", err)
 	}
+	hs := ep.NewHealthServer()
+	go ep.MonitorDB(context.Background(), db, DB_MONITOR_INTERVAL, hs)
 
 	if os.Getenv(ep.ENV_DEV) == "true" {
 		// TODO: deletion is solely for development to eliminate cumulative state
 		ep.DeleteDb(db, cfg.DbCreds.DbName, ep.PostsTable)
 	}
 
-	if err = ep.EnsureDB(db, cfg.DbCreds.DbName, &ep.Post{}); err != nil {
+	ep.SetFieldEncryption(cfg.Encryption.Keys)
+	if cfg.Encryption.Keys != nil {
+		log.Printf("encrypting post text with key %s\n", cfg.Encryption.Keys.Active())
+	}
+
+	if err = ep.EnsureDB(db, cfg.DbCreds.DbName, ep.Models...); err != nil {
 		log.Fatalf("%s db creation failed: %v\n", cfg.DbCreds.DbName, err)
 	} else {
 		log.Printf("%s db exists\n", cfg.DbCreds.DbName)
@@ -46,12 +69,157 @@ func main() {
 
 	log.Printf("Listening at %s\n", cfg.Addr)
 
-	opts := []grpc.ServerOption{}
+	tracker := ep.NewRPCTracker()
+	tenants := ep.NewTenantResolver(cfg.Tenants)
+	opts := append(tracker.ServerOptions(), cfg.Deadlines.ServerOptions()...)
+	opts = append(opts, tenants.ServerOptions()...)
+	opts = append(opts, cfg.Keepalive.ServerOptions()...)
+	srvOpts := []ep.ServerOption{
+		ep.WithIdempotencyWindow(cfg.IdempotencyWindow),
+		ep.WithQuota(cfg.Tenants.MaxPosts, cfg.Tenants.MaxBytes),
+		ep.WithBatchGetMax(cfg.BatchGetMax),
+	}
+	// auditOpts record mutating rpcs once their caller is resolved, admin rpcs included.
+	var auditOpts []grpc.ServerOption
+	if cfg.Audit.Sink != "" {
+		var sink ep.AuditSink = ep.NewDBAuditSink(db)
+		if cfg.Audit.Sink == ep.AUDIT_SINK_FILE {
+			fileSink, err := ep.NewFileAuditSink(cfg.Audit.File)
+			if err != nil {
+				log.Fatalf("audit log: %v\n", err)
+			}
+			defer fileSink.Close()
+			sink = fileSink
+		}
+		auditOpts = ep.NewAuditor(sink).ServerOptions()
+		opts = append(opts, auditOpts...)
+		srvOpts = append(srvOpts, ep.WithAuditLog(sink))
+		log.Printf("recording mutating rpcs in the %s audit log\n", cfg.Audit.Sink)
+	}
 	gs := grpc.NewServer(opts...)
-	ep := ep.NewServer(db)
-	pb.RegisterCrudServiceServer(gs, ep)
+	if len(cfg.DbReplicas.Addrs) > 0 {
+		replicas := []ep.Replica{}
+		for _, addr := range cfg.DbReplicas.Addrs {
+			replica, err := ep.ConnectReplica(&cfg.DbCreds, &cfg.DbPool, addr)
+			if err != nil {
+				log.Fatalf("db replica %s: %v\n", addr, err)
+			}
+			replicas = append(replicas, ep.Replica{Name: addr, DB: replica})
+		}
+		rs, err := ep.NewReplicaSet(db, replicas, cfg.DbReplicas.ReadYourWrites)
+		if err != nil {
+			log.Fatalf("db replicas: %v\n", err)
+		}
+		go rs.Monitor(context.Background(), cfg.DbReplicas.CheckInterval)
+		srvOpts = append(srvOpts, ep.WithReplicas(rs))
+		log.Printf("reading from %d db replicas\n", len(replicas))
+	}
+	if cfg.ReadCacheSize > 0 {
+		cache, err := ep.NewLRUPostCache(cfg.ReadCacheSize)
+		if err != nil {
+			log.Fatalf("read cache: %v\n", err)
+		}
+		srvOpts = append(srvOpts, ep.WithReadCache(cache))
+	}
+	srvOpts = append(srvOpts, ep.WithRenderCache(cfg.RenderCacheSize))
+	if cfg.StatsCacheTTL > 0 {
+		srvOpts = append(srvOpts, ep.WithStatsCache(cfg.StatsCacheTTL))
+	}
+	if cfg.Attachments.BlobStore != "" {
+		store, err := blobstore.New(cfg.Attachments.BlobStore, cfg.Attachments.BlobTarget, cfg.Attachments.S3Creds)
+		if err != nil {
+			log.Fatalf("blob store: %v\n", err)
+		}
+		srvOpts = append(srvOpts,
+			ep.WithBlobStore(store),
+			ep.WithAttachmentLimits(cfg.Attachments.MaxBytes, cfg.Attachments.ContentTypes))
+		log.Printf("storing attachments in %s blob store\n", cfg.Attachments.BlobStore)
+	}
+	if cfg.OutboxPublisher != "" {
+		pub, err := outbox.NewPublisher(cfg.OutboxPublisher, cfg.OutboxTarget)
+		if err != nil {
+			log.Fatalf("outbox publisher: %v\n", err)
+		}
+
+		relay := outbox.NewRelay(db, pub)
+		relay.PublishExpvar("outbox")
+		go relay.Run(context.Background())
+		srvOpts = append(srvOpts, ep.WithOutbox())
+		log.Printf("publishing post events via %s publisher\n", cfg.OutboxPublisher)
+	}
+
+	if cfg.MetricsAddr != "" {
+		// The expvar import registers /debug/vars on the default mux.
+		go func() {
+			log.Printf("serving metrics at %s/debug/vars\n", cfg.MetricsAddr)
+			if err := http.ListenAndServe(cfg.MetricsAddr, nil); err != nil {
+				log.Printf("metrics server failed: %v\n", err)
+			}
+		}()
+	}
+
+	srv := ep.NewServer(db, srvOpts...)
+	pb.RegisterCrudServiceServer(gs, srv)
+	crudv2.RegisterCrudServiceServer(gs, ep.NewServerV2(srv))
+	healthpb.RegisterHealthServer(gs, hs)
+
+	admin := ep.NewAdminServer(srv, tracker, cfg.Admin.Toolchain)
+	if cfg.Admin.Addr == "" {
+		pb.RegisterAdminServiceServer(gs, admin)
+	} else {
+		adminLis, err := net.Listen("tcp", cfg.Admin.Addr)
+		if err != nil {
+			log.Fatalf("Failed to listen for admin rpcs: %v\n", err)
+		}
+		// Admin rpcs are neither capped by the rpc deadlines nor counted with the crud rpcs.
+		ags := grpc.NewServer(append(tenants.ServerOptions(), auditOpts...)...)
+		pb.RegisterAdminServiceServer(ags, admin)
+		go func() {
+			log.Printf("serving admin rpcs at %s\n", cfg.Admin.Addr)
+			if err := ags.Serve(adminLis); err != nil {
+				log.Printf("admin server failed: %v\n", err)
+			}
+		}()
+	}
+
+	if cfg.GrpcWeb.Addr != "" {
+		// Browsers get the rpcs of gs, with its interceptors, translated from gRPC-Web.
+		web := &http.Server{
+			Addr:              cfg.GrpcWeb.Addr,
+			Handler:           grpcweb.New(gs, cfg.GrpcWeb.AllowedOrigins),
+			ReadHeaderTimeout: 10 * time.Second,
+		}
+		go func() {
+			log.Printf("serving gRPC-Web at %s for origins %v\n", cfg.GrpcWeb.Addr, cfg.GrpcWeb.AllowedOrigins)
+			if err := web.ListenAndServe(); err != nil {
+				log.Printf("gRPC-Web server failed: %v\n", err)
+			}
+		}()
+	}
+
+	go purgeIdempotencyRecords(db)
+	go srv.RunScheduler(context.Background(), cfg.SchedulerInterval)
+	if cfg.Encryption.Keys != nil {
+		go ep.RunReencryption(context.Background(), db, cfg.Encryption.ReencryptInterval)
+	}
 
 	if err := gs.Serve(lis); err != nil {
 		log.Fatalf("Failed to serve: %v\n", err)
 	}
 }
+
+// purgeIdempotencyRecords periodically reclaims expired idempotency records.
+// FUTURE: with multiple replicas this runs on each; harmless, but a leader or cron job would be tidier.
+func purgeIdempotencyRecords(db *gorm.DB) {
+	ticker := time.NewTicker(time.Hour)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		n, err := ep.PurgeIdempotencyRecords(context.Background(), db)
+		if err != nil {
+			log.Printf("idempotency record purge failed: %v\n", err)
+			continue
+		}
+		log.Printf("purged %d expired idempotency records\n", n)
+	}
+}